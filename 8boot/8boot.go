@@ -0,0 +1,287 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8boot boots OS/8 from a disk image on an emulated PDP-8/E.
+//
+//   Usage: 8boot [-r] [-c CYCLES] [-d DEVICE] [-f FIELDS] [-l LINE,...] [-p PARITY] [-u UNIT] IMAGE ...
+//    -c CYCLES     characters take CYCLES cycles to transfer (default 100)
+//    -d DEVICE     boot from DEVICE, rk (RK8E) or rx (RX8E)
+//    -f FIELDS     number of fields of memory (default 8)
+//    -l LINE       add the serial line LINE
+//    -p PARITY     use PARITY on the console and lines: mark, 7, or 8 (default mark)
+//    -r            mount the images read only
+//    -u UNIT       boot from drive UNIT (default 0)
+//
+// Each IMAGE is mounted on the next drive of the disk controller, starting
+// with drive 0.  An RK8E has 4 drives and an RX8E has 2.  If DEVICE is not
+// given, an RX8E is used if the first IMAGE has the extension .rx01,
+// otherwise an RK8E is used.  The images are read and written through package
+// os8fs, so, for example, the A: and B: sides of an RK05 image are the two
+// OS/8 partitions of the drive.
+//
+// The processor has an EAE.  The console is connected to the terminal, which
+// is put into raw mode while the emulator runs.  Typing ^E stops the
+// emulator.  Changes made to the images are written when the emulator stops.
+//
+// Each LINE adds a KL8E serial line with its keyboard on the octal device
+// code CODE and its teleprinter on CODE+1.  LINE is one of:
+//
+//  CODE:pty                  connect to a new pseudo-terminal
+//  CODE:tcp:PORT             accept telnet connections on 127.0.0.1:PORT
+//  CODE:file:INPUT:OUTPUT    type the file INPUT and print to the file OUTPUT
+//
+// The path of each pseudo-terminal is printed when the emulator starts.  Lines
+// with the same PORT share it: each connection is given the first free line.
+// Either INPUT or OUTPUT may be empty.
+//
+// With PARITY mark, the parity bit (0200) is cleared from printed characters
+// and set on typed characters, as OS/8 expects.  With 7 it is cleared from
+// both and with 8 all 8 bits are passed unchanged.
+//
+// For example, to boot an RK05 image with two more terminals that users can
+// telnet to on port 2300:
+//
+//  8boot -l 40:tcp:2300,42:tcp:2300 os8.rk05
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/cpu"
+	"github.com/pborman/pdp8/disk"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/tty"
+)
+
+// quit is the character that stops the emulator.
+const quit = 'E' & 037
+
+// A controller is a disk controller.
+type controller interface {
+	cpu.Clocked
+	Mount(unit int, d *os8fs.Disk) error
+	Boot(c *cpu.CPU, unit int) error
+	Err() error
+}
+
+func main() {
+	getopt.SetParameters("IMAGE ...")
+	delay := getopt.Int('c', tty.DefaultDelay, "characters take CYCLES cycles to transfer", "CYCLES")
+	device := getopt.String('d', "", "boot from DEVICE, rk (RK8E) or rx (RX8E)", "DEVICE")
+	fields := getopt.Int('f', core.MaxFields, "number of fields of memory", "FIELDS")
+	lineSpecs := getopt.List('l', "add the serial line LINE", "LINE")
+	parityName := getopt.String('p', "mark", "use PARITY on the console and lines: mark, 7, or 8", "PARITY")
+	readOnly := getopt.Bool('r', "mount the images read only")
+	unit := getopt.Int('u', 0, "boot from drive UNIT", "UNIT")
+	getopt.Parse()
+	images := getopt.Args()
+	if len(images) == 0 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	if *fields < 1 || *fields > core.MaxFields {
+		exitcode.Exitf("fields must be between 1 and %d", core.MaxFields)
+	}
+	if *delay < 1 {
+		exitcode.Exitf("invalid delay: %d", *delay)
+	}
+	parity, ok := parities[*parityName]
+	if !ok {
+		exitcode.Exitf("unknown parity: %s", *parityName)
+	}
+	if *device == "" {
+		*device = "rk"
+		if strings.EqualFold(filepath.Ext(images[0]), ".rx01") {
+			*device = "rx"
+		}
+	}
+
+	var dc controller
+	var code int
+	switch strings.ToLower(*device) {
+	case "rk":
+		dc, code = disk.NewRK8E(), disk.RKDevice
+	case "rx":
+		dc, code = disk.NewRX8E(), disk.RXDevice
+	default:
+		exitcode.Exitf("unknown device: %s", *device)
+	}
+
+	var disks []*os8fs.Disk
+	for i, image := range images {
+		d, err := os8fs.OpenImage(image, !*readOnly)
+		if err != nil {
+			exitcode.Exit(err)
+		}
+		d.Atomic = true
+		if err := dc.Mount(i, d); err != nil {
+			exitcode.Exit(fmt.Errorf("%s: %w", image, err))
+		}
+		disks = append(disks, d)
+	}
+
+	c := cpu.New(*fields)
+	c.EAE = true
+	c.Attach(code, dc)
+
+	stop := make(chan struct{})
+	pr, pw := io.Pipe()
+	go keyboard(pw, stop)
+	console := tty.NewKL8E(pr, os.Stdout)
+	console.Delay = uint64(*delay)
+	console.Parity = parity
+	console.Attach(c)
+
+	lines := []*tty.KL8E{console}
+	used := map[int]bool{console.Keyboard: true, console.Printer: true, code: true}
+	listeners := map[string][]*tty.KL8E{}
+	for _, spec := range *lineSpecs {
+		k, port, err := line(spec, used)
+		if err != nil {
+			exitcode.Exit(fmt.Errorf("%s: %w", spec, err))
+		}
+		k.Delay = uint64(*delay)
+		k.Parity = parity
+		k.Attach(c)
+		lines = append(lines, k)
+		if port != "" {
+			listeners[port] = append(listeners[port], k)
+		}
+	}
+	for port, ks := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			exitcode.Exit(err)
+		}
+		go tty.Serve(l, ks...)
+	}
+
+	if err := dc.Boot(c, *unit); err != nil {
+		exitcode.Exit(fmt.Errorf("drive %d: %w", *unit, err))
+	}
+
+	restore, err := tty.MakeRaw(os.Stdin)
+	if err != nil {
+		restore = func() {}
+	}
+	err = run(c, stop)
+	for _, k := range lines {
+		k.Flush(time.Second)
+		k.Disconnect()
+	}
+	restore()
+	fmt.Println()
+	if err != nil {
+		// The processor halted, which is not an error.
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	errs := []error{dc.Err()}
+	for i, d := range disks {
+		if err := d.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", images[i], err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		exitcode.Exit(err)
+	}
+}
+
+// run runs c until it halts or stop is closed.
+func run(c *cpu.CPU, stop chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err := c.Run(100000); err != nil {
+			return fmt.Errorf("%v at %s", err, core.MakeAddr(c.IF, (c.PC-1)&07777))
+		}
+	}
+}
+
+// keyboard copies standard input to w until the quit character is typed, when
+// it closes stop.
+func keyboard(w io.Writer, stop chan struct{}) {
+	var buf [256]byte
+	for {
+		n, err := os.Stdin.Read(buf[:])
+		if x := strings.IndexByte(string(buf[:n]), quit); x >= 0 {
+			w.Write(buf[:x])
+			close(stop)
+			return
+		}
+		if _, werr := w.Write(buf[:n]); werr != nil || err != nil {
+			return
+		}
+	}
+}
+
+// parities are the names of the parities.
+var parities = map[string]tty.Parity{
+	"mark": tty.Mark,
+	"7":    tty.Seven,
+	"8":    tty.Eight,
+}
+
+// line returns the serial line described by spec and, for a tcp line, the port
+// to listen on.  The device codes of the line, which must not already be in
+// used, are added to used.
+func line(spec string, used map[int]bool) (*tty.KL8E, string, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) < 2 {
+		return nil, "", fmt.Errorf("invalid line")
+	}
+	code, err := strconv.ParseUint(parts[0], 8, 6)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid device code: %s", parts[0])
+	}
+	k := tty.NewLine(int(code))
+	for _, dev := range []int{k.Keyboard, k.Printer} {
+		if dev <= 0 || dev >= 0100 || dev&070 == 020 || used[dev] {
+			return nil, "", fmt.Errorf("device code %02o is not available", dev)
+		}
+		used[dev] = true
+	}
+	switch {
+	case parts[1] == "pty" && len(parts) == 2:
+		p, name, err := tty.OpenPTY()
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Fprintf(os.Stderr, "line %02o: %s\n", code, name)
+		k.Connect(p)
+	case parts[1] == "tcp" && len(parts) == 3:
+		if _, err := strconv.ParseUint(parts[2], 10, 16); err != nil {
+			return nil, "", fmt.Errorf("invalid port: %s", parts[2])
+		}
+		return k, parts[2], nil
+	case parts[1] == "file" && len(parts) == 3:
+		x := strings.Index(parts[2], ":")
+		if x < 0 {
+			return nil, "", fmt.Errorf("missing output file")
+		}
+		f, err := tty.OpenFiles(parts[2][:x], parts[2][x+1:])
+		if err != nil {
+			return nil, "", err
+		}
+		k.Connect(f)
+	default:
+		return nil, "", fmt.Errorf("invalid line")
+	}
+	return k, "", nil
+}
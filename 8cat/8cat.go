@@ -5,14 +5,16 @@
 
 // Program 8cat is used to display files from a PDP-8 disk image.
 //
-//   Usage: 8cat [-678r] [IMAGE/]FILE
+//   Usage: 8cat [-678rt] [IMAGE/]FILE
 //    -6    decode as 6 bit ascii
 //    -7    decode as 7 bit ascii
 //    -8    decode as packed 8 bit bytes
 //    -r    raw bytes
+//    -t    decode as 7 bit host text (stop at ^Z, CR/LF to LF)
 //
 // By default, 8cat tries to determine if the file is encoded as ASCII6,
-// 7 bit ASCII, or is a binary file.
+// 7 bit ASCII, or is a binary file.  7 bit ASCII files are displayed as host
+// text, as with -t.
 //
 // The disk image is either specified as the directory component of the file to
 // cat, or by the environment variable PDP_IMAGE.
@@ -30,24 +32,12 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
 	"github.com/pborman/pdp8/os8fs"
 )
 
-func exit(v ...interface{}) {
-	fmt.Fprintln(os.Stderr, v...)
-	os.Exit(1)
-}
-func exitf(format string, v ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, v...)
-	os.Exit(1)
-}
-
 func isAscii(data []byte) bool {
 	bad := 0
 	for _, c := range data {
@@ -78,6 +68,7 @@ func main() {
 	as6 := getopt.Bool('6', "decode as 6 bit ascii")
 	as7 := getopt.Bool('7', "decode as 7 bit ascii")
 	as8 := getopt.Bool('8', "decode as packed 8 bit bytes")
+	text := getopt.Bool('t', "decode as 7 bit host text (stop at ^Z, CR/LF to LF)")
 	raw := getopt.Bool('r', "raw bytes")
 	getopt.Parse()
 	args := getopt.Args()
@@ -87,7 +78,7 @@ func main() {
 	}
 	f, err := os8fs.GetFile(args[0])
 	if err != nil {
-		exit(err)
+		exitcode.Exit(err)
 	}
 	switch {
 	case *as6:
@@ -96,11 +87,13 @@ func main() {
 		_, err = os.Stdout.Write(f.ASCII(true))
 	case *as8:
 		_, err = os.Stdout.Write(f.ASCII(false))
+	case *text:
+		_, err = os.Stdout.Write(f.Text())
 	case *raw:
 		_, err = os.Stdout.Write(f.Bytes())
 	default:
 		if bytes := f.ASCII(true); isAscii(bytes) {
-			_, err = os.Stdout.Write(bytes)
+			_, err = os.Stdout.Write(f.Text())
 		} else if bytes = f.ASCII6(); isAscii6(bytes) {
 			_, err = os.Stdout.Write(bytes)
 		} else {
@@ -108,6 +101,6 @@ func main() {
 		}
 	}
 	if err != nil {
-		exit("%s: %v", args[1], err)
+		exitcode.Exit(fmt.Errorf("%s: %w", args[0], err))
 	}
 }
@@ -0,0 +1,103 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8conv converts PDP-8 paper tapes between BIN and RIM formats.
+//
+//   Usage: 8conv [-t FORMAT] [-o OUTPUT] [IMAGE/]FILE
+//    -o    write the tape to OUTPUT rather than standard output
+//    -t    format of the output tape, bin or rim
+//
+// The format of the input tape is detected automatically.  By default a BIN
+// tape is converted to RIM and a RIM tape is converted to BIN.  Only programs
+// that load entirely into field 0 can be written as RIM tapes.
+//
+// If FILE names a file on the host it is read from the host, otherwise it is
+// read from a disk image.  The following examples of path names assume
+// PDP8_IMAGE is /tmp/os8.rk05:
+//
+//  PATH                   DRIVE         SIDE FILE
+//  foobar.bn               /tmp/os8.rk05  A  FOOBAR.BN
+//  b:foobar.bn             /tmp/os8.rk05  B  FOOBAR.BN
+//  ./os8.rk05/foobar.bn    ./os8.rk05     A  FOOBAR.BN
+//  ./os8.rk05/b:foobar.bn  ./os8.rk05     B  FOOBAR.BN
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/papertape"
+)
+
+func main() {
+	getopt.SetParameters("[IMAGE/]FILE")
+	format := getopt.String('t', "", "format of the output tape, bin or rim")
+	output := getopt.String('o', "", "write the tape to OUTPUT", "OUTPUT")
+	getopt.Parse()
+	args := getopt.Args()
+	if len(args) != 1 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	data, err := readFile(args[0])
+	if err != nil {
+		exitcode.Exit(err)
+	}
+	img, in, err := papertape.Decode(data)
+	if img == nil {
+		exitcode.Exit(fmt.Errorf("%s: %w", args[0], err))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+	}
+
+	out := papertape.BIN
+	switch strings.ToUpper(*format) {
+	case "":
+		if in == papertape.BIN {
+			out = papertape.RIM
+		}
+	case "BIN":
+	case "RIM":
+		out = papertape.RIM
+	default:
+		exitcode.Exitf("unknown format: %s", *format)
+	}
+
+	var tape []byte
+	if out == papertape.RIM {
+		if tape, err = papertape.EncodeRIM(img); err != nil {
+			exitcode.Exit(fmt.Errorf("%s: %w", args[0], err))
+		}
+	} else {
+		tape = papertape.EncodeBIN(img)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(tape)
+	} else {
+		err = os.WriteFile(*output, tape, 0666)
+	}
+	if err != nil {
+		exitcode.Exit(err)
+	}
+}
+
+// readFile returns the contents of the file path as paper tape frames.  If
+// path is a file on the host then it is read from the host, otherwise it is
+// read from a disk image.
+func readFile(path string) ([]byte, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		return os.ReadFile(path)
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.ASCII(false), nil
+}
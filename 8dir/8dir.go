@@ -10,39 +10,27 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 
+	"github.com/pborman/pdp8/exitcode"
 	"github.com/pborman/pdp8/os8fs"
 )
 
-func exit(v ...interface{}) {
-	fmt.Fprintln(os.Stderr, v...)
-	os.Exit(1)
-}
-func exitf(format string, v ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, v...)
-	os.Exit(1)
-}
-
 func main() {
 	var path string
 	switch len(os.Args) {
 	case 1:
 		path = os.Getenv("PDP8_IMAGE")
 		if path == "" {
-			exit("usage: 8dir IMAGE")
+			exitcode.Exit("usage: 8dir IMAGE")
 		}
 	case 2:
 		path = os.Args[1]
 	default:
-		exit("usage: 8dir [IMAGE]")
+		exitcode.Exit("usage: 8dir [IMAGE]")
 	}
 	d, err := os8fs.OpenImage(path, false)
 	if err != nil {
-		exit(err)
+		exitcode.Exit(err)
 	}
 	fis, err := d.List()
 	for _, fi := range fis {
@@ -53,6 +41,6 @@ func main() {
 		fmt.Printf("%-11s %-3d%s\n", fi.Name, fi.Size, date)
 	}
 	if err != nil {
-		exit(err)
+		exitcode.Exit(err)
 	}
 }
@@ -4,8 +4,93 @@
 // https://github.com/pborman/pdp8/blob/master/LICENSE
 
 // Program 8dis is an experimental disassembler for the PDP-8.  It is more of
-// a toy at this point.  If the named file ends with .BN then it is decoded
-// as a BIN file.  Other files currently are just treated as raw instructions.
+// a toy at this point.
+//
+//   Usage: 8dis [-Dnpx] [-a ADDR] [-d TABLES] [-e ADDR] [-g FORMAT] [-l FILES] [-m MODEL] [-r START-END] [-s FILES] [-t TYPE] [IMAGE/]FILE
+//    -a ADDR         load raw files at ADDR (default 0)
+//    -D              write the device table and exit
+//    -d TABLES       decode IOTs with the device TABLES
+//    -e ADDR         start analysis at ADDR
+//    -g FORMAT       write the call graph as FORMAT: text or dot
+//    -m MODEL        decode instructions for MODEL (default pdp8e)
+//    -n              decode every word as an instruction
+//    -p              write PAL8 source
+//    -x              write a cross reference table
+//    -l FILES        name addresses with the symbols in the PAL8 listings FILES
+//    -r START-END    only disassemble addresses START through END
+//    -s FILES        name addresses with the symbols in FILES
+//    -t TYPE         decode FILE as TYPE: bin, rim, sv, or raw
+//
+// By default the type of the file is determined by its contents.  A file that
+// starts with a valid core control block is an OS/8 core image (.SV).  A file
+// that looks like a paper tape is decoded as either a BIN or RIM tape.  Any
+// other file is treated as raw instructions.
+//
+// Addresses are written in octal as AAAA or F:AAAA, where F is the field.  If
+// END is omitted it is the end of memory.  If the field of END is omitted it
+// is the field of START.  When any field other than 0 is present all addresses
+// are printed as F:AAAA.  Memory between the loaded parts of a field is
+// marked as not loaded rather than disassembled.
+//
+// Words are separated into code and data by following the flow of control
+// from the entry point.  The entry point is the starting address of a core
+// image, the first origin on a paper tape (tapes do not record a starting
+// address), or the load address of a raw file.  The -e option overrides the
+// entry point.  Words that are never reached are displayed as .WORD or TEXT.
+// With -n every word is decoded as an instruction.
+//
+// The instruction and data fields are followed through straight-line code, as
+// changed by CDF and CIF.  Indirect instructions, and JMP and JMS to another
+// field, are annotated with their effective address as F:AAAA.  An indirect
+// instruction is not annotated if the program writes its pointer with DCA or
+// ISZ, as the loaded contents of the pointer are not the address used.  With
+// -n the instruction and data fields are assumed to be the field of the
+// instruction.
+//
+// Addresses are named with symbols read from the comma separated lists of
+// files given to -s and -l.  The -s files are symbol files as described by
+// symtab.Parse.  The -l files are PAL8 listings, symbol tables, or CREF output
+// (e.g., the .LS file written by PAL8), as described by symtab.ParsePAL8.
+// Each file is read from the host or from an image.  When an address has more
+// than one name the first is used.  Symbols replace addresses in the operands
+// of memory reference instructions and are written as labels before the
+// addresses they name.  With -p, symbols not used as labels are defined with
+// = at the start of the source.
+//
+// With -x the output is a cross reference table listing, for each referenced
+// address, each instruction that refers to it and how it is used: read,
+// write, modify (ISZ), call (JMS), jump, return (JMP I through the return
+// address of a subroutine), pointer, or autoindex (indirect through 0010-0017,
+// which increments the pointer).  Indirect references also refer to the
+// contents of the pointer unless the program writes the pointer.
+//
+// With -g the output is the JMS call graph.  Routines are named by their
+// address, the address of the return address word for subroutines.  The text
+// format lists each routine followed by the routines it calls, indented.  The
+// dot format is for Graphviz.
+//
+// With -p the output is PAL8 source that assembles to the same image.  Labels
+// are generated for referenced addresses, literals are written as (VALUE) or
+// [VALUE], and strings as TEXT.  Words that PAL8 would not reproduce exactly
+// from a symbolic form are written as octal constants.
+//
+// Instructions are decoded for the PDP-8 model given by -m, one of pdp8,
+// pdp8s, pdp8i, pdp8l, pdp8e, pdp8e/eaea, pdp8e/eaeb, pdp8a, pdp12, or hd6120.
+// Instructions that are not legal on the model are marked as illegal.  See
+// package disasm for the differences between models.
+//
+// The IOTs of peripherals are decoded with the standard DEC device table
+// unless -d is given.  TABLES is a comma separated list of device table files
+// on the host, in the text or JSON format described by disasm.ParseDevices.
+// The name default refers to the standard table.  A device in a later table
+// replaces a device with the same code in an earlier table, so -d
+// default,site.dev adds the devices in site.dev to the standard table.  With
+// -D the device table is written to standard output, in text form, as a
+// starting point for a new table.
+//
+// If FILE names a file on the host it is read from the host.  Paper tapes on
+// the host contain one frame per byte.  Other host files contain 2 bytes per
+// word, just as a disk image.
 //
 // The following examples of path names assume PDP8_IMAGE is /tmp/os8.rk05:
 //
@@ -20,364 +105,398 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/disasm"
+	"github.com/pborman/pdp8/exitcode"
 	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/papertape"
+	"github.com/pborman/pdp8/saveimage"
+	"github.com/pborman/pdp8/symtab"
 )
 
-func exit(v ...interface{}) {
-	fmt.Fprintln(os.Stderr, v...)
-	os.Exit(1)
-}
-func exitf(format string, v ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, v...)
-	os.Exit(1)
-}
+// decoder decodes instructions for the selected model and devices.
+var decoder = disasm.Decoder{Model: disasm.PDP8E}
 
 func main() {
 	getopt.SetParameters("[IMAGE/]FILE")
+	load := getopt.String('a', "0", "load raw files at ADDR", "ADDR")
+	window := getopt.String('r', "", "only disassemble addresses START through END", "START-END")
+	ftype := getopt.String('t', "", "decode FILE as TYPE: bin, rim, sv, or raw", "TYPE")
+	pal := getopt.Bool('p', "write PAL8 source")
+	entry := getopt.String('e', "", "start analysis at ADDR", "ADDR")
+	noflow := getopt.Bool('n', "decode every word as an instruction")
+	xref := getopt.Bool('x', "write a cross reference table")
+	graph := getopt.String('g', "", "write the call graph as FORMAT: text or dot", "FORMAT")
+	mname := getopt.String('m', decoder.Model.String(), "decode instructions for MODEL", "MODEL")
+	tables := getopt.String('d', "", "decode IOTs with the device TABLES", "TABLES")
+	dump := getopt.Bool('D', "write the device table and exit")
+	symfiles := getopt.String('s', "", "name addresses with the symbols in FILES", "FILES")
+	listings := getopt.String('l', "", "name addresses with the symbols in the PAL8 listings FILES", "FILES")
 	getopt.Parse()
+	var err error
+	if decoder.Model, err = disasm.ParseModel(*mname); err != nil {
+		exitcode.Exitf("-m: %v", err)
+	}
+	if *tables != "" {
+		if decoder.Devices, err = readDevices(*tables); err != nil {
+			exitcode.Exit(fmt.Errorf("-d: %w", err))
+		}
+	}
+	if *dump {
+		devices := decoder.Devices
+		if devices == nil {
+			devices = disasm.DefaultDevices
+		}
+		if err := devices.Write(os.Stdout); err != nil {
+			exitcode.Exit(err)
+		}
+		return
+	}
 	args := getopt.Args()
 	if len(args) != 1 {
 		getopt.PrintUsage(os.Stderr)
 		os.Exit(1)
 	}
-	f, err := os8fs.GetFile(args[0])
+	if *symfiles != "" || *listings != "" {
+		symbols = symtab.New()
+		if err := readSymbols(*symfiles, symtab.Parse); err != nil {
+			exitcode.Exit(fmt.Errorf("-s: %w", err))
+		}
+		if err := readSymbols(*listings, symtab.ParsePAL8); err != nil {
+			exitcode.Exit(fmt.Errorf("-l: %w", err))
+		}
+	}
+	origin, err := core.ParseAddr(*load)
 	if err != nil {
-		exit(err)
+		exitcode.Exitf("-a: %v", err)
 	}
-	w := bufio.NewWriter(os.Stdout)
-	if strings.HasSuffix(f.Name(), ".BN") {
-		start, mem := readBin(f.ASCII(false))
-		for i, word := range mem {
-			if word != 0 {
-				fmt.Fprintf(w, "%04o: %04o %-30s %2s\n", start+i, word, decode(uint16(start+i), word), os8fs.ASCII6(word))
-			}
+	lo, hi := core.Addr(0), core.Addr(core.Size)
+	if *window != "" {
+		if lo, hi, err = parseRange(*window); err != nil {
+			exitcode.Exitf("-r: %v", err)
 		}
-	} else {
-		return
-		words := f.Words()
+	}
+	name, data, words, err := readFile(args[0])
+	if err != nil {
+		exitcode.Exit(err)
+	}
+	if *ftype == "" {
+		*ftype = detect(data, words)
+	}
+	*ftype = strings.ToLower(*ftype)
+	img, err := decode(*ftype, data, words, origin)
+	if img == nil {
+		exitcode.Exit(fmt.Errorf("%s: %w", name, err))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	}
 
-		for i, word := range words {
-			fmt.Fprintf(w, "%04o: %04o %-30s %2s\n", i, word, decode(uint16(i), word), os8fs.ASCII6(word))
+	pc := entryPoint(img, *ftype, data, origin)
+	if *entry != "" {
+		if pc, err = core.ParseAddr(*entry); err != nil {
+			exitcode.Exitf("-e: %v", err)
 		}
 	}
-	w.Flush()
-}
-
-var ops = []string{"AND", "TAD", "ISZ", "DCA", "JMS", "JMP", "IOT", "OPR"}
+	entries := []core.Addr{pc}
+	var fl *flow
+	var code map[core.Addr]bool
+	if !*noflow {
+		fl = analyze(img, entries)
+		code = fl.code
+	}
 
-func decode(a, w uint16) string {
-	if op, ok := fixed[w]; ok {
-		return op
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	switch {
+	case *graph != "" && fl == nil:
+		exitcode.Exitf("-g cannot be used with -n")
+	case *graph == "text":
+		writeCallGraph(w, callGraph(fl, entries))
+		return
+	case *graph == "dot":
+		writeDOT(w, callGraph(fl, entries))
+		return
+	case *graph != "":
+		exitcode.Exitf("unknown graph format: %s", *graph)
+	case *xref:
+		writeXref(w, xrefs(img, fl))
+		return
+	}
+	if *pal {
+		writePAL(w, img, img.Fields(), lo, hi, code)
+		return
 	}
-	op := (w >> 9) & 7
+	writeListing(w, img, fl, lo, hi)
+}
 
-	if op < 6 {
-		addr := w & 0177
-		if w&0200 != 0 {
-			addr |= a & 07600
+// decode returns the image held in the file of type ftype, bin, rim, sv, or
+// raw, with the contents data and words.  Raw files are loaded at origin.  An
+// image is returned along with the error if the file is damaged, such as a
+// tape with a bad checksum, but not unreadable.
+func decode(ftype string, data []byte, words []uint16, origin core.Addr) (*core.Image, error) {
+	switch ftype {
+	case "bin":
+		return papertape.DecodeBIN(data)
+	case "rim":
+		return papertape.DecodeRIM(data)
+	case "sv":
+		img, _, err := saveimage.Decode(words)
+		return img, err
+	case "raw":
+		if int(origin)+len(words) > core.Size {
+			return nil, fmt.Errorf("%d words do not fit in memory at %v", len(words), origin)
 		}
-		if w&0400 != 0 {
-			return fmt.Sprintf("%s [%04o]", ops[op], addr)
+		img := core.New()
+		for i, w := range words {
+			img.Load(origin+core.Addr(i), w)
 		}
-		return fmt.Sprintf("%s %04o", ops[op], addr)
-	}
-	switch {
-	case w&07000 == 06000:
-		return decodeiot(a, w)
-	case w&07400 == 07000:
-		return decode1(a, w)
-	case w&07401 == 07400:
-		return decode2(a, w)
-	case w&07401 == 07401:
-		return decodemq(a, w)
+		return img, nil
 	default:
-		return fmt.Sprintf("%04o", w)
+		return nil, fmt.Errorf("unknown type: %s", ftype)
 	}
 }
 
-func decode1(a, w uint16) string {
-	var parts []string
-	if w == 7000 {
-		return "NOP"
-	}
-	if w&0200 != 0 {
-		parts = append(parts, "CLA")
-	}
-	if w&0100 != 0 {
-		parts = append(parts, "CLL")
-	}
-	if w&0040 != 0 {
-		parts = append(parts, "CMA")
-	}
-	if w&0020 != 0 {
-		parts = append(parts, "CML")
-	}
-	if w&0001 != 0 {
-		parts = append(parts, "IAC")
-	}
-	switch w & 0016 {
-	case 0000:
-	case 0002:
-		parts = append(parts, "BSW")
-	case 0010:
-		parts = append(parts, "RAR")
-	case 0012:
-		parts = append(parts, "RTR")
-	case 0004:
-		parts = append(parts, "RAL")
-	case 0006:
-		parts = append(parts, "RTL")
-	case 0014:
-		parts = append(parts, "RARL?")
-	case 0016:
-		parts = append(parts, "RTRL?")
-	}
-	return strings.Join(parts, " ")
+// entryPoint returns the entry point of img, decoded from a file of type
+// ftype with the contents data.  It is the starting address of a core image,
+// the first origin on a paper tape, or origin, the load address of a raw file.
+func entryPoint(img *core.Image, ftype string, data []byte, origin core.Addr) core.Addr {
+	switch {
+	case img.HasStart:
+		return img.Start
+	case ftype == "bin" || ftype == "rim":
+		if a, ok := papertape.Origin(data); ok {
+			return a
+		}
+		if r := img.Ranges(); len(r) > 0 {
+			return r[0].Start
+		}
+	}
+	return origin
 }
 
-func decode2(a, w uint16) string {
-	var parts []string
-	if w == 7400 || w == 7410 {
-		return "NOP"
-	}
-	jmps := []string{"SMA", "SZA", "SNL"}
-	if w&010 != 0 {
-		jmps = []string{"SPA", "SNA", "SZL"}
-	}
-	if w&0100 != 0 {
-		parts = append(parts, jmps[0])
-	}
-	if w&0040 != 0 {
-		parts = append(parts, jmps[1])
-	}
-	if w&0020 != 0 {
-		parts = append(parts, jmps[2])
+// writeListing writes the disassembly of the addresses lo through hi of img
+// to w.  Code and data are separated by fl unless fl is nil, in which case
+// every word is decoded as an instruction.
+func writeListing(w io.Writer, img *core.Image, fl *flow, lo, hi core.Addr) {
+	var code map[core.Addr]bool
+	if fl != nil {
+		code = fl.code
+	}
+	written := stores(img, fl)
+	fields := img.Fields()
+	qualify := len(fields) > 1 || (len(fields) == 1 && fields[0] != 0)
+	for _, f := range fields {
+		p := newPALField(img, f, lo, hi, code)
+		start, end := p.start, p.end
+		for a := start; a < end; {
+			if !img.Loaded(a) {
+				b := a
+				for b < end && !img.Loaded(b) {
+					b++
+				}
+				fmt.Fprintf(w, "; %v not loaded\n", core.Range{Start: a, End: b})
+				a = b
+				continue
+			}
+			word := img.Word(a)
+			addr := fmt.Sprintf("%04o", a.Offset())
+			if qualify {
+				addr = a.String()
+			}
+			text, legal := decoder.Decode(a.Offset(), word)
+			if name, ok := symbol(a); ok {
+				fmt.Fprintf(w, "%s,\n", name)
+			}
+			if code == nil || code[a] {
+				t, _ := disasm.Target(a, word)
+				e, ok := effective(img, fl, written, a)
+				if ok && word&0400 == 0 {
+					t = e
+				}
+				text = withSymbol(word, text, t)
+				if ok && (word&0400 != 0 || e.Field() != a.Field()) {
+					text += fmt.Sprintf(" ; %v", e)
+				}
+			}
+			switch {
+			case code == nil:
+				if !legal {
+					text += " ; illegal"
+				}
+			case p.kind[a] == palText:
+				text = p.text[a]
+			case p.kind[a] == palTextCont:
+				text = ""
+			case !code[a]:
+				text = fmt.Sprintf(".WORD %04o", word)
+			case !legal:
+				text += " ; illegal"
+			}
+			fmt.Fprintf(w, "%s: %04o %-30s %2s\n", addr, word, text, os8fs.ASCII6(word))
+			a++
+		}
 	}
-	if w&0200 != 0 {
-		parts = append(parts, "CLA")
+}
+
+// withSymbol returns text, the decoded instruction w, with the address of a
+// memory reference instruction replaced by the symbol for t, the address it
+// refers to.
+func withSymbol(w uint16, text string, t core.Addr) string {
+	if w>>9 >= 6 {
+		return text
 	}
-	if w&0004 != 0 {
-		parts = append(parts, "OSR")
+	name, ok := symbol(t)
+	if !ok {
+		return text
 	}
-	if w&0002 != 0 {
-		parts = append(parts, "HLT")
+	if w&0400 != 0 {
+		return fmt.Sprintf("%s [%s]", ops[w>>9], name)
 	}
-	return strings.Join(parts, " ")
+	return fmt.Sprintf("%s %s", ops[w>>9], name)
 }
 
-func decodemq(a, w uint16) string {
-	if w == 7401 {
-		return "NOP"
-	}
-	A := decodeA(a, w)
-	B := decodeB(a, w)
-	if A == B {
-		return A
+// readSymbols adds the symbols in files, a comma separated list of files, to
+// symbols.  Each file is parsed by parse.
+func readSymbols(files string, parse func([]byte) (*symtab.Table, error)) error {
+	if files == "" {
+		return nil
+	}
+	for _, path := range strings.Split(files, ",") {
+		name, text, err := readText(path)
+		if err != nil {
+			return err
+		}
+		t, err := parse(text)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		symbols.Merge(t)
 	}
-	return A + "/" + "B"
+	return nil
 }
 
-func decodeA(a, w uint16) string {
-	var parts []string
-	if w&0200 != 0 {
-		parts = append(parts, "CLA")
-	}
-	if w&0100 != 0 {
-		parts = append(parts, "MQA")
-	}
-	if w&0040 != 0 {
-		parts = append(parts, "SCA")
-	}
-	if w&0020 != 0 {
-		parts = append(parts, "MQL")
+// readText returns the name and contents of the text file path.  A file on an
+// OS/8 image is converted to host text.
+func readText(path string) (name string, text []byte, err error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		text, err := os.ReadFile(path)
+		return path, text, err
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return "", nil, err
 	}
-	op := (w >> 1) & 7
-	if op == 4 {
-		if len(parts) > 0 {
-			return fmt.Sprintf("%04o", w)
+	return f.Name(), f.Text(), nil
+}
+
+// readDevices returns the device table made from tables, a comma separated
+// list of device table files.  The name default is the standard table.
+func readDevices(tables string) (disasm.DeviceTable, error) {
+	var ts []disasm.DeviceTable
+	for _, path := range strings.Split(tables, ",") {
+		if path == "default" {
+			ts = append(ts, disasm.DefaultDevices)
+			continue
 		}
-		return "NMI"
-	}
-	ops := []string{"NOP", "SCL", "MUY", "DVI", "NMI", "SHL", "ASR", "LSR"}
-	if op > 0 {
-		parts = append(parts, ops[op])
+		t, err := disasm.ReadDevices(path)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
 	}
-	return strings.Join(parts, " ")
+	return disasm.MergeDevices(ts...), nil
 }
 
-var specialB = map[uint16]string{
-	07763: "DLD",
-	07445: "DST",
-	07443: "DAD",
-	07573: "DPIC",
-	07575: "DCM",
-	07451: "DPSZ",
+// detect returns the type of file, sv, bin, rim, or raw, as determined by its
+// contents.
+func detect(data []byte, words []uint16) string {
+	if _, err := saveimage.DecodeCCB(words); err == nil {
+		return "sv"
+	}
+	switch papertape.Detect(data) {
+	case papertape.BIN:
+		return "bin"
+	case papertape.RIM:
+		return "rim"
+	}
+	return "raw"
 }
 
-func decodeB(a, w uint16) string {
-	if op, ok := specialB[w]; ok {
-		return op
-	}
-	var parts []string
-	op := ((w >> 1) & 7) | ((w & 0040) >> 2)
-	ops := []string{"NOP", "ACS", "MUY", "DVI", "NMI", "SHL", "ASR", "LSR", "SCA", "DAD", "DST", "SWBA", "DPSZ", "DPIC", "DCM", "SAM"}
-	if op == 4 {
-		if len(parts) > 0 {
-			return fmt.Sprintf("%04o", w)
-		}
-		return "NMI"
+// parseRange parses s as START-END, or START, returning the range of addresses
+// to disassemble.  The returned end follows the last address.
+func parseRange(s string) (start, end core.Addr, err error) {
+	ends := ""
+	if x := strings.Index(s, "-"); x >= 0 {
+		s, ends = s[:x], s[x+1:]
 	}
-	if op == 015 || op == 016 {
-		if w&0120 != 0 {
-			return fmt.Sprintf("%04o", w)
-		}
+	if start, err = core.ParseAddr(s); err != nil {
+		return 0, 0, err
 	}
-	if w&0200 != 0 {
-		parts = append(parts, "CLA")
+	if ends == "" {
+		return start, core.Size, nil
 	}
-	if w&0100 != 0 {
-		parts = append(parts, "MBA")
+	if !strings.Contains(ends, ":") {
+		ends = fmt.Sprintf("%o:%s", start.Field(), ends)
 	}
-	if w&0020 != 0 {
-		parts = append(parts, "MQL")
+	if end, err = core.ParseAddr(ends); err != nil {
+		return 0, 0, err
 	}
-	if op > 0 {
-		parts = append(parts, ops[op])
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range: %s-%s", s, ends)
 	}
-	return strings.Join(parts, " ")
+	return start, end + 1, nil
 }
 
-func decodeiot(a, w uint16) string {
-	dev := w >> 3 & 077
-	iot := w & 07
-	return fmt.Sprintf("IOT DEV%02o %o", dev, iot)
-}
-
-var fixed = map[uint16]string{
-	06000: "SKON",
-	06001: "ION",
-	06002: "IOF",
-	06003: "SRQ",
-	06004: "GTF",
-	06005: "RTF",
-	06006: "SGT",
-	06007: "CAF",
-	06010: "RPE",
-	06011: "RSF",
-	06012: "RRB",
-	06014: "RCF",
-	06016: "RCC",
-	06020: "PCE",
-	06021: "PSF",
-	06022: "PCF",
-	06024: "PPC",
-	06026: "PLS",
-	06030: "KCF",
-	06031: "KSF",
-	06032: "KCC",
-	06034: "KRS",
-	06035: "KIE",
-	06036: "KRB",
-	06040: "SPF",
-	06041: "TSF",
-	06042: "TCF",
-	06044: "TPC",
-	06045: "SPI",
-	06046: "TLS",
-	06601: "DCMA",
-	06603: "DMAR",
-	06605: "DMAW",
-	06611: "DCEA",
-	06612: "DSAC",
-	06615: "DEAL",
-	06616: "DEAC",
-	06621: "DFSE",
-	06622: "DFSC",
-	06626: "DMAC",
-	06761: "DTRA",
-	06762: "DTCA",
-	06764: "DTXA",
-	06771: "DTSF",
-	06772: "DTRB",
-	06774: "DTLB",
-	07403: "SCL/ASC",
-	07431: "SWAB",
-	07447: "SWBA",
-	07457: "SAM",
-}
-
-func skipHeader(data []byte) []byte {
-	for i, c := range data {
-		if c&0x80 == 0 {
-			fmt.Printf("skipped %d\n", i)
-			return data[i:]
-		}
+// fieldWindow returns the addresses of field f to disassemble.  The window
+// starts at the first loaded word in f and ends after the last loaded word in
+// f, limited to lo through hi.
+func fieldWindow(img *core.Image, f int, lo, hi core.Addr) (start, end core.Addr) {
+	start = core.MakeAddr(f, 0)
+	end = start + core.FieldSize
+	for start < end && !img.Loaded(start) {
+		start++
 	}
-	return nil
-}
-
-type block struct {
-	start int
-	words []uint16
+	for end > start && !img.Loaded(end-1) {
+		end--
+	}
+	if start < lo {
+		start = lo
+	}
+	if end > hi {
+		end = hi
+	}
+	return start, end
 }
 
-func readBin(data []byte) (int, []uint16) {
-	data = skipHeader(data)
-	if len(data) == 0 {
-		return 0, nil
-	}
-	var addr uint16
-	var blocks []block
-	var b block
-	for i := 0; i+1 < len(data); i += 2 {
-		if data[i]&0200 != 0 {
-			break
-		}
-		if data[i]&0100 != 0 {
-			addr = (uint16(data[i]&077) << 6) | uint16(data[i+1]&077)
-			if len(b.words) > 0 {
-				blocks = append(blocks, b)
-			}
-			b.start = int(addr)
-			b.words = nil
-			continue
-		}
-		value := (uint16(data[i]&077) << 6) | uint16(data[i+1]&077)
-		b.words = append(b.words, value)
-	}
-	if len(b.words) > 0 {
-		blocks = append(blocks, b)
-	}
-	if len(blocks) == 0 {
-		return 0, nil
-	}
-	lb := blocks[len(blocks)-1]
-	if len(lb.words) > 0 {
-		lb.words = lb.words[:len(lb.words)-1]
-		blocks[len(blocks)-1] = lb
-	}
-	start := blocks[0].start
-	stop := blocks[0].start + len(blocks[0].words)
-	for _, b := range blocks[1:] {
-		t := b.start
-		p := b.start + len(b.words)
-		if t < start {
-			start = t
+// readFile returns the name and contents of the file path.  The contents are
+// returned both as bytes (paper tape frames) and as 12 bit words.  If path is a
+// file on the host then it is read from the host, the words are taken as 2
+// bytes per word, just as in a disk image.  Otherwise path is read from a disk
+// image.
+func readFile(path string) (name string, data []byte, words []uint16, err error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, nil, err
 		}
-		if p > stop {
-			stop = p
+		words := make([]uint16, len(data)/2)
+		for i := range words {
+			words[i] = (uint16(data[i*2]) | uint16(data[i*2+1])<<8) & 07777
 		}
+		return strings.ToUpper(filepath.Base(path)), data, words, nil
 	}
-	mem := make([]uint16, stop-start)
-	for _, b := range blocks {
-		copy(mem[b.start-start:], b.words)
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return "", nil, nil, err
 	}
-	return start, mem
+	return f.Name(), f.ASCII(false), f.Words(), nil
 }
+
+var ops = []string{"AND", "TAD", "ISZ", "DCA", "JMS", "JMP", "IOT", "OPR"}
@@ -0,0 +1,166 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/pal"
+	"github.com/pborman/pdp8/papertape"
+	"github.com/pborman/pdp8/saveimage"
+)
+
+// assemble returns the image assembled from the PAL8 source src.
+func assemble(t *testing.T, src string) *core.Image {
+	t.Helper()
+	p, err := pal.Assemble([]byte(src))
+	if err != nil {
+		t.Fatalf("%v: %v", err, p.Errors)
+	}
+	return p.Image
+}
+
+// bytesOf returns words as a host file, 2 bytes per word.
+func bytesOf(words []uint16) []byte {
+	var data []byte
+	for _, w := range words {
+		data = append(data, byte(w), byte(w>>8))
+	}
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	img := core.New()
+	img.Load(0200, 07300)
+	img.Load(0201, 07402)
+	img.Load(010200, 01234)
+	img.Start, img.HasStart = 0200, true
+	sv, err := saveimage.Encode(img, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rim, err := papertape.EncodeRIM(assemble(t, "*200\n\tCLA CLL\n\tHLT\n$\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		data  []byte
+		words []uint16
+		ftype string
+		want  map[core.Addr]uint16
+	}{
+		{"bin", papertape.EncodeBIN(img), nil, "bin", map[core.Addr]uint16{0200: 07300, 0201: 07402, 010200: 01234}},
+		{"rim", rim, nil, "rim", map[core.Addr]uint16{0200: 07300, 0201: 07402}},
+		{"sv", bytesOf(sv), sv, "sv", map[core.Addr]uint16{0200: 07300, 0201: 07402, 010200: 01234}},
+		{"raw", bytesOf([]uint16{01234, 07402}), []uint16{01234, 07402}, "raw", map[core.Addr]uint16{0400: 01234, 0401: 07402}},
+	} {
+		if ftype := detect(tt.data, tt.words); ftype != tt.ftype {
+			t.Errorf("%s: detected %s", tt.name, ftype)
+		}
+		got, err := decode(tt.ftype, tt.data, tt.words, 0400)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// Save images load whole pages.
+		if got.Len() != len(tt.want) && tt.ftype != "sv" {
+			t.Errorf("%s: got %v", tt.name, got.Ranges())
+		}
+		for a, w := range tt.want {
+			if got.Word(a) != w || !got.Loaded(a) {
+				t.Errorf("%s: %v: got %04o, want %04o", tt.name, a, got.Word(a), w)
+			}
+		}
+	}
+	if _, err := decode("raw", nil, make([]uint16, 2), 077777); err == nil {
+		t.Error("raw file past the end of memory was accepted")
+	}
+	if _, err := decode("xyz", nil, nil, 0); err == nil {
+		t.Error("unknown type was accepted")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		in         string
+		start, end core.Addr
+		err        bool
+	}{
+		{in: "200", start: 0200, end: core.Size},
+		{in: "200-277", start: 0200, end: 0300},
+		{in: "1:200-377", start: 010200, end: 010400},
+		{in: "1:200-2:0", start: 010200, end: 020001},
+		{in: "300-200", err: true},
+		{in: "x", err: true},
+	} {
+		start, end, err := parseRange(tt.in)
+		switch {
+		case tt.err:
+			if err == nil {
+				t.Errorf("%s: got %v-%v, want error", tt.in, start, end)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.in, err)
+		case start != tt.start || end != tt.end:
+			t.Errorf("%s: got %v-%v, want %v-%v", tt.in, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestListing(t *testing.T) {
+	img := assemble(t, `*200
+START,	CLA
+	HLT
+*210
+	TAD 211
+	3
+	FIELD 1
+*200
+	JMP 200
+$
+`)
+	for _, tt := range []struct {
+		name   string
+		flow   bool
+		lo, hi core.Addr
+		want   []string
+	}{{
+		name: "flow",
+		flow: true,
+		lo:   0,
+		hi:   core.Size,
+		want: []string{
+			"0:0200: 7200 CLA                            :@",
+			"0:0201: 7402 HLT                            <B",
+			"; 0:0202-0207 not loaded",
+			"0:0210: 1211 .WORD 1211                     JI",
+			"0:0211: 0003 .WORD 0003                     @C",
+			"1:0200: 5200 .WORD 5200                     *@",
+		},
+	}, {
+		name: "every word",
+		lo:   0210,
+		hi:   010200,
+		want: []string{
+			"0:0210: 1211 TAD 0211                       JI",
+			"0:0211: 0003 AND 0003                       @C",
+		},
+	}} {
+		var fl *flow
+		if tt.flow {
+			fl = analyze(img, []core.Addr{0200})
+		}
+		var b bytes.Buffer
+		writeListing(&b, img, fl, tt.lo, tt.hi)
+		if want := strings.Join(tt.want, "\n") + "\n"; b.String() != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, b.String(), want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"sort"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/disasm"
+)
+
+// A flow is a recursive descent analysis of a program.  Starting at the entry
+// points it follows the possible paths of execution, marking each word that
+// is reached as code.  Words that are never reached are data.
+//
+// Execution falls through to the next word except that:
+//
+//   - JMP continues at its target.  JMP I continues at the contents of the
+//     pointer, but only if the pointer is known: it is loaded, is not the
+//     return address of a subroutine, and is not modified by DCA or ISZ.
+//   - ISZ, skip microinstructions, and IOTs with the skip bit (1) set may skip
+//     the next word.  SKP always skips the next word.  ISZ of the return
+//     address of a subroutine does not skip.
+//   - HLT stops execution.
+//   - JMS continues at the word after its target, the word at the target
+//     holding the return address.  JMP I through the return address is the
+//     return from the subroutine and is not followed.  Execution continues
+//     after the JMS only if the subroutine only uses its return address to
+//     return (JMP I) or skip return (ISZ).  Subroutines that use the return
+//     address any other way, such as to read arguments following the JMS,
+//     return to an unknown location.
+//
+// The instruction and data fields are followed through straight-line code.
+// At an entry point both are the field of the entry point.  CDF changes the
+// data field.  CIF changes the instruction buffer, which becomes the
+// instruction field at the next JMP or JMS.  RMF makes the data field
+// unknown.  Execution after a JMS returns with the data field it was called
+// with.  When a word is reached along more than one path the fields of the
+// first path are used.
+type flow struct {
+	img    *core.Image
+	code   map[core.Addr]bool
+	fields map[core.Addr]fields    // fields when each word of code executes
+	subs   map[core.Addr]bool      // return address words of subroutines
+	calls  map[core.Addr]core.Addr // JMS to a subroutine not yet returned from
+	jumps  map[core.Addr]core.Addr // JMP I through a pointer not yet known
+	work   []item
+}
+
+// fields are the data field and the instruction buffer.  A negative df means
+// the data field is not known.
+type fields struct {
+	df, ib int
+}
+
+// An item is an address to be visited with the fields when it executes.
+type item struct {
+	a core.Addr
+	f fields
+}
+
+// analyze follows the flow of control of img from entries.
+func analyze(img *core.Image, entries []core.Addr) *flow {
+	fl := &flow{
+		img:    img,
+		code:   map[core.Addr]bool{},
+		fields: map[core.Addr]fields{},
+		subs:   map[core.Addr]bool{},
+		calls:  map[core.Addr]core.Addr{},
+		jumps:  map[core.Addr]core.Addr{},
+	}
+	for _, a := range entries {
+		fl.add(a, fields{df: a.Field(), ib: a.Field()})
+	}
+	for len(fl.work) > 0 {
+		for len(fl.work) > 0 {
+			it := fl.work[len(fl.work)-1]
+			fl.work = fl.work[:len(fl.work)-1]
+			if !img.Loaded(it.a) || fl.code[it.a] {
+				continue
+			}
+			fl.code[it.a] = true
+			fl.fields[it.a] = it.f
+			fl.step(it.a, img.Word(it.a))
+		}
+		// Returns and indirect jumps can only be resolved once all the
+		// code that might use the return address or modify the pointer
+		// has been found.
+		for _, a := range sortedKeys(fl.calls) {
+			sub := fl.calls[a]
+			if ok, skip := fl.returns(sub); ok {
+				f := fields{df: fl.fields[a].df, ib: a.Field()}
+				fl.push(a, 1, f)
+				if skip {
+					fl.push(a, 2, f)
+				}
+				delete(fl.calls, a)
+			}
+		}
+		for _, a := range sortedKeys(fl.jumps) {
+			p, f := fl.jumps[a], fl.fields[a]
+			if t, ok := fl.pointer(p, f.ib); ok {
+				fl.add(t, f)
+				delete(fl.jumps, a)
+			}
+		}
+	}
+	return fl
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[core.Addr]core.Addr) []core.Addr {
+	keys := make([]core.Addr, 0, len(m))
+	for a := range m {
+		keys = append(keys, a)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// add adds a, executing with the fields f, to the work list.
+func (fl *flow) add(a core.Addr, f fields) {
+	fl.work = append(fl.work, item{a: a, f: f})
+}
+
+// push adds the word n words after a, in the same field, to the work list.
+func (fl *flow) push(a core.Addr, n uint16, f fields) {
+	fl.add(core.MakeAddr(a.Field(), a.Offset()+n), f)
+}
+
+// call records a JMS at a to the subroutine with the return address word sub,
+// called with the data field df.
+func (fl *flow) call(a, sub core.Addr, df int) {
+	fl.subs[sub] = true
+	fl.calls[a] = sub
+	fl.push(sub, 1, fields{df: df, ib: sub.Field()})
+}
+
+// step adds the possible successors of the instruction w at a to the work
+// list.
+func (fl *flow) step(a core.Addr, w uint16) {
+	f := fl.fields[a]
+	t, _ := disasm.Target(a, w)
+	switch w & 07400 {
+	case 04000: // JMS
+		fl.call(a, core.MakeAddr(f.ib, t.Offset()), f.df)
+	case 04400: // JMS I
+		if sub, ok := fl.pointer(t, f.ib); ok {
+			fl.call(a, sub, f.df)
+		} else {
+			fl.push(a, 1, fields{df: f.df, ib: a.Field()})
+		}
+	case 05400: // JMP I
+		if !fl.subs[t] {
+			fl.jumps[a] = t
+		}
+	default:
+		f = f.after(w)
+		for _, n := range fl.next(a, w) {
+			fl.add(n, f)
+		}
+	}
+}
+
+// after returns the fields after executing the instruction w with the fields
+// f.
+func (f fields) after(w uint16) fields {
+	if w&07700 != 06200 {
+		return f
+	}
+	field := int(w>>3) & 7
+	switch w & 07 {
+	case 1: // CDF
+		f.df = field
+	case 2: // CIF
+		f.ib = field
+	case 3: // CDF CIF
+		f.df, f.ib = field, field
+	case 4:
+		if w == 06244 { // RMF
+			f.df = -1
+		}
+	}
+	return f
+}
+
+// next returns the possible successors of the instruction w at a, within the
+// subroutine containing a.  A JMS is followed by its returns.
+func (fl *flow) next(a core.Addr, w uint16) []core.Addr {
+	at := func(n uint16) core.Addr {
+		return core.MakeAddr(a.Field(), a.Offset()+n)
+	}
+	op := w >> 9
+	if op < 6 {
+		t, _ := disasm.Target(a, w)
+		switch w & 07400 {
+		case 02000, 02400: // ISZ
+			// Incrementing a return address never skips.
+			if fl.subs[t] {
+				return []core.Addr{at(1)}
+			}
+			return []core.Addr{at(1), at(2)}
+		case 04000, 04400: // JMS
+			sub, ok := fl.callee(a)
+			if !ok {
+				return []core.Addr{at(1)}
+			}
+			switch ok, skip := fl.returns(sub); {
+			case skip:
+				return []core.Addr{at(1), at(2)}
+			case ok:
+				return []core.Addr{at(1)}
+			}
+			return nil
+		case 05000: // JMP
+			return []core.Addr{core.MakeAddr(fl.fields[a].ib, t.Offset())}
+		case 05400: // JMP I
+			if fl.subs[t] {
+				return nil
+			}
+			if p, ok := fl.pointer(t, fl.fields[a].ib); ok {
+				return []core.Addr{p}
+			}
+			return nil
+		}
+		return []core.Addr{at(1)}
+	}
+	switch {
+	case op == 6: // IOT
+		if w&1 != 0 && w != 06001 && w&07700 != 06200 {
+			return []core.Addr{at(1), at(2)}
+		}
+	case w&0401 == 0400: // group 2
+		switch {
+		case w&0002 != 0: // HLT
+			return nil
+		case w&0160 != 0:
+			return []core.Addr{at(1), at(2)}
+		case w&0010 != 0: // SKP
+			return []core.Addr{at(2)}
+		}
+	}
+	return []core.Addr{at(1)}
+}
+
+// callee returns the return address word of the subroutine called by the JMS
+// at a.
+func (fl *flow) callee(a core.Addr) (core.Addr, bool) {
+	w := fl.img.Word(a)
+	t, _ := disasm.Target(a, w)
+	ib := fl.fields[a].ib
+	if w&0400 == 0 {
+		return core.MakeAddr(ib, t.Offset()), true
+	}
+	return fl.pointer(t, ib)
+}
+
+// refs calls fn for each memory reference instruction found so far that
+// refers to t, not counting indirection.
+func (fl *flow) refs(t core.Addr, fn func(a core.Addr, w uint16)) {
+	for a := range fl.code {
+		w := fl.img.Word(a)
+		if r, ok := disasm.Target(a, w); ok && r == t {
+			fn(a, w)
+		}
+	}
+}
+
+// returns reports if the subroutine with the return address word sub returns
+// to the word following the JMS, and if it may also skip return.
+func (fl *flow) returns(sub core.Addr) (ok, skip bool) {
+	ok = true
+	fl.refs(sub, func(a core.Addr, w uint16) {
+		switch w & 07400 {
+		case 05400: // JMP I
+		case 04000: // JMS, the call itself
+		case 02000: // ISZ
+			skip = true
+		default:
+			ok = false
+		}
+	})
+	return ok, skip
+}
+
+// pointer returns the address, in field, held in the pointer p if the pointer
+// is known.
+func (fl *flow) pointer(p core.Addr, field int) (core.Addr, bool) {
+	if !fl.img.Loaded(p) || fl.subs[p] {
+		return 0, false
+	}
+	known := true
+	fl.refs(p, func(a core.Addr, w uint16) {
+		if op := w & 07400; op == 02000 || op == 03000 {
+			known = false
+		}
+	})
+	return core.MakeAddr(field, fl.img.Word(p)), known
+}
+
+// stores returns the set of words written by the DCA and ISZ instructions of
+// the code found by fl, not counting indirection.  If fl is nil every word of
+// img is considered code.
+func stores(img *core.Image, fl *flow) map[core.Addr]bool {
+	written := map[core.Addr]bool{}
+	for _, r := range img.Ranges() {
+		for a := r.Start; a < r.End; a++ {
+			if fl != nil && !fl.code[a] {
+				continue
+			}
+			w := img.Word(a)
+			if op := w & 07400; op != 02000 && op != 03000 {
+				continue
+			}
+			if t, ok := disasm.Target(a, w); ok {
+				written[t] = true
+			}
+		}
+	}
+	return written
+}
+
+// effective returns the effective address of the memory reference instruction
+// at a, following indirection through a loaded pointer that is not in
+// written, the words written by the program (see stores).  The fields are
+// those found by fl.  If fl is nil both fields are assumed to be the field of
+// a.  The effective address of JMP I through the return address of a
+// subroutine is not known.
+func effective(img *core.Image, fl *flow, written map[core.Addr]bool, a core.Addr) (core.Addr, bool) {
+	w := img.Word(a)
+	f := fields{df: a.Field(), ib: a.Field()}
+	if fl != nil {
+		f = fl.fields[a]
+	}
+	read := func(p core.Addr) (uint16, bool) {
+		if written[p] || (fl != nil && fl.subs[p]) {
+			return 0, false
+		}
+		return img.Word(p), img.Loaded(p)
+	}
+	return disasm.Effective(a, w, f.df, f.ib, read)
+}
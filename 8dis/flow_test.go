@@ -0,0 +1,141 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/papertape"
+)
+
+// flowSource has a skip returning subroutine, a table of data between the
+// code, a jump through a known pointer, and a jump through a pointer that is
+// written by the program.
+const flowSource = `*400
+START,	CLA
+	JMS SUB
+	JMP ERR
+	JMP I PTR
+PTR,	NEXT
+TABLE,	1234
+	5670
+SUB,	0
+	ISZ SUB
+	JMP I SUB
+ERR,	HLT
+NEXT,	TAD VAL
+	DCA VEC
+	JMP I VEC
+VEC,	LOST
+VAL,	ERR
+LOST,	CLA
+	HLT
+$
+`
+
+func TestEntryPoint(t *testing.T) {
+	tape := papertape.EncodeBIN(assemble(t, flowSource))
+	img, err := papertape.DecodeBIN(tape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := entryPoint(img, "bin", tape, 0); a != 0400 {
+		t.Errorf("BIN tape: got %v, want 0:0400", a)
+	}
+
+	tape = papertape.EncodeBIN(assemble(t, "\tFIELD 2\n*3000\n\tHLT\n$\n"))
+	if img, err = papertape.DecodeBIN(tape); err != nil {
+		t.Fatal(err)
+	}
+	if a := entryPoint(img, "bin", tape, 0); a != 023000 {
+		t.Errorf("field 2 tape: got %v, want 2:3000", a)
+	}
+
+	img.Start, img.HasStart = 023001, true
+	if a := entryPoint(img, "sv", nil, 0); a != 023001 {
+		t.Errorf("core image: got %v, want 2:3001", a)
+	}
+	if a := entryPoint(core.New(), "raw", nil, 01000); a != 01000 {
+		t.Errorf("raw file: got %v, want 0:1000", a)
+	}
+}
+
+func TestFlow(t *testing.T) {
+	tape := papertape.EncodeBIN(assemble(t, flowSource))
+	img, err := papertape.DecodeBIN(tape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := analyze(img, []core.Addr{entryPoint(img, "bin", tape, 0)})
+	code := map[core.Addr]bool{
+		0400: true, // START
+		0401: true,
+		0402: true, // reached by the normal return from SUB
+		0403: true, // reached by the skip return from SUB
+		0410: true, // SUB+1
+		0411: true,
+		0412: true, // ERR
+		0413: true, // NEXT, through PTR
+		0414: true,
+		0415: true,
+	}
+	for a := core.Addr(0400); a <= 0421; a++ {
+		if fl.code[a] != code[a] {
+			t.Errorf("%v: got code %v, want %v", a, fl.code[a], code[a])
+		}
+	}
+	if !fl.subs[0407] || len(fl.subs) != 1 {
+		t.Errorf("got subroutines %v, want 0:0407", fl.subs)
+	}
+}
+
+func TestFlowFields(t *testing.T) {
+	img := assemble(t, `	FIELD 1
+*200
+	CDF 20
+	CIF 30
+	JMP 300
+*300
+	HLT
+	FIELD 3
+*300
+	TAD I 310
+	JMS 320
+	HLT
+*310
+	320
+*320
+	0
+	RMF
+	JMP I 320
+$
+`)
+	fl := analyze(img, []core.Addr{010200})
+	for _, tt := range []struct {
+		a      core.Addr
+		df, ib int
+	}{
+		{010200, 1, 1},
+		{010201, 2, 1},
+		{010202, 2, 3},
+		{030300, 2, 3},
+		{030301, 2, 3},
+		{030302, 2, 3}, // the data field is restored after JMS
+		{030322, -1, 3},
+	} {
+		if !fl.code[tt.a] {
+			t.Errorf("%v: not code", tt.a)
+			continue
+		}
+		if f := fl.fields[tt.a]; f.df != tt.df || f.ib != tt.ib {
+			t.Errorf("%v: got df %d ib %d, want %d %d", tt.a, f.df, f.ib, tt.df, tt.ib)
+		}
+	}
+	if fl.code[010300] {
+		t.Error("JMP after CIF went to the wrong field")
+	}
+}
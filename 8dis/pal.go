@@ -0,0 +1,544 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/disasm"
+	"github.com/pborman/pdp8/symtab"
+)
+
+// PAL8 output
+//
+// The source produced by writePAL assembles with PAL8 to the same words at the
+// same addresses as the original image.  To make sure of this every symbolic
+// form is encoded again and compared to the original word.  Words that cannot
+// be reproduced symbolically are written as octal constants.
+//
+// PAL8 places current page literals at the top of the page, starting at 0177
+// of the page and working down, in the order they are first used.  Identical
+// literals on the same page share a word.  Page zero literals are placed the
+// same way at the top of page zero of the field.  A literal pool is only
+// recognized if assigning the literals in this order reproduces the pool
+// exactly.
+
+// palSymbols are the PAL8 permanent symbols used in symbolic output.
+var palSymbols = map[string]uint16{
+	// Group 1 operate microinstructions
+	"NOP": 07000, "IAC": 07001, "BSW": 07002, "RAL": 07004, "RTL": 07006,
+	"RAR": 07010, "RTR": 07012, "CML": 07020, "CMA": 07040, "CIA": 07041,
+	"CLL": 07100, "STL": 07120, "CLA": 07200, "GLK": 07204, "STA": 07240,
+
+	// Group 2 operate microinstructions
+	"HLT": 07402, "OSR": 07404, "SKP": 07410, "SNL": 07420, "SZL": 07430,
+	"SZA": 07440, "SNA": 07450, "SMA": 07500, "SPA": 07510,
+
+	// Group 3 (MQ) microinstructions
+	"MQL": 07421, "SCA": 07441, "MQA": 07501, "SWP": 07521, "CAM": 07621,
+
+	// Interrupts and the console
+	"SKON": 06000, "ION": 06001, "IOF": 06002, "SRQ": 06003,
+	"GTF": 06004, "RTF": 06005, "SGT": 06006, "CAF": 06007,
+	"RPE": 06010, "RSF": 06011, "RRB": 06012, "RFC": 06014,
+	"PCE": 06020, "PSF": 06021, "PCF": 06022, "PPC": 06024, "PLS": 06026,
+	"KCF": 06030, "KSF": 06031, "KCC": 06032, "KRS": 06034, "KIE": 06035, "KRB": 06036,
+	"TFL": 06040, "TSF": 06041, "TCF": 06042, "TPC": 06044, "SPI": 06045, "TLS": 06046,
+
+	// Memory extension, the field is or'ed in as an octal number
+	"CDF": 06201, "CIF": 06202, "RDF": 06214, "RIF": 06224, "RIB": 06234, "RMF": 06244,
+}
+
+// A palKind is how a word is written in PAL8 source.
+type palKind int
+
+const (
+	palData     = palKind(iota) // octal constant
+	palInstr                    // instruction
+	palLiteral                  // memory reference to a literal
+	palPool                     // literal, generated by the assembler
+	palPointer                  // address of a label
+	palText                     // first word of a TEXT string
+	palTextCont                 // remaining words of a TEXT string
+)
+
+// palField holds the analysis of a single field of an image.
+type palField struct {
+	img        *core.Image
+	start, end core.Addr // window to write
+	kind       map[core.Addr]palKind
+	text       map[core.Addr]string // TEXT strings by first word
+	textLen    map[core.Addr]int    // words in each TEXT string
+	labels     map[core.Addr]bool
+	defs       map[core.Addr]bool // unlabeled targets defined as symbols
+	code       map[core.Addr]bool // words that are code, nil if unknown
+}
+
+// newPALField returns the analysis of field f of img limited to lo through
+// hi.  If code is not nil only the words in code are treated as instructions.
+func newPALField(img *core.Image, f int, lo, hi core.Addr, code map[core.Addr]bool) *palField {
+	start, end := fieldWindow(img, f, lo, hi)
+	p := &palField{
+		img:     img,
+		start:   start,
+		end:     end,
+		kind:    map[core.Addr]palKind{},
+		text:    map[core.Addr]string{},
+		textLen: map[core.Addr]int{},
+		labels:  map[core.Addr]bool{},
+		defs:    map[core.Addr]bool{},
+		code:    code,
+	}
+	p.literals()
+	p.findText()
+	direct, indirect := p.targets()
+	for p.dropText(direct, indirect) {
+		direct, indirect = p.targets()
+	}
+	p.classify(indirect)
+	for a := p.start; a < p.end; a++ {
+		if _, ok := symbols.Name(a); ok && p.loaded(a) && p.kind[a] != palPool && p.kind[a] != palTextCont {
+			p.labels[a] = true
+		}
+	}
+	return p
+}
+
+// symbols are the symbols read from symbol files, nil if none.
+var symbols *symtab.Table
+
+// Each symbol names at most one address in the output.  PAL8 symbols name
+// their address in every field.
+var (
+	symbolNames = map[core.Addr]string{}
+	symbolAddrs = map[string]core.Addr{}
+)
+
+// symbol returns the symbol for address a.  A symbol that has already been
+// used for a different address is not used again.
+func symbol(a core.Addr) (string, bool) {
+	if name, ok := symbolNames[a]; ok {
+		return name, true
+	}
+	name, ok := symbols.Name(a)
+	if !ok {
+		return "", false
+	}
+	if b, ok := symbolAddrs[name]; ok && b != a {
+		return "", false
+	}
+	symbolNames[a] = name
+	symbolAddrs[name] = a
+	return name, true
+}
+
+// label returns the label of address a, its symbol if it has one.
+func label(a core.Addr) string {
+	if name, ok := symbol(a); ok {
+		return name
+	}
+	return fmt.Sprintf("L%o%04o", a.Field(), a.Offset())
+}
+
+// encodeMRI returns the word PAL8 generates for the memory reference
+// instruction with the op code and indirect bit of w, at address a, that
+// refers to target.  PAL8 uses page zero addressing whenever target is on page
+// zero.
+func encodeMRI(a core.Addr, w uint16, target core.Addr) (uint16, bool) {
+	word := w & 07400
+	switch {
+	case target.Offset()&07600 == 0:
+		word |= target.Offset()
+	case target.Offset()&07600 == a.Offset()&07600:
+		word |= 0200 | target.Offset()&0177
+	default:
+		return 0, false
+	}
+	return word, true
+}
+
+// symbolic returns w, an operate or IOT instruction, as PAL8 source, or "" if
+// PAL8 would not generate w from the symbolic form.
+func symbolic(a core.Addr, w uint16) string {
+	text, legal := decoder.Decode(a.Offset(), w)
+	if !legal {
+		return ""
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	var value uint16
+	for _, f := range fields {
+		v, ok := palSymbols[f]
+		if !ok {
+			n, err := strconv.ParseUint(f, 8, 12)
+			if err != nil {
+				return ""
+			}
+			v = uint16(n)
+		}
+		value |= v
+	}
+	if value != w {
+		return ""
+	}
+	return strings.Join(fields, " ")
+}
+
+func (p *palField) loaded(a core.Addr) bool {
+	return a >= p.start && a < p.end && p.img.Loaded(a)
+}
+
+// isCode reports if the word at a may be an instruction.
+func (p *palField) isCode(a core.Addr) bool {
+	return p.code == nil || p.code[a]
+}
+
+// literals finds the literal pools in the field.  Current page pools are found
+// before the page zero pool so the page zero pool does not consider literals
+// in the other pools.
+func (p *palField) literals() {
+	f := p.start.Field()
+	for page := 1; page < core.FieldSize/core.PageSize; page++ {
+		base := core.MakeAddr(f, uint16(page*core.PageSize))
+		p.pool(base, func(a core.Addr) bool {
+			return a >= base && a < base+core.PageSize && p.img.Word(a)&0200 != 0
+		})
+	}
+	p.pool(core.MakeAddr(f, 0), func(a core.Addr) bool {
+		return p.img.Word(a)&0200 == 0
+	})
+}
+
+// pool finds the largest literal pool at the top of the page starting at
+// base.  use reports if the memory reference instruction at an address may
+// refer to a literal in the pool.
+func (p *palField) pool(base core.Addr, use func(core.Addr) bool) {
+	top := base + core.PageSize - 1
+	best := core.Addr(0)
+	var bestRefs []core.Addr
+	for s := top; s > base; s-- {
+		if !p.loaded(s) || p.kind[s] == palPool {
+			break
+		}
+		var refs []core.Addr
+		for a := p.start; a < p.end; a++ {
+			if a >= s && a <= top || !p.loaded(a) || p.kind[a] != palData || !p.isCode(a) {
+				continue
+			}
+			w := p.img.Word(a)
+			if t, ok := disasm.Target(a, w); ok && w != 0 && t >= s && t <= top && use(a) {
+				refs = append(refs, a)
+			}
+		}
+		if p.assign(refs, s, top) {
+			best, bestRefs = s, refs
+		}
+	}
+	if bestRefs == nil {
+		return
+	}
+	for a := best; a <= top; a++ {
+		p.kind[a] = palPool
+	}
+	for _, a := range bestRefs {
+		p.kind[a] = palLiteral
+	}
+}
+
+// assign reports if assigning the literals referenced by refs, in order, from
+// top down exactly reproduces the words s through top.
+func (p *palField) assign(refs []core.Addr, s, top core.Addr) bool {
+	if len(refs) == 0 {
+		return false
+	}
+	next := top
+	assigned := map[uint16]core.Addr{}
+	for _, a := range refs {
+		t, _ := disasm.Target(a, p.img.Word(a))
+		v := p.img.Word(t)
+		if at, ok := assigned[v]; ok {
+			if at != t {
+				return false
+			}
+			continue
+		}
+		if t != next {
+			return false
+		}
+		assigned[v] = next
+		next--
+	}
+	return next == s-1
+}
+
+// targets returns the addresses that are referenced directly and indirectly
+// by the memory reference instructions that are not literal references or part
+// of TEXT strings.  Words that are referenced indirectly are pointers, not
+// instructions, so their references are not included.
+func (p *palField) targets() (direct, indirect map[core.Addr]bool) {
+	direct = map[core.Addr]bool{}
+	indirect = map[core.Addr]bool{}
+	refs := func(cb func(a, t core.Addr, w uint16)) {
+		for a := p.start; a < p.end; a++ {
+			if !p.loaded(a) || p.kind[a] != palData || !p.isCode(a) {
+				continue
+			}
+			w := p.img.Word(a)
+			t, ok := disasm.Target(a, w)
+			if ok && w != 0 && p.loaded(t) && p.kind[t] != palPool {
+				cb(a, t, w)
+			}
+		}
+	}
+	refs(func(a, t core.Addr, w uint16) {
+		if w&0400 != 0 {
+			indirect[t] = true
+		}
+	})
+	pointers := indirect
+	indirect = map[core.Addr]bool{}
+	refs(func(a, t core.Addr, w uint16) {
+		switch {
+		case pointers[a]:
+		case w&0400 != 0:
+			indirect[t] = true
+		default:
+			direct[t] = true
+		}
+	})
+	return direct, indirect
+}
+
+// textChars are the characters, other than letters and digits, that may
+// appear in recognized TEXT strings.
+const textChars = " .,:-+*()=?!#%&'$"
+
+// sixbitChar returns the ASCII character of the 6 bit character c, or 0 if c is
+// not a character found in recognized TEXT strings.
+func sixbitChar(c uint16) byte {
+	ch := byte(c & 077)
+	if ch < 040 {
+		ch += 0100
+	}
+	switch {
+	case ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		return ch
+	case strings.IndexByte(textChars, ch) >= 0:
+		return ch
+	}
+	return 0
+}
+
+// findText finds TEXT strings.  A TEXT string is at least 4 characters,
+// including 2 letters, terminated by a 0 character.  When the code is known
+// TEXT strings are only found in data.
+func (p *palField) findText() {
+	for a := p.start; a < p.end; a++ {
+		if !p.loaded(a) || p.kind[a] != palData || p.code[a] {
+			continue
+		}
+		var text []byte
+		end := a
+		ok := false
+	scan:
+		for ; p.loaded(end) && p.kind[end] == palData && !p.code[end]; end++ {
+			w := p.img.Word(end)
+			for _, c := range []uint16{w >> 6, w & 077} {
+				if c == 0 {
+					// Either the right half or the whole word is 0.
+					ok = w&077 == 0
+					end++
+					break scan
+				}
+				ch := sixbitChar(c)
+				if ch == 0 {
+					break scan
+				}
+				text = append(text, ch)
+			}
+		}
+		if !ok || len(text) < 4 {
+			continue
+		}
+		letters := 0
+		for _, c := range text {
+			if c >= 'A' && c <= 'Z' {
+				letters++
+			}
+		}
+		if letters < 2 {
+			continue
+		}
+		// textChars does not include /
+		p.text[a] = fmt.Sprintf("TEXT /%s/", text)
+		p.textLen[a] = int(end - a)
+		p.kind[a] = palText
+		for t := a + 1; t < end; t++ {
+			p.kind[t] = palTextCont
+		}
+		a = end - 1
+	}
+}
+
+// dropText drops the TEXT strings with a referenced word other than the first,
+// or whose first word is referenced indirectly.  It reports if any were
+// dropped.  The words of dropped strings may refer to other strings so
+// dropText is called until it returns false.
+func (p *palField) dropText(direct, indirect map[core.Addr]bool) bool {
+	dropped := false
+	for a, n := range p.textLen {
+		bad := indirect[a]
+		for t := a + 1; t < a+core.Addr(n); t++ {
+			bad = bad || direct[t] || indirect[t]
+		}
+		if !bad {
+			continue
+		}
+		for t := a; t < a+core.Addr(n); t++ {
+			p.kind[t] = palData
+		}
+		delete(p.text, a)
+		delete(p.textLen, a)
+		dropped = true
+	}
+	return dropped
+}
+
+// classify determines how each remaining word is written and which addresses
+// need labels.
+func (p *palField) classify(indirect map[core.Addr]bool) {
+	for a := p.start; a < p.end; a++ {
+		if !p.loaded(a) || p.kind[a] != palData {
+			continue
+		}
+		w := p.img.Word(a)
+		// A pointer of 0 is usually the return address of a subroutine.
+		if indirect[a] && w != 0 && p.loaded(core.MakeAddr(a.Field(), w)) {
+			if k := p.kind[core.MakeAddr(a.Field(), w)]; k != palPool && k != palTextCont {
+				p.kind[a] = palPointer
+				p.labels[core.MakeAddr(a.Field(), w)] = true
+				continue
+			}
+		}
+		if w == 0 || !p.isCode(a) {
+			continue
+		}
+		if t, ok := disasm.Target(a, w); ok {
+			if enc, ok := encodeMRI(a, w, t); ok && enc == w {
+				p.kind[a] = palInstr
+				if p.loaded(t) && p.kind[t] != palPool && p.kind[t] != palTextCont {
+					p.labels[t] = true
+				}
+			}
+			continue
+		}
+		if symbolic(a, w) != "" {
+			p.kind[a] = palInstr
+		}
+	}
+}
+
+// operand returns the operand of the memory reference instruction at a.
+func (p *palField) operand(a core.Addr) string {
+	w := p.img.Word(a)
+	t, _ := disasm.Target(a, w)
+	ind := ""
+	if w&0400 != 0 {
+		ind = "I "
+	}
+	switch {
+	case p.kind[a] == palLiteral && w&0200 != 0:
+		return fmt.Sprintf("%s(%04o)", ind, p.img.Word(t))
+	case p.kind[a] == palLiteral:
+		return fmt.Sprintf("%s[%04o]", ind, p.img.Word(t))
+	case p.labels[t]:
+		return ind + label(t)
+	case p.defs[t]:
+		name, _ := symbol(t)
+		return ind + name
+	default:
+		return fmt.Sprintf("%s%04o", ind, t.Offset())
+	}
+}
+
+// writePAL writes the loaded words of img in fields, limited to lo through hi,
+// as PAL8 source to w.  If code is not nil only the words in code are written
+// as instructions.
+//
+// Labels are named by their symbols.  The symbols of other addresses referred
+// to by instructions are defined with = before the first field.
+func writePAL(w io.Writer, img *core.Image, fields []int, lo, hi core.Addr, code map[core.Addr]bool) {
+	var ps []*palField
+	for _, f := range fields {
+		ps = append(ps, newPALField(img, f, lo, hi, code))
+	}
+	// Labels claim their symbols before the definitions.
+	for _, p := range ps {
+		for a := p.start; a < p.end; a++ {
+			if p.labels[a] {
+				label(a)
+			}
+		}
+	}
+	for _, p := range ps {
+		for a := p.start; a < p.end; a++ {
+			if p.kind[a] != palInstr {
+				continue
+			}
+			t, ok := disasm.Target(a, img.Word(a))
+			if !ok || p.labels[t] || p.defs[t] {
+				continue
+			}
+			if name, ok := symbol(t); ok {
+				p.defs[t] = true
+				fmt.Fprintf(w, "%s=%04o\n", name, t.Offset())
+			}
+		}
+	}
+	for _, p := range ps {
+		f := p.start.Field()
+		fmt.Fprintf(w, "\tFIELD %o\n", f)
+		loc := core.Addr(core.Size)
+		for a := p.start; a < p.end; {
+			if !img.Loaded(a) || p.kind[a] == palPool {
+				a++
+				continue
+			}
+			if a != loc {
+				fmt.Fprintf(w, "*%04o\n", a.Offset())
+			}
+			if p.labels[a] {
+				fmt.Fprintf(w, "%s,", label(a))
+			}
+			word := img.Word(a)
+			n := 1
+			switch p.kind[a] {
+			case palText:
+				fmt.Fprintf(w, "\t%s\n", p.text[a])
+				n = p.textLen[a]
+			case palPointer:
+				fmt.Fprintf(w, "\t%s\n", label(core.MakeAddr(f, word)))
+			case palInstr, palLiteral:
+				if s := symbolic(a, word); s != "" && word>>9 >= 6 {
+					fmt.Fprintf(w, "\t%s\n", s)
+				} else {
+					fmt.Fprintf(w, "\t%s %s\n", ops[word>>9], p.operand(a))
+				}
+			default:
+				fmt.Fprintf(w, "\t%04o\n", word)
+			}
+			a += core.Addr(n)
+			loc = a
+		}
+	}
+	fmt.Fprintf(w, "$\n")
+}
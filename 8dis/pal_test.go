@@ -0,0 +1,84 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/papertape"
+)
+
+// roundTripSource exercises labels, both kinds of literals, pointers, text,
+// data, and more than one field.
+const roundTripSource = `*10
+AUTO,	0
+*200
+START,	CLA CLL
+	TAD (MSG-1)
+	DCA AUTO
+	TAD [7]
+	JMS PRINT
+	CDF 10
+	TAD I PTR
+	CDF 0
+	JMP I (DONE)
+PTR,	DATA
+PRINT,	0
+	TAD I AUTO
+	SNA
+	JMP I PRINT
+	ISZ COUNT
+	JMP PRINT+1
+	HLT
+COUNT,	0
+MSG,	TEXT "HELLO"
+*400
+DONE,	HLT
+	FIELD 1
+*200
+DATA,	1234
+	-1
+$
+`
+
+func TestPALRoundTrip(t *testing.T) {
+	img := assemble(t, roundTripSource)
+	for _, flow := range []bool{true, false} {
+		var code map[core.Addr]bool
+		if flow {
+			code = analyze(img, []core.Addr{0200}).code
+		}
+		var b bytes.Buffer
+		writePAL(&b, img, img.Fields(), 0, core.Size, code)
+		got := assemble(t, b.String())
+		if !bytes.Equal(papertape.EncodeBIN(got), papertape.EncodeBIN(img)) {
+			t.Errorf("flow %v: the source assembles to a different image:\n%s", flow, b.String())
+		}
+	}
+}
+
+func TestPALSource(t *testing.T) {
+	img := assemble(t, roundTripSource)
+	var b bytes.Buffer
+	writePAL(&b, img, img.Fields(), 0, core.Size, analyze(img, []core.Addr{0200}).code)
+	src := b.String()
+	for _, want := range []string{
+		"\tFIELD 1\n",
+		"*0200\n",
+		"\tTAD (",
+		"\tTAD [0007]\n",
+		"\tTEXT /HELLO/\n",
+		"\tJMS L00212\n",
+		"\tDCA L00010\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("source does not contain %q:\n%s", want, src)
+		}
+	}
+}
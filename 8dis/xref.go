@@ -0,0 +1,169 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/disasm"
+)
+
+// A ref is a single reference to an address.
+type ref struct {
+	From core.Addr // address of the referencing instruction
+	Kind string    // how the address is used
+}
+
+// Kinds of references.  A memory reference instruction refers to its
+// effective address with the kind for its op code.  An indirect instruction
+// also refers to its pointer as a pointer, or as an autoindex pointer that is
+// modified for pointers in 0010-0017, and refers to the contents of the
+// pointer (plus 1 for autoindex pointers) with the kind for its op code.  The
+// contents of the pointer are in the data field, or the instruction field
+// after a CIF for JMP I and JMS I, as found by the flow analysis.  The
+// contents of a pointer written by DCA or ISZ are not known.  JMP I through
+// the return address of a subroutine refers to the return address as a
+// return.
+var refKinds = [6]string{"read", "read", "modify", "write", "call", "jump"}
+
+const (
+	refPointer   = "pointer"
+	refAutoindex = "autoindex"
+	refReturn    = "return"
+)
+
+// xrefs returns the references made by the code in fl, by address.  If fl is
+// nil then every memory reference instruction in img is considered code.
+func xrefs(img *core.Image, fl *flow) map[core.Addr][]ref {
+	refs := map[core.Addr][]ref{}
+	add := func(t, from core.Addr, kind string) {
+		refs[t] = append(refs[t], ref{From: from, Kind: kind})
+	}
+	written := stores(img, fl)
+	for _, r := range img.Ranges() {
+		for a := r.Start; a < r.End; a++ {
+			if fl != nil && !fl.code[a] {
+				continue
+			}
+			w := img.Word(a)
+			t, ok := disasm.Target(a, w)
+			if !ok {
+				continue
+			}
+			kind := refKinds[w>>9]
+			switch {
+			case w&0400 == 0:
+			case fl != nil && fl.subs[t] && w&07000 == 05000:
+				add(t, a, refReturn)
+				continue
+			case t.Offset() >= 010 && t.Offset() <= 017:
+				add(t, a, refAutoindex)
+			default:
+				add(t, a, refPointer)
+			}
+			if e, ok := effective(img, fl, written, a); ok {
+				add(e, a, kind)
+			}
+		}
+	}
+	for _, rs := range refs {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].From < rs[j].From })
+	}
+	return refs
+}
+
+// writeXref writes the cross reference table refs to w, one reference per
+// line, ordered by address.
+func writeXref(w io.Writer, refs map[core.Addr][]ref) {
+	addrs := make([]core.Addr, 0, len(refs))
+	for a := range refs {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, a := range addrs {
+		for _, r := range refs[a] {
+			fmt.Fprintf(w, "%v %-9s %v\n", a, r.Kind, r.From)
+		}
+	}
+}
+
+// callGraph returns the JMS call graph of the code in fl.  Each routine, the
+// entries and the subroutines, is named by its starting address.  A
+// subroutine starts at its return address word.  The graph maps each routine
+// to the routines it calls.
+func callGraph(fl *flow, entries []core.Addr) map[core.Addr][]core.Addr {
+	graph := map[core.Addr][]core.Addr{}
+	roots := append([]core.Addr(nil), entries...)
+	for sub := range fl.subs {
+		roots = append(roots, sub)
+	}
+	for _, root := range roots {
+		start := root
+		if fl.subs[root] {
+			start = core.MakeAddr(root.Field(), root.Offset()+1)
+		}
+		called := map[core.Addr]bool{}
+		seen := map[core.Addr]bool{}
+		work := []core.Addr{start}
+		for len(work) > 0 {
+			a := work[len(work)-1]
+			work = work[:len(work)-1]
+			if seen[a] || !fl.code[a] {
+				continue
+			}
+			seen[a] = true
+			w := fl.img.Word(a)
+			if w&07000 == 04000 {
+				if sub, ok := fl.callee(a); ok {
+					called[sub] = true
+				}
+			}
+			work = append(work, fl.next(a, w)...)
+		}
+		graph[root] = nil
+		for sub := range called {
+			graph[root] = append(graph[root], sub)
+		}
+		sort.Slice(graph[root], func(i, j int) bool { return graph[root][i] < graph[root][j] })
+	}
+	return graph
+}
+
+// writeCallGraph writes graph to w as text, each routine followed by the
+// routines it calls, indented by a tab.
+func writeCallGraph(w io.Writer, graph map[core.Addr][]core.Addr) {
+	for _, a := range graphNodes(graph) {
+		fmt.Fprintf(w, "%v\n", a)
+		for _, sub := range graph[a] {
+			fmt.Fprintf(w, "\t%v\n", sub)
+		}
+	}
+}
+
+// writeDOT writes graph to w in the Graphviz DOT language.
+func writeDOT(w io.Writer, graph map[core.Addr][]core.Addr) {
+	fmt.Fprintf(w, "digraph calls {\n")
+	for _, a := range graphNodes(graph) {
+		fmt.Fprintf(w, "\t\"%v\";\n", a)
+		for _, sub := range graph[a] {
+			fmt.Fprintf(w, "\t\"%v\" -> \"%v\";\n", a, sub)
+		}
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// graphNodes returns the routines in graph in ascending order.
+func graphNodes(graph map[core.Addr][]core.Addr) []core.Addr {
+	nodes := make([]core.Addr, 0, len(graph))
+	for a := range graph {
+		nodes = append(nodes, a)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// xrefSource calls subroutines, uses a known pointer, and reads through an
+// autoindex pointer that the program sets.
+const xrefSource = `*10
+AUTO,	0
+*200
+START,	CLA
+	TAD (BUF-1)
+	DCA AUTO
+	TAD I AUTO
+	JMS ONE
+	TAD I PTR
+	HLT
+PTR,	BUF
+ONE,	0
+	JMS TWO
+	JMP I ONE
+TWO,	0
+	ISZ BUF
+	JMP I TWO
+BUF,	0
+$
+`
+
+func TestXref(t *testing.T) {
+	img := assemble(t, xrefSource)
+	for _, follow := range []bool{true, false} {
+		var fl *flow
+		if follow {
+			fl = analyze(img, []core.Addr{0200})
+		}
+		var b bytes.Buffer
+		writeXref(&b, xrefs(img, fl))
+		got := b.String()
+		for _, want := range []string{
+			"0:0010 write     0:0202\n",
+			"0:0010 autoindex 0:0203\n",
+			"0:0207 pointer   0:0205\n",
+			"0:0210 call      0:0204\n",
+			"0:0213 call      0:0211\n",
+			"0:0216 read      0:0205\n",
+			"0:0216 modify    0:0214\n",
+			"0:0377 read      0:0201\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("flow %v: missing %q:\n%s", follow, want, got)
+			}
+		}
+		// AUTO is written by DCA, so what TAD I AUTO reads is not
+		// known.  Its loaded contents, 0, would give 0:0001.
+		if strings.Contains(got, "0:0001") {
+			t.Errorf("flow %v: reference through the written pointer AUTO:\n%s", follow, got)
+		}
+		if follow {
+			for _, want := range []string{
+				"0:0210 return    0:0212\n",
+				"0:0213 return    0:0215\n",
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q:\n%s", want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestEffectiveWritten(t *testing.T) {
+	img := assemble(t, xrefSource)
+	fl := analyze(img, []core.Addr{0200})
+	written := stores(img, fl)
+	if !written[010] || !written[0216] || len(written) != 2 {
+		t.Errorf("got stores %v, want 0:0010 and 0:0216", written)
+	}
+	if e, ok := effective(img, fl, written, 0203); ok {
+		t.Errorf("TAD I AUTO: got %v", e)
+	}
+	if e, ok := effective(img, fl, written, 0205); !ok || e != 0216 {
+		t.Errorf("TAD I PTR: got %v, %v, want 0:0216", e, ok)
+	}
+	var b bytes.Buffer
+	writeListing(&b, img, fl, 0203, 0204)
+	if got, want := b.String(), "0203: 1410 TAD [0010]"; !strings.HasPrefix(got, want+" ") || strings.Contains(got, ";") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	img := assemble(t, xrefSource)
+	fl := analyze(img, []core.Addr{0200})
+	graph := callGraph(fl, []core.Addr{0200})
+
+	var b bytes.Buffer
+	writeCallGraph(&b, graph)
+	if got, want := b.String(), "0:0200\n\t0:0210\n0:0210\n\t0:0213\n0:0213\n"; got != want {
+		t.Errorf("text: got %q, want %q", got, want)
+	}
+	b.Reset()
+	writeDOT(&b, graph)
+	want := `digraph calls {
+	"0:0200";
+	"0:0200" -> "0:0210";
+	"0:0210";
+	"0:0210" -> "0:0213";
+	"0:0213";
+}
+`
+	if got := b.String(); got != want {
+		t.Errorf("dot: got:\n%s\nwant:\n%s", got, want)
+	}
+}
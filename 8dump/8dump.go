@@ -28,24 +28,12 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
 	"github.com/pborman/pdp8/os8fs"
 )
 
-func exit(v ...interface{}) {
-	fmt.Fprintln(os.Stderr, v...)
-	os.Exit(1)
-}
-func exitf(format string, v ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, v...)
-	os.Exit(1)
-}
-
 func main() {
 	getopt.SetParameters("[IMAGE/]FILE")
 	a6 := getopt.Bool('6', "dump 6 bit ascii")
@@ -64,7 +52,7 @@ func main() {
 	}
 	file, err := os8fs.GetFile(args[0])
 	if err != nil {
-		exit(err)
+		exitcode.Exit(err)
 	}
 	words := file.Words()
 	w := bufio.NewWriter(os.Stdout)
@@ -104,7 +92,7 @@ func main() {
 	w.Flush()
 }
 
-func fix(b [3]byte)[3]byte {
+func fix(b [3]byte) [3]byte {
 	for i, c := range b {
 		if c < ' ' || c > '~' {
 			b[i] = '.'
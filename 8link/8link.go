@@ -0,0 +1,295 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8link links BIN tapes and relocatable modules into a core image
+// (.SV) file.
+//
+//   Usage: 8link [-j JSW] [-m MAP] [-o OUTPUT] [-s START] [-w [IMAGE/]FILE] [IMAGE/]FILE[@WHERE] ...
+//    -j JSW        set the job status word to the octal JSW (default 0)
+//    -m MAP        write the load map to MAP
+//    -o OUTPUT     write the core image to the host file OUTPUT
+//    -s START      start at START, an address (F:AAAA) or an entry point
+//    -w FILE       write the core image to FILE on a disk image
+//
+// Each FILE is a BIN or RIM tape, a core image, a relocatable module (.RL), or
+// a library of modules (.LB).  Tapes and core images are loaded at their
+// absolute addresses.  Modules are placed in the first free pages of field 0.
+// Only the modules of a library that define symbols referred to by other
+// modules are loaded.  See package link for how modules are placed and
+// linked.
+//
+// WHERE places the modules of FILE.  WHERE is either a field, F, in which
+// case the modules are placed in the first free pages of field F, or an
+// address, F:AAAA, on a page boundary, in which case the modules are placed
+// one after the other starting at F:AAAA.  WHERE may be used to lay out the
+// core segments of the image, for example to place modules that are used
+// together in the same field.
+//
+// WHERE may also be an overlay, L.N or F:L.N, in which case the modules are
+// placed in overlay N of overlay level L, in field F (default 0).  The
+// overlays of a level share memory.  The overlay of each level with the lowest
+// number is loaded into the core image, and every overlay is written after the
+// core image, each starting on a new block, for the program to read when it
+// needs it.  The load map lists the address, length, and block, relative to
+// the start of the file, of each overlay.
+//
+// The starting address is START, if given, otherwise the starting address of
+// the first module that has one, otherwise the starting address of the first
+// core image that has one (paper tapes do not record a starting address).  The
+// core image is written to standard output if neither -o nor -w is given.
+//
+// The load map lists where each module and common block was placed, followed
+// by the address of each entry point and common block.  It may be read by
+// 8dis -s.
+//
+// If FILE names a file on the host it is read from the host, otherwise it is
+// read from a disk image.  The following examples of path names assume
+// PDP8_IMAGE is /tmp/os8.rk05:
+//
+//  PATH                   DRIVE         SIDE FILE
+//  foobar.rl               /tmp/os8.rk05  A  FOOBAR.RL
+//  b:foobar.rl             /tmp/os8.rk05  B  FOOBAR.RL
+//  ./os8.rk05/foobar.lb    ./os8.rk05     A  FOOBAR.LB
+//  ./os8.rk05/b:foobar.lb  ./os8.rk05     B  FOOBAR.LB
+//
+// For example, to link two modules and a library into a new file on the
+// default image:
+//
+//  8link -w foobar.sv main.rl sub.rl@1 lib.lb
+//
+// To link a program with two overlays that share memory in field 1:
+//
+//  8link -o prog.sv -m prog.map main.rl pass1.rl@1:1.0 pass2.rl@1:1.1
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/link"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/papertape"
+	"github.com/pborman/pdp8/reloc"
+	"github.com/pborman/pdp8/saveimage"
+)
+
+func main() {
+	getopt.SetParameters("[IMAGE/]FILE[@WHERE] ...")
+	jsw := getopt.String('j', "0", "set the job status word to the octal JSW", "JSW")
+	mapFile := getopt.String('m', "", "write the load map to MAP", "MAP")
+	output := getopt.String('o', "", "write the core image to the host file OUTPUT", "OUTPUT")
+	start := getopt.String('s', "", "start at START, an address or an entry point", "START")
+	write := getopt.String('w', "", "write the core image to FILE on a disk image", "FILE")
+	getopt.Parse()
+	args := getopt.Args()
+	if len(args) == 0 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	j, err := strconv.ParseUint(*jsw, 8, 12)
+	if err != nil {
+		exitcode.Exitf("invalid job status word: %s", *jsw)
+	}
+
+	l := link.New()
+	for _, arg := range args {
+		if err := load(l, arg); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	img, mp, err := l.Link()
+	if err != nil {
+		exitcode.Exit(err)
+	}
+	if *start != "" {
+		a, err := core.ParseAddr(*start)
+		if err != nil {
+			var ok bool
+			if a, ok = mp.Symbols[strings.ToUpper(*start)]; !ok {
+				exitcode.Exitf("%s: no such address or entry point", *start)
+			}
+		}
+		img.Start, img.HasStart = a, true
+	}
+	words, err := saveimage.Encode(img, uint16(j))
+	if err != nil {
+		exitcode.Exit(err)
+	}
+	words = mp.AppendOverlays(words)
+
+	if *mapFile != "" {
+		f, err := os.Create(*mapFile)
+		if err != nil {
+			exitcode.Exit(err)
+		}
+		if err := mp.Write(f); err != nil {
+			exitcode.Exit(err)
+		}
+		if err := f.Close(); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if *output == "" && *write == "" {
+		if _, err := os.Stdout.Write(raw(words)); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, raw(words), 0666); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if *write != "" {
+		if err := writeImage(*write, words); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+}
+
+// load adds the file named by arg, with its optional @WHERE, to l.
+func load(l *link.Linker, arg string) error {
+	path, where := arg, ""
+	if x := strings.LastIndex(arg, "@"); x >= 0 {
+		path, where = arg[:x], arg[x+1:]
+	}
+	name, data, words, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	modules, err := reloc.Decode(data)
+	if err != nil {
+		if where != "" {
+			return fmt.Errorf("%s: only modules may be placed", path)
+		}
+		if _, err := saveimage.DecodeCCB(words); err == nil {
+			img, _, err := saveimage.Decode(words)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			return l.LoadImage(img)
+		}
+		img, _, err := papertape.Decode(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return l.LoadImage(img)
+	}
+
+	field := 0
+	switch {
+	case where == "":
+	case strings.Contains(where, "."):
+		if strings.HasSuffix(name, ".LB") {
+			return fmt.Errorf("%s: libraries may not be placed in overlays", path)
+		}
+		if x := strings.Index(where, ":"); x >= 0 {
+			f, err := strconv.ParseUint(where[:x], 8, 3)
+			if err != nil {
+				return fmt.Errorf("%s: invalid field: %s", path, where[:x])
+			}
+			field, where = int(f), where[x+1:]
+		}
+		x := strings.Index(where, ".")
+		level, err1 := strconv.Atoi(where[:x])
+		number, err2 := strconv.Atoi(where[x+1:])
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("%s: invalid overlay: %s", path, where)
+		}
+		for _, m := range modules {
+			if err := l.AddOverlay(m, field, level, number); err != nil {
+				return err
+			}
+		}
+		return nil
+	case !strings.Contains(where, ":"):
+		f, err := strconv.ParseUint(where, 8, 3)
+		if err != nil {
+			return fmt.Errorf("%s: invalid field: %s", path, where)
+		}
+		field = int(f)
+	default:
+		a, err := core.ParseAddr(where)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, m := range modules {
+			if err := l.AddAt(m, a); err != nil {
+				return err
+			}
+			pages := (len(m.Code) + core.PageSize - 1) / core.PageSize
+			a += core.Addr(pages * core.PageSize)
+		}
+		return nil
+	}
+	if strings.HasSuffix(name, ".LB") {
+		l.AddLibrary(modules, field)
+		return nil
+	}
+	for _, m := range modules {
+		l.Add(m, field)
+	}
+	return nil
+}
+
+// raw returns words as bytes, 2 bytes per word, as they are stored in a disk
+// image.
+func raw(words []uint16) []byte {
+	data := make([]byte, len(words)*2)
+	for i, w := range words {
+		data[i*2] = byte(w)
+		data[i*2+1] = byte(w >> 8)
+	}
+	return data
+}
+
+// writeImage writes words to the file named by path on a disk image,
+// replacing the file if it exists.
+func writeImage(path string, words []uint16) error {
+	image := os8fs.DefaultImage
+	if x := strings.LastIndex(path, "/"); x >= 0 {
+		image = path[:x]
+		path = path[x+1:]
+	}
+	d, err := os8fs.OpenImage(image, true)
+	if err != nil {
+		return err
+	}
+	// Never leave a half modified image behind.
+	d.Atomic = true
+	if err := d.Write(path, words); err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+// readFile returns the name and contents of the file path.  The contents are
+// returned both as bytes (paper tape frames) and as 12 bit words.  If path is a
+// file on the host then it is read from the host, the words are taken as 2
+// bytes per word, just as in a disk image.  Otherwise path is read from a disk
+// image.
+func readFile(path string) (name string, data []byte, words []uint16, err error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		words := make([]uint16, len(data)/2)
+		for i := range words {
+			words[i] = (uint16(data[i*2]) | uint16(data[i*2+1])<<8) & 07777
+		}
+		return strings.ToUpper(filepath.Base(path)), data, words, nil
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return f.Name(), f.ASCII(false), f.Words(), nil
+}
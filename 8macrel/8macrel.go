@@ -0,0 +1,183 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8macrel assembles MACREL style sources into relocatable modules.
+//
+//   Usage: 8macrel [-l LISTING] [-n NAME] [-o OUTPUT] [-s SYMBOLS] [IMAGE/]FILE ...
+//    -l LISTING    write the listing, including the symbol table, to LISTING
+//    -n NAME       name the first section NAME
+//    -o OUTPUT     write the modules to OUTPUT rather than standard output
+//    -s SYMBOLS    write the symbol table to SYMBOLS
+//
+// The FILEs are assembled as a single source, in order.  See package macrel
+// for the language accepted.  Each section of the source becomes a module.  A
+// single module is written as a relocatable module (.RL) and several modules
+// are written as a library (.LB), either of which may be listed by 8rl and
+// linked by 8link.  The first section is named by NAME, which defaults to the
+// name of the first FILE without its extension.
+//
+// Errors are written to standard error with the error code, the location, and
+// the file and line of the error.  The outputs are written even if there are
+// errors, but 8macrel exits with a non-zero status.
+//
+// If FILE names a file on the host it is read from the host, otherwise it is
+// read from a disk image and converted to host text.  The following examples
+// of path names assume PDP8_IMAGE is /tmp/os8.rk05:
+//
+//  PATH                   DRIVE         SIDE FILE
+//  foobar.ma               /tmp/os8.rk05  A  FOOBAR.MA
+//  b:foobar.ma             /tmp/os8.rk05  B  FOOBAR.MA
+//  ./os8.rk05/foobar.ma    ./os8.rk05     A  FOOBAR.MA
+//  ./os8.rk05/b:foobar.ma  ./os8.rk05     B  FOOBAR.MA
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/macrel"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/reloc"
+)
+
+// A source is one of the source files.
+type source struct {
+	name  string
+	first int // line number of the first line in the combined source
+}
+
+func main() {
+	getopt.SetParameters("[IMAGE/]FILE ...")
+	listing := getopt.String('l', "", "write the listing to LISTING", "LISTING")
+	name := getopt.String('n', "", "name the first section NAME", "NAME")
+	output := getopt.String('o', "", "write the modules to OUTPUT", "OUTPUT")
+	symbols := getopt.String('s', "", "write the symbol table to SYMBOLS", "SYMBOLS")
+	getopt.Parse()
+	args := getopt.Args()
+	if len(args) == 0 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	var src []byte
+	var sources []source
+	for _, path := range args {
+		name, text, err := readText(path)
+		if err != nil {
+			exitcode.Exit(err)
+		}
+		if len(text) > 0 && text[len(text)-1] != '\n' {
+			text = append(text, '\n')
+		}
+		sources = append(sources, source{name: name, first: bytes.Count(src, []byte("\n")) + 1})
+		src = append(src, text...)
+	}
+	if *name == "" {
+		*name = sectionName(sources[0].name)
+	}
+
+	prog, err := macrel.Assemble(strings.ToUpper(*name), src)
+	for _, e := range prog.Errors {
+		s := sources[0]
+		for _, t := range sources {
+			if t.first <= e.Line {
+				s = t
+			}
+		}
+		msg := fmt.Sprintf("%s AT %o%04o", e.Code, e.Addr.Field(), e.Addr.Offset())
+		if e.Symbol != "" {
+			msg += " " + e.Symbol
+		}
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", s.name, e.Line-s.first+1, msg)
+	}
+
+	var data []byte
+	if len(prog.Modules) == 1 {
+		data = prog.Modules[0].Encode()
+	} else {
+		data = reloc.EncodeLibrary(prog.Modules)
+	}
+	var werr error
+	if *output == "" {
+		_, werr = os.Stdout.Write(data)
+	} else {
+		werr = os.WriteFile(*output, data, 0666)
+	}
+	if werr != nil {
+		exitcode.Exit(werr)
+	}
+	if *listing != "" {
+		if err := writeFile(*listing, prog.WriteListing); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if *symbols != "" {
+		if err := writeFile(*symbols, prog.WriteSymbols); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// sectionName returns the section name derived from the file name path: its
+// base name without its extension, in upper case, truncated to 6 characters.
+func sectionName(path string) string {
+	name := filepath.Base(path)
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	var b strings.Builder
+	for _, c := range strings.ToUpper(name) {
+		if c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && b.Len() > 0 {
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+	if len(name) > 6 {
+		name = name[:6]
+	}
+	if name == "" {
+		name = "MAIN"
+	}
+	return name
+}
+
+// writeFile creates the host file path and writes it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readText returns the name and contents of the text file path.  A file on an
+// OS/8 image is converted to host text.
+func readText(path string) (name string, text []byte, err error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		text, err := os.ReadFile(path)
+		return path, text, err
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return f.Name(), f.Text(), nil
+}
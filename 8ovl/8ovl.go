@@ -0,0 +1,79 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8ovl manages copy-on-write overlays of PDP-8 disk images.  An overlay
+// is opened in place of its base image and records all changes made to the
+// image without modifying the base image.  Any program that accepts a disk
+// image also accepts an overlay.  Overlays must have the extension .ovl.
+//
+//   Usage: 8ovl create IMAGE OVERLAY
+//          8ovl list OVERLAY
+//          8ovl merge OVERLAY
+//          8ovl discard OVERLAY
+//
+// The create command creates a new, empty, overlay for IMAGE.  The list command
+// lists the image blocks modified by the overlay.  The merge command writes the
+// changes in the overlay to the base image and removes the overlay.  The
+// discard command removes the overlay without modifying the base image.
+//
+// For example:
+//
+//  8ovl create os8.rk05 test.ovl
+//  8rm test.ovl/b:foobar.xy
+//  8dir test.ovl
+//  8ovl discard test.ovl
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/os8fs"
+)
+
+const usage = `usage: 8ovl create IMAGE OVERLAY
+       8ovl list OVERLAY
+       8ovl merge OVERLAY
+       8ovl discard OVERLAY`
+
+func main() {
+	if len(os.Args) < 3 {
+		exitcode.Exit(usage)
+	}
+	args := os.Args[2:]
+	var err error
+	switch os.Args[1] {
+	case "create":
+		if len(args) != 2 {
+			exitcode.Exit(usage)
+		}
+		err = os8fs.CreateOverlay(args[0], args[1])
+	case "list":
+		if len(args) != 1 {
+			exitcode.Exit(usage)
+		}
+		var blocks []int
+		blocks, err = os8fs.OverlayBlocks(args[0])
+		for _, b := range blocks {
+			fmt.Println(b)
+		}
+	case "merge":
+		if len(args) != 1 {
+			exitcode.Exit(usage)
+		}
+		err = os8fs.MergeOverlay(args[0])
+	case "discard":
+		if len(args) != 1 {
+			exitcode.Exit(usage)
+		}
+		err = os8fs.DiscardOverlay(args[0])
+	default:
+		exitcode.Exitf("8ovl: unknown command %q\n%s", os.Args[1], usage)
+	}
+	if err != nil {
+		exitcode.Exit(err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8pal assembles PAL8 sources into a BIN paper tape.
+//
+//   Usage: 8pal [-l LISTING] [-o OUTPUT] [-s SYMBOLS] [IMAGE/]FILE ...
+//    -l LISTING    write the listing, including the symbol table, to LISTING
+//    -o OUTPUT     write the BIN tape to OUTPUT rather than standard output
+//    -s SYMBOLS    write the symbol table to SYMBOLS
+//
+// The FILEs are assembled as a single source, in order, just as PAL8 does.
+// See package pal for the language accepted.  Errors are written to standard
+// error with the PAL8 error code, the location, and the file and line of the
+// error.  The outputs are written even if there are errors, but 8pal exits
+// with a non-zero status.
+//
+// The symbol table is in the form read by 8dis -l.
+//
+// If FILE names a file on the host it is read from the host, otherwise it is
+// read from a disk image and converted to host text.  The following examples
+// of path names assume PDP8_IMAGE is /tmp/os8.rk05:
+//
+//  PATH                   DRIVE         SIDE FILE
+//  foobar.pa               /tmp/os8.rk05  A  FOOBAR.PA
+//  b:foobar.pa             /tmp/os8.rk05  B  FOOBAR.PA
+//  ./os8.rk05/foobar.pa    ./os8.rk05     A  FOOBAR.PA
+//  ./os8.rk05/b:foobar.pa  ./os8.rk05     B  FOOBAR.PA
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/pal"
+	"github.com/pborman/pdp8/papertape"
+)
+
+// A source is one of the source files.
+type source struct {
+	name  string
+	first int // line number of the first line in the combined source
+}
+
+func main() {
+	getopt.SetParameters("[IMAGE/]FILE ...")
+	listing := getopt.String('l', "", "write the listing to LISTING", "LISTING")
+	output := getopt.String('o', "", "write the BIN tape to OUTPUT", "OUTPUT")
+	symbols := getopt.String('s', "", "write the symbol table to SYMBOLS", "SYMBOLS")
+	getopt.Parse()
+	args := getopt.Args()
+	if len(args) == 0 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	var src []byte
+	var sources []source
+	for _, path := range args {
+		name, text, err := readText(path)
+		if err != nil {
+			exitcode.Exit(err)
+		}
+		if len(text) > 0 && text[len(text)-1] != '\n' {
+			text = append(text, '\n')
+		}
+		sources = append(sources, source{name: name, first: bytes.Count(src, []byte("\n")) + 1})
+		src = append(src, text...)
+	}
+
+	prog, err := pal.Assemble(src)
+	for _, e := range prog.Errors {
+		s := sources[0]
+		for _, t := range sources {
+			if t.first <= e.Line {
+				s = t
+			}
+		}
+		msg := fmt.Sprintf("%s AT %o%04o", e.Code, e.Addr.Field(), e.Addr.Offset())
+		if e.Symbol != "" {
+			msg += " " + e.Symbol
+		}
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", s.name, e.Line-s.first+1, msg)
+	}
+
+	tape := papertape.EncodeBIN(prog.Image)
+	var werr error
+	if *output == "" {
+		_, werr = os.Stdout.Write(tape)
+	} else {
+		werr = os.WriteFile(*output, tape, 0666)
+	}
+	if werr != nil {
+		exitcode.Exit(werr)
+	}
+	if *listing != "" {
+		if err := writeFile(*listing, prog.WriteListing); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if *symbols != "" {
+		if err := writeFile(*symbols, prog.WriteSymbols); err != nil {
+			exitcode.Exit(err)
+		}
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// writeFile creates the host file path and writes it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readText returns the name and contents of the text file path.  A file on an
+// OS/8 image is converted to host text.
+func readText(path string) (name string, text []byte, err error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		text, err := os.ReadFile(path)
+		return path, text, err
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return f.Name(), f.Text(), nil
+}
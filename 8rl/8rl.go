@@ -0,0 +1,118 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Program 8rl lists the symbols in relocatable binary modules (.RL) and
+// libraries (.LB).
+//
+//   Usage: 8rl [-r] [IMAGE/]FILE ...
+//    -r    also list the relocation records
+//
+// For each module the module name and length are listed, followed by its entry
+// points, the external symbols it references, and the common blocks it uses.
+// Addresses and lengths are in octal and addresses are relative to the start
+// of the module.  With -r, the offset added to an external symbol or common
+// block is listed as a signed decimal number (e.g., FOO-1).
+//
+// If FILE names a file on the host it is read from the host, otherwise it is
+// read from a disk image.  The following examples of path names assume
+// PDP8_IMAGE is /tmp/os8.rk05:
+//
+//  PATH                   DRIVE         SIDE FILE
+//  foobar.rl               /tmp/os8.rk05  A  FOOBAR.RL
+//  b:foobar.rl             /tmp/os8.rk05  B  FOOBAR.RL
+//  ./os8.rk05/foobar.lb    ./os8.rk05     A  FOOBAR.LB
+//  ./os8.rk05/b:foobar.lb  ./os8.rk05     B  FOOBAR.LB
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/pborman/getopt"
+	"github.com/pborman/pdp8/exitcode"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/reloc"
+)
+
+func main() {
+	getopt.SetParameters("[IMAGE/]FILE ...")
+	relocs := getopt.Bool('r', "also list the relocation records")
+	getopt.Parse()
+	args := getopt.Args()
+	if len(args) == 0 {
+		getopt.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, path := range args {
+		data, err := readFile(path)
+		if err != nil {
+			w.Flush()
+			exitcode.Exit(err)
+		}
+		modules, err := reloc.Decode(data)
+		for _, m := range modules {
+			list(w, m, *relocs)
+		}
+		if err != nil {
+			w.Flush()
+			exitcode.Exit(fmt.Errorf("%s: %w", path, err))
+		}
+	}
+}
+
+func list(w *bufio.Writer, m *reloc.Module, relocs bool) {
+	fmt.Fprintf(w, "MODULE %-6s %04o", m.Name, len(m.Code))
+	if m.Start >= 0 {
+		fmt.Fprintf(w, " START %04o", m.Start)
+	}
+	fmt.Fprintln(w)
+	for _, e := range m.Entries {
+		fmt.Fprintf(w, "  ENTRY  %-6s %04o\n", e.Name, e.Addr)
+	}
+	for _, e := range m.Externals {
+		fmt.Fprintf(w, "  EXTERN %s\n", e)
+	}
+	for _, c := range m.Commons {
+		fmt.Fprintf(w, "  COMMON %-6s %04o\n", c.Name, c.Size)
+	}
+	if !relocs {
+		return
+	}
+	for _, r := range m.Relocs {
+		switch r.Kind {
+		case reloc.External:
+			fmt.Fprintf(w, "  %04o %s %s%+d\n", r.Addr, r.Kind, m.Externals[r.Index], signed(m.Code[r.Addr]))
+		case reloc.Common:
+			fmt.Fprintf(w, "  %04o %s %s%+d\n", r.Addr, r.Kind, m.Commons[r.Index].Name, signed(m.Code[r.Addr]))
+		default:
+			fmt.Fprintf(w, "  %04o %s %04o\n", r.Addr, r.Kind, m.Code[r.Addr])
+		}
+	}
+}
+
+// signed returns the 12 bit word w as a signed value.
+func signed(w uint16) int {
+	if w&04000 != 0 {
+		return int(w&07777) - 010000
+	}
+	return int(w & 07777)
+}
+
+// readFile returns the contents of the file path as bytes.  If path is a file
+// on the host then it is read from the host, otherwise it is read from a disk
+// image.
+func readFile(path string) ([]byte, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+		return os.ReadFile(path)
+	}
+	f, err := os8fs.GetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.ASCII(false), nil
+}
@@ -17,32 +17,20 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"strings"
 
+	"github.com/pborman/pdp8/exitcode"
 	"github.com/pborman/pdp8/os8fs"
 )
 
-func exit(v ...interface{}) {
-	fmt.Fprintln(os.Stderr, v...)
-	os.Exit(1)
-}
-func exitf(format string, v ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, v...)
-	os.Exit(1)
-}
-
 func main() {
 	var path string
 	switch len(os.Args) {
 	case 2:
 		path = os.Args[1]
 	default:
-		exit("usage: 8rm [IMAGE/]FILE")
+		exitcode.Exit("usage: 8rm [IMAGE/]FILE")
 	}
 	image := os8fs.DefaultImage
 	if x := strings.LastIndex(path, "/"); x >= 0 {
@@ -52,9 +40,14 @@ func main() {
 
 	d, err := os8fs.OpenImage(image, true)
 	if err != nil {
-		exit(err)
+		exitcode.Exit(err)
 	}
+	// Never leave a half modified image behind.
+	d.Atomic = true
 	if err := d.Remove(path); err != nil {
-		exit(err)
+		exitcode.Exit(err)
+	}
+	if err := d.Close(); err != nil {
+		exitcode.Exit(err)
 	}
 }
@@ -0,0 +1,198 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package core provides an image of PDP-8 core memory as loaded from a binary
+// program.
+//
+// A PDP-8 can address up to 8 fields of 4,096 12 bit words each.  Addresses are
+// represented as 15 bit extended addresses, the upper 3 bits being the field
+// and the lower 12 bits the address within the field.  Extended addresses are
+// written as F:AAAA, e.g., 1:4200 is location 4200 in field 1.
+//
+// An Image records which words have been loaded so that programs that only
+// load parts of memory can be distinguished from programs that load zeros.
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FieldSize = 010000                // Words per field
+	PageSize  = 0200                  // Words per page
+	MaxFields = 8                     // Maximum number of fields
+	Size      = MaxFields * FieldSize // Maximum words of memory
+)
+
+// An Addr is a 15 bit extended address.
+type Addr uint16
+
+// MakeAddr returns the extended address of addr in field.
+func MakeAddr(field int, addr uint16) Addr {
+	return Addr(field&07)<<12 | Addr(addr&07777)
+}
+
+// Field returns the field of a.
+func (a Addr) Field() int {
+	return int(a>>12) & 07
+}
+
+// Offset returns the address of a within its field.
+func (a Addr) Offset() uint16 {
+	return uint16(a) & 07777
+}
+
+// String returns a as F:AAAA.
+func (a Addr) String() string {
+	return fmt.Sprintf("%o:%04o", a.Field(), a.Offset())
+}
+
+// ParseAddr parses s, an octal address written as AAAA or F:AAAA, as an
+// extended address.  The field defaults to 0.
+func ParseAddr(s string) (Addr, error) {
+	field, addr := "0", s
+	if x := strings.Index(s, ":"); x >= 0 {
+		field, addr = s[:x], s[x+1:]
+	}
+	f, err := strconv.ParseUint(field, 8, 3)
+	if err != nil {
+		return 0, fmt.Errorf("invalid field: %s", s)
+	}
+	a, err := strconv.ParseUint(addr, 8, 12)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address: %s", s)
+	}
+	return MakeAddr(int(f), uint16(a)), nil
+}
+
+// A Range is a range of contiguous extended addresses.
+type Range struct {
+	Start Addr // First address in the range
+	End   Addr // Address following the last address in the range
+}
+
+// Len returns the number of words in r.
+func (r Range) Len() int {
+	return int(r.End) - int(r.Start)
+}
+
+func (r Range) String() string {
+	if r.Len() == 0 {
+		return r.Start.String() + "-"
+	}
+	return fmt.Sprintf("%v-%04o", r.Start, (r.End - 1).Offset())
+}
+
+// An Image is an image of core memory.
+type Image struct {
+	// Start is the starting address of the program, if known.
+	Start    Addr
+	HasStart bool
+
+	words  [Size]uint16
+	loaded [Size]bool
+}
+
+// New returns a new, empty, Image.
+func New() *Image {
+	return &Image{}
+}
+
+// Load stores the 12 bit word w at address a and marks it as loaded.
+func (m *Image) Load(a Addr, w uint16) {
+	a &= Size - 1
+	m.words[a] = w & 07777
+	m.loaded[a] = true
+}
+
+// Unload marks address a as not loaded and clears it.
+func (m *Image) Unload(a Addr) {
+	a &= Size - 1
+	m.words[a] = 0
+	m.loaded[a] = false
+}
+
+// Word returns the word at address a.  Words that have not been loaded are 0.
+func (m *Image) Word(a Addr) uint16 {
+	return m.words[a&(Size-1)]
+}
+
+// Loaded returns true if the word at address a has been loaded.
+func (m *Image) Loaded(a Addr) bool {
+	return m.loaded[a&(Size-1)]
+}
+
+// Field returns the 4,096 words of field f.
+func (m *Image) Field(f int) []uint16 {
+	f &= 07
+	return m.words[f*FieldSize : (f+1)*FieldSize]
+}
+
+// Fields returns the fields, in order, that have at least one loaded word.
+func (m *Image) Fields() []int {
+	var fields []int
+	for f := 0; f < MaxFields; f++ {
+		for _, l := range m.loaded[f*FieldSize : (f+1)*FieldSize] {
+			if l {
+				fields = append(fields, f)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// Ranges returns the ranges of loaded words, in ascending order.  Ranges do
+// not cross field boundaries.
+func (m *Image) Ranges() []Range {
+	var ranges []Range
+	for a := 0; a < Size; a++ {
+		if !m.loaded[a] {
+			continue
+		}
+		r := Range{Start: Addr(a)}
+		for a < Size && m.loaded[a] {
+			a++
+			if a%FieldSize == 0 {
+				break
+			}
+		}
+		r.End = Addr(a)
+		ranges = append(ranges, r)
+		a--
+	}
+	return ranges
+}
+
+// Len returns the number of loaded words in m.
+func (m *Image) Len() int {
+	n := 0
+	for _, l := range m.loaded {
+		if l {
+			n++
+		}
+	}
+	return n
+}
+
+// Copy copies all the loaded words of src into m.  It returns the addresses,
+// in ascending order, that were loaded in both m and src with different
+// values.
+func (m *Image) Copy(src *Image) []Addr {
+	var conflicts []Addr
+	for a, l := range src.loaded {
+		if !l {
+			continue
+		}
+		if m.loaded[a] && m.words[a] != src.words[a] {
+			conflicts = append(conflicts, Addr(a))
+		}
+		m.words[a] = src.words[a]
+		m.loaded[a] = true
+	}
+	return conflicts
+}
@@ -0,0 +1,330 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package cpu emulates the PDP-8/E processor.
+//
+// The emulated processor has:
+//
+//   - The PDP-8/E instruction set: memory reference instructions with page
+//     zero, current page, indirect, and autoindex (locations 0010-0017)
+//     addressing, and the group 1, group 2, and group 3 (MQ) operate
+//     microinstructions, including BSW.
+//   - An optional KE8E EAE, in mode A or mode B, switched with SWAB and SWBA.
+//   - The interrupt system, including the PDP-8/E IOTs SKON, SRQ, GTF, RTF,
+//     SGT, and CAF.
+//   - A KM8E memory extension of up to 8 fields (32K words), with the
+//     optional time-share feature.  In user mode IOTs, HLT, and OSR are not
+//     executed and instead cause a user mode interrupt.
+//
+// Devices are attached to device codes.  An IOT is dispatched to the device
+// attached to its device code, and IOTs of device codes with no device attached
+// do nothing.  Device codes 00 (the processor) and 20-27 (the memory
+// extension) are handled by the processor.
+//
+// The emulation is deterministic.  Time is measured in memory cycles, counted
+// in Cycles: each instruction takes a cycle to fetch, a cycle to fetch the
+// address of an indirect instruction, and a cycle to fetch or store its
+// operand.  JMP takes no operand cycle.  EAE instructions take a cycle for
+// each word they fetch or store, but the time taken by the arithmetic is not
+// counted.  Taking an interrupt takes a cycle.
+//
+// Programs are loaded from a core.Image, such as those returned by
+// papertape.DecodeBIN and saveimage.Decode.  Paper tapes do not record a
+// starting address, so Start must be called after loading one.
+package cpu
+
+import (
+	"errors"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// ErrHalt is returned by Step and Run when the processor is halted.
+var ErrHalt = errors.New("halted")
+
+// A Device is a device attached to the processor.
+type Device interface {
+	// IOT executes w, an IOT addressed to the device.  It may read and
+	// change the registers of c and reports if the next instruction is to be
+	// skipped.
+	IOT(c *CPU, w uint16) bool
+
+	// Interrupt reports if the device is requesting an interrupt.
+	Interrupt() bool
+
+	// Reset resets the device, as done by CAF.
+	Reset()
+}
+
+// A Clocked device is a device that is told the time, in Cycles, after each
+// instruction.  Devices use the time to set their flags at the proper time.
+type Clocked interface {
+	Device
+	Clock(cycles uint64)
+}
+
+// A CPU is a PDP-8/E processor and its memory.
+type CPU struct {
+	AC uint16 // Accumulator
+	L  uint16 // Link, 0 or 1
+	MQ uint16 // Multiplier quotient
+	PC uint16 // Program counter
+	SR uint16 // Switch register, read by OSR and LAS
+
+	IF int    // Instruction field
+	IB int    // Instruction buffer, becomes IF at the next JMP or JMS
+	DF int    // Data field
+	UF int    // User flag, 1 in user mode
+	UB int    // User buffer, becomes UF at the next JMP or JMS
+	SF uint16 // Save field, UF IF DF as saved by an interrupt
+
+	SC    uint16 // EAE step counter
+	GT    bool   // EAE greater than flag
+	ModeB bool   // EAE is in mode B
+
+	IE        bool // Interrupts are enabled
+	Halted    bool // The processor is halted
+	Cycles    uint64
+	EAE       bool // An EAE is installed
+	TimeShare bool // The KM8E time-share feature is installed
+
+	Mem []uint16 // Memory, 4096 words per field
+
+	ionDelay bool // ION was just executed
+	inhibit  bool // interrupts inhibited until the next JMP or JMS
+	userInt  bool // user mode interrupt request
+
+	devices  [0100]Device
+	attached []Device
+}
+
+// New returns a new processor with fields fields of memory, between 1 and 8.
+// The processor starts at 0200 of field 0 with everything else cleared.
+func New(fields int) *CPU {
+	if fields < 1 {
+		fields = 1
+	}
+	if fields > core.MaxFields {
+		fields = core.MaxFields
+	}
+	return &CPU{
+		PC:  0200,
+		Mem: make([]uint16, fields*core.FieldSize),
+	}
+}
+
+// Attach attaches d to the device code code.  A device may be attached to
+// several device codes.  It panics if code is handled by the processor.
+func (c *CPU) Attach(code int, d Device) {
+	if code <= 0 || code >= len(c.devices) || code&070 == 020 {
+		panic("cpu: cannot attach a device to a processor device code")
+	}
+	c.devices[code] = d
+	for _, a := range c.attached {
+		if a == d {
+			return
+		}
+	}
+	c.attached = append(c.attached, d)
+}
+
+// Load loads the words of img into memory.  If img has a starting address the
+// processor is set to start there.  Words beyond the end of memory are not
+// loaded.
+func (c *CPU) Load(img *core.Image) {
+	for a := core.Addr(0); int(a) < len(c.Mem); a++ {
+		if img.Loaded(a) {
+			c.Mem[a] = img.Word(a)
+		}
+	}
+	if img.HasStart {
+		c.Start(img.Start)
+	}
+}
+
+// Start sets the processor to start at a, as done by the front panel.
+func (c *CPU) Start(a core.Addr) {
+	c.PC = a.Offset()
+	c.IF = a.Field()
+	c.IB = c.IF
+	c.Halted = false
+}
+
+// Read returns the word at a, or 0 if a is beyond the end of memory.
+func (c *CPU) Read(a core.Addr) uint16 {
+	if int(a) >= len(c.Mem) {
+		return 0
+	}
+	return c.Mem[a]
+}
+
+// Write writes w to a.  Writes beyond the end of memory are ignored.
+func (c *CPU) Write(a core.Addr, w uint16) {
+	if int(a) < len(c.Mem) {
+		c.Mem[a] = w & 07777
+	}
+}
+
+// Reset clears the processor and all its devices, as done by CAF.  Memory is
+// not changed.
+func (c *CPU) Reset() {
+	c.AC, c.L = 0, 0
+	c.IE, c.ionDelay, c.inhibit, c.userInt = false, false, false, false
+	c.GT = false
+	for _, d := range c.attached {
+		d.Reset()
+	}
+}
+
+// Run executes instructions until the processor halts, in which case it
+// returns ErrHalt, or until at least cycles cycles have passed.
+func (c *CPU) Run(cycles uint64) error {
+	end := c.Cycles + cycles
+	for c.Cycles < end {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes a single instruction, or takes an interrupt.  It returns
+// ErrHalt, without executing an instruction, if the processor is halted.
+func (c *CPU) Step() error {
+	if c.Halted {
+		return ErrHalt
+	}
+	if c.interrupt() {
+		c.clock()
+		return nil
+	}
+	pc := c.PC
+	w := c.Read(core.MakeAddr(c.IF, pc))
+	c.Cycles++
+	c.PC = (pc + 1) & 07777
+	switch op := w >> 9; op {
+	case 6:
+		c.iot(w)
+	case 7:
+		c.operate(w)
+	default:
+		c.mri(op, pc, w)
+	}
+	c.clock()
+	if c.Halted {
+		return ErrHalt
+	}
+	return nil
+}
+
+// clock tells the clocked devices the time.
+func (c *CPU) clock() {
+	for _, d := range c.attached {
+		if cd, ok := d.(Clocked); ok {
+			cd.Clock(c.Cycles)
+		}
+	}
+}
+
+// Request reports if an interrupt is requested.
+func (c *CPU) Request() bool {
+	if c.userInt {
+		return true
+	}
+	for _, d := range c.attached {
+		if d.Interrupt() {
+			return true
+		}
+	}
+	return false
+}
+
+// interrupt takes an interrupt, if one is requested and permitted, and
+// reports if it did.
+func (c *CPU) interrupt() bool {
+	if c.ionDelay {
+		c.ionDelay = false
+		return false
+	}
+	if !c.IE || c.inhibit || !c.Request() {
+		return false
+	}
+	c.SF = uint16(c.UF<<6 | c.IF<<3 | c.DF)
+	c.IF, c.IB, c.DF, c.UF, c.UB = 0, 0, 0, 0, 0
+	c.IE = false
+	c.Write(0, c.PC)
+	c.PC = 1
+	c.Cycles++
+	return true
+}
+
+// skip skips the next instruction.
+func (c *CPU) skip() {
+	c.PC = (c.PC + 1) & 07777
+}
+
+// mri executes the memory reference instruction w, with op code op, fetched
+// from pc.
+func (c *CPU) mri(op, pc, w uint16) {
+	addr := w & 0177
+	if w&0200 != 0 {
+		addr |= pc & 07600
+	}
+	field := c.IF
+	if op == 4 || op == 5 {
+		field = c.IB
+	}
+	if w&0400 != 0 {
+		p := core.MakeAddr(c.IF, addr)
+		if addr&07770 == 0010 {
+			c.Write(p, c.Read(p)+1)
+		}
+		addr = c.Read(p)
+		c.Cycles++
+		if op < 4 {
+			field = c.DF
+		}
+	}
+	a := core.MakeAddr(field, addr)
+	switch op {
+	case 0: // AND
+		c.AC &= c.Read(a)
+	case 1: // TAD
+		c.add(c.Read(a))
+	case 2: // ISZ
+		v := (c.Read(a) + 1) & 07777
+		c.Write(a, v)
+		if v == 0 {
+			c.skip()
+		}
+	case 3: // DCA
+		c.Write(a, c.AC)
+		c.AC = 0
+	case 4: // JMS
+		c.jump()
+		c.Write(core.MakeAddr(c.IF, addr), c.PC)
+		c.PC = (addr + 1) & 07777
+	case 5: // JMP
+		c.jump()
+		c.PC = addr
+		return
+	}
+	c.Cycles++
+}
+
+// jump transfers the instruction and user buffers as done by JMP and JMS.
+func (c *CPU) jump() {
+	c.IF, c.UF = c.IB, c.UB
+	c.inhibit = false
+}
+
+// add adds v to the AC, complementing the link on a carry.
+func (c *CPU) add(v uint16) {
+	sum := c.AC + v
+	if sum > 07777 {
+		c.L ^= 1
+	}
+	c.AC = sum & 07777
+}
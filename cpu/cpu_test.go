@@ -0,0 +1,920 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package cpu
+
+import (
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/pal"
+)
+
+// A state is the part of the processor checked after an instruction.
+type state struct {
+	AC, L, MQ, PC, SC uint16
+
+	GT, ModeB, Halted, UserInt bool
+
+	Cycles uint64
+}
+
+func stateOf(c *CPU) state {
+	return state{
+		AC: c.AC, L: c.L, MQ: c.MQ, PC: c.PC, SC: c.SC,
+		GT: c.GT, ModeB: c.ModeB, Halted: c.Halted, UserInt: c.userInt,
+		Cycles: c.Cycles,
+	}
+}
+
+// mem is the contents of memory.
+type mem map[core.Addr]uint16
+
+// eae installs an EAE in mode A.
+func eae(c *CPU) { c.EAE = true }
+
+// modeB installs an EAE in mode B.
+func modeB(c *CPU) { c.EAE, c.ModeB = true, true }
+
+func TestStep(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(c *CPU)
+		code  []uint16 // at 0200
+		mem   mem
+		want  state
+		after mem
+	}{
+		// Memory reference instructions.
+		{
+			name:  "TAD page zero",
+			setup: func(c *CPU) { c.AC = 1 },
+			code:  []uint16{01020},
+			mem:   mem{020: 5},
+			want:  state{AC: 6, PC: 0201, Cycles: 2},
+		}, {
+			name: "TAD current page",
+			code: []uint16{01250},
+			mem:  mem{0250: 7, 050: 1},
+			want: state{AC: 7, PC: 0201, Cycles: 2},
+		}, {
+			name: "TAD indirect",
+			code: []uint16{01420},
+			mem:  mem{020: 0300, 0300: 3},
+			want: state{AC: 3, PC: 0201, Cycles: 3},
+		}, {
+			name: "TAD indirect current page",
+			code: []uint16{01650},
+			mem:  mem{0250: 0300, 0300: 3},
+			want: state{AC: 3, PC: 0201, Cycles: 3},
+		}, {
+			name:  "TAD autoindex",
+			code:  []uint16{01410},
+			mem:   mem{010: 0277, 0300: 4},
+			want:  state{AC: 4, PC: 0201, Cycles: 3},
+			after: mem{010: 0300},
+		}, {
+			name:  "TAD autoindex 17",
+			code:  []uint16{01417},
+			mem:   mem{017: 07777, 0: 2},
+			want:  state{AC: 2, PC: 0201, Cycles: 3},
+			after: mem{017: 0},
+		}, {
+			name:  "TAD 20 is not autoindex",
+			code:  []uint16{01420},
+			mem:   mem{020: 0277, 0277: 4},
+			want:  state{AC: 4, PC: 0201, Cycles: 3},
+			after: mem{020: 0277},
+		}, {
+			name:  "autoindex only when indirect",
+			code:  []uint16{01010},
+			mem:   mem{010: 0277},
+			want:  state{AC: 0277, PC: 0201, Cycles: 2},
+			after: mem{010: 0277},
+		}, {
+			name:  "TAD indirect uses the data field",
+			setup: func(c *CPU) { c.DF = 1 },
+			code:  []uint16{01420},
+			mem:   mem{020: 0300, 0300: 3, 010300: 6},
+			want:  state{AC: 6, PC: 0201, Cycles: 3},
+		}, {
+			name:  "TAD direct ignores the data field",
+			setup: func(c *CPU) { c.DF = 1 },
+			code:  []uint16{01020},
+			mem:   mem{020: 3, 010020: 6},
+			want:  state{AC: 3, PC: 0201, Cycles: 2},
+		}, {
+			name:  "TAD carry complements the link",
+			setup: func(c *CPU) { c.AC, c.L = 07777, 1 },
+			code:  []uint16{01020},
+			mem:   mem{020: 2},
+			want:  state{AC: 1, L: 0, PC: 0201, Cycles: 2},
+		}, {
+			name:  "AND",
+			setup: func(c *CPU) { c.AC, c.L = 07070, 1 },
+			code:  []uint16{00020},
+			mem:   mem{020: 00770},
+			want:  state{AC: 00070, L: 1, PC: 0201, Cycles: 2},
+		}, {
+			name:  "ISZ",
+			code:  []uint16{02020},
+			mem:   mem{020: 5},
+			want:  state{PC: 0201, Cycles: 2},
+			after: mem{020: 6},
+		}, {
+			name:  "ISZ skip",
+			code:  []uint16{02020},
+			mem:   mem{020: 07777},
+			want:  state{PC: 0202, Cycles: 2},
+			after: mem{020: 0},
+		}, {
+			name:  "DCA",
+			setup: func(c *CPU) { c.AC = 5 },
+			code:  []uint16{03020},
+			want:  state{PC: 0201, Cycles: 2},
+			after: mem{020: 5},
+		}, {
+			name:  "DCA indirect",
+			setup: func(c *CPU) { c.AC, c.DF = 5, 1 },
+			code:  []uint16{03420},
+			mem:   mem{020: 0300},
+			want:  state{PC: 0201, Cycles: 3},
+			after: mem{0300: 0, 010300: 5},
+		}, {
+			name:  "JMS",
+			code:  []uint16{04250},
+			want:  state{PC: 0251, Cycles: 2},
+			after: mem{0250: 0201},
+		}, {
+			name:  "JMS indirect",
+			code:  []uint16{04420},
+			mem:   mem{020: 0300},
+			want:  state{PC: 0301, Cycles: 3},
+			after: mem{0300: 0201},
+		}, {
+			name: "JMP",
+			code: []uint16{05250},
+			want: state{PC: 0250, Cycles: 1},
+		}, {
+			name: "JMP indirect",
+			code: []uint16{05420},
+			mem:  mem{020: 0300},
+			want: state{PC: 0300, Cycles: 2},
+		},
+
+		// Group 1 operate instructions.
+		{
+			name:  "NOP",
+			setup: func(c *CPU) { c.AC, c.L = 05, 1 },
+			code:  []uint16{07000},
+			want:  state{AC: 05, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "CLA CLL before CMA CML",
+			setup: func(c *CPU) { c.AC, c.L = 01234, 1 },
+			code:  []uint16{07360},
+			want:  state{AC: 07777, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "CMA before IAC",
+			setup: func(c *CPU) { c.AC = 5 },
+			code:  []uint16{07041},
+			want:  state{AC: 07773, PC: 0201, Cycles: 1},
+		}, {
+			name:  "IAC carry",
+			setup: func(c *CPU) { c.AC = 07777 },
+			code:  []uint16{07001},
+			want:  state{AC: 0, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name: "IAC before RAL",
+			code: []uint16{07205},
+			want: state{AC: 2, PC: 0201, Cycles: 1},
+		}, {
+			name:  "IAC before RTL",
+			setup: func(c *CPU) { c.L = 1 },
+			code:  []uint16{07107},
+			want:  state{AC: 4, PC: 0201, Cycles: 1},
+		}, {
+			name:  "RAL through the link",
+			setup: func(c *CPU) { c.AC, c.L = 04000, 1 },
+			code:  []uint16{07004},
+			want:  state{AC: 1, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "RAR",
+			setup: func(c *CPU) { c.AC = 1 },
+			code:  []uint16{07010},
+			want:  state{AC: 0, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "RTR",
+			setup: func(c *CPU) { c.AC = 1 },
+			code:  []uint16{07012},
+			want:  state{AC: 04000, PC: 0201, Cycles: 1},
+		}, {
+			name: "CML RAR",
+			code: []uint16{07030},
+			want: state{AC: 04000, PC: 0201, Cycles: 1},
+		}, {
+			name:  "BSW",
+			setup: func(c *CPU) { c.AC, c.L = 01234, 1 },
+			code:  []uint16{07002},
+			want:  state{AC: 03412, L: 1, PC: 0201, Cycles: 1},
+		}, {
+			name: "IAC before BSW",
+			code: []uint16{07203},
+			want: state{AC: 0100, PC: 0201, Cycles: 1},
+		},
+
+		// Group 2 operate instructions.
+		{
+			name:  "SMA",
+			setup: func(c *CPU) { c.AC = 04000 },
+			code:  []uint16{07500},
+			want:  state{AC: 04000, PC: 0202, Cycles: 1},
+		}, {
+			name:  "SMA no skip",
+			setup: func(c *CPU) { c.AC = 03777 },
+			code:  []uint16{07500},
+			want:  state{AC: 03777, PC: 0201, Cycles: 1},
+		}, {
+			name: "SZA",
+			code: []uint16{07440},
+			want: state{PC: 0202, Cycles: 1},
+		}, {
+			name:  "SNL",
+			setup: func(c *CPU) { c.L = 1 },
+			code:  []uint16{07420},
+			want:  state{L: 1, PC: 0202, Cycles: 1},
+		}, {
+			name: "SMA SZA skips on either",
+			code: []uint16{07540},
+			want: state{PC: 0202, Cycles: 1},
+		}, {
+			name:  "SPA",
+			setup: func(c *CPU) { c.AC = 1 },
+			code:  []uint16{07510},
+			want:  state{AC: 1, PC: 0202, Cycles: 1},
+		}, {
+			name: "SNA",
+			code: []uint16{07450},
+			want: state{PC: 0201, Cycles: 1},
+		}, {
+			name: "SZL",
+			code: []uint16{07430},
+			want: state{PC: 0202, Cycles: 1},
+		}, {
+			name: "SPA SNA skips on both",
+			code: []uint16{07550},
+			want: state{PC: 0201, Cycles: 1},
+		}, {
+			name: "SKP",
+			code: []uint16{07410},
+			want: state{PC: 0202, Cycles: 1},
+		}, {
+			name:  "SNA CLA tests before clearing",
+			setup: func(c *CPU) { c.AC = 5 },
+			code:  []uint16{07650},
+			want:  state{PC: 0202, Cycles: 1},
+		}, {
+			name:  "CLA OSR",
+			setup: func(c *CPU) { c.AC, c.SR = 07000, 01234 },
+			code:  []uint16{07604},
+			want:  state{AC: 01234, PC: 0201, Cycles: 1},
+		}, {
+			name:  "OSR",
+			setup: func(c *CPU) { c.AC, c.SR = 0070, 0007 },
+			code:  []uint16{07404},
+			want:  state{AC: 0077, PC: 0201, Cycles: 1},
+		}, {
+			name: "HLT",
+			code: []uint16{07402},
+			want: state{PC: 0201, Halted: true, Cycles: 1},
+		}, {
+			name:  "OSR in user mode",
+			setup: func(c *CPU) { c.UF, c.SR = 1, 01234 },
+			code:  []uint16{07404},
+			want:  state{PC: 0201, UserInt: true, Cycles: 1},
+		}, {
+			name:  "HLT in user mode",
+			setup: func(c *CPU) { c.UF = 1 },
+			code:  []uint16{07402},
+			want:  state{PC: 0201, UserInt: true, Cycles: 1},
+		}, {
+			name:  "IOT in user mode",
+			setup: func(c *CPU) { c.UF = 1 },
+			code:  []uint16{06001},
+			want:  state{PC: 0201, UserInt: true, Cycles: 1},
+		},
+
+		// Group 3 operate instructions without an EAE.
+		{
+			name:  "MQL",
+			setup: func(c *CPU) { c.AC = 5 },
+			code:  []uint16{07421},
+			want:  state{MQ: 5, PC: 0201, Cycles: 1},
+		}, {
+			name:  "MQA",
+			setup: func(c *CPU) { c.AC, c.MQ = 1, 6 },
+			code:  []uint16{07501},
+			want:  state{AC: 7, MQ: 6, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SWP",
+			setup: func(c *CPU) { c.AC, c.MQ = 1, 2 },
+			code:  []uint16{07521},
+			want:  state{AC: 2, MQ: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "CAM",
+			setup: func(c *CPU) { c.AC, c.MQ = 1, 2 },
+			code:  []uint16{07621},
+			want:  state{PC: 0201, Cycles: 1},
+		}, {
+			name:  "ACL",
+			setup: func(c *CPU) { c.AC, c.MQ = 1, 2 },
+			code:  []uint16{07701},
+			want:  state{AC: 2, MQ: 2, PC: 0201, Cycles: 1},
+		}, {
+			name:  "no EAE",
+			setup: func(c *CPU) { c.MQ = 3 },
+			code:  []uint16{07405, 2},
+			want:  state{MQ: 3, PC: 0201, Cycles: 1},
+		},
+
+		// EAE mode A.
+		{
+			name:  "SCL",
+			setup: eae,
+			code:  []uint16{07403, 0025},
+			want:  state{SC: 012, PC: 0202, Cycles: 2},
+		}, {
+			name:  "SCA",
+			setup: func(c *CPU) { eae(c); c.AC, c.SC = 060, 5 },
+			code:  []uint16{07441},
+			want:  state{AC: 065, SC: 5, PC: 0201, Cycles: 1},
+		}, {
+			name:  "MUY",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ, c.L = 1, 01001, 1 },
+			code:  []uint16{07405, 010},
+			want:  state{AC: 01, MQ: 011, SC: 014, PC: 0202, Cycles: 2},
+		}, {
+			name:  "DVI",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ = 1, 3 },
+			code:  []uint16{07407, 010},
+			want:  state{AC: 3, MQ: 01000, SC: 015, PC: 0202, Cycles: 2},
+		}, {
+			name:  "DVI overflow",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ = 010, 3 },
+			code:  []uint16{07407, 010},
+			want:  state{AC: 010, MQ: 3, L: 1, SC: 015, PC: 0202, Cycles: 2},
+		}, {
+			name:  "NMI",
+			setup: func(c *CPU) { eae(c); c.MQ = 1 },
+			code:  []uint16{07411},
+			want:  state{AC: 02000, SC: 026, PC: 0201, Cycles: 1},
+		}, {
+			name:  "NMI of 6000",
+			setup: func(c *CPU) { eae(c); c.AC = 06000 },
+			code:  []uint16{07411},
+			want:  state{AC: 04000, L: 1, SC: 1, PC: 0201, Cycles: 1},
+		}, {
+			name:  "NMI of 0",
+			setup: eae,
+			code:  []uint16{07411},
+			want:  state{PC: 0201, Cycles: 1},
+		}, {
+			name:  "SHL shifts one more than its count",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ, c.SC = 04000, 04001, 7 },
+			code:  []uint16{07413, 2},
+			want:  state{AC: 4, MQ: 010, PC: 0202, Cycles: 2},
+		}, {
+			name:  "ASR",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ = 04001, 2 },
+			code:  []uint16{07415, 0},
+			want:  state{AC: 06000, MQ: 04001, L: 1, PC: 0202, Cycles: 2},
+		}, {
+			name:  "LSR",
+			setup: func(c *CPU) { eae(c); c.AC, c.MQ, c.L = 04000, 1, 1 },
+			code:  []uint16{07417, 1},
+			want:  state{AC: 01000, MQ: 0, PC: 0202, Cycles: 2},
+		}, {
+			name:  "SWAB",
+			setup: eae,
+			code:  []uint16{07431},
+			want:  state{ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "DAD is SCA SCL in mode A",
+			setup: func(c *CPU) { eae(c); c.AC, c.SC = 1, 4 },
+			code:  []uint16{07443, 0300},
+			want:  state{AC: 5, SC: 037, PC: 0202, Cycles: 2},
+		},
+
+		// EAE mode B.
+		{
+			name:  "ACS",
+			setup: func(c *CPU) { modeB(c); c.AC = 0145 },
+			code:  []uint16{07403},
+			want:  state{SC: 05, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SCA mode B",
+			setup: func(c *CPU) { modeB(c); c.SC = 7 },
+			code:  []uint16{07441},
+			want:  state{AC: 7, SC: 7, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "MUY mode B",
+			setup: func(c *CPU) { modeB(c); c.MQ = 5 },
+			code:  []uint16{07405, 0300},
+			mem:   mem{0300: 3},
+			want:  state{MQ: 017, SC: 014, ModeB: true, PC: 0202, Cycles: 3},
+		}, {
+			name:  "MUY mode B uses the data field",
+			setup: func(c *CPU) { modeB(c); c.MQ, c.DF = 5, 1 },
+			code:  []uint16{07405, 0300},
+			mem:   mem{0300: 3, 010300: 2},
+			want:  state{MQ: 012, SC: 014, ModeB: true, PC: 0202, Cycles: 3},
+		}, {
+			name:  "DVI mode B",
+			setup: func(c *CPU) { modeB(c); c.MQ = 021 },
+			code:  []uint16{07407, 0300},
+			mem:   mem{0300: 4},
+			want:  state{AC: 1, MQ: 4, SC: 015, ModeB: true, PC: 0202, Cycles: 3},
+		}, {
+			name:  "NMI mode B stops at 6000",
+			setup: func(c *CPU) { modeB(c); c.AC = 06000 },
+			code:  []uint16{07411},
+			want:  state{AC: 06000, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SHL mode B",
+			setup: func(c *CPU) { modeB(c); c.MQ = 1 },
+			code:  []uint16{07413, 2},
+			want:  state{MQ: 4, ModeB: true, PC: 0202, Cycles: 2},
+		}, {
+			name:  "ASR mode B",
+			setup: func(c *CPU) { modeB(c); c.MQ = 3 },
+			code:  []uint16{07415, 1},
+			want:  state{MQ: 1, GT: true, ModeB: true, PC: 0202, Cycles: 2},
+		}, {
+			name:  "LSR mode B",
+			setup: func(c *CPU) { modeB(c); c.AC, c.GT = 1, true },
+			code:  []uint16{07417, 2},
+			want:  state{MQ: 02000, ModeB: true, PC: 0202, Cycles: 2},
+		}, {
+			name:  "DAD",
+			setup: func(c *CPU) { modeB(c); c.MQ = 07777 },
+			code:  []uint16{07443, 0300},
+			mem:   mem{0300: 1, 0301: 2},
+			want:  state{AC: 3, ModeB: true, PC: 0202, Cycles: 4},
+		}, {
+			name:  "DAD carry",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ = 07777, 07777 },
+			code:  []uint16{07443, 0300},
+			mem:   mem{0300: 1},
+			want:  state{L: 1, ModeB: true, PC: 0202, Cycles: 4},
+		}, {
+			name:  "DST",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ, c.DF = 1, 2, 1 },
+			code:  []uint16{07445, 0300},
+			want:  state{AC: 1, MQ: 2, ModeB: true, PC: 0202, Cycles: 4},
+			after: mem{010300: 2, 010301: 1},
+		}, {
+			name:  "DLD",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ = 1, 2 },
+			code:  []uint16{07763, 0300},
+			mem:   mem{0300: 5, 0301: 6},
+			want:  state{AC: 6, MQ: 5, ModeB: true, PC: 0202, Cycles: 4},
+		}, {
+			name:  "DPSZ",
+			setup: modeB,
+			code:  []uint16{07451},
+			want:  state{ModeB: true, PC: 0202, Cycles: 1},
+		}, {
+			name:  "DPSZ no skip",
+			setup: func(c *CPU) { modeB(c); c.MQ = 1 },
+			code:  []uint16{07451},
+			want:  state{MQ: 1, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "DPIC",
+			setup: func(c *CPU) { modeB(c); c.MQ = 07777 },
+			code:  []uint16{07573},
+			want:  state{AC: 1, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "DCM",
+			setup: func(c *CPU) { modeB(c); c.MQ = 1 },
+			code:  []uint16{07575},
+			want:  state{AC: 07777, MQ: 07777, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SAM",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ = 1, 3 },
+			code:  []uint16{07457},
+			want:  state{AC: 2, MQ: 3, L: 1, GT: true, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SAM less",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ, c.GT = 2, 1, true },
+			code:  []uint16{07457},
+			want:  state{AC: 07777, MQ: 1, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SAM is signed",
+			setup: func(c *CPU) { modeB(c); c.AC, c.MQ = 07777, 1 },
+			code:  []uint16{07457},
+			want:  state{AC: 2, MQ: 1, GT: true, ModeB: true, PC: 0201, Cycles: 1},
+		}, {
+			name:  "SGT",
+			setup: func(c *CPU) { modeB(c); c.GT = true },
+			code:  []uint16{06006},
+			want:  state{GT: true, ModeB: true, PC: 0202, Cycles: 1},
+		}, {
+			name:  "SWBA",
+			setup: func(c *CPU) { modeB(c); c.GT = true },
+			code:  []uint16{07447},
+			want:  state{PC: 0201, Cycles: 1},
+		},
+	} {
+		c := New(2)
+		if tt.setup != nil {
+			tt.setup(c)
+		}
+		for a, w := range tt.mem {
+			c.Write(a, w)
+		}
+		for i, w := range tt.code {
+			c.Write(core.Addr(0200+i), w)
+		}
+		err := c.Step()
+		if got := stateOf(c); got != tt.want {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+		if (err == ErrHalt) != tt.want.Halted {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		for a, w := range tt.after {
+			if got := c.Read(a); got != w {
+				t.Errorf("%s: %v: got %04o, want %04o", tt.name, a, got, w)
+			}
+		}
+	}
+}
+
+// A dev is a device whose flag is raised by the test or by its clock and
+// which counts the times its flag is cleared.
+type dev struct {
+	flag   bool
+	every  uint64 // raise the flag every every cycles
+	count  int
+	resets int
+}
+
+// IOT 1 skips on the flag and IOT 2 clears it.
+func (d *dev) IOT(c *CPU, w uint16) bool {
+	switch w & 7 {
+	case 1:
+		return d.flag
+	case 2:
+		d.flag = false
+		d.count++
+	}
+	return false
+}
+
+func (d *dev) Interrupt() bool { return d.flag }
+func (d *dev) Reset()          { d.flag = false; d.resets++ }
+
+func (d *dev) Clock(n uint64) {
+	if d.every != 0 && n%d.every == 0 {
+		d.flag = true
+	}
+}
+
+// step executes n instructions or interrupts.
+func step(t *testing.T, c *CPU, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatalf("step %d: %v at %o:%04o", i, err, c.IF, c.PC)
+		}
+	}
+}
+
+// load writes words starting at a.
+func load(c *CPU, a core.Addr, words ...uint16) {
+	for i, w := range words {
+		c.Write(a+core.Addr(i), w)
+	}
+}
+
+func TestInterruptDelay(t *testing.T) {
+	d := &dev{flag: true}
+	c := New(1)
+	c.Attach(040, d)
+	load(c, 0200,
+		06001, // ION
+		07000, // NOP
+		07000, // NOP
+	)
+	step(t, c, 2)
+	if c.PC != 0202 {
+		t.Fatalf("interrupt taken after ION, PC %04o", c.PC)
+	}
+	before := c.Cycles
+	step(t, c, 1)
+	if c.PC != 1 || c.Read(0) != 0202 || c.IE {
+		t.Errorf("got PC %04o, 0: %04o, IE %v", c.PC, c.Read(0), c.IE)
+	}
+	if c.Cycles != before+1 {
+		t.Errorf("interrupt took %d cycles", c.Cycles-before)
+	}
+}
+
+func TestCIF(t *testing.T) {
+	d := &dev{flag: true}
+	c := New(2)
+	c.Attach(040, d)
+	load(c, 0200,
+		06001, // ION
+		06212, // CIF 10
+		07000, // NOP
+		04300, // JMS 0300
+	)
+	load(c, 1,
+		06234, // RIB
+		06244, // RMF
+		06001, // ION
+		07000, // NOP
+		05400, // JMP I 0
+	)
+	step(t, c, 3)
+	if c.IF != 0 || c.IB != 1 || c.PC != 0203 {
+		t.Fatalf("after CIF: IF %o IB %o PC %04o", c.IF, c.IB, c.PC)
+	}
+	// JMS transfers IB to IF and stores the return in the new field.
+	step(t, c, 1)
+	if c.IF != 1 || c.PC != 0301 || c.Read(010300) != 0204 {
+		t.Fatalf("after JMS: IF %o PC %04o 1:0300 %04o", c.IF, c.PC, c.Read(010300))
+	}
+	// The interrupt is taken after the JMS and saves the fields.
+	c.DF = 2
+	step(t, c, 1)
+	if c.IF != 0 || c.DF != 0 || c.PC != 1 || c.SF != 012 || c.Read(0) != 0301 {
+		t.Fatalf("after interrupt: IF %o DF %o PC %04o SF %03o 0: %04o", c.IF, c.DF, c.PC, c.SF, c.Read(0))
+	}
+	step(t, c, 2) // RIB RMF
+	if c.AC != 012 || c.IB != 1 || c.DF != 2 || c.IF != 0 {
+		t.Fatalf("after RIB RMF: AC %04o IB %o DF %o IF %o", c.AC, c.IB, c.DF, c.IF)
+	}
+	// RMF inhibits interrupts until the JMP, even after ION.
+	step(t, c, 3) // ION NOP JMP I 0
+	if c.IF != 1 || c.PC != 0301 {
+		t.Fatalf("after JMP: IF %o PC %04o", c.IF, c.PC)
+	}
+	step(t, c, 1)
+	if c.PC != 1 || c.Read(0) != 0301 {
+		t.Errorf("interrupt not taken after JMP: PC %04o", c.PC)
+	}
+}
+
+func TestGTFRTF(t *testing.T) {
+	c := New(1)
+	c.EAE = true
+	c.L, c.GT, c.IE, c.SF = 1, true, true, 0123
+	load(c, 0200,
+		06004, // GTF
+		06005, // RTF
+		05300, // JMP 0300
+	)
+	step(t, c, 1)
+	if c.AC != 06323 {
+		t.Errorf("GTF: got %04o, want 6323", c.AC)
+	}
+	c.L, c.GT, c.IE = 0, false, false
+	c.AC = 06000 | 0100 | 030 | 2
+	step(t, c, 1)
+	if c.L != 1 || !c.GT || c.UB != 1 || c.IB != 3 || c.DF != 2 || !c.IE || !c.inhibit {
+		t.Errorf("RTF: L %o GT %v UB %o IB %o DF %o IE %v inhibit %v", c.L, c.GT, c.UB, c.IB, c.DF, c.IE, c.inhibit)
+	}
+	if c.UF != 0 || c.IF != 0 {
+		t.Errorf("RTF changed UF %o or IF %o before JMP", c.UF, c.IF)
+	}
+	step(t, c, 1)
+	if c.UF != 1 || c.IF != 3 || c.PC != 0300 || c.inhibit {
+		t.Errorf("after JMP: UF %o IF %o PC %04o inhibit %v", c.UF, c.IF, c.PC, c.inhibit)
+	}
+}
+
+func TestProcessorIOTs(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(c *CPU, d *dev)
+		w     uint16
+		check func(c *CPU, d *dev) bool
+		pc    uint16
+	}{
+		{"SKON on", func(c *CPU, d *dev) { c.IE = true }, 06000, func(c *CPU, d *dev) bool { return !c.IE }, 0202},
+		{"SKON off", nil, 06000, func(c *CPU, d *dev) bool { return !c.IE }, 0201},
+		{"IOF", func(c *CPU, d *dev) { c.IE = true }, 06002, func(c *CPU, d *dev) bool { return !c.IE }, 0201},
+		{"SRQ", func(c *CPU, d *dev) { d.flag = true }, 06003, nil, 0202},
+		{"SRQ none", nil, 06003, nil, 0201},
+		{"SGT off", func(c *CPU, d *dev) { c.GT = false }, 06006, nil, 0201},
+		{"CAF", func(c *CPU, d *dev) { c.AC, c.L, c.IE, c.GT = 5, 1, true, true }, 06007,
+			func(c *CPU, d *dev) bool { return c.AC == 0 && c.L == 0 && !c.IE && !c.GT && d.resets == 1 }, 0201},
+		{"CDF", nil, 06231, func(c *CPU, d *dev) bool { return c.DF == 3 && c.IB == 0 }, 0201},
+		{"CDF CIF", nil, 06233, func(c *CPU, d *dev) bool { return c.DF == 3 && c.IB == 3 && c.IF == 0 }, 0201},
+		{"RDF", func(c *CPU, d *dev) { c.AC, c.DF = 1, 3 }, 06214, func(c *CPU, d *dev) bool { return c.AC == 031 }, 0201},
+		{"RIF", func(c *CPU, d *dev) { c.IF, c.IB = 2, 2 }, 06224, func(c *CPU, d *dev) bool { return c.AC == 020 }, 0201},
+		{"device skip", func(c *CPU, d *dev) { d.flag = true }, 06401, nil, 0202},
+		{"device clear", func(c *CPU, d *dev) { d.flag = true }, 06402, func(c *CPU, d *dev) bool { return !d.flag && d.count == 1 }, 0201},
+		{"no device", nil, 06501, nil, 0201},
+		{"CUF without time share", nil, 06264, func(c *CPU, d *dev) bool { return !c.inhibit }, 0201},
+	} {
+		d := &dev{}
+		c := New(4)
+		c.Attach(040, d)
+		if tt.setup != nil {
+			tt.setup(c, d)
+		}
+		load(c, core.MakeAddr(c.IF, 0200), tt.w)
+		if err := c.Step(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if c.PC != tt.pc {
+			t.Errorf("%s: got PC %04o, want %04o", tt.name, c.PC, tt.pc)
+		}
+		if tt.check != nil && !tt.check(c, d) {
+			t.Errorf("%s: wrong state %+v", tt.name, stateOf(c))
+		}
+	}
+}
+
+func TestTimeShare(t *testing.T) {
+	c := New(2)
+	c.TimeShare = true
+	load(c, 0200,
+		06274, // SUF
+		06212, // CIF 10
+		05300, // JMP 0300
+	)
+	load(c, 010300,
+		06001, // ION
+	)
+	load(c, 1,
+		06254, // SINT
+		07402, // HLT
+		06204, // CINT
+		06254, // SINT
+		07000, // NOP
+		06264, // CUF
+	)
+	step(t, c, 2)
+	if c.UF != 0 || c.UB != 1 {
+		t.Fatalf("SUF: UF %o UB %o", c.UF, c.UB)
+	}
+	step(t, c, 1)
+	if c.UF != 1 || c.IF != 1 {
+		t.Fatalf("JMP: UF %o IF %o", c.UF, c.IF)
+	}
+	// ION is an IOT, which is a user mode interrupt.
+	c.IE = true
+	step(t, c, 1)
+	if !c.userInt || c.PC != 0301 {
+		t.Fatalf("IOT in user mode: userInt %v PC %04o", c.userInt, c.PC)
+	}
+	step(t, c, 1)
+	if c.PC != 1 || c.SF != 0110 || c.UF != 0 || c.IF != 0 {
+		t.Fatalf("interrupt: PC %04o SF %03o UF %o IF %o", c.PC, c.SF, c.UF, c.IF)
+	}
+	step(t, c, 1) // SINT
+	if c.PC != 3 {
+		t.Errorf("SINT did not skip: PC %04o", c.PC)
+	}
+	step(t, c, 2) // CINT SINT
+	if c.userInt || c.PC != 5 {
+		t.Errorf("CINT: userInt %v PC %04o", c.userInt, c.PC)
+	}
+	c.UB = 1
+	step(t, c, 2) // NOP CUF
+	if c.UB != 0 || !c.inhibit {
+		t.Errorf("CUF: UB %o inhibit %v", c.UB, c.inhibit)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	c := New(1)
+	for _, code := range []int{0, 020, 027, 0100} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Attach(%02o) did not panic", code)
+				}
+			}()
+			c.Attach(code, &dev{})
+		}()
+	}
+	d := &dev{}
+	c.Attach(030, d)
+	c.Attach(031, d)
+	if len(c.attached) != 1 {
+		t.Errorf("device attached %d times", len(c.attached))
+	}
+}
+
+// run assembles src, runs it from 0200 until it halts, and returns the
+// processor.
+func run(t *testing.T, src string, setup func(c *CPU)) *CPU {
+	t.Helper()
+	p, err := pal.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(2)
+	if setup != nil {
+		setup(c)
+	}
+	c.Load(p.Image)
+	c.Start(0200)
+	if err := c.Run(100000); err != ErrHalt {
+		t.Fatalf("run: %v at %o:%04o", err, c.IF, c.PC)
+	}
+	return c
+}
+
+func TestProgram(t *testing.T) {
+	c := run(t, `*10
+X10,	1777
+*200
+	CLA CLL
+	TAD (5)
+	TAD (7773)
+	SZA
+	HLT
+	SNL
+	HLT
+	TAD (-3)
+	DCA CNT
+LOOP,	TAD (1)
+	DCA I X10
+	ISZ CNT
+	JMP LOOP
+	CDF 10
+	TAD (42)
+	DCA I (300)
+	CDF 0
+	CLA CLL CMA RAL
+	HLT
+CNT,	0
+`, nil)
+	if c.AC != 07776 || c.L != 1 {
+		t.Errorf("got AC %04o L %o, want 7776 1", c.AC, c.L)
+	}
+	for a, w := range (mem{02000: 1, 02001: 1, 02002: 1, 02003: 0, 010300: 042, 0300: 0}) {
+		if got := c.Read(a); got != w {
+			t.Errorf("%v: got %04o, want %04o", a, got, w)
+		}
+	}
+}
+
+func TestInterrupts(t *testing.T) {
+	d := &dev{every: 50}
+	c := New(1)
+	c.Attach(040, d)
+	p, err := pal.Assemble([]byte(`*0
+	0
+	JMP I (INT)
+*200
+	ION
+WAIT,	TAD N
+	TAD (-5)
+	SZA CLA
+	JMP WAIT
+	HLT
+INT,	6402
+	ISZ N
+	NOP
+	ION
+	JMP I 0
+N,	0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Load(p.Image)
+	c.Start(0200)
+	if err := c.Run(100000); err != ErrHalt {
+		t.Fatal(err)
+	}
+	if d.count != 5 {
+		t.Errorf("got %d interrupts, want 5", d.count)
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := New(1)
+	load(c, 0200, 05200) // JMP .
+	if err := c.Run(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Cycles != 10 {
+		t.Errorf("got %d cycles, want 10", c.Cycles)
+	}
+	c.Halted = true
+	if err := c.Step(); err != ErrHalt {
+		t.Errorf("halted Step returned %v", err)
+	}
+	if c.Cycles != 10 {
+		t.Errorf("halted Step took a cycle")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package cpu
+
+// iot executes the IOT w.
+func (c *CPU) iot(w uint16) {
+	if c.UF != 0 {
+		// IOTs are privileged.
+		c.userInt = true
+		return
+	}
+	code := int(w>>3) & 077
+	switch {
+	case code == 0:
+		c.processor(w)
+		return
+	case code&070 == 020:
+		c.memory(w)
+		return
+	}
+	if d := c.devices[code]; d != nil && d.IOT(c, w) {
+		c.skip()
+	}
+}
+
+// processor executes the processor IOT w (device 00).
+func (c *CPU) processor(w uint16) {
+	switch w & 7 {
+	case 0: // SKON
+		if c.IE {
+			c.skip()
+		}
+		c.IE = false
+	case 1: // ION
+		if !c.IE {
+			c.ionDelay = true
+		}
+		c.IE = true
+	case 2: // IOF
+		c.IE = false
+	case 3: // SRQ
+		if c.Request() {
+			c.skip()
+		}
+	case 4: // GTF
+		c.AC = c.L<<11 | c.SF&0177
+		if c.GT {
+			c.AC |= 02000
+		}
+		if c.Request() {
+			c.AC |= 01000
+		}
+		if c.inhibit {
+			c.AC |= 00400
+		}
+		if c.IE {
+			c.AC |= 00200
+		}
+	case 5: // RTF
+		c.L = c.AC >> 11
+		c.GT = c.AC&02000 != 0
+		c.UB = int(c.AC>>6) & 1
+		c.IB = int(c.AC>>3) & 7
+		c.DF = int(c.AC) & 7
+		c.IE = true
+		c.ionDelay = true
+		c.inhibit = true
+	case 6: // SGT
+		if c.GT {
+			c.skip()
+		}
+	case 7: // CAF
+		c.Reset()
+	}
+}
+
+// memory executes the KM8E memory extension IOT w (devices 20-27).
+func (c *CPU) memory(w uint16) {
+	field := int(w>>3) & 7
+	if w&3 != 0 {
+		if w&1 != 0 { // CDF
+			c.DF = field
+		}
+		if w&2 != 0 { // CIF
+			c.IB = field
+			c.inhibit = true
+		}
+		return
+	}
+	switch w {
+	case 06214: // RDF
+		c.AC |= uint16(c.DF << 3)
+	case 06224: // RIF
+		c.AC |= uint16(c.IF << 3)
+	case 06234: // RIB
+		c.AC |= c.SF & 0177
+	case 06244: // RMF
+		c.UB = int(c.SF>>6) & 1
+		c.IB = int(c.SF>>3) & 7
+		c.DF = int(c.SF) & 7
+		c.inhibit = true
+	}
+	if !c.TimeShare {
+		return
+	}
+	switch w {
+	case 06204: // CINT
+		c.userInt = false
+	case 06254: // SINT
+		if c.userInt {
+			c.skip()
+		}
+	case 06264: // CUF
+		c.UB = 0
+		c.inhibit = true
+	case 06274: // SUF
+		c.UB = 1
+		c.inhibit = true
+	}
+}
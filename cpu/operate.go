@@ -0,0 +1,325 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package cpu
+
+import "github.com/pborman/pdp8/core"
+
+// operate executes the operate microinstruction w.
+func (c *CPU) operate(w uint16) {
+	switch {
+	case w&0400 == 0:
+		c.group1(w)
+	case w&0001 == 0:
+		c.group2(w)
+	default:
+		c.group3(w)
+	}
+}
+
+// group1 executes the group 1 microinstruction w.
+func (c *CPU) group1(w uint16) {
+	if w&0200 != 0 { // CLA
+		c.AC = 0
+	}
+	if w&0100 != 0 { // CLL
+		c.L = 0
+	}
+	if w&0040 != 0 { // CMA
+		c.AC ^= 07777
+	}
+	if w&0020 != 0 { // CML
+		c.L ^= 1
+	}
+	if w&0001 != 0 { // IAC
+		c.add(1)
+	}
+	switch w & 0016 {
+	case 0002: // BSW
+		c.AC = (c.AC<<6 | c.AC>>6) & 07777
+	case 0004: // RAL
+		c.rotateLeft()
+	case 0006: // RTL
+		c.rotateLeft()
+		c.rotateLeft()
+	case 0010: // RAR
+		c.rotateRight()
+	case 0012: // RTR
+		c.rotateRight()
+		c.rotateRight()
+	}
+}
+
+// rotateLeft rotates the link and AC left one bit.
+func (c *CPU) rotateLeft() {
+	v := c.L<<12 | c.AC
+	v = (v<<1 | v>>12) & 017777
+	c.L, c.AC = v>>12, v&07777
+}
+
+// rotateRight rotates the link and AC right one bit.
+func (c *CPU) rotateRight() {
+	v := c.L<<12 | c.AC
+	v = (v>>1 | v<<12) & 017777
+	c.L, c.AC = v>>12, v&07777
+}
+
+// group2 executes the group 2 microinstruction w.
+func (c *CPU) group2(w uint16) {
+	if w&0006 != 0 && c.UF != 0 {
+		// OSR and HLT are privileged.
+		c.userInt = true
+		return
+	}
+	skip := w&0100 != 0 && c.AC&04000 != 0 || // SMA
+		w&0040 != 0 && c.AC == 0 || // SZA
+		w&0020 != 0 && c.L != 0 // SNL
+	if w&0010 != 0 {
+		skip = !skip
+	}
+	if skip {
+		c.skip()
+	}
+	if w&0200 != 0 { // CLA
+		c.AC = 0
+	}
+	if w&0004 != 0 { // OSR
+		c.AC |= c.SR
+	}
+	if w&0002 != 0 { // HLT
+		c.Halted = true
+	}
+}
+
+// group3 executes the group 3 (MQ and EAE) microinstruction w.
+func (c *CPU) group3(w uint16) {
+	if c.EAE && c.ModeB {
+		switch w {
+		case 07763: // DLD
+			c.AC, c.MQ = 0, 0
+			c.dad()
+			return
+		case 07573: // DPIC
+			v := (uint32(c.AC)<<12 | uint32(c.MQ)) + 1
+			c.L = uint16(v>>24) & 1
+			c.AC, c.MQ = uint16(v>>12)&07777, uint16(v)&07777
+			return
+		case 07575: // DCM
+			v := (^(uint32(c.AC)<<12 | uint32(c.MQ)) & 077777777) + 1
+			c.L = uint16(v>>24) & 1
+			c.AC, c.MQ = uint16(v>>12)&07777, uint16(v)&07777
+			return
+		}
+	}
+	if w&0200 != 0 { // CLA
+		c.AC = 0
+	}
+	switch w & 0120 {
+	case 0120: // SWP
+		c.AC, c.MQ = c.MQ, c.AC
+	case 0100: // MQA
+		c.AC |= c.MQ
+	case 0020: // MQL
+		c.MQ, c.AC = c.AC, 0
+	}
+	if !c.EAE {
+		return
+	}
+	if !c.ModeB {
+		if w == 07431 { // SWAB
+			c.ModeB = true
+			return
+		}
+		if w&0040 != 0 { // SCA
+			c.AC |= c.SC
+		}
+		c.eaeA((w >> 1) & 7)
+		return
+	}
+	c.eaeB((w>>1)&7 | (w&0040)>>2)
+}
+
+// next returns the word following the instruction and skips over it.
+func (c *CPU) next() uint16 {
+	v := c.Read(core.MakeAddr(c.IF, c.PC))
+	c.skip()
+	c.Cycles++
+	return v
+}
+
+// operand returns the address of a mode B operand, which is the word following
+// the instruction, in the data field.
+func (c *CPU) operand() core.Addr {
+	return core.MakeAddr(c.DF, c.next())
+}
+
+// eaeA executes the mode A EAE instruction op.
+func (c *CPU) eaeA(op uint16) {
+	switch op {
+	case 1: // SCL
+		c.SC = ^c.next() & 037
+	case 2: // MUY
+		c.muy(c.next())
+	case 3: // DVI
+		c.dvi(c.next())
+	case 4: // NMI
+		c.nmi()
+	case 5: // SHL
+		c.shl(c.next()&037 + 1)
+	case 6: // ASR
+		c.asr(c.next()&037 + 1)
+	case 7: // LSR
+		c.lsr(c.next()&037 + 1)
+	}
+}
+
+// eaeB executes the mode B EAE instruction op.
+func (c *CPU) eaeB(op uint16) {
+	switch op {
+	case 001: // ACS
+		c.SC = c.AC & 037
+		c.AC = 0
+	case 002: // MUY
+		a := c.operand()
+		c.Cycles++
+		c.muy(c.Read(a))
+	case 003: // DVI
+		a := c.operand()
+		c.Cycles++
+		c.dvi(c.Read(a))
+	case 004: // NMI
+		c.nmi()
+	case 005: // SHL
+		c.shl(c.next() & 037)
+	case 006: // ASR
+		c.asr(c.next() & 037)
+	case 007: // LSR
+		c.lsr(c.next() & 037)
+	case 010: // SCA
+		c.AC |= c.SC
+	case 011: // DAD
+		c.dad()
+	case 012: // DST
+		a := c.operand()
+		c.Write(a, c.MQ)
+		c.Write(core.MakeAddr(a.Field(), a.Offset()+1), c.AC)
+		c.Cycles += 2
+	case 013: // SWBA
+		c.ModeB = false
+		c.GT = false
+	case 014: // DPSZ
+		if c.AC == 0 && c.MQ == 0 {
+			c.skip()
+		}
+	case 017: // SAM
+		mq, ac := signed(c.MQ), signed(c.AC)
+		c.GT = mq > ac
+		v := c.MQ + (^c.AC & 07777) + 1
+		c.L = v >> 12 & 1
+		c.AC = v & 07777
+	}
+}
+
+// signed returns the 12 bit word w as a signed number.
+func signed(w uint16) int {
+	if w&04000 != 0 {
+		return int(w) - 010000
+	}
+	return int(w)
+}
+
+// dad adds the double word operand, low word first, to the AC (high) and MQ
+// (low).
+func (c *CPU) dad() {
+	a := c.operand()
+	lo := c.Read(a)
+	hi := c.Read(core.MakeAddr(a.Field(), a.Offset()+1))
+	c.Cycles += 2
+	v := uint32(c.AC)<<12 | uint32(c.MQ)
+	v += uint32(hi)<<12 | uint32(lo)
+	c.L = uint16(v>>24) & 1
+	c.AC, c.MQ = uint16(v>>12)&07777, uint16(v)&07777
+}
+
+// muy multiplies the MQ by n and adds the AC, leaving the product in the AC
+// (high) and MQ (low).
+func (c *CPU) muy(n uint16) {
+	v := uint32(c.MQ)*uint32(n) + uint32(c.AC)
+	c.AC, c.MQ = uint16(v>>12)&07777, uint16(v)&07777
+	c.L = 0
+	c.SC = 014
+}
+
+// dvi divides the AC (high) and MQ (low) by n, leaving the quotient in the MQ
+// and the remainder in the AC.  The link is set on overflow, in which case the
+// AC and MQ are not changed.
+func (c *CPU) dvi(n uint16) {
+	c.SC = 015
+	if c.AC >= n {
+		c.L = 1
+		return
+	}
+	v := uint32(c.AC)<<12 | uint32(c.MQ)
+	c.MQ, c.AC = uint16(v/uint32(n)), uint16(v%uint32(n))
+	c.L = 0
+}
+
+// nmi normalizes the AC and MQ, shifting them left until the two high bits of
+// the AC differ, and sets the SC to the number of shifts.
+func (c *CPU) nmi() {
+	c.SC = 0
+	for c.AC != 0 || c.MQ != 0 {
+		if (c.AC&04000 == 0) != (c.AC&02000 == 0) {
+			break
+		}
+		if c.ModeB && c.AC == 06000 && c.MQ == 0 {
+			break
+		}
+		c.L = c.AC >> 11
+		c.AC = (c.AC<<1 | c.MQ>>11) & 07777
+		c.MQ = c.MQ << 1 & 07777
+		c.SC = (c.SC + 1) & 037
+	}
+}
+
+// shl shifts the link, AC, and MQ left n bits.
+func (c *CPU) shl(n uint16) {
+	for ; n > 0; n-- {
+		c.L = c.AC >> 11
+		c.AC = (c.AC<<1 | c.MQ>>11) & 07777
+		c.MQ = c.MQ << 1 & 07777
+	}
+	c.SC = 0
+}
+
+// asr shifts the AC and MQ right n bits, extending the sign of the AC, which
+// is also copied to the link.  In mode B the GT flag is set to the last bit
+// shifted out of the MQ.
+func (c *CPU) asr(n uint16) {
+	sign := c.AC & 04000
+	for ; n > 0; n-- {
+		if c.ModeB {
+			c.GT = c.MQ&1 != 0
+		}
+		c.MQ = (c.MQ>>1 | (c.AC&1)<<11) & 07777
+		c.AC = c.AC>>1 | sign
+	}
+	c.L = sign >> 11
+	c.SC = 0
+}
+
+// lsr shifts the AC and MQ right n bits, shifting in zeros, and clears the
+// link.  In mode B the GT flag is set to the last bit shifted out of the MQ.
+func (c *CPU) lsr(n uint16) {
+	for ; n > 0; n-- {
+		if c.ModeB {
+			c.GT = c.MQ&1 != 0
+		}
+		c.MQ = (c.MQ>>1 | (c.AC&1)<<11) & 07777
+		c.AC >>= 1
+	}
+	c.L = 0
+	c.SC = 0
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disasm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Device is a peripheral addressed by IOT instructions.  The IOT 6DDF
+// performs function F on the device with the code DD.
+type Device struct {
+	Code        uint16    // device code, 00 through 77
+	Name        string    // name of the device, e.g., RK8E
+	Description string    // optional description
+	Functions   [8]string // mnemonic of each function, "" if none
+}
+
+// A DeviceTable maps device codes to devices.
+type DeviceTable map[uint16]*Device
+
+// Lookup returns the mnemonic of the IOT instruction w.
+func (t DeviceTable) Lookup(w uint16) (string, bool) {
+	if w&07000 != 06000 {
+		return "", false
+	}
+	d := t[w>>3&077]
+	if d == nil || d.Functions[w&07] == "" {
+		return "", false
+	}
+	return d.Functions[w&07], true
+}
+
+// MergeDevices returns a table of the devices in tables.  A device in a later
+// table replaces a device with the same code in an earlier table.
+func MergeDevices(tables ...DeviceTable) DeviceTable {
+	m := DeviceTable{}
+	for _, t := range tables {
+		for code, d := range t {
+			m[code] = d
+		}
+	}
+	return m
+}
+
+// ReadDevices reads a device table from the file path.  See ParseDevices.
+func ReadDevices(path string) (DeviceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseDevices(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// ParseDevices parses a device table in either text or JSON.  Device codes
+// are in octal.
+//
+// The text form has one device per line:
+//
+//	CODE NAME F0 F1 F2 F3 F4 F5 F6 F7 DESCRIPTION
+//
+// F0 through F7 are the mnemonics of functions 0 through 7, - if the function
+// has no mnemonic.  The DESCRIPTION is optional and is the rest of the line.
+// Blank lines and lines starting with # are ignored.
+//
+// The JSON form, recognized by starting with [, is an array of devices:
+//
+//	[{"code": "74", "name": "RK8E", "description": "RK05 disk",
+//	  "functions": {"1": "DSKP", "2": "DCLR"}}]
+func ParseDevices(data []byte) (DeviceTable, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return parseJSONDevices(data)
+	}
+	t := DeviceTable{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("line %d: want CODE NAME and 8 functions", line)
+		}
+		d := &Device{
+			Name:        fields[1],
+			Description: strings.Join(fields[10:], " "),
+		}
+		for i, f := range fields[2:10] {
+			if f != "-" {
+				d.Functions[i] = f
+			}
+		}
+		if err := t.add(fields[0], d); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return t, s.Err()
+}
+
+// A jsonDevice is the JSON form of a Device.
+type jsonDevice struct {
+	Code        string            `json:"code"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Functions   map[string]string `json:"functions"`
+}
+
+func parseJSONDevices(data []byte) (DeviceTable, error) {
+	var jds []jsonDevice
+	if err := json.Unmarshal(data, &jds); err != nil {
+		return nil, err
+	}
+	t := DeviceTable{}
+	for _, jd := range jds {
+		if jd.Name == "" {
+			return nil, fmt.Errorf("device %s: missing name", jd.Code)
+		}
+		d := &Device{Name: jd.Name, Description: jd.Description}
+		for f, op := range jd.Functions {
+			n, err := strconv.ParseUint(f, 8, 3)
+			if err != nil {
+				return nil, fmt.Errorf("device %s: invalid function: %q", jd.Code, f)
+			}
+			d.Functions[n] = op
+		}
+		if err := t.add(jd.Code, d); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// add adds d to t with the octal device code code.
+func (t DeviceTable) add(code string, d *Device) error {
+	n, err := strconv.ParseUint(code, 8, 6)
+	if err != nil {
+		return fmt.Errorf("invalid device code: %q", code)
+	}
+	if t[uint16(n)] != nil {
+		return fmt.Errorf("duplicate device code: %02o", n)
+	}
+	d.Code = uint16(n)
+	t[d.Code] = d
+	return nil
+}
+
+// Write writes t to w in the text form read by ParseDevices.
+func (t DeviceTable) Write(w io.Writer) error {
+	codes := make([]int, 0, len(t))
+	for code := range t {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		d := t[uint16(code)]
+		line := fmt.Sprintf("%02o %-6s", code, d.Name)
+		for _, f := range d.Functions {
+			if f == "" {
+				f = "-"
+			}
+			line += fmt.Sprintf(" %-5s", f)
+		}
+		line = strings.TrimRight(line+" "+d.Description, " ")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultDevices are the standard DEC peripherals at their usual device codes.
+var DefaultDevices = mustParseDevices(defaultDevices)
+
+const defaultDevices = `
+# CODE NAME  F0    F1    F2    F3    F4    F5    F6    F7    DESCRIPTION
+01 PR8E   RPE   RSF   RRB   -     RCF   -     RCC   -     high speed paper tape reader
+02 PP8E   PCE   PSF   PCF   -     PPC   -     PLS   -     high speed paper tape punch
+03 KL8E   KCF   KSF   KCC   -     KRS   KIE   KRB   -     console keyboard
+04 KL8E   SPF   TSF   TCF   -     TPC   SPI   TLS   -     console teleprinter
+13 DK8EP  CLZE  CLSK  CLOE  CLAB  CLEN  CLSA  CLBA  CLCA  programmable real time clock
+60 DF32   -     DCMA  -     DMAR  -     DMAW  -     -     disk file, memory address
+61 DF32   -     DCEA  DSAC  -     -     DEAL  DEAC  -     disk file, extended address
+62 DF32   -     DFSE  DFSC  -     -     -     DMAC  -     disk file, status
+66 LE8    -     PSKF  PCLF  PSKE  PSTB  PSIE  -     PCIE  line printer
+74 RK8E   -     DSKP  DCLR  DLAG  DLCA  DRST  DLDC  DMAN  RK05 disk
+75 RX8E   -     LCD   XDR   STR   SER   SDN   INTR  INIT  RX01 floppy disk
+76 TC08   -     DTRA  DTCA  -     DTXA  -     -     -     DECtape, command register
+77 TC08   -     DTSF  DTRB  -     DTLB  -     -     -     DECtape, status register
+`
+
+func mustParseDevices(s string) DeviceTable {
+	t, err := ParseDevices([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
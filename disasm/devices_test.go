@@ -0,0 +1,114 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disasm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const testDevices = `
+# A comment
+40 XY8   -     XYSF  XYCF  -     -     -     XYLS  -     made up device
+
+41 XY8   XYA   -     -     -     -     -     -     XYH
+`
+
+func TestParseDevices(t *testing.T) {
+	got, err := ParseDevices([]byte(testDevices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DeviceTable{
+		040: {Code: 040, Name: "XY8", Description: "made up device", Functions: [8]string{1: "XYSF", 2: "XYCF", 6: "XYLS"}},
+		041: {Code: 041, Name: "XY8", Functions: [8]string{0: "XYA", 7: "XYH"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Write writes what ParseDevices reads.
+	var buf bytes.Buffer
+	if err := got.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseDevices(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("after Write got %v, want %v", again, want)
+	}
+}
+
+func TestParseJSONDevices(t *testing.T) {
+	got, err := ParseDevices([]byte(`[{"code": "40", "name": "XY8", "description": "made up device",
+	  "functions": {"1": "XYSF", "2": "XYCF", "6": "XYLS"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DeviceTable{
+		040: {Code: 040, Name: "XY8", Description: "made up device", Functions: [8]string{1: "XYSF", 2: "XYCF", 6: "XYLS"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseDevicesErrors(t *testing.T) {
+	for _, in := range []string{
+		"40 XY8 - - -",
+		"100 XY8 - - - - - - - -",
+		"40 XY8 - - - - - - - -\n40 XY9 - - - - - - - -",
+		`[{"code": "40", "functions": {}}]`,
+		`[{"code": "40", "name": "XY8", "functions": {"8": "X"}}]`,
+		`[{"code": "4x", "name": "XY8"}]`,
+		`[`,
+	} {
+		if _, err := ParseDevices([]byte(in)); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestDecoderDevices(t *testing.T) {
+	devices, err := ParseDevices([]byte(testDevices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		devices DeviceTable
+		w       uint16
+		want    string
+	}{
+		{nil, 06046, "TLS"},
+		{nil, 06741, "DSKP"},
+		{nil, 06401, "IOT DEV40 1"},
+		{devices, 06401, "XYSF"},
+		{devices, 06403, "IOT DEV40 3"},
+		{devices, 06046, "IOT DEV04 6"},
+		{MergeDevices(DefaultDevices, devices), 06046, "TLS"},
+		{MergeDevices(DefaultDevices, devices), 06406, "XYLS"},
+	} {
+		got, ok := Decoder{Model: PDP8E, Devices: tt.devices}.Decode(0, tt.w)
+		if got != tt.want || !ok {
+			t.Errorf("%04o got %q, %v, want %q", tt.w, got, ok, tt.want)
+		}
+	}
+}
+
+func TestMergeDevices(t *testing.T) {
+	a := DeviceTable{040: {Code: 040, Name: "A"}, 041: {Code: 041, Name: "A"}}
+	b := DeviceTable{041: {Code: 041, Name: "B"}}
+	m := MergeDevices(a, b)
+	if len(m) != 2 || m[040].Name != "A" || m[041].Name != "B" {
+		t.Errorf("got %v", m)
+	}
+	if a[041].Name != "A" {
+		t.Error("MergeDevices modified its argument")
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package disasm decodes PDP-8 instructions.
+//
+// The instruction set varied between the models of the PDP-8.  A Model
+// determines which instructions are decoded and which are illegal:
+//
+//   - BSW (7002) was added with the PDP-8/E.  On earlier models 7002 is
+//     illegal.
+//   - Combining RAL and RAR (or RTL and RTR) is illegal on all models except
+//     the HD-6120, where 7014 is R3L (rotate 3 left).
+//   - The PDP-8 and PDP-8/S cannot combine IAC with a rotate.
+//   - The MQ register (MQA, MQL) is standard on the PDP-8/E, PDP-8/A, and
+//     HD-6120.  The other group 3 microinstructions require an EAE, which is
+//     either in mode A or mode B.
+//   - SKON, SRQ, GTF, RTF, SGT, and CAF were added with the PDP-8/E.
+//   - The HD-6120 adds stack, panel, and register IOTs in the 62xx range.
+//   - The PDP-12 adds LINC (6141) to switch to LINC mode.
+//   - The KM8E time-share IOTs (CINT, SINT, CUF, SUF) were added with the
+//     PDP-8/E.
+//
+// The memory extension IOTs (62xx) are decoded with their field in the form
+// used by PAL8, e.g., CDF 10 for 6211 and CDF CIF 30 for 6233.
+//
+// The IOTs of peripherals are decoded with a DeviceTable, which may be read
+// from a file.  IOTs of unknown devices are decoded as IOT DEVnn f.
+//
+// Memory reference instructions are decoded as the op code followed by the
+// effective address, before indirection, in octal.  The address of an
+// indirect instruction is enclosed in brackets.
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// A Model is a model of the PDP-8 family.
+type Model int
+
+const (
+	PDP8      = Model(iota) // The original PDP-8
+	PDP8S                   // PDP-8/S
+	PDP8I                   // PDP-8/I
+	PDP8L                   // PDP-8/L
+	PDP8E                   // PDP-8/E
+	PDP8EEAEA               // PDP-8/E with EAE in mode A
+	PDP8EEAEB               // PDP-8/E with EAE in mode B
+	PDP8A                   // PDP-8/A
+	PDP12                   // PDP-12 in PDP-8 mode
+	HD6120                  // Harris HD-6120
+)
+
+var modelNames = []string{
+	PDP8:      "pdp8",
+	PDP8S:     "pdp8s",
+	PDP8I:     "pdp8i",
+	PDP8L:     "pdp8l",
+	PDP8E:     "pdp8e",
+	PDP8EEAEA: "pdp8e/eaea",
+	PDP8EEAEB: "pdp8e/eaeb",
+	PDP8A:     "pdp8a",
+	PDP12:     "pdp12",
+	HD6120:    "hd6120",
+}
+
+func (m Model) String() string {
+	if m < 0 || int(m) >= len(modelNames) {
+		return fmt.Sprintf("Model(%d)", int(m))
+	}
+	return modelNames[m]
+}
+
+// ParseModel returns the model named name, as returned by Model.String.  Case
+// is ignored.
+func ParseModel(name string) (Model, error) {
+	for m, n := range modelNames {
+		if strings.EqualFold(n, name) {
+			return Model(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown model: %s (models: %s)", name, strings.Join(modelNames, ", "))
+}
+
+// is8E reports if m is a PDP-8/E or a later model.
+func (m Model) is8E() bool {
+	switch m {
+	case PDP8E, PDP8EEAEA, PDP8EEAEB, PDP8A, HD6120:
+		return true
+	}
+	return false
+}
+
+// Target returns the effective address, before indirection, of the memory
+// reference instruction w at address a.  It returns false if w is not a memory
+// reference instruction.
+func Target(a core.Addr, w uint16) (core.Addr, bool) {
+	if w>>9 >= 6 {
+		return 0, false
+	}
+	offset := w & 0177
+	if w&0200 != 0 {
+		offset |= a.Offset() & 07600
+	}
+	return core.MakeAddr(a.Field(), offset), true
+}
+
+// Effective returns the effective address of the memory reference instruction
+// w at a, following indirection, and reports if it is known.  a is in the
+// instruction field.  AND, TAD, ISZ, and DCA refer to the data field df when
+// indirect and the instruction field when direct.  JMP and JMS go to field ib,
+// the instruction buffer, which is the instruction field unless a CIF is
+// pending.  read returns the contents of a pointer and reports if it is known.
+// Indirect references through 0010-0017 increment the pointer first.  A
+// negative df means the data field is not known.
+func Effective(a core.Addr, w uint16, df, ib int, read func(core.Addr) (uint16, bool)) (core.Addr, bool) {
+	t, ok := Target(a, w)
+	if !ok {
+		return 0, false
+	}
+	jump := w&07000 >= 04000
+	if w&0400 == 0 {
+		if jump {
+			return core.MakeAddr(ib, t.Offset()), true
+		}
+		return t, true
+	}
+	p, ok := read(t)
+	if !ok {
+		return 0, false
+	}
+	if o := t.Offset(); o >= 010 && o <= 017 {
+		p++
+	}
+	switch {
+	case jump:
+		return core.MakeAddr(ib, p), true
+	case df < 0:
+		return 0, false
+	default:
+		return core.MakeAddr(df, p), true
+	}
+}
+
+var ops = []string{"AND", "TAD", "ISZ", "DCA", "JMS", "JMP", "IOT", "OPR"}
+
+// Decode returns the instruction w at address a in field 0 as text, and
+// reports if w is a legal instruction on m.  The IOTs of peripherals are
+// decoded with DefaultDevices.
+func (m Model) Decode(a, w uint16) (string, bool) {
+	return Decoder{Model: m}.Decode(a, w)
+}
+
+// A Decoder decodes instructions for a model with a table of peripherals.
+type Decoder struct {
+	Model   Model
+	Devices DeviceTable // DefaultDevices if nil
+}
+
+// Decode returns the instruction w at address a in field 0 as text, and
+// reports if w is a legal instruction on d.Model.
+func (d Decoder) Decode(a, w uint16) (string, bool) {
+	m := d.Model
+	w &= 07777
+	op := (w >> 9) & 7
+
+	if op < 6 {
+		t, _ := Target(core.Addr(a), w)
+		if w&0400 != 0 {
+			return fmt.Sprintf("%s [%04o]", ops[op], t.Offset()), true
+		}
+		return fmt.Sprintf("%s %04o", ops[op], t.Offset()), true
+	}
+	switch {
+	case w&07000 == 06000:
+		return d.decodeIOT(w)
+	case w&07400 == 07000:
+		return m.decode1(w)
+	case w&07401 == 07400:
+		return m.decode2(w), true
+	default:
+		return m.decodeMQ(w)
+	}
+}
+
+// decode1 decodes the group 1 operate microinstruction w.
+func (m Model) decode1(w uint16) (string, bool) {
+	if w == 07000 {
+		return "NOP", true
+	}
+	var parts []string
+	legal := true
+	if w&0200 != 0 {
+		parts = append(parts, "CLA")
+	}
+	if w&0100 != 0 {
+		parts = append(parts, "CLL")
+	}
+	if w&0040 != 0 {
+		parts = append(parts, "CMA")
+	}
+	if w&0020 != 0 {
+		parts = append(parts, "CML")
+	}
+	if w&0001 != 0 {
+		parts = append(parts, "IAC")
+		if w&0016 != 0 && (m == PDP8 || m == PDP8S) {
+			legal = false
+		}
+	}
+	switch w & 0016 {
+	case 0000:
+	case 0002:
+		parts = append(parts, "BSW")
+		legal = legal && m.is8E()
+	case 0004:
+		parts = append(parts, "RAL")
+	case 0006:
+		parts = append(parts, "RTL")
+	case 0010:
+		parts = append(parts, "RAR")
+	case 0012:
+		parts = append(parts, "RTR")
+	case 0014:
+		if m == HD6120 {
+			parts = append(parts, "R3L")
+		} else {
+			parts = append(parts, "RAL", "RAR")
+			legal = false
+		}
+	case 0016:
+		parts = append(parts, "RTL", "RTR")
+		legal = false
+	}
+	return strings.Join(parts, " "), legal
+}
+
+// decode2 decodes the group 2 operate microinstruction w.
+func (m Model) decode2(w uint16) string {
+	switch w {
+	case 07400:
+		return "NOP"
+	case 07410:
+		return "SKP"
+	}
+	var parts []string
+	jmps := []string{"SMA", "SZA", "SNL"}
+	if w&010 != 0 {
+		jmps = []string{"SPA", "SNA", "SZL"}
+		if w&0160 == 0 {
+			parts = append(parts, "SKP")
+		}
+	}
+	if w&0100 != 0 {
+		parts = append(parts, jmps[0])
+	}
+	if w&0040 != 0 {
+		parts = append(parts, jmps[1])
+	}
+	if w&0020 != 0 {
+		parts = append(parts, jmps[2])
+	}
+	if w&0200 != 0 {
+		parts = append(parts, "CLA")
+	}
+	if w&0004 != 0 {
+		parts = append(parts, "OSR")
+	}
+	if w&0002 != 0 {
+		parts = append(parts, "HLT")
+	}
+	return strings.Join(parts, " ")
+}
+
+// decodeMQ decodes the group 3 (MQ and EAE) microinstruction w.
+func (m Model) decodeMQ(w uint16) (string, bool) {
+	switch m {
+	case PDP8EEAEA:
+		if w == 07431 {
+			return "SWAB", true
+		}
+		return decodeA(w), true
+	case PDP8EEAEB:
+		return decodeB(w), true
+	}
+	if w == 07401 {
+		return "NOP", m.is8E()
+	}
+	var parts []string
+	if w&0200 != 0 {
+		parts = append(parts, "CLA")
+	}
+	if w&0100 != 0 {
+		parts = append(parts, "MQA")
+	}
+	if w&0020 != 0 {
+		parts = append(parts, "MQL")
+	}
+	if w&0056 != 0 {
+		// The remaining bits require an EAE, decode them as mode A.
+		return decodeA(w), false
+	}
+	return strings.Join(parts, " "), m.is8E()
+}
+
+// decodeA decodes the group 3 microinstruction w for an EAE in mode A.
+func decodeA(w uint16) string {
+	if w == 07401 {
+		return "NOP"
+	}
+	var parts []string
+	if w&0200 != 0 {
+		parts = append(parts, "CLA")
+	}
+	if w&0100 != 0 {
+		parts = append(parts, "MQA")
+	}
+	if w&0040 != 0 {
+		parts = append(parts, "SCA")
+	}
+	if w&0020 != 0 {
+		parts = append(parts, "MQL")
+	}
+	op := (w >> 1) & 7
+	if op == 4 {
+		if len(parts) > 0 {
+			return fmt.Sprintf("%04o", w)
+		}
+		return "NMI"
+	}
+	ops := []string{"NOP", "SCL", "MUY", "DVI", "NMI", "SHL", "ASR", "LSR"}
+	if op > 0 {
+		parts = append(parts, ops[op])
+	}
+	return strings.Join(parts, " ")
+}
+
+var specialB = map[uint16]string{
+	07401: "NOP",
+	07431: "SWAB",
+	07447: "SWBA",
+	07457: "SAM",
+	07763: "DLD",
+	07445: "DST",
+	07443: "DAD",
+	07573: "DPIC",
+	07575: "DCM",
+	07451: "DPSZ",
+}
+
+// decodeB decodes the group 3 microinstruction w for an EAE in mode B.
+func decodeB(w uint16) string {
+	if op, ok := specialB[w]; ok {
+		return op
+	}
+	var parts []string
+	op := ((w >> 1) & 7) | ((w & 0040) >> 2)
+	ops := []string{"NOP", "ACS", "MUY", "DVI", "NMI", "SHL", "ASR", "LSR", "SCA", "DAD", "DST", "SWBA", "DPSZ", "DPIC", "DCM", "SAM"}
+	if op == 4 {
+		if w&0320 != 0 {
+			return fmt.Sprintf("%04o", w)
+		}
+		return "NMI"
+	}
+	if op == 015 || op == 016 {
+		if w&0120 != 0 {
+			return fmt.Sprintf("%04o", w)
+		}
+	}
+	if w&0200 != 0 {
+		parts = append(parts, "CLA")
+	}
+	if w&0100 != 0 {
+		parts = append(parts, "MQA")
+	}
+	if w&0020 != 0 {
+		parts = append(parts, "MQL")
+	}
+	if op > 0 {
+		parts = append(parts, ops[op])
+	}
+	return strings.Join(parts, " ")
+}
+
+// decodeIOT decodes the IOT instruction w.
+func (d Decoder) decodeIOT(w uint16) (string, bool) {
+	m := d.Model
+	if op, ok := iots[w]; ok {
+		return op, true
+	}
+	if w&07700 == 06200 {
+		field := w & 070
+		switch w & 07 {
+		case 1:
+			return fmt.Sprintf("CDF %02o", field), true
+		case 2:
+			return fmt.Sprintf("CIF %02o", field), true
+		case 3:
+			return fmt.Sprintf("CDF CIF %02o", field), true
+		}
+	}
+	if op, ok := iots8E[w]; ok {
+		return op, m.is8E()
+	}
+	if op, ok := iots6120[w]; ok && m == HD6120 {
+		return op, true
+	}
+	if w == 06141 && m == PDP12 {
+		return "LINC", true
+	}
+	devices := d.Devices
+	if devices == nil {
+		devices = DefaultDevices
+	}
+	if op, ok := devices.Lookup(w); ok {
+		return op, true
+	}
+	dev := w >> 3 & 077
+	iot := w & 07
+	return fmt.Sprintf("IOT DEV%02o %o", dev, iot), true
+}
+
+// iots are the processor IOT instructions found on all models.  The IOTs of
+// peripherals are found in a DeviceTable.
+var iots = map[uint16]string{
+	06001: "ION",
+	06002: "IOF",
+	06214: "RDF",
+	06224: "RIF",
+	06234: "RIB",
+	06244: "RMF",
+}
+
+// iots8E are the processor IOTs added with the PDP-8/E.
+var iots8E = map[uint16]string{
+	06000: "SKON",
+	06003: "SRQ",
+	06004: "GTF",
+	06005: "RTF",
+	06006: "SGT",
+	06007: "CAF",
+	06204: "CINT",
+	06254: "SINT",
+	06264: "CUF",
+	06274: "SUF",
+}
+
+// iots6120 are the IOTs added with the HD-6120.
+var iots6120 = map[uint16]string{
+	06205: "PPC1",
+	06245: "PPC2",
+	06215: "PAC1",
+	06255: "PAC2",
+	06225: "RTN1",
+	06265: "RTN2",
+	06235: "POP1",
+	06275: "POP2",
+	06207: "RSP1",
+	06227: "RSP2",
+	06217: "LSP1",
+	06237: "LSP2",
+	06246: "WSR",
+	06256: "GCF",
+	06206: "PR0",
+	06216: "PR1",
+	06226: "PR2",
+	06236: "PR3",
+	06266: "CPD",
+	06276: "SPD",
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disasm
+
+import (
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		m     Model
+		a, w  uint16
+		want  string
+		legal bool
+	}{
+		// Memory reference
+		{PDP8E, 0200, 00005, "AND 0005", true},
+		{PDP8E, 0200, 01205, "TAD 0205", true},
+		{PDP8E, 04377, 03377, "DCA 4377", true},
+		{PDP8E, 0200, 05410, "JMP [0010]", true},
+		{PDP8E, 0200, 05610, "JMP [0210]", true},
+		{PDP8E, 07600, 04777, "JMS [7777]", true},
+
+		// Group 1
+		{PDP8E, 0, 07000, "NOP", true},
+		{PDP8E, 0, 07300, "CLA CLL", true},
+		{PDP8E, 0, 07041, "CMA IAC", true},
+		{PDP8E, 0, 07120, "CLL CML", true},
+		{PDP8E, 0, 07002, "BSW", true},
+		{PDP8I, 0, 07002, "BSW", false},
+		{PDP8E, 0, 07006, "RTL", true},
+		{PDP8E, 0, 07012, "RTR", true},
+		{PDP8E, 0, 07014, "RAL RAR", false},
+		{HD6120, 0, 07014, "R3L", true},
+		{PDP8E, 0, 07016, "RTL RTR", false},
+		{PDP8I, 0, 07005, "IAC RAL", true},
+		{PDP8, 0, 07005, "IAC RAL", false},
+		{PDP8S, 0, 07011, "IAC RAR", false},
+
+		// Group 2
+		{PDP8E, 0, 07400, "NOP", true},
+		{PDP8E, 0, 07410, "SKP", true},
+		{PDP8E, 0, 07500, "SMA", true},
+		{PDP8E, 0, 07510, "SPA", true},
+		{PDP8E, 0, 07450, "SNA", true},
+		{PDP8E, 0, 07640, "SZA CLA", true},
+		{PDP8E, 0, 07430, "SZL", true},
+		{PDP8E, 0, 07560, "SMA SZA SNL", true},
+		{PDP8E, 0, 07412, "SKP HLT", true},
+		{PDP8E, 0, 07402, "HLT", true},
+		{PDP8E, 0, 07604, "CLA OSR", true},
+
+		// Group 3 without an EAE
+		{PDP8E, 0, 07401, "NOP", true},
+		{PDP8I, 0, 07401, "NOP", false},
+		{PDP8E, 0, 07421, "MQL", true},
+		{PDP8E, 0, 07501, "MQA", true},
+		{PDP8E, 0, 07621, "CLA MQL", true},
+		{PDP8E, 0, 07403, "SCL", false},
+
+		// Group 3 mode A
+		{PDP8EEAEA, 0, 07403, "SCL", true},
+		{PDP8EEAEA, 0, 07405, "MUY", true},
+		{PDP8EEAEA, 0, 07407, "DVI", true},
+		{PDP8EEAEA, 0, 07411, "NMI", true},
+		{PDP8EEAEA, 0, 07413, "SHL", true},
+		{PDP8EEAEA, 0, 07415, "ASR", true},
+		{PDP8EEAEA, 0, 07417, "LSR", true},
+		{PDP8EEAEA, 0, 07441, "SCA", true},
+		{PDP8EEAEA, 0, 07431, "SWAB", true},
+		{PDP8EEAEA, 0, 07621, "CLA MQL", true},
+
+		// Group 3 mode B
+		{PDP8EEAEB, 0, 07403, "ACS", true},
+		{PDP8EEAEB, 0, 07405, "MUY", true},
+		{PDP8EEAEB, 0, 07441, "SCA", true},
+		{PDP8EEAEB, 0, 07443, "DAD", true},
+		{PDP8EEAEB, 0, 07445, "DST", true},
+		{PDP8EEAEB, 0, 07447, "SWBA", true},
+		{PDP8EEAEB, 0, 07451, "DPSZ", true},
+		{PDP8EEAEB, 0, 07457, "SAM", true},
+		{PDP8EEAEB, 0, 07763, "DLD", true},
+		{PDP8EEAEB, 0, 07573, "DPIC", true},
+		{PDP8EEAEB, 0, 07575, "DCM", true},
+
+		// Processor IOTs
+		{PDP8, 0, 06001, "ION", true},
+		{PDP8, 0, 06002, "IOF", true},
+		{PDP8, 0, 06000, "SKON", false},
+		{PDP8E, 0, 06000, "SKON", true},
+		{PDP8E, 0, 06007, "CAF", true},
+		{HD6120, 0, 06205, "PPC1", true},
+		{PDP12, 0, 06141, "LINC", true},
+		{PDP8E, 0, 06141, "IOT DEV14 1", true},
+	} {
+		got, legal := tt.m.Decode(tt.a, tt.w)
+		if got != tt.want || legal != tt.legal {
+			t.Errorf("%v %04o: %04o got %q, %v, want %q, %v", tt.m, tt.a, tt.w, got, legal, tt.want, tt.legal)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	for _, tt := range []struct {
+		a    core.Addr
+		w    uint16
+		want core.Addr
+		ok   bool
+	}{
+		{0200, 01005, 0005, true},
+		{0200, 01205, 0205, true},
+		{core.MakeAddr(2, 04321), 05377, core.MakeAddr(2, 04377), true},
+		{core.MakeAddr(2, 04321), 05577, core.MakeAddr(2, 0177), true},
+		{0200, 06001, 0, false},
+		{0200, 07200, 0, false},
+	} {
+		got, ok := Target(tt.a, tt.w)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Target(%v, %04o) got %v, %v, want %v, %v", tt.a, tt.w, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseModel(t *testing.T) {
+	for m := PDP8; m <= HD6120; m++ {
+		got, err := ParseModel(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseModel(%q) got %v, %v", m.String(), got, err)
+		}
+	}
+	if m, err := ParseModel("PDP8E/EAEB"); err != nil || m != PDP8EEAEB {
+		t.Errorf("ParseModel is case sensitive: %v, %v", m, err)
+	}
+	if _, err := ParseModel("pdp11"); err == nil {
+		t.Error("ParseModel(pdp11) did not fail")
+	}
+	if s := Model(99).String(); s != "Model(99)" {
+		t.Errorf("Model(99) got %q", s)
+	}
+}
+
+func TestDecodeKM8E(t *testing.T) {
+	for _, tt := range []struct {
+		m     Model
+		w     uint16
+		want  string
+		legal bool
+	}{
+		{PDP8, 06201, "CDF 00", true},
+		{PDP8, 06211, "CDF 10", true},
+		{PDP8, 06272, "CIF 70", true},
+		{PDP8, 06233, "CDF CIF 30", true},
+		{PDP8, 06214, "RDF", true},
+		{PDP8, 06224, "RIF", true},
+		{PDP8, 06234, "RIB", true},
+		{PDP8, 06244, "RMF", true},
+		{PDP8I, 06204, "CINT", false},
+		{PDP8E, 06204, "CINT", true},
+		{PDP8E, 06254, "SINT", true},
+		{PDP8E, 06264, "CUF", true},
+		{PDP8E, 06274, "SUF", true},
+	} {
+		got, legal := tt.m.Decode(0, tt.w)
+		if got != tt.want || legal != tt.legal {
+			t.Errorf("%v %04o got %q, %v, want %q, %v", tt.m, tt.w, got, legal, tt.want, tt.legal)
+		}
+	}
+}
+
+func TestEffective(t *testing.T) {
+	mem := map[core.Addr]uint16{
+		0010:                   01000,
+		0177:                   04000,
+		core.MakeAddr(1, 0020): 02000,
+		core.MakeAddr(1, 0017): 07777,
+		core.MakeAddr(1, 0300): 00400,
+		core.MakeAddr(1, 0377): 03000,
+		core.MakeAddr(1, 0201): 00000,
+	}
+	read := func(a core.Addr) (uint16, bool) {
+		w, ok := mem[a]
+		return w, ok
+	}
+	f1 := func(a uint16) core.Addr { return core.MakeAddr(1, a) }
+	for _, tt := range []struct {
+		a      core.Addr
+		w      uint16
+		df, ib int
+		want   core.Addr
+		ok     bool
+	}{
+		// Direct references stay in the instruction field.
+		{f1(0200), 01020, 2, 3, f1(0020), true},
+		{f1(0200), 01300, 2, 3, f1(0300), true},
+		// Direct jumps go to the instruction buffer field.
+		{f1(0200), 05300, 2, 3, core.MakeAddr(3, 0300), true},
+		// Indirect data references use the data field.
+		{f1(0200), 01420, 2, 3, core.MakeAddr(2, 02000), true},
+		{f1(0200), 01777, 2, 3, core.MakeAddr(2, 03000), true},
+		// Indirect jumps use the instruction buffer field.
+		{f1(0200), 05777, 2, 3, core.MakeAddr(3, 03000), true},
+		// Autoindex registers are incremented first, wrapping in the field.
+		{f1(0200), 01417, 2, 1, core.MakeAddr(2, 0), true},
+		{0200, 01410, 0, 0, 01001, true},
+		// Unknown pointers and data fields.
+		{f1(0200), 01421, 2, 1, 0, false},
+		{f1(0200), 01420, -1, 1, 0, false},
+		{f1(0200), 05420, -1, 1, f1(02000), true},
+		// Not a memory reference instruction.
+		{f1(0200), 07200, 2, 1, 0, false},
+	} {
+		got, ok := Effective(tt.a, tt.w, tt.df, tt.ib, read)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Effective(%v, %04o, %d, %d) got %v, %v, want %v, %v", tt.a, tt.w, tt.df, tt.ib, got, ok, tt.want, tt.ok)
+		}
+	}
+}
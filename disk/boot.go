@@ -0,0 +1,107 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disk
+
+import (
+	"errors"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/cpu"
+)
+
+// ErrNotMounted is returned when booting from a drive with no image mounted.
+var ErrNotMounted = errors.New("no image mounted")
+
+// rkBoot is the DEC RK8E bootstrap, loaded at 0023.  It reads block 0 of the
+// drive in UNIT into 0000-0377 of field 0 while waiting at 0031, which the
+// block overwrites with its own code.  The block is entered at 0031 with the
+// drive number, shifted left one bit, in AC.
+var rkBoot = []uint16{
+	06007, // 0023 CAF
+	06744, // 0024 DLCA
+	01032, // 0025 TAD UNIT
+	06746, // 0026 DLDC
+	06743, // 0027 DLAG
+	01032, // 0030 TAD UNIT
+	05031, // 0031 JMP .
+	00000, // 0032 UNIT
+}
+
+// Boot loads the bootstrap for drive unit of r into field 0 of c and sets c to
+// run it.
+func (r *RK8E) Boot(c *cpu.CPU, unit int) error {
+	if unit < 0 || unit >= len(r.drives) {
+		return ErrNoDrive
+	}
+	if r.drives[unit] == nil {
+		return ErrNotMounted
+	}
+	for i, w := range rkBoot {
+		c.Write(core.Addr(0023+i), w)
+	}
+	c.Write(0032, uint16(unit)<<1)
+	c.Start(0023)
+	return nil
+}
+
+// rxBoot is the DEC RX8E bootstrap, loaded at 0022.  It waits for the
+// initialization of the controller to complete, reads sector 1 of track 1 of
+// the drive in UNIT, and empties the sector into 0002-0101 of field 0,
+// overwriting itself.  The sector must hold the same words as the bootstrap at
+// 0033-0034 and 0047-0057, and 3050 at 0050.  It is entered at 0035 when the
+// last word has been emptied.  The bootstrap halts at 0041 if the read fails.
+var rxBoot = []uint16{
+	06755, // 0022        SDN         / wait for the initialization
+	05022, // 0023        JMP .-1
+	07126, // 0024        CLL CML RTL / 2, the read function
+	01060, // 0025        TAD UNIT
+	06751, // 0026        LCD
+	07201, // 0027        CLA IAC     / sector 1 and track 1
+	04053, // 0030        JMS LOAD
+	04053, // 0031        JMS LOAD
+	07104, // 0032        CLL RAL     / 2, the empty buffer function
+	06755, // 0033        SDN
+	05054, // 0034        JMP LOAD+1
+	06754, // 0035        SER
+	07450, // 0036        SNA
+	07610, // 0037        CLA SKP
+	05046, // 0040        JMP EMPTY
+	07402, // 0041        HLT
+	07402, // 0042        HLT
+	07402, // 0043        HLT
+	07402, // 0044        HLT
+	07402, // 0045        HLT
+	06751, // 0046 EMPTY, LCD
+	04053, // 0047        JMS LOAD
+	03002, // 0050        DCA 2
+	02050, // 0051        ISZ 50
+	05047, // 0052        JMP 47
+	00000, // 0053 LOAD,  0
+	06753, // 0054        STR
+	05033, // 0055        JMP 33
+	06752, // 0056        XDR
+	05453, // 0057        JMP I LOAD
+	07004, // 0060 UNIT,  7004
+}
+
+// Boot loads the bootstrap for drive unit of r into field 0 of c and sets c to
+// run it.  Like the START key, Boot resets c and its devices, which
+// initializes r.
+func (r *RX8E) Boot(c *cpu.CPU, unit int) error {
+	if unit < 0 || unit >= len(r.drives) {
+		return ErrNoDrive
+	}
+	if r.drives[unit] == nil {
+		return ErrNotMounted
+	}
+	for i, w := range rxBoot {
+		c.Write(core.Addr(0022+i), w)
+	}
+	c.Write(0060, 07004|uint16(unit)<<4)
+	c.Reset()
+	c.Start(0022)
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package disk emulates the RK8E and RX8E disk controllers for package cpu.
+//
+// The drives of a controller are os8fs disk images.  Blocks are read and
+// written through the same block layer os8fs uses, so the sides of an image
+// (the A: and B: partitions of an RK05) appear one after the other on the
+// drive, just as OS/8 sees them.  Changes to an image are held by the os8fs
+// Disk until it is synced or closed.
+//
+// A transfer takes Delay cycles of the processor.  The data is moved to or from
+// memory when the transfer completes, not when it is started, so a program
+// that waits for a transfer into the memory it is running from, as the
+// hardware bootstraps do, behaves as it does on the hardware.
+//
+// Boot loads a bootstrap into memory that reads block 0 of a drive into
+// 0000-0377 and enters it at 0031, and sets the processor to run it.  The RK8E
+// bootstrap is DEC's.  The RX8E bootstrap is not: it loads block 0 as the RK8E
+// bootstrap does, through the RX8E IOTs.
+package disk
+
+import (
+	"errors"
+
+	"github.com/pborman/pdp8/os8fs"
+)
+
+// DefaultDelay is the default number of cycles a transfer takes.
+const DefaultDelay = 250
+
+// ErrNoDrive is returned when mounting an image on a drive that does not
+// exist.
+var ErrNoDrive = errors.New("no such drive")
+
+// blocks returns the number of blocks on d.
+func blocks(d *os8fs.Disk) int {
+	n := 0
+	for s := 0; s < d.Sides(); s++ {
+		n += d.Side(s).Blocks()
+	}
+	return n
+}
+
+// side returns the side of d holding block n of d and the number of the block
+// on the side.  It returns nil if d has no block n.
+func side(d *os8fs.Disk, n int) (*os8fs.FileSystem, int) {
+	if n < 0 {
+		return nil, 0
+	}
+	for s := 0; s < d.Sides(); s++ {
+		fs := d.Side(s)
+		if n < fs.Blocks() {
+			return fs, n
+		}
+		n -= fs.Blocks()
+	}
+	return nil, 0
+}
+
+// readBlock returns block n of d.
+func readBlock(d *os8fs.Disk, n int) ([]uint16, error) {
+	fs, b := side(d, n)
+	if fs == nil {
+		return nil, errors.New("block out of range")
+	}
+	return fs.ReadBlocks(b, 1)
+}
+
+// writeBlock writes the 256 words of data to block n of d.
+func writeBlock(d *os8fs.Disk, n int, data []uint16) error {
+	fs, b := side(d, n)
+	if fs == nil {
+		return errors.New("block out of range")
+	}
+	return fs.WriteBlocks(b, data)
+}
@@ -0,0 +1,634 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disk
+
+import (
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/cpu"
+	"github.com/pborman/pdp8/os8fs"
+	"github.com/pborman/pdp8/pal"
+)
+
+// image returns an empty image of drive d held in memory.
+func image(t *testing.T, d os8fs.Drive) *os8fs.Disk {
+	t.Helper()
+	disk, err := os8fs.NewMemoryImage(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return disk
+}
+
+// pattern returns the words of block n as written by fill.
+func pattern(n int) []uint16 {
+	data := make([]uint16, 256)
+	for i := range data {
+		data[i] = uint16(n*7+i) & 07777
+	}
+	return data
+}
+
+// fill writes pattern(n) to each block n of d in blocks.
+func fill(t *testing.T, d *os8fs.Disk, blocks ...int) {
+	t.Helper()
+	for _, n := range blocks {
+		if err := writeBlock(d, n, pattern(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assemble assembles src and loads it into c.
+func assemble(t *testing.T, c *cpu.CPU, src string) {
+	t.Helper()
+	p, err := pal.Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Load(p.Image)
+}
+
+func TestSide(t *testing.T) {
+	d := image(t, os8fs.RK05)
+	n := d.Side(0).Blocks()
+	if got := blocks(d); got != 2*n {
+		t.Fatalf("got %d blocks, want %d", got, 2*n)
+	}
+	for _, tt := range []struct {
+		n     int
+		side  int
+		block int
+	}{
+		{0, 0, 0},
+		{n - 1, 0, n - 1},
+		{n, 1, 0},
+		{2*n - 1, 1, n - 1},
+		{2 * n, -1, 0},
+		{-1, -1, 0},
+	} {
+		fs, b := side(d, tt.n)
+		switch {
+		case tt.side < 0:
+			if fs != nil {
+				t.Errorf("block %d: got a side", tt.n)
+			}
+		case fs != d.Side(tt.side) || b != tt.block:
+			t.Errorf("block %d: got block %d of %p, want block %d of side %d", tt.n, b, fs, tt.block, tt.side)
+		}
+	}
+
+	fill(t, d, n+5)
+	data, err := d.Side(1).ReadBlocks(5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range pattern(n + 5) {
+		if data[i] != w {
+			t.Fatalf("B:5 word %d: got %04o, want %04o", i, data[i], w)
+		}
+	}
+	if _, err := readBlock(d, 2*n); err == nil {
+		t.Error("read past the end did not fail")
+	}
+	if err := writeBlock(d, -1, pattern(0)); err == nil {
+		t.Error("write before the start did not fail")
+	}
+}
+
+// rk executes the function cmd on block da of r to or from ca and returns the
+// status once it completes.
+func rk(t *testing.T, r *RK8E, c *cpu.CPU, cmd, da, ca uint16) uint16 {
+	t.Helper()
+	for _, x := range []struct{ w, ac uint16 }{{DLCA, ca}, {DLDC, cmd}, {DLAG, da}} {
+		c.AC = x.ac
+		r.IOT(c, x.w)
+		if c.AC != 0 {
+			t.Fatalf("%04o left AC %04o", x.w, c.AC)
+		}
+	}
+	if r.IOT(c, DSKP) {
+		t.Fatal("done before the function completed")
+	}
+	if r.IOT(c, DRST); c.AC&rkMotion == 0 {
+		t.Errorf("status %04o while busy", c.AC)
+	}
+	r.Clock(r.now + r.Delay - 1)
+	if r.IOT(c, DSKP) {
+		t.Fatal("done before the delay")
+	}
+	r.Clock(r.now + 1)
+	r.IOT(c, DRST)
+	return c.AC
+}
+
+func TestRK8EStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		cmd    uint16
+		da     uint16
+		status uint16
+	}{
+		{"read", 00000, 5, rkDone},
+		{"write", 04000, 5, rkDone},
+		{"no drive", 00002, 5, rkDone | rkNotReady | rkStatus},
+		{"cylinder", 00001, 07777, rkDone | rkCylError},
+		{"bad function", 06000, 0, rkDone | rkStatus},
+		{"seek", 03200, 0, rkDone},
+		{"seek without done", 03000, 0, 0},
+	} {
+		r := NewRK8E()
+		r.Mount(0, image(t, os8fs.RK05))
+		c := cpu.New(1)
+		if got := rk(t, r, c, tt.cmd, tt.da, 0); got != tt.status {
+			t.Errorf("%s: got status %04o, want %04o", tt.name, got, tt.status)
+		}
+		if skip := r.IOT(c, DSKP); skip != (tt.status != 0) {
+			t.Errorf("%s: DSKP got %v", tt.name, skip)
+		}
+	}
+}
+
+func TestRK8ETransfer(t *testing.T) {
+	d := image(t, os8fs.RK05)
+	n := d.Side(0).Blocks()
+	fill(t, d, 5, 4096, n)
+	r := NewRK8E()
+	r.Mount(1, d)
+	c := cpu.New(2)
+
+	// Read block 5 of drive 1 into field 1 at 1000.
+	if s := rk(t, r, c, 00012, 5, 01000); s != rkDone {
+		t.Fatalf("read: status %04o", s)
+	}
+	for i, w := range pattern(5) {
+		if got := c.Read(core.MakeAddr(1, uint16(01000+i))); got != w {
+			t.Fatalf("read word %d: got %04o, want %04o", i, got, w)
+		}
+	}
+
+	// Read half of the first block of side B into field 0.
+	if s := rk(t, r, c, 00102, uint16(n), 02000); s != rkDone {
+		t.Fatalf("half read: status %04o", s)
+	}
+	if got, want := c.Read(02177), pattern(n)[0177]; got != want {
+		t.Errorf("half read word 177: got %04o, want %04o", got, want)
+	}
+	if got := c.Read(02200); got != 0 {
+		t.Errorf("half read read word 200: %04o", got)
+	}
+
+	// The high bit of the cylinder is in the command.
+	if s := rk(t, r, c, 00003, 0, 03000); s != rkDone {
+		t.Fatalf("cylinder read: status %04o", s)
+	}
+	if got, want := c.Read(03001), pattern(4096)[1]; got != want {
+		t.Errorf("block 4096 word 1: got %04o, want %04o", got, want)
+	}
+
+	// Write field 1 at 1000 to block 7.
+	c.Write(core.MakeAddr(1, 01003), 01234)
+	if s := rk(t, r, c, 04012, 7, 01000); s != rkDone {
+		t.Fatalf("write: status %04o", s)
+	}
+	data, err := readBlock(d, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[3] != 01234 || data[4] != pattern(5)[4] {
+		t.Errorf("write: got %04o %04o", data[3], data[4])
+	}
+
+	// Write protect the drive.
+	if s := rk(t, r, c, 02002, 0, 0); s != rkDone {
+		t.Fatalf("protect: status %04o", s)
+	}
+	if s := rk(t, r, c, 04002, 8, 01000); s != rkDone|rkLocked {
+		t.Errorf("protected write: status %04o", s)
+	}
+	if err := r.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRK8EControl(t *testing.T) {
+	r := NewRK8E()
+	r.Mount(0, image(t, os8fs.RK05))
+	c := cpu.New(1)
+
+	// DLAG while busy sets busy and done.
+	c.AC = 0400
+	r.IOT(c, DLDC)
+	r.IOT(c, DLAG)
+	r.IOT(c, DLAG)
+	r.IOT(c, DRST)
+	if c.AC != rkDone|rkBusy|rkMotion {
+		t.Errorf("busy: status %04o", c.AC)
+	}
+	if !r.Interrupt() {
+		t.Error("no interrupt on error")
+	}
+
+	// Clear status.
+	c.AC = 0
+	r.IOT(c, DCLR)
+	r.IOT(c, DRST)
+	if c.AC != rkMotion {
+		t.Errorf("clear status: status %04o", c.AC)
+	}
+	r.Clock(r.now + r.Delay)
+	if !r.Interrupt() {
+		t.Error("no interrupt on done")
+	}
+
+	// Clear control.
+	c.AC = 1
+	r.IOT(c, DCLR)
+	if r.cmd != 0 || r.status != 0 || r.Interrupt() {
+		t.Errorf("clear control: command %04o status %04o", r.cmd, r.status)
+	}
+
+	// Recalibrate with done on seek.
+	c.AC = 0200
+	r.IOT(c, DLDC)
+	c.AC = 2
+	r.IOT(c, DCLR)
+	r.Clock(r.now + r.Delay)
+	if !r.IOT(c, DSKP) {
+		t.Error("recalibrate did not complete")
+	}
+
+	r.Reset()
+	if r.IOT(c, DSKP) || r.busy {
+		t.Error("reset did not clear the controller")
+	}
+}
+
+func TestRKBoot(t *testing.T) {
+	d := image(t, os8fs.RK05)
+	b0 := make([]uint16, 256)
+	for i := range b0 {
+		b0[i] = uint16(i + 1)
+	}
+	b0[031] = 07402 // HLT
+	if err := d.Side(0).WriteBlocks(0, b0); err != nil {
+		t.Fatal(err)
+	}
+	fill(t, d, d.Side(0).Blocks())
+	r := NewRK8E()
+	if err := r.Mount(4, d); err != ErrNoDrive {
+		t.Errorf("Mount(4) got %v", err)
+	}
+	r.Mount(0, image(t, os8fs.RK05))
+	r.Mount(2, d)
+	c := cpu.New(1)
+	c.Attach(RKDevice, r)
+	if err := r.Boot(c, 3); err != ErrNotMounted {
+		t.Errorf("Boot(3) got %v", err)
+	}
+	if err := r.Boot(c, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(10000); err != cpu.ErrHalt {
+		t.Fatalf("got %v", err)
+	}
+	if c.PC != 032 || c.AC != 4 {
+		t.Errorf("halted at %04o with AC %04o, want 0032 and 0004", c.PC, c.AC)
+	}
+	if c.Read(0377) != 0400 || c.Read(0400) != 0 {
+		t.Errorf("got 0377 %04o and 0400 %04o", c.Read(0377), c.Read(0400))
+	}
+}
+
+// rx executes the IOT w of r with ac in AC and returns AC and if it skipped.
+func rx(r *RX8E, c *cpu.CPU, w, ac uint16) (uint16, bool) {
+	c.AC = ac
+	skip := r.IOT(c, w)
+	return c.AC, skip
+}
+
+// rxWait waits for the function in progress on r to complete and returns the
+// error flag.
+func rxWait(t *testing.T, r *RX8E, c *cpu.CPU) bool {
+	t.Helper()
+	r.Clock(r.now + r.Delay)
+	if _, done := rx(r, c, SDN, 0); !done {
+		t.Fatal("not done")
+	}
+	_, errf := rx(r, c, SER, 0)
+	return errf
+}
+
+// rxStart loads the command cmd and then the sector and track, if they are
+// requested.
+func rxStart(r *RX8E, c *cpu.CPU, cmd, sector, track uint16) {
+	rx(r, c, LCD, cmd)
+	for _, v := range []uint16{sector, track} {
+		if _, tr := rx(r, c, STR, 0); !tr {
+			return
+		}
+		rx(r, c, XDR, v)
+	}
+}
+
+// rxUnload empties the sector buffer of r.
+func rxUnload(t *testing.T, r *RX8E, c *cpu.CPU) []uint16 {
+	t.Helper()
+	rx(r, c, LCD, rxEmpty<<1)
+	var data []uint16
+	for {
+		if _, tr := rx(r, c, STR, 0); !tr {
+			break
+		}
+		w, _ := rx(r, c, XDR, 0)
+		data = append(data, w)
+	}
+	if _, done := rx(r, c, SDN, 0); !done {
+		t.Fatal("empty not done")
+	}
+	return data
+}
+
+func TestRXLogical(t *testing.T) {
+	r := NewRX8E()
+	for _, tt := range []struct {
+		track, sector uint16
+		block, offset int
+	}{
+		{1, 1, 0, 0},
+		{1, 3, 0, 64},
+		{1, 7, 0, 192},
+		{1, 9, 1, 0},
+		{1, 25, 3, 0},
+		{1, 2, 3, 64},
+		{1, 26, 6, 64},
+		{2, 1, 6, 128},
+		{76, 26, 493, 192},
+		{0, 1, -1, 0},
+	} {
+		r.track, r.sector = tt.track, tt.sector
+		if b, o := r.logical(); b != tt.block || o != tt.offset {
+			t.Errorf("track %d sector %d: got block %d offset %d, want %d %d", tt.track, tt.sector, b, o, tt.block, tt.offset)
+		}
+	}
+}
+
+func TestRX8E(t *testing.T) {
+	d := image(t, os8fs.RX01)
+	fill(t, d, 0, 3)
+	r := NewRX8E()
+	r.Mount(1, d)
+	c := cpu.New(1)
+
+	// INIT reads sector 1 of track 1 of drive 0, which is not mounted.
+	rx(r, c, INIT, 0)
+	if errf := rxWait(t, r, c); !errf {
+		t.Error("INIT of drive 0 did not fail")
+	}
+	if s, _ := rx(r, c, XDR, 0); s&rxInitDone == 0 || s&rxReady != 0 {
+		t.Errorf("INIT status %04o", s)
+	}
+	rx(r, c, LCD, rxECode<<1)
+	if e, _ := rx(r, c, XDR, 0); e != rxNotReady {
+		t.Errorf("error code %04o, want %04o", e, rxNotReady)
+	}
+
+	// Read sector 2 of track 1 of drive 1, the second quarter of block 3.
+	rxStart(r, c, rxRead<<1|rxDrive, 2, 1)
+	if errf := rxWait(t, r, c); errf {
+		t.Fatal("read failed")
+	}
+	if s, _ := rx(r, c, XDR, 0); s&rxReady == 0 || s&rxInitDone != 0 {
+		t.Errorf("read status %04o", s)
+	}
+	data := rxUnload(t, r, c)
+	if len(data) != 64 {
+		t.Fatalf("emptied %d words", len(data))
+	}
+	for i, w := range pattern(3)[64:128] {
+		if data[i] != w {
+			t.Fatalf("word %d: got %04o, want %04o", i, data[i], w)
+		}
+	}
+
+	// Fill the buffer and write it to sector 3 of track 1, the second
+	// quarter of block 0.
+	rx(r, c, LCD, rxFill<<1)
+	for i := 0; i < 64; i++ {
+		if _, tr := rx(r, c, STR, 0); !tr {
+			t.Fatalf("no transfer request for word %d", i)
+		}
+		rx(r, c, XDR, uint16(07700+i))
+	}
+	if _, done := rx(r, c, SDN, 0); !done {
+		t.Fatal("fill not done")
+	}
+	rxStart(r, c, rxWrite<<1|rxDrive, 3, 1)
+	if errf := rxWait(t, r, c); errf {
+		t.Fatal("write failed")
+	}
+	block, err := readBlock(d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block[63] != pattern(0)[63] || block[64] != 07700 || block[127] != 07777 || block[128] != pattern(0)[128] {
+		t.Errorf("block 0: got %04o %04o %04o %04o", block[63], block[64], block[127], block[128])
+	}
+
+	// Track 0 reads as zeros.
+	rxStart(r, c, rxRead<<1|rxDrive, 1, 0)
+	if errf := rxWait(t, r, c); errf {
+		t.Fatal("read of track 0 failed")
+	}
+	for i, w := range rxUnload(t, r, c) {
+		if w != 0 {
+			t.Fatalf("track 0 word %d: %04o", i, w)
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRX8EErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		cmd           uint16
+		sector, track uint16
+		ecode         uint16
+	}{
+		{"bad track", rxRead<<1 | rxDrive, 1, RXTracks, rxBadTrack},
+		{"sector 0", rxRead<<1 | rxDrive, 0, 1, rxBadSector},
+		{"sector 27", rxWrite<<1 | rxDrive, 27, 1, rxBadSector},
+		{"no drive", rxRead << 1, 1, 1, rxNotReady},
+		{"8 bit mode", rxRead<<1 | rxDrive | rxMode, 1, 1, rxBadCRC},
+	} {
+		r := NewRX8E()
+		r.Mount(1, image(t, os8fs.RX01))
+		c := cpu.New(1)
+		rxStart(r, c, tt.cmd, tt.sector, tt.track)
+		if errf := rxWait(t, r, c); !errf {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		rx(r, c, LCD, rxECode<<1|rxDrive)
+		if e, _ := rx(r, c, XDR, 0); e != tt.ecode {
+			t.Errorf("%s: error code %04o, want %04o", tt.name, e, tt.ecode)
+		}
+	}
+}
+
+func TestRX8EInterrupt(t *testing.T) {
+	r := NewRX8E()
+	r.Mount(0, image(t, os8fs.RX01))
+	c := cpu.New(1)
+	rx(r, c, INTR, 1)
+	rx(r, c, LCD, rxNop<<1)
+	if !r.Interrupt() {
+		t.Error("no interrupt when done")
+	}
+	rx(r, c, SDN, 0)
+	if r.Interrupt() {
+		t.Error("interrupt after SDN")
+	}
+	// LCD is ignored while a function is in progress.
+	rxStart(r, c, rxRead<<1, 1, 1)
+	rx(r, c, LCD, rxNop<<1)
+	if r.Interrupt() {
+		t.Error("LCD while busy completed")
+	}
+	r.Clock(r.now + r.Delay)
+	if !r.Interrupt() {
+		t.Error("no interrupt after read")
+	}
+	r.Reset()
+	r.Clock(r.now + r.Delay)
+	if r.Interrupt() {
+		t.Error("interrupt enabled after reset")
+	}
+}
+
+func TestRX8EProgram(t *testing.T) {
+	d := image(t, os8fs.RX01)
+	fill(t, d, 3)
+	r := NewRX8E()
+	r.Mount(0, d)
+	c := cpu.New(1)
+	c.Attach(RXDevice, r)
+	assemble(t, c, `*200
+	6757		/ INIT
+	6755
+	JMP .-1
+	TAD (6)		/ read
+	6751
+	6753
+	JMP .-1
+	TAD (2)		/ sector 2
+	6752
+	CLA
+	6753
+	JMP .-1
+	IAC		/ track 1
+	6752
+	CLA
+	6755
+	JMP .-1
+	6754
+	SKP
+	HLT
+	TAD (2)		/ empty
+	6751
+	TAD (777)
+	DCA 10
+LOOP,	6755
+	SKP
+	HLT
+	6753
+	JMP LOOP
+	6752
+	DCA I 10
+	JMP LOOP
+`)
+	c.Start(0200)
+	if err := c.Run(100000); err != cpu.ErrHalt {
+		t.Fatalf("got %v", err)
+	}
+	if c.PC != 0233 {
+		t.Fatalf("halted at %04o, want 0233", c.PC)
+	}
+	for i, w := range pattern(3)[64:128] {
+		if got := c.Read(core.Addr(01000 + i)); got != w {
+			t.Fatalf("word %d: got %04o, want %04o", i, got, w)
+		}
+	}
+}
+
+func TestRXBoot(t *testing.T) {
+	// The boot sector is emptied into 0002-0101.  It keeps the loop of
+	// the bootstrap and continues at 0035, where it halts with 1234 in AC.
+	sector := make([]uint16, 64)
+	for i := range sector {
+		sector[i] = uint16(07000 + i)
+	}
+	for a := 033; a <= 057; a++ {
+		if a < 035 || a >= 047 {
+			sector[a-2] = rxBoot[a-022]
+		}
+	}
+	sector[050-2] = 03050
+	sector[035-2] = 07200 // CLA
+	sector[036-2] = 01071 // TAD 71
+	sector[037-2] = 07402 // HLT
+	sector[071-2] = 01234
+
+	d := image(t, os8fs.RX01)
+	b0 := make([]uint16, 256)
+	copy(b0, sector)
+	if err := writeBlock(d, 0, b0); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRX8E()
+	if err := r.Mount(2, d); err != ErrNoDrive {
+		t.Errorf("Mount(2) got %v", err)
+	}
+	r.Mount(1, d)
+	c := cpu.New(1)
+	c.Attach(RXDevice, r)
+	if err := r.Boot(c, 0); err != ErrNotMounted {
+		t.Errorf("Boot(0) got %v", err)
+	}
+	c.AC = 07777
+	if err := r.Boot(c, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(100000); err != cpu.ErrHalt {
+		t.Fatalf("got %v", err)
+	}
+	if c.PC != 040 || c.AC != 01234 {
+		t.Fatalf("halted at %04o with AC %04o, want 0040 and 1234", c.PC, c.AC)
+	}
+	for i, w := range sector {
+		a := core.Addr(i + 2)
+		switch a {
+		case 050:
+			w = 03102 // DCA 102 after the last word
+		case 053:
+			continue // the return address of LOAD
+		}
+		if got := c.Read(a); got != w {
+			t.Errorf("%04o: got %04o, want %04o", a, got, w)
+		}
+	}
+	if c.Read(0102) != 0 {
+		t.Errorf("0102 loaded with %04o", c.Read(0102))
+	}
+	// The initialization and the read each took the delay of the
+	// controller.
+	if c.Cycles < 2*r.Delay {
+		t.Errorf("booted in %d cycles", c.Cycles)
+	}
+}
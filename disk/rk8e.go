@@ -0,0 +1,252 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disk
+
+import (
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/cpu"
+	"github.com/pborman/pdp8/os8fs"
+)
+
+// RK8E device code and IOTs.
+const (
+	RKDevice = 074
+
+	DSKP = 06741 // skip on transfer done or error
+	DCLR = 06742 // clear, as selected by AC bits 10-11
+	DLAG = 06743 // load disk address and go
+	DLCA = 06744 // load current address
+	DRST = 06745 // read status
+	DLDC = 06746 // load command
+)
+
+// RK8E command register bits.
+const (
+	rkFunc     = 07000 // function
+	rkIE       = 00400 // interrupt on done
+	rkSeekDone = 00200 // set done when a seek completes
+	rkHalf     = 00100 // transfer 128 words
+	rkField    = 00070 // memory field
+	rkDrive    = 00006 // drive
+	rkCylinder = 00001 // high bit of the cylinder
+)
+
+// RK8E functions.
+const (
+	rkRead     = 0
+	rkReadAll  = 1
+	rkProtect  = 2
+	rkSeek     = 3
+	rkWrite    = 4
+	rkWriteAll = 5
+)
+
+// RK8E status register bits.
+const (
+	rkDone     = 04000 // transfer done
+	rkMotion   = 02000 // head in motion
+	rkSeekFail = 00400 // seek failed
+	rkNotReady = 00200 // drive not ready
+	rkBusy     = 00100 // controller busy
+	rkTiming   = 00040 // timing error
+	rkLocked   = 00020 // write to a protected drive
+	rkCRC      = 00010 // CRC error
+	rkLate     = 00004 // data request late
+	rkStatus   = 00002 // drive status error
+	rkCylError = 00001 // cylinder address error
+
+	rkErrors = rkSeekFail | rkNotReady | rkBusy | rkTiming | rkLocked | rkCRC | rkLate | rkStatus | rkCylError
+)
+
+// RKBlocks is the number of blocks on an RK05 cartridge, 203 cylinders of two
+// surfaces of 16 sectors.
+const RKBlocks = 203 * 2 * 16
+
+// An RK8E is an RK8E disk controller with up to 4 RK05 drives.  The disk
+// address of a block, with the high bit of the cylinder from the command
+// register, is the block number on the drive.  The block numbers of a drive
+// run through the sides of its image in order, so the A: side of an RK05
+// image is blocks 0-3247 and the B: side is blocks 3248-6495.
+type RK8E struct {
+	Delay uint64 // cycles a function takes
+
+	drives  [4]*os8fs.Disk
+	protect [4]bool
+
+	cmd    uint16 // command register
+	da     uint16 // disk address register
+	ca     uint16 // current address register
+	status uint16 // status register
+
+	busy bool     // a function is in progress
+	due  uint64   // cycle the function completes
+	now  uint64   // current cycle
+	c    *cpu.CPU // processor memory is transferred to and from
+	err  error    // first error from an image
+}
+
+// NewRK8E returns a new RK8E with no drives mounted.
+func NewRK8E() *RK8E {
+	return &RK8E{Delay: DefaultDelay}
+}
+
+// Mount mounts d on drive unit (0-3), replacing any image already mounted.  A
+// nil d unmounts the drive.
+func (r *RK8E) Mount(unit int, d *os8fs.Disk) error {
+	if unit < 0 || unit >= len(r.drives) {
+		return ErrNoDrive
+	}
+	r.drives[unit] = d
+	r.protect[unit] = false
+	return nil
+}
+
+// Err returns the first error encountered reading or writing an image, or nil.
+// The program sees such errors as CRC errors.
+func (r *RK8E) Err() error {
+	return r.err
+}
+
+// IOT implements cpu.Device.
+func (r *RK8E) IOT(c *cpu.CPU, w uint16) bool {
+	switch w {
+	case DSKP:
+		return r.status&(rkDone|rkErrors) != 0
+	case DCLR:
+		switch c.AC & 3 {
+		case 0: // clear status
+			r.status = 0
+		case 1: // clear control
+			r.busy = false
+			r.cmd, r.da, r.ca, r.status = 0, 0, 0, 0
+		case 2: // recalibrate
+			r.status = 0
+			if r.cmd&rkSeekDone != 0 {
+				r.start(c)
+			}
+		case 3: // clear status
+			r.status = 0
+		}
+		c.AC = 0
+	case DLAG:
+		r.da = c.AC
+		c.AC = 0
+		if r.busy {
+			r.status |= rkBusy | rkDone
+			return false
+		}
+		r.start(c)
+	case DLCA:
+		r.ca = c.AC
+		c.AC = 0
+	case DRST:
+		c.AC = r.status
+		if r.busy {
+			c.AC |= rkMotion
+		}
+	case DLDC:
+		r.cmd = c.AC
+		r.status = 0
+		c.AC = 0
+	}
+	return false
+}
+
+// start starts the function in the command register.
+func (r *RK8E) start(c *cpu.CPU) {
+	r.c = c
+	r.busy = true
+	r.due = r.now + r.Delay
+}
+
+// Interrupt implements cpu.Device.
+func (r *RK8E) Interrupt() bool {
+	return r.cmd&rkIE != 0 && r.status&(rkDone|rkErrors) != 0
+}
+
+// Reset implements cpu.Device.
+func (r *RK8E) Reset() {
+	r.busy = false
+	r.cmd, r.da, r.ca, r.status = 0, 0, 0, 0
+}
+
+// Clock implements cpu.Clocked.
+func (r *RK8E) Clock(cycles uint64) {
+	r.now = cycles
+	if r.busy && cycles >= r.due {
+		r.busy = false
+		r.finish()
+	}
+}
+
+// finish performs the function in the command register and sets the status.
+func (r *RK8E) finish() {
+	unit := int(r.cmd&rkDrive) >> 1
+	d := r.drives[unit]
+	fn := (r.cmd & rkFunc) >> 9
+	if d == nil {
+		r.status |= rkDone | rkNotReady | rkStatus
+		return
+	}
+	block := int(r.cmd&rkCylinder)<<12 | int(r.da)
+	if block >= RKBlocks || block >= blocks(d) {
+		r.status |= rkDone | rkCylError
+		return
+	}
+	n := 256
+	if r.cmd&rkHalf != 0 {
+		n = 128
+	}
+	field := int(r.cmd&rkField) >> 3
+	switch fn {
+	case rkRead, rkReadAll:
+		data, err := readBlock(d, block)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			r.c.Write(core.MakeAddr(field, r.ca), data[i])
+			r.ca = (r.ca + 1) & 07777
+		}
+	case rkProtect:
+		r.protect[unit] = true
+	case rkSeek:
+		if r.cmd&rkSeekDone == 0 {
+			return
+		}
+	case rkWrite, rkWriteAll:
+		if r.protect[unit] {
+			r.status |= rkDone | rkLocked
+			return
+		}
+		data := make([]uint16, 256)
+		for i := 0; i < n; i++ {
+			data[i] = r.c.Read(core.MakeAddr(field, r.ca))
+			r.ca = (r.ca + 1) & 07777
+		}
+		if err := writeBlock(d, block, data); err != nil {
+			if err == os8fs.ErrReadOnly {
+				r.status |= rkDone | rkLocked
+				return
+			}
+			r.fail(err)
+			return
+		}
+	default:
+		r.status |= rkDone | rkStatus
+		return
+	}
+	r.status |= rkDone
+}
+
+// fail records err and sets the CRC error status.
+func (r *RK8E) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.status |= rkDone | rkCRC
+}
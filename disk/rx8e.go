@@ -0,0 +1,343 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package disk
+
+import (
+	"github.com/pborman/pdp8/cpu"
+	"github.com/pborman/pdp8/os8fs"
+)
+
+// RX8E device code and IOTs.
+const (
+	RXDevice = 075
+
+	LCD  = 06751 // load command
+	XDR  = 06752 // transfer data register
+	STR  = 06753 // skip on transfer request
+	SER  = 06754 // skip on error
+	SDN  = 06755 // skip on done
+	INTR = 06756 // set interrupt enable from AC bit 11
+	INIT = 06757 // initialize
+)
+
+// RX8E command register bits.
+const (
+	rxFunc  = 00016 // function
+	rxDrive = 00020 // drive
+	rxMode  = 00100 // 8 bit mode
+)
+
+// RX8E functions.
+const (
+	rxFill     = 0
+	rxEmpty    = 1
+	rxWrite    = 2
+	rxRead     = 3
+	rxNop      = 4
+	rxStatus   = 5
+	rxWriteDel = 6
+	rxECode    = 7
+)
+
+// RX8E status (RXES) bits.
+const (
+	rxInitDone = 00004
+	rxReady    = 00200
+)
+
+// RX8E error codes.
+const (
+	rxBadTrack  = 00040 // track greater than 76
+	rxBadSector = 00070 // sector not found
+	rxNotReady  = 00110 // no diskette in the drive
+	rxBadCRC    = 00200 // CRC error
+)
+
+// RX8E states.
+const (
+	rxIdle     = iota // waiting for a command
+	rxFilling         // loading the sector buffer
+	rxEmptying        // unloading the sector buffer
+	rxSector          // waiting for the sector address
+	rxTrack           // waiting for the track address
+	rxBusy            // a function is in progress
+)
+
+// RXTracks and RXSectors are the number of tracks of an RX01 diskette and the
+// number of sectors on each track.
+const (
+	RXTracks  = 77
+	RXSectors = 26
+)
+
+// An RX8E is an RX8E floppy disk controller with up to 2 RX01 drives.  Only
+// the 12 bit mode, with 64 word sectors, is supported.  Commands in 8 bit mode
+// fail with a CRC error.
+//
+// An os8fs image holds the blocks of a diskette in the order OS/8 uses them,
+// not in the order of the sectors on the diskette.  OS/8 starts at track 1
+// and uses a 2:1 interleave: block B is the 4 sectors starting at logical
+// sector L = 4*B, and logical sector L is on track L/26+1 at sector 2*(L%26)+1
+// if L%26 is less than 13 and sector 2*(L%26-13)+2 otherwise.  Track 0, which
+// OS/8 does not use, reads as zeros and writes to it are discarded.  Deleted
+// data marks are not recorded.
+type RX8E struct {
+	Delay uint64 // cycles a read or write takes
+
+	drives [2]*os8fs.Disk
+
+	cmd    uint16     // command register
+	dbr    uint16     // data buffer register
+	buf    [64]uint16 // sector buffer
+	ptr    int        // next word of buf
+	state  int        // rxIdle, rxFilling, ...
+	sector uint16     // sector address
+	track  uint16     // track address
+	status uint16     // RXES
+	ecode  uint16     // error code
+
+	tr      bool // transfer request flag
+	done    bool // done flag
+	errf    bool // error flag
+	ie      bool // interrupt enable
+	initing bool // INIT is in progress
+
+	due uint64 // cycle the function completes
+	now uint64 // current cycle
+	err error  // first error from an image
+}
+
+// NewRX8E returns a new RX8E with no drives mounted.
+func NewRX8E() *RX8E {
+	return &RX8E{Delay: DefaultDelay}
+}
+
+// Mount mounts d on drive unit (0-1), replacing any image already mounted.  A
+// nil d unmounts the drive.
+func (r *RX8E) Mount(unit int, d *os8fs.Disk) error {
+	if unit < 0 || unit >= len(r.drives) {
+		return ErrNoDrive
+	}
+	r.drives[unit] = d
+	return nil
+}
+
+// Err returns the first error encountered reading or writing an image, or nil.
+// The program sees such errors as CRC errors.
+func (r *RX8E) Err() error {
+	return r.err
+}
+
+// IOT implements cpu.Device.
+func (r *RX8E) IOT(c *cpu.CPU, w uint16) bool {
+	switch w {
+	case LCD:
+		if r.state != rxIdle {
+			break
+		}
+		r.cmd = c.AC
+		c.AC = 0
+		r.command()
+	case XDR:
+		r.xdr(c)
+	case STR:
+		tr := r.tr
+		r.tr = false
+		return tr
+	case SER:
+		errf := r.errf
+		r.errf = false
+		return errf
+	case SDN:
+		done := r.done
+		r.done = false
+		return done
+	case INTR:
+		r.ie = c.AC&1 != 0
+	case INIT:
+		r.init()
+	}
+	return false
+}
+
+// command starts the function in the command register.
+func (r *RX8E) command() {
+	r.done, r.errf, r.tr = false, false, false
+	r.status &^= rxInitDone
+	if r.cmd&rxMode != 0 {
+		r.finish(rxBadCRC)
+		return
+	}
+	switch (r.cmd & rxFunc) >> 1 {
+	case rxFill:
+		r.state, r.ptr, r.tr = rxFilling, 0, true
+	case rxEmpty:
+		r.state, r.ptr, r.tr = rxEmptying, 0, true
+		r.dbr = r.buf[0]
+	case rxWrite, rxRead, rxWriteDel:
+		r.state, r.tr = rxSector, true
+	case rxNop, rxStatus:
+		r.finish(0)
+	case rxECode:
+		r.finish(0)
+		r.dbr = r.ecode
+	}
+}
+
+// xdr executes XDR, transferring the data buffer to or from c.AC as the
+// current function requires.
+func (r *RX8E) xdr(c *cpu.CPU) {
+	switch r.state {
+	case rxFilling:
+		r.buf[r.ptr] = c.AC
+		if r.ptr++; r.ptr == len(r.buf) {
+			r.finish(0)
+		} else {
+			r.tr = true
+		}
+	case rxEmptying:
+		c.AC = r.dbr
+		if r.ptr++; r.ptr == len(r.buf) {
+			r.finish(0)
+		} else {
+			r.dbr = r.buf[r.ptr]
+			r.tr = true
+		}
+	case rxSector:
+		r.sector = c.AC & 037
+		r.state, r.tr = rxTrack, true
+	case rxTrack:
+		r.track = c.AC & 0177
+		r.state = rxBusy
+		r.due = r.now + r.Delay
+	default:
+		c.AC = r.dbr
+	}
+}
+
+// init initializes the controller.  As on the hardware, sector 1 of track 1
+// of drive 0 is read into the sector buffer.
+func (r *RX8E) init() {
+	r.cmd = rxRead << 1
+	r.sector, r.track = 1, 1
+	r.tr, r.errf, r.done = false, false, false
+	r.status &^= rxInitDone
+	r.initing = true
+	r.state = rxBusy
+	r.due = r.now + r.Delay
+}
+
+// Interrupt implements cpu.Device.
+func (r *RX8E) Interrupt() bool {
+	return r.ie && r.done
+}
+
+// Reset implements cpu.Device.
+func (r *RX8E) Reset() {
+	r.ie = false
+	r.init()
+}
+
+// Clock implements cpu.Clocked.
+func (r *RX8E) Clock(cycles uint64) {
+	r.now = cycles
+	if r.state == rxBusy && cycles >= r.due {
+		r.transfer()
+	}
+}
+
+// finish completes the current function, setting the error flag and error
+// code if ecode is not 0.  The status is left in the data buffer register.
+func (r *RX8E) finish(ecode uint16) {
+	r.state = rxIdle
+	r.status &^= rxReady
+	if r.initing {
+		r.status |= rxInitDone
+		r.initing = false
+	}
+	if r.drives[(r.cmd&rxDrive)>>4] != nil {
+		r.status |= rxReady
+	}
+	if ecode != 0 {
+		r.ecode = ecode
+		r.errf = true
+	}
+	r.dbr = r.status
+	r.done = true
+}
+
+// transfer reads or writes the addressed sector.
+func (r *RX8E) transfer() {
+	d := r.drives[(r.cmd&rxDrive)>>4]
+	switch {
+	case d == nil:
+		r.finish(rxNotReady)
+		return
+	case r.track >= RXTracks:
+		r.finish(rxBadTrack)
+		return
+	case r.sector < 1 || r.sector > RXSectors:
+		r.finish(rxBadSector)
+		return
+	}
+	var err error
+	if (r.cmd&rxFunc)>>1 == rxRead {
+		err = r.read(d)
+	} else {
+		err = r.write(d)
+	}
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		r.finish(rxBadCRC)
+		return
+	}
+	r.finish(0)
+}
+
+// logical returns the block holding the addressed sector and the offset of
+// the sector in the block.  It returns -1 for sectors on track 0.
+func (r *RX8E) logical() (block, offset int) {
+	if r.track == 0 {
+		return -1, 0
+	}
+	s := int(r.sector-1) / 2
+	if r.sector%2 == 0 {
+		s += 13
+	}
+	l := int(r.track-1)*RXSectors + s
+	return l / 4, l % 4 * 64
+}
+
+// read reads the addressed sector of d into the sector buffer.
+func (r *RX8E) read(d *os8fs.Disk) error {
+	block, offset := r.logical()
+	if block < 0 {
+		r.buf = [64]uint16{}
+		return nil
+	}
+	data, err := readBlock(d, block)
+	if err != nil {
+		return err
+	}
+	copy(r.buf[:], data[offset:])
+	return nil
+}
+
+// write writes the sector buffer to the addressed sector of d.
+func (r *RX8E) write(d *os8fs.Disk) error {
+	block, offset := r.logical()
+	if block < 0 {
+		return nil
+	}
+	data, err := readBlock(d, block)
+	if err != nil {
+		return err
+	}
+	copy(data[offset:], r.buf[:])
+	return writeBlock(d, block, data)
+}
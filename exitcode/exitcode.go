@@ -0,0 +1,70 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package exitcode maps errors to the exit codes used by the PDP-8 programs
+// in this repository.
+package exitcode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pborman/pdp8/os8fs"
+)
+
+// Exit codes returned by Code.
+const (
+	OK        = 0 // no error
+	Error     = 1 // any other error
+	NotExist  = 2 // os8fs.ErrNotExist
+	NoSide    = 3 // os8fs.ErrNoSide
+	Corrupt   = 4 // *os8fs.CorruptError
+	Truncated = 5 // *os8fs.TruncatedError
+)
+
+// Code returns the exit code a program should use when exiting due to err.
+func Code(err error) int {
+	var cerr *os8fs.CorruptError
+	var terr *os8fs.TruncatedError
+	switch {
+	case err == nil:
+		return OK
+	case errors.Is(err, os8fs.ErrNotExist):
+		return NotExist
+	case errors.Is(err, os8fs.ErrNoSide):
+		return NoSide
+	case errors.As(err, &cerr):
+		return Corrupt
+	case errors.As(err, &terr):
+		return Truncated
+	default:
+		return Error
+	}
+}
+
+// Exit prints v to standard error and exits.  If v is a single error then the
+// exit code is determined by Code, otherwise it is Error.
+func Exit(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	code := Error
+	if len(v) == 1 {
+		if err, ok := v[0].(error); ok {
+			code = Code(err)
+		}
+	}
+	os.Exit(code)
+}
+
+// Exitf prints the message described by format and v to standard error and
+// exits with the code Error.  Use Exit to exit due to an error.
+func Exitf(format string, v ...interface{}) {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	fmt.Fprintf(os.Stderr, format, v...)
+	os.Exit(Error)
+}
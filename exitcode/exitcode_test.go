@@ -0,0 +1,36 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package exitcode
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/pborman/pdp8/os8fs"
+)
+
+func TestCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, OK},
+		{errors.New("other"), Error},
+		{os8fs.ErrNotExist, NotExist},
+		{fmt.Errorf("a.img: %w", fmt.Errorf("%w: FOO.PA", os8fs.ErrNotExist)), NotExist},
+		{fs.ErrNotExist, Error},
+		{fmt.Errorf("%w: c:FOO", os8fs.ErrNoSide), NoSide},
+		{&os8fs.CorruptError{Block: 1, Reason: "bad"}, Corrupt},
+		{fmt.Errorf("x: %w", &os8fs.TruncatedError{Size: 1, Want: 2}), Truncated},
+		{os8fs.ErrReadOnly, Error},
+	} {
+		if got := Code(tt.err); got != tt.want {
+			t.Errorf("Code(%v) got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package link links absolute programs and relocatable modules (see package
+// reloc) into a single memory image, as the OS/8 linking loader does.
+//
+// Absolute programs, such as those read from BIN tapes, are loaded first and
+// are never moved.  Each relocatable module is then placed on a page
+// boundary, either at a given address or in the first free pages of a given
+// field large enough to hold it, and common blocks are placed in the same way
+// after the modules.  Modules may not cross a field boundary.  Pages that hold
+// absolute words, page zero of each field, and the OS/8 resident pages 07600
+// of fields 0 and 1 are never used for modules or common blocks.
+//
+// The modules of a library are only loaded if they define a symbol referred to
+// by a module that is loaded.
+//
+// Modules may also be placed in overlays.  The overlays of an overlay level
+// share the same memory, which is allocated in the field of the level after
+// the resident modules are placed and is large enough for the largest overlay
+// of the level.  The modules of an overlay are placed one after the other from
+// the start of the level.  The overlay of each level with the lowest number is
+// loaded into the image, and every overlay, including that one, is written
+// after the core image, starting on a block boundary, for the program to read
+// into memory when it needs it.  A module may not refer to a symbol of another
+// overlay of its own level, since the two are never in memory together.
+//
+// Once placed, the words of each module are relocated and external references
+// are resolved to the entry points of the other modules.  A relocated value is
+// the 12 bit address within the field of its target.  A module that refers to
+// a symbol in another field must change the data or instruction field itself.
+package link
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/reloc"
+	"github.com/pborman/pdp8/saveimage"
+)
+
+const (
+	pagesPerField = core.FieldSize / core.PageSize
+	blockSize     = 2 * core.PageSize // words per block
+)
+
+// A Linker links programs and modules into a memory image.
+type Linker struct {
+	img      *core.Image
+	modules  []*module
+	library  []*module
+	absolute []core.Addr // starting addresses of absolute programs
+}
+
+// A module is a module to be linked.
+type module struct {
+	m     *reloc.Module
+	field int
+	at    core.Addr // base of the module, if fixed
+	fixed bool
+	base  core.Addr // base once placed
+
+	level   int // overlay level, 0 if resident
+	overlay int // overlay of level
+}
+
+// A Placed is a module or common block placed in memory.
+type Placed struct {
+	Name string
+	Addr core.Addr // first address
+	Len  int       // length in words
+}
+
+// An Overlay is one overlay of an overlay level.
+type Overlay struct {
+	Level  int
+	Number int
+	Addr   core.Addr // first address of the level
+	Words  []uint16  // the relocated words, filling the level
+	Block  int       // block of the overlay relative to the start of the file
+}
+
+// A Map describes where the modules, common blocks, overlays, and symbols were
+// placed.
+type Map struct {
+	Modules  []Placed
+	Commons  []Placed
+	Overlays []Overlay
+	Symbols  map[string]core.Addr
+}
+
+// New returns a new Linker with nothing loaded.
+func New() *Linker {
+	return &Linker{img: core.New()}
+}
+
+// LoadImage loads the absolute program img.  It is an error for img to load a
+// word with a different value than a program already loaded.
+func (l *Linker) LoadImage(img *core.Image) error {
+	if conflicts := l.img.Copy(img); len(conflicts) > 0 {
+		return fmt.Errorf("%v: loaded twice with different values", conflicts[0])
+	}
+	if img.HasStart {
+		l.absolute = append(l.absolute, img.Start)
+	}
+	return nil
+}
+
+// Add adds the module m to be placed in field.
+func (l *Linker) Add(m *reloc.Module, field int) {
+	l.modules = append(l.modules, &module{m: m, field: field & 07})
+}
+
+// AddAt adds the module m to be placed at a, which must be on a page
+// boundary.
+func (l *Linker) AddAt(m *reloc.Module, a core.Addr) error {
+	if a.Offset()%core.PageSize != 0 {
+		return fmt.Errorf("%s: %v is not on a page boundary", m.Name, a)
+	}
+	l.modules = append(l.modules, &module{m: m, field: a.Field(), at: a, fixed: true})
+	return nil
+}
+
+// AddOverlay adds the module m to overlay number of overlay level, which are
+// numbered from 1.  The level is placed in field.  All the modules of a level
+// must be placed in the same field.
+func (l *Linker) AddOverlay(m *reloc.Module, field, level, number int) error {
+	if level < 1 || number < 0 {
+		return fmt.Errorf("%s: invalid overlay %d.%d", m.Name, level, number)
+	}
+	l.modules = append(l.modules, &module{m: m, field: field & 07, level: level, overlay: number})
+	return nil
+}
+
+// AddLibrary adds the modules of a library.  A module of the library is only
+// placed, in field, if it defines a symbol referred to by another module.
+func (l *Linker) AddLibrary(lib []*reloc.Module, field int) {
+	for _, m := range lib {
+		l.library = append(l.library, &module{m: m, field: field & 07})
+	}
+}
+
+// Link places and relocates the modules and returns the memory image and its
+// map.  The starting address is the starting address of the first module that
+// has one, or else of the first absolute program that has one.
+func (l *Linker) Link() (*core.Image, *Map, error) {
+	modules, err := l.resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+	used := l.used()
+	mp := &Map{Symbols: map[string]core.Addr{}}
+	owners := map[string]*module{}
+	define := func(m *module) error {
+		mp.Modules = append(mp.Modules, Placed{Name: m.m.Name, Addr: m.base, Len: len(m.m.Code)})
+		for _, e := range m.m.Entries {
+			if a, ok := mp.Symbols[e.Name]; ok {
+				return fmt.Errorf("%s: %s already defined at %v", m.m.Name, e.Name, a)
+			}
+			mp.Symbols[e.Name] = m.base + core.Addr(e.Addr)
+			owners[e.Name] = m
+		}
+		return nil
+	}
+
+	// Place the modules at fixed addresses before the others, and the
+	// resident modules before the overlays.
+	sort.SliceStable(modules, func(i, j int) bool { return modules[i].fixed && !modules[j].fixed })
+	for _, m := range modules {
+		if m.level > 0 {
+			continue
+		}
+		pages := (len(m.m.Code) + core.PageSize - 1) / core.PageSize
+		if m.fixed {
+			if !reserve(used, m.at, pages) {
+				return nil, nil, fmt.Errorf("%s: %v is in use", m.m.Name, m.at)
+			}
+			m.base = m.at
+		} else {
+			a, ok := allocate(used, m.field, pages)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: no room for %o words in field %o", m.m.Name, len(m.m.Code), m.field)
+			}
+			m.base = a
+		}
+		if err := define(m); err != nil {
+			return nil, nil, err
+		}
+	}
+	overlays, err := placeOverlays(used, modules)
+	if err != nil {
+		return nil, nil, err
+	}
+	first := map[int]int{} // the first overlay of each level
+	for _, k := range overlayOrder(overlays) {
+		if _, ok := first[k.level]; !ok {
+			first[k.level] = k.number
+		}
+	}
+	for _, m := range modules {
+		if m.level > 0 {
+			if err := define(m); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Place the common blocks, each in the field of the first module that
+	// uses it, with the largest size any module gives it.
+	commons := map[string]*Placed{}
+	var cfields []int
+	for _, m := range modules {
+		for _, b := range m.m.Commons {
+			c := commons[b.Name]
+			if c == nil {
+				mp.Commons = append(mp.Commons, Placed{Name: b.Name})
+				c = &mp.Commons[len(mp.Commons)-1]
+				commons[b.Name] = c
+				cfields = append(cfields, m.field)
+			}
+			if b.Size > c.Len {
+				c.Len = b.Size
+			}
+		}
+	}
+	for i := range mp.Commons {
+		c := &mp.Commons[i]
+		pages := (c.Len + core.PageSize - 1) / core.PageSize
+		a, ok := allocate(used, cfields[i], pages)
+		if !ok {
+			return nil, nil, fmt.Errorf("common %s: no room for %o words in field %o", c.Name, c.Len, cfields[i])
+		}
+		c.Addr = a
+		commons[c.Name] = c
+	}
+
+	// Relocate the modules.  The words of an overlay module go to its
+	// overlay, and also to the image if it is the first overlay of its
+	// level.
+	img := core.New()
+	img.Copy(l.img)
+	var undefined []string
+	for _, m := range modules {
+		code := make([]uint16, len(m.m.Code))
+		copy(code, m.m.Code)
+		for _, r := range m.m.Relocs {
+			var target core.Addr
+			switch r.Kind {
+			case reloc.Relative:
+				target = m.base
+			case reloc.External:
+				name := m.m.Externals[r.Index]
+				a, ok := mp.Symbols[name]
+				if !ok {
+					undefined = append(undefined, name)
+					continue
+				}
+				if o := owners[name]; m.level > 0 && o.level == m.level && o.overlay != m.overlay {
+					return nil, nil, fmt.Errorf("%s: %s is in overlay %d.%d", m.m.Name, name, o.level, o.overlay)
+				}
+				target = a
+			case reloc.Common:
+				target = commons[m.m.Commons[r.Index].Name].Addr
+			}
+			code[r.Addr] = (code[r.Addr] + target.Offset()) & 07777
+		}
+		if m.level > 0 {
+			ov := overlays[overlayKey{m.level, m.overlay}]
+			copy(ov.Words[m.base-ov.Addr:], code)
+		}
+		if m.level == 0 || m.overlay == first[m.level] {
+			for i, w := range code {
+				img.Load(m.base+core.Addr(i), w)
+			}
+		}
+		if m.m.Start >= 0 && !img.HasStart {
+			img.Start = m.base + core.Addr(m.m.Start)
+			img.HasStart = true
+		}
+	}
+	if len(undefined) > 0 {
+		return nil, nil, fmt.Errorf("undefined symbols: %s", strings.Join(unique(undefined), ", "))
+	}
+	if !img.HasStart && len(l.absolute) > 0 {
+		img.Start = l.absolute[0]
+		img.HasStart = true
+	}
+
+	// The overlays follow the core image in the file, each on its own
+	// blocks.
+	block := 1
+	for _, s := range saveimage.Segments(img) {
+		block += s.Pages / 2
+	}
+	for _, k := range overlayOrder(overlays) {
+		ov := overlays[k]
+		ov.Block = block
+		block += (len(ov.Words) + blockSize - 1) / blockSize
+		mp.Overlays = append(mp.Overlays, *ov)
+	}
+	return img, mp, nil
+}
+
+// An overlayKey identifies an overlay by its level and number.
+type overlayKey struct {
+	level, number int
+}
+
+// placeOverlays allocates the memory of each overlay level of modules and
+// places the modules of each of its overlays.  It returns the overlays by
+// level and number.
+func placeOverlays(used []bool, modules []*module) (map[overlayKey]*Overlay, error) {
+	overlays := map[overlayKey]*Overlay{}
+	fields := map[int]int{}
+	pages := map[overlayKey]int{}
+	for _, m := range modules {
+		if m.level == 0 {
+			continue
+		}
+		if f, ok := fields[m.level]; !ok {
+			fields[m.level] = m.field
+		} else if f != m.field {
+			return nil, fmt.Errorf("%s: overlay level %d is in field %o", m.m.Name, m.level, f)
+		}
+		k := overlayKey{m.level, m.overlay}
+		if overlays[k] == nil {
+			overlays[k] = &Overlay{Level: m.level, Number: m.overlay}
+		}
+		pages[k] += (len(m.m.Code) + core.PageSize - 1) / core.PageSize
+	}
+	var levels []int
+	for level := range fields {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	for _, level := range levels {
+		n := 0
+		for k, p := range pages {
+			if k.level == level && p > n {
+				n = p
+			}
+		}
+		base, ok := allocate(used, fields[level], n)
+		if !ok {
+			return nil, fmt.Errorf("overlay level %d: no room for %o words in field %o", level, n*core.PageSize, fields[level])
+		}
+		for k, ov := range overlays {
+			if k.level == level {
+				ov.Addr = base
+				ov.Words = make([]uint16, n*core.PageSize)
+			}
+		}
+	}
+	next := map[overlayKey]core.Addr{}
+	for _, m := range modules {
+		if m.level == 0 {
+			continue
+		}
+		k := overlayKey{m.level, m.overlay}
+		a, ok := next[k]
+		if !ok {
+			a = overlays[k].Addr
+		}
+		m.base = a
+		next[k] = a + core.Addr((len(m.m.Code)+core.PageSize-1)/core.PageSize*core.PageSize)
+	}
+	return overlays, nil
+}
+
+// overlayOrder returns the keys of overlays in order of level and number.
+func overlayOrder(overlays map[overlayKey]*Overlay) []overlayKey {
+	var keys []overlayKey
+	for k := range overlays {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].level != keys[j].level {
+			return keys[i].level < keys[j].level
+		}
+		return keys[i].number < keys[j].number
+	})
+	return keys
+}
+
+// resolve returns the modules to place: all the modules added by Add and AddAt
+// and the library modules that define symbols they, or other library modules
+// that are placed, refer to.
+func (l *Linker) resolve() ([]*module, error) {
+	modules := append([]*module{}, l.modules...)
+	defined := map[string]bool{}
+	for _, m := range modules {
+		for _, e := range m.m.Entries {
+			defined[e.Name] = true
+		}
+	}
+	loaded := make([]bool, len(l.library))
+	for {
+		more := false
+		for _, m := range modules {
+			for _, name := range m.m.Externals {
+				if defined[name] {
+					continue
+				}
+				for i, lm := range l.library {
+					if loaded[i] || lm.m.Entry(name) == nil {
+						continue
+					}
+					loaded[i] = true
+					modules = append(modules, lm)
+					for _, e := range lm.m.Entries {
+						defined[e.Name] = true
+					}
+					more = true
+					break
+				}
+			}
+		}
+		if !more {
+			return modules, nil
+		}
+	}
+}
+
+// used returns which pages may not be used by modules.
+func (l *Linker) used() []bool {
+	used := make([]bool, core.MaxFields*pagesPerField)
+	for a := core.Addr(0); a < core.Size; a++ {
+		if l.img.Loaded(a) {
+			used[int(a)/core.PageSize] = true
+		}
+	}
+	for f := 0; f < core.MaxFields; f++ {
+		used[f*pagesPerField] = true
+	}
+	used[pagesPerField-1] = true
+	used[2*pagesPerField-1] = true
+	return used
+}
+
+// reserve marks the pages pages starting at a as used and reports if they
+// were all free and in the field of a.
+func reserve(used []bool, a core.Addr, pages int) bool {
+	first := int(a) / core.PageSize
+	if pages == 0 {
+		return true
+	}
+	if first%pagesPerField+pages > pagesPerField {
+		return false
+	}
+	for p := first; p < first+pages; p++ {
+		if used[p] {
+			return false
+		}
+	}
+	for p := first; p < first+pages; p++ {
+		used[p] = true
+	}
+	return true
+}
+
+// allocate reserves the first pages free pages in field and returns their
+// address.
+func allocate(used []bool, field, pages int) (core.Addr, bool) {
+	for p := 0; p+pages <= pagesPerField; p++ {
+		a := core.MakeAddr(field, uint16(p*core.PageSize))
+		if reserve(used, a, pages) {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// unique returns the sorted unique strings of names.
+func unique(names []string) []string {
+	sort.Strings(names)
+	var u []string
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			u = append(u, name)
+		}
+	}
+	return u
+}
+
+// AppendOverlays returns the core image words, as encoded by saveimage.Encode
+// from the image returned with mp, followed by the overlays of mp, each
+// starting at its block.
+func (mp *Map) AppendOverlays(words []uint16) []uint16 {
+	for _, ov := range mp.Overlays {
+		for len(words) < ov.Block*blockSize {
+			words = append(words, 0)
+		}
+		words = append(words, ov.Words...)
+	}
+	return words
+}
+
+// Write writes the map to w.  The modules, common blocks, and overlays are
+// written, as comments, with their addresses and lengths, and the block of
+// each overlay, followed by the symbols and common blocks in alphabetical
+// order.  The map may be read by symtab.Parse.
+func (mp *Map) Write(w io.Writer) error {
+	var b strings.Builder
+	symbols := map[string]core.Addr{}
+	for _, m := range mp.Modules {
+		fmt.Fprintf(&b, "/ MODULE %-6s %v %04o\n", m.Name, m.Addr, m.Len)
+	}
+	for _, c := range mp.Commons {
+		fmt.Fprintf(&b, "/ COMMON %-6s %v %04o\n", c.Name, c.Addr, c.Len)
+		symbols[c.Name] = c.Addr
+	}
+	for _, ov := range mp.Overlays {
+		fmt.Fprintf(&b, "/ OVERLAY %-6s %v %04o %04o\n", fmt.Sprintf("%d.%d", ov.Level, ov.Number), ov.Addr, len(ov.Words), ov.Block)
+	}
+	for name, a := range mp.Symbols {
+		symbols[name] = a
+	}
+	var names []string
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%-6s %v\n", name, symbols[name])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
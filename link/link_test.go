@@ -0,0 +1,359 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package link
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/pal"
+	"github.com/pborman/pdp8/reloc"
+	"github.com/pborman/pdp8/symtab"
+)
+
+// words returns a module named name of the given words that has no start.
+func words(name string, code ...uint16) *reloc.Module {
+	return &reloc.Module{Name: name, Code: code, Start: -1}
+}
+
+// entry returns a module named name with the entry point name at 0.
+func entry(name string, code ...uint16) *reloc.Module {
+	m := words(name, code...)
+	m.Entries = []reloc.Symbol{{Name: name, Addr: 0}}
+	return m
+}
+
+func TestLink(t *testing.T) {
+	a := &reloc.Module{
+		Name:      "A",
+		Code:      []uint16{0, 1, 0},
+		Externals: []string{"B"},
+		Commons:   []reloc.Block{{Name: "C", Size: 3}},
+		Relocs: []reloc.Reloc{
+			{Addr: 0, Kind: reloc.Relative},
+			{Addr: 2, Kind: reloc.External},
+			{Addr: 1, Kind: reloc.Common},
+		},
+		Start: 1,
+	}
+	l := New()
+	abs := core.New()
+	abs.Load(0200, 1)
+	if err := l.LoadImage(abs); err != nil {
+		t.Fatal(err)
+	}
+	l.Add(a, 0)
+	l.AddLibrary([]*reloc.Module{entry("U", 7), entry("B", 7)}, 1)
+	img, mp, err := l.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Placed{{"A", 0400, 3}, {"B", 010200, 1}}
+	if len(mp.Modules) != len(want) {
+		t.Fatalf("got modules %v, want %v", mp.Modules, want)
+	}
+	for i, p := range want {
+		if mp.Modules[i] != p {
+			t.Errorf("got module %v, want %v", mp.Modules[i], p)
+		}
+	}
+	if len(mp.Commons) != 1 || mp.Commons[0] != (Placed{"C", 0600, 3}) {
+		t.Errorf("got commons %v", mp.Commons)
+	}
+	for a, w := range map[core.Addr]uint16{0200: 1, 0400: 0400, 0401: 0601, 0402: 0200, 010200: 7} {
+		if got := img.Word(a); got != w {
+			t.Errorf("%v: got %04o, want %04o", a, got, w)
+		}
+	}
+	if img.Loaded(010400) {
+		t.Error("unused library module U was loaded")
+	}
+	if !img.HasStart || img.Start != 0401 {
+		t.Errorf("got start %v, %v, want 0:0401", img.Start, img.HasStart)
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	big := words("BIG", make([]uint16, 3*core.PageSize)...)
+	l := New()
+	l.Add(words("ONE", 1), 0)
+	l.Add(big, 0)
+	if err := l.AddAt(words("FIXED", 2), 0200); err != nil {
+		t.Fatal(err)
+	}
+	l.Add(words("F1", 3), 1)
+	_, mp, err := l.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FIXED is placed first, page 0 is never used, and field 1 starts
+	// after its page zero.
+	want := map[string]core.Addr{"FIXED": 0200, "ONE": 0400, "BIG": 0600, "F1": 010200}
+	for _, p := range mp.Modules {
+		if p.Addr != want[p.Name] {
+			t.Errorf("%s: got %v, want %v", p.Name, p.Addr, want[p.Name])
+		}
+	}
+
+	// The resident page 07600 of field 0 is never used.
+	l = New()
+	l.Add(words("FILL", make([]uint16, 07600-0200)...), 0)
+	l.Add(words("LAST", 1), 0)
+	if _, _, err := l.Link(); err == nil || !strings.Contains(err.Error(), "LAST: no room") {
+		t.Errorf("got error %v, want no room for LAST", err)
+	}
+	l = New()
+	l.Add(words("FILL", make([]uint16, 07600-0200)...), 2)
+	l.Add(words("LAST", 1), 2)
+	if _, mp, err := l.Link(); err != nil {
+		t.Error(err)
+	} else if mp.Modules[1].Addr != 027600 {
+		t.Errorf("got LAST at %v, want 2:7600", mp.Modules[1].Addr)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	ext := words("EXT", 0)
+	ext.Externals = []string{"NONE"}
+	ext.Relocs = []reloc.Reloc{{Addr: 0, Kind: reloc.External}}
+
+	for _, tt := range []struct {
+		name string
+		add  func(l *Linker)
+		want string
+	}{{
+		name: "undefined",
+		add:  func(l *Linker) { l.Add(ext, 0) },
+		want: "undefined symbols: NONE",
+	}, {
+		name: "duplicate",
+		add:  func(l *Linker) { l.Add(entry("X"), 0); l.Add(entry("X"), 0) },
+		want: "X already defined",
+	}, {
+		name: "in use",
+		add: func(l *Linker) {
+			l.AddAt(words("A", 1), 0400)
+			l.AddAt(words("B", 1), 0400)
+		},
+		want: "B: 0:0400 is in use",
+	}, {
+		name: "field boundary",
+		add:  func(l *Linker) { l.AddAt(words("A", make([]uint16, 2*core.PageSize)...), 017600) },
+		want: "A: 1:7600 is in use",
+	}, {
+		name: "too big",
+		add:  func(l *Linker) { l.Add(words("A", make([]uint16, core.FieldSize)...), 3) },
+		want: "A: no room",
+	}} {
+		l := New()
+		tt.add(l)
+		_, _, err := l.Link()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if err := New().AddAt(words("A"), 0201); err == nil {
+		t.Error("AddAt off a page boundary did not fail")
+	}
+
+	l := New()
+	a, b := core.New(), core.New()
+	a.Load(0200, 1)
+	b.Load(0200, 2)
+	if err := l.LoadImage(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.LoadImage(b); err == nil {
+		t.Error("conflicting images did not fail")
+	}
+}
+
+func TestMap(t *testing.T) {
+	mp := &Map{
+		Modules: []Placed{{"MAIN", 0400, 0200}},
+		Commons: []Placed{{"BUF", 010200, 010}},
+		Symbols: map[string]core.Addr{"START": 0401},
+	}
+	var b strings.Builder
+	if err := mp.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	tab, err := symtab.Parse([]byte(b.String()))
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, b.String())
+	}
+	for name, a := range map[string]core.Addr{"START": 0401, "BUF": 010200} {
+		if got, ok := tab.Name(a); !ok || got != name {
+			t.Errorf("%v: got %q, want %q", a, got, name)
+		}
+	}
+	if !strings.Contains(b.String(), "/ MODULE MAIN") {
+		t.Errorf("map does not list MAIN:\n%s", b.String())
+	}
+}
+
+func TestLinkAssembled(t *testing.T) {
+	src := `START,	JMS SUB
+	HLT
+	.START START
+	.RSECT SUBS
+SUB,	0
+	ISZ COUNT
+	JMP I SUB
+COUNT,	0
+`
+	p, err := pal.AssembleModules("MAIN", []byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	l.Add(p.Modules[0], 0)
+	l.Add(p.Modules[1], 1)
+	img, mp, err := l.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := mp.Symbols["SUB"]; a != 010200 {
+		t.Fatalf("got SUB at %v, want 1:0200", a)
+	}
+	// JMS SUB goes through a link at the end of the page of MAIN.
+	if got := img.Word(0200); got != 04777 {
+		t.Errorf("0:0200: got %04o, want 4777", got)
+	}
+	if got := img.Word(0377); got != 0200 {
+		t.Errorf("0:0377: got %04o, want 0200", got)
+	}
+	// ISZ COUNT is on the current page of SUBS.
+	if got := img.Word(010201); got != 02203 {
+		t.Errorf("1:0201: got %04o, want 2203", got)
+	}
+	if !img.HasStart || img.Start != 0200 {
+		t.Errorf("got start %v, want 0:0200", img.Start)
+	}
+}
+
+func TestOverlays(t *testing.T) {
+	main := entry("MAIN", 0, 0)
+	main.Externals = []string{"A", "B"}
+	main.Relocs = []reloc.Reloc{
+		{Addr: 0, Kind: reloc.External, Index: 0},
+		{Addr: 1, Kind: reloc.External, Index: 1},
+	}
+	main.Start = 0
+	b := entry("B", 5)
+	b.Relocs = []reloc.Reloc{{Addr: 0, Kind: reloc.Relative}}
+
+	l := New()
+	l.Add(main, 0)
+	for _, o := range []struct {
+		m             *reloc.Module
+		level, number int
+	}{
+		{b, 1, 1},
+		{entry("A", make([]uint16, 2*core.PageSize)...), 1, 0},
+		{entry("A2", 2), 1, 0},
+		{entry("C", 3), 2, 0},
+	} {
+		if err := l.AddOverlay(o.m, 0, o.level, o.number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	img, mp, err := l.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Level 1 holds the 3 pages of overlay 0, and level 2 follows it.
+	for name, a := range map[string]core.Addr{"MAIN": 0200, "A": 0400, "A2": 01000, "B": 0400, "C": 01200} {
+		if got := mp.Symbols[name]; got != a {
+			t.Errorf("%s: got %v, want %v", name, got, a)
+		}
+	}
+	for a, w := range map[core.Addr]uint16{0200: 0400, 0201: 0400, 01000: 2, 01200: 3} {
+		if got := img.Word(a); got != w {
+			t.Errorf("%v: got %04o, want %04o", a, got, w)
+		}
+	}
+	want := []struct {
+		level, number int
+		addr          core.Addr
+		len, block    int
+	}{
+		{1, 0, 0400, 0600, 4},
+		{1, 1, 0400, 0600, 6},
+		{2, 0, 01200, 0200, 8},
+	}
+	if len(mp.Overlays) != len(want) {
+		t.Fatalf("got %d overlays, want %d", len(mp.Overlays), len(want))
+	}
+	for i, w := range want {
+		ov := mp.Overlays[i]
+		if ov.Level != w.level || ov.Number != w.number || ov.Addr != w.addr || len(ov.Words) != w.len || ov.Block != w.block {
+			t.Errorf("overlay %d: got %d.%d %v %04o %d, want %d.%d %v %04o %d", i, ov.Level, ov.Number, ov.Addr, len(ov.Words), ov.Block, w.level, w.number, w.addr, w.len, w.block)
+		}
+	}
+	if got := mp.Overlays[1].Words[0]; got != 0405 {
+		t.Errorf("B: got %04o, want 0405", got)
+	}
+	if got := mp.Overlays[0].Words[0400]; got != 2 {
+		t.Errorf("A2: got %04o, want 0002", got)
+	}
+
+	words := mp.AppendOverlays(make([]uint16, 4*blockSize))
+	if len(words) != 8*blockSize+0200 || words[6*blockSize] != 0405 || words[8*blockSize] != 3 {
+		t.Errorf("appended %d words", len(words))
+	}
+	var sb strings.Builder
+	if err := mp.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "/ OVERLAY 1.1    0:0400 0600 0006\n") {
+		t.Errorf("map does not list overlay 1.1:\n%s", sb.String())
+	}
+}
+
+func TestOverlayErrors(t *testing.T) {
+	b := entry("B", 0)
+	b.Externals = []string{"A"}
+	b.Relocs = []reloc.Reloc{{Addr: 0, Kind: reloc.External}}
+
+	for _, tt := range []struct {
+		name string
+		add  func(l *Linker)
+		want string
+	}{{
+		name: "other overlay",
+		add:  func(l *Linker) { l.AddOverlay(entry("A", 0), 0, 1, 0); l.AddOverlay(b, 0, 1, 1) },
+		want: "B: A is in overlay 1.0",
+	}, {
+		name: "fields",
+		add:  func(l *Linker) { l.AddOverlay(entry("A", 0), 0, 1, 0); l.AddOverlay(entry("C", 0), 1, 1, 1) },
+		want: "C: overlay level 1 is in field 0",
+	}, {
+		name: "too big",
+		add:  func(l *Linker) { l.AddOverlay(words("A", make([]uint16, core.FieldSize)...), 3, 1, 0) },
+		want: "overlay level 1: no room",
+	}} {
+		l := New()
+		tt.add(l)
+		_, _, err := l.Link()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if err := New().AddOverlay(words("A"), 0, 0, 0); err == nil {
+		t.Error("overlay level 0 did not fail")
+	}
+
+	// An overlay may refer to the resident program and to other levels.
+	l := New()
+	l.Add(entry("A", 0), 0)
+	l.AddOverlay(b, 0, 1, 1)
+	if _, _, err := l.Link(); err != nil {
+		t.Error(err)
+	}
+}
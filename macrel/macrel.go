@@ -0,0 +1,348 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package macrel assembles MACREL style relocatable macro sources.
+//
+// The source is expanded by this package and then assembled by
+// pal.AssembleModules, which describes the language and directives of
+// relocatable source.  The result is a set of modules in the format of package
+// reloc, which are linked by package link.  This package adds macros, which
+// are defined by:
+//
+//	.MACRO NAME FORMAL,...
+//	BODY
+//	.ENDM
+//
+// A macro is invoked by its name at the start of a line, optionally preceded
+// by labels, followed by the actual arguments separated by commas:
+//
+//	LABEL,  NAME ARG,...
+//
+// An argument that contains commas or spaces is enclosed in <>, which are
+// removed.  Each line of the body is assembled with each symbol that is a
+// FORMAL replaced by its argument.  A missing argument is blank and may be
+// tested with .IF BL and .IF NB.  Bodies may invoke other macros and define
+// macros.  Macro names and formals are folded to upper case.
+//
+// Definitions and invocations are kept as comments in the listing, and lines
+// produced by a macro are reported with the line number of the invocation.
+// Macro errors are reported with the following codes:
+//
+//	MD  bad macro definition, such as .ENDM without .MACRO
+//	MX  macros nested too deeply, such as a macro that invokes itself
+package macrel
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pborman/pdp8/pal"
+)
+
+// MaxDepth is the maximum depth of nested macro invocations.
+const MaxDepth = 32
+
+// A macro is a macro definition.
+type macro struct {
+	formals []string
+	body    []string
+}
+
+// An expander expands the macros in a source.
+type expander struct {
+	macros map[string]*macro
+	out    []string
+	nums   []int
+	errs   pal.ErrorList
+}
+
+// Assemble expands the macros in src and assembles the result with
+// pal.AssembleModules.  name is the name of the first section.  If there are
+// errors, the returned error is a pal.ErrorList of all the errors, which are
+// also found in the returned program.
+func Assemble(name string, src []byte) (*pal.Program, error) {
+	text, lineNums, errs := Expand(src)
+	p, _ := pal.AssembleModules(name, text, lineNums)
+	p.Errors = append(errs, p.Errors...)
+	sort.SliceStable(p.Errors, func(i, j int) bool { return p.Errors[i].Line < p.Errors[j].Line })
+	if len(p.Errors) > 0 {
+		return p, p.Errors
+	}
+	return p, nil
+}
+
+// Expand returns src with its macros expanded and the line number in src of
+// each line of the expanded source, as passed to pal.AssembleModules, along
+// with any errors in the macros.
+func Expand(src []byte) ([]byte, []int, pal.ErrorList) {
+	x := &expander{macros: map[string]*macro{}}
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	nums := make([]int, len(lines))
+	for i := range nums {
+		nums[i] = i + 1
+	}
+	x.expand(lines, nums, 0)
+	var out bytes.Buffer
+	for _, l := range x.out {
+		out.WriteString(l)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), x.nums, x.errs
+}
+
+// errorf records an error with code on line num.
+func (x *expander) errorf(num int, code string) {
+	x.errs = append(x.errs, &pal.Error{Line: num, Code: code})
+}
+
+// emit adds the line s, from line num, to the output.
+func (x *expander) emit(num int, s string) {
+	x.out = append(x.out, s)
+	x.nums = append(x.nums, num)
+}
+
+// expand expands lines, whose line numbers are nums, at the given depth of
+// macro invocations.
+func (x *expander) expand(lines []string, nums []int, depth int) {
+	for i := 0; i < len(lines); i++ {
+		s, num := lines[i], nums[i]
+		labels, rest := splitLabels(s)
+		word, args := splitWord(rest)
+		switch word {
+		case ".MACRO":
+			x.emit(num, comment(s))
+			name, formals := splitWord(args)
+			m := &macro{}
+			for _, f := range splitArgs(formals) {
+				m.formals = append(m.formals, strings.ToUpper(f))
+			}
+			nest := 0
+			for i++; ; i++ {
+				if i >= len(lines) {
+					x.errorf(num, "MD")
+					return
+				}
+				x.emit(nums[i], comment(lines[i]))
+				_, rest := splitLabels(lines[i])
+				w, _ := splitWord(rest)
+				if w == ".MACRO" {
+					nest++
+				} else if w == ".ENDM" {
+					if nest == 0 {
+						break
+					}
+					nest--
+				}
+				m.body = append(m.body, lines[i])
+			}
+			if name == "" || !isSymbol(name) {
+				x.errorf(num, "MD")
+				continue
+			}
+			x.macros[name] = m
+			continue
+		case ".ENDM":
+			x.emit(num, comment(s))
+			x.errorf(num, "MD")
+			continue
+		}
+		m := x.macros[word]
+		if m == nil {
+			x.emit(num, s)
+			continue
+		}
+		x.emit(num, labels+comment(rest))
+		if depth >= MaxDepth {
+			x.errorf(num, "MX")
+			continue
+		}
+		actuals := splitArgs(stripComment(args))
+		body := make([]string, len(m.body))
+		bnums := make([]int, len(m.body))
+		for j, l := range m.body {
+			body[j] = substitute(l, m.formals, actuals)
+			bnums[j] = num
+		}
+		x.expand(body, bnums, depth+1)
+	}
+}
+
+// comment returns s as a comment.
+func comment(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+	return "/" + s
+}
+
+func isLetter(c byte) bool { return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// isSymbol reports if s is a symbol.
+func isSymbol(s string) bool {
+	if s == "" || !isLetter(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// symbolEnd returns the index of the end of the symbol that starts s[i].
+func symbolEnd(s string, i int) int {
+	for i < len(s) && (isLetter(s[i]) || isDigit(s[i])) {
+		i++
+	}
+	return i
+}
+
+// splitLabels splits s into its leading labels (NAME,) and the rest of s.
+func splitLabels(s string) (labels, rest string) {
+	i := 0
+	for {
+		j := i
+		for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+			j++
+		}
+		if j >= len(s) || !isLetter(s[j]) {
+			return s[:i], s[i:]
+		}
+		k := symbolEnd(s, j)
+		for k < len(s) && (s[k] == ' ' || s[k] == '\t') {
+			k++
+		}
+		if k >= len(s) || s[k] != ',' {
+			return s[:i], s[i:]
+		}
+		i = k + 1
+	}
+}
+
+// splitWord returns the first word of s, in upper case, and the rest of s.  A
+// word is a symbol or a directive (a symbol preceded by .).
+func splitWord(s string) (word, rest string) {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	start := i
+	if i < len(s) && s[i] == '.' {
+		i++
+	}
+	if i >= len(s) || !isLetter(s[i]) {
+		return "", s
+	}
+	i = symbolEnd(s, i)
+	if i < len(s) && s[i] != ' ' && s[i] != '\t' && s[i] != '/' && s[i] != ';' {
+		return "", s
+	}
+	return strings.ToUpper(s[start:i]), s[i:]
+}
+
+// stripComment returns s without a trailing comment.  A / within <> is not a
+// comment.
+func stripComment(s string) string {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '<':
+			depth++
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// splitArgs splits s into its comma separated arguments.  Enclosing <> are
+// removed.
+func splitArgs(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch s[i] {
+			case '<':
+				depth++
+				continue
+			case '>':
+				if depth > 0 {
+					depth--
+				}
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		arg := strings.TrimSpace(s[start:i])
+		if len(arg) >= 2 && arg[0] == '<' && arg[len(arg)-1] == '>' {
+			arg = arg[1 : len(arg)-1]
+		}
+		args = append(args, arg)
+		start = i + 1
+	}
+	return args
+}
+
+// substitute returns s with each symbol in formals replaced by the
+// corresponding actual argument.  Comments are not changed.
+func substitute(s string, formals, actuals []string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '/':
+			b.WriteString(s[i:])
+			return b.String()
+		case c == '"' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i += 2
+		case isLetter(c):
+			j := symbolEnd(s, i)
+			word := s[i:j]
+			for k, f := range formals {
+				if strings.EqualFold(word, f) {
+					word = ""
+					if k < len(actuals) {
+						word = actuals[k]
+					}
+					break
+				}
+			}
+			b.WriteString(word)
+			i = j
+		case isDigit(c):
+			j := symbolEnd(s, i)
+			b.WriteString(s[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
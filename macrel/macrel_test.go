@@ -0,0 +1,185 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package macrel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/reloc"
+)
+
+// code returns the lines of text that are not comments.
+func code(text []byte) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSuffix(string(text), "\n"), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(l), "/") {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestExpand(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want []string
+	}{{
+		name: "arguments",
+		src: `	.MACRO PUT A,B
+	A
+	B
+	.ENDM
+	PUT <1, 2>,3
+	put 4
+`,
+		want: []string{"\t1, 2", "\t3", "\t4", "\t"},
+	}, {
+		name: "labels",
+		src: `	.MACRO PUT A
+	A
+	.ENDM
+X, Y,	PUT 1
+`,
+		want: []string{"X, Y,/\tPUT 1", "\t1"},
+	}, {
+		name: "comments",
+		src: `	.MACRO PUT A
+	A / A
+	.ENDM
+	PUT 1 / PUT 2
+	PUT <7/>
+`,
+		want: []string{"\t1 / A", "\t7/ / A"},
+	}, {
+		name: "characters",
+		src: `	.MACRO CH A
+	"A
+	A
+	.ENDM
+	CH B
+`,
+		want: []string{"\t\"A", "\tB"},
+	}, {
+		name: "nested definitions",
+		src: `	.MACRO OUTER N
+	.MACRO INNER
+	TAD N
+	.ENDM
+	.ENDM
+	OUTER 5
+	INNER
+`,
+		want: []string{"\tTAD 5"},
+	}, {
+		name: "nested invocations",
+		src: `	.MACRO ONE A
+	TAD A
+	.ENDM
+	.MACRO TWO A,B
+	ONE A
+	ONE B
+	.ENDM
+	TWO 1,2
+`,
+		want: []string{"\tTAD 1", "\tTAD 2"},
+	}} {
+		text, _, errs := Expand([]byte(tt.src))
+		if len(errs) != 0 {
+			t.Errorf("%s: %v", tt.name, errs)
+		}
+		if got := code(text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandLines(t *testing.T) {
+	src := "\t.MACRO M\n\tCLA\n\tHLT\n\t.ENDM\n\tM\n\tNOP\n"
+	text, nums, _ := Expand([]byte(src))
+	lines := strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+	if len(lines) != len(nums) {
+		t.Fatalf("%d lines with %d numbers", len(lines), len(nums))
+	}
+	want := []int{1, 2, 3, 4, 5, 5, 5, 6}
+	if !reflect.DeepEqual(nums, want) {
+		t.Errorf("got %v, want %v", nums, want)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		code string
+		line int
+	}{
+		{"\t.ENDM\n", "MD", 1},
+		{"\tCLA\n\t.MACRO M\n\tHLT\n", "MD", 2},
+		{"\t.MACRO\n\t.ENDM\n", "MD", 1},
+		{"\t.MACRO 1X\n\t.ENDM\n", "MD", 1},
+		{"\t.MACRO R\n\tR\n\t.ENDM\n\tR\n", "MX", 4},
+	} {
+		_, _, errs := Expand([]byte(tt.src))
+		if len(errs) != 1 || errs[0].Code != tt.code || errs[0].Line != tt.line {
+			t.Errorf("%q: got %v, want %s on line %d", tt.src, errs, tt.code, tt.line)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := `	.EXTERNAL PRINT
+	.MACRO CALL F,A
+	JMS F
+	.IF NB A <A>
+	.ENDM
+START,	CALL PRINT,<START+1>
+	CALL PRINT
+	.RSECT TWO
+X,	START
+`
+	p, err := Assemble("ONE", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Modules) != 2 {
+		t.Fatalf("got %d modules, want 2", len(p.Modules))
+	}
+	m := p.Modules[0]
+	if m.Name != "ONE" {
+		t.Errorf("got name %q, want ONE", m.Name)
+	}
+	want := []uint16{04777, 00001, 04777}
+	for i, w := range want {
+		if m.Code[i] != w {
+			t.Errorf("word %o: got %04o, want %04o", i, m.Code[i], w)
+		}
+	}
+	if m.Entry("START") == nil {
+		t.Error("START is not an entry")
+	}
+	if len(m.Relocs) != 2 || m.Relocs[0].Kind != reloc.Relative || m.Relocs[1].Kind != reloc.External {
+		t.Errorf("got relocs %v", m.Relocs)
+	}
+	if m := p.Modules[1]; m.Name != "TWO" || len(m.Externals) != 1 || m.Externals[0] != "START" {
+		t.Errorf("got module %s with externals %v", m.Name, m.Externals)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	p, err := Assemble("E", []byte("\tTAD FOO\n\t.ENDM\n\t.MACRO R\n\tR\n\t.ENDM\n\tR\n"))
+	if err == nil {
+		t.Fatal("no error")
+	}
+	var got []string
+	for _, e := range p.Errors {
+		got = append(got, e.Code)
+	}
+	if want := []string{"US", "MD", "MX"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
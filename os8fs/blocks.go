@@ -0,0 +1,43 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+// Drive returns the type of drive d is.
+func (d *Disk) Drive() Drive {
+	return d.drive
+}
+
+// Sides returns the number of sides of d.
+func (d *Disk) Sides() int {
+	return len(d.sides)
+}
+
+// Side returns the filesystem on side n of d, where side 0 is A:, or nil if d
+// has no side n.
+func (d *Disk) Side(n int) *FileSystem {
+	if n < 0 || n >= len(d.sides) {
+		return nil
+	}
+	return d.sides[n]
+}
+
+// Blocks returns the number of blocks on f.
+func (f *FileSystem) Blocks() int {
+	return f.nblocks
+}
+
+// ReadBlocks returns the contents of cnt blocks of f starting at block start.
+// Blocks are numbered from the start of f, not of the image.
+func (f *FileSystem) ReadBlocks(start, cnt int) ([]uint16, error) {
+	return f.getBlocks(start, cnt)
+}
+
+// WriteBlocks writes words, which must be a multiple of 256 words, to the
+// blocks of f starting at block start.  As with all changes, the blocks are
+// not written to the image until the disk is synced or closed.
+func (f *FileSystem) WriteBlocks(start int, words []uint16) error {
+	return f.writeBlocks(start, words)
+}
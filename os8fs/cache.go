@@ -0,0 +1,306 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A blockStore reads and writes blocks of an image.  Block numbers are
+// absolute 512 byte blocks in the image.
+type blockStore interface {
+	read(start, cnt int) ([]uint16, error)
+	write(start int, words []uint16) error
+	size() int64
+}
+
+// A blockCache sits between the filesystems on a disk and the image file.
+// Reads are passed through to the image with any pending changes overlaid.
+// Writes are held in memory until flush is called.  Block numbers are
+// absolute 512 byte blocks in the image, not blocks within a side.
+type blockCache struct {
+	mu    sync.Mutex
+	fd    Storage
+	rw    bool             // image was opened read/write
+	dirty map[int][]uint16 // modified blocks not yet written to fd
+}
+
+func newBlockCache(fd Storage, rw bool) *blockCache {
+	return &blockCache{
+		fd:    fd,
+		rw:    rw,
+		dirty: map[int][]uint16{},
+	}
+}
+
+// read returns cnt blocks starting at block start.
+func (c *blockCache) read(start, cnt int) ([]uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make([]byte, cnt*512)
+	n, err := c.fd.ReadAt(data, int64(start)*512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n < cnt*512 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	words := raw2words(data)
+	for b := start; b < start+cnt; b++ {
+		if d := c.dirty[b]; d != nil {
+			copy(words[(b-start)*256:], d)
+		}
+	}
+	return words, nil
+}
+
+// write records words, which must be a multiple of 256 words, as the new
+// contents of the blocks starting at block start.  Nothing is written to the
+// image until flush is called.  Blocks past the end of the image may not be
+// written.
+func (c *blockCache) write(start int, words []uint16) error {
+	if !c.rw {
+		return ErrReadOnly
+	}
+	if end := int64(start)*512 + int64(len(words))*2; start < 0 || end > c.fd.Size() {
+		return fmt.Errorf("write past end of image (%d > %d)", end, c.fd.Size())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < len(words); i += 256 {
+		b := make([]uint16, 256)
+		copy(b, words[i:i+256])
+		c.dirty[start+i/256] = b
+	}
+	return nil
+}
+
+// size returns the size of the image in bytes.
+func (c *blockCache) size() int64 {
+	return c.fd.Size()
+}
+
+// modified returns the sorted list of blocks with pending changes.
+func (c *blockCache) modified() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocks()
+}
+
+func (c *blockCache) blocks() []int {
+	blocks := make([]int, 0, len(c.dirty))
+	for b := range c.dirty {
+		blocks = append(blocks, b)
+	}
+	sort.Ints(blocks)
+	return blocks
+}
+
+// discard throws away all pending changes.
+func (c *blockCache) discard() {
+	c.mu.Lock()
+	c.dirty = map[int][]uint16{}
+	c.mu.Unlock()
+}
+
+// flush writes all pending changes to the image.  Runs of contiguous blocks
+// are written with a single write.  If atomic is true, and the image is a
+// regular file, then the changes are written to a copy of the image which
+// then is renamed over path.  The original image is never modified in place.
+func (c *blockCache) flush(path string, atomic bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.dirty) == 0 {
+		return nil
+	}
+	if fd, ok := c.fd.(*fileStorage); atomic && ok {
+		if err := c.flushCopy(fd.File, path, c.dirty); err != nil {
+			return err
+		}
+	} else {
+		w, ok := c.fd.(io.WriterAt)
+		if !ok {
+			return ErrReadOnly
+		}
+		if err := writeBlocks(w, c.dirty); err != nil {
+			return err
+		}
+		if s, ok := c.fd.(syncer); ok {
+			if err := s.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	c.dirty = map[int][]uint16{}
+	return nil
+}
+
+// writeBlocks writes blocks, which maps block numbers to their contents, to
+// w.  Runs of contiguous blocks are written with a single write.
+func writeBlocks(w io.WriterAt, blocks map[int][]uint16) error {
+	nums := make([]int, 0, len(blocks))
+	for b := range blocks {
+		nums = append(nums, b)
+	}
+	sort.Ints(nums)
+	for len(nums) > 0 {
+		n := 1
+		for n < len(nums) && nums[n] == nums[0]+n {
+			n++
+		}
+		words := make([]uint16, 0, n*256)
+		for _, b := range nums[:n] {
+			words = append(words, blocks[b]...)
+		}
+		if _, err := w.WriteAt(words2raw(words), int64(nums[0])*512); err != nil {
+			return fmt.Errorf("writing block %d: %v", nums[0], err)
+		}
+		nums = nums[n:]
+	}
+	return nil
+}
+
+// commit writes blocks, which maps block numbers to their new contents, to
+// the image as a single change.  Other pending changes are not written and
+// remain pending.  If the image is a regular file then the blocks are written
+// to a copy of the image which then is renamed over path.  Otherwise, if
+// writing fails, the blocks already written are restored.
+func (c *blockCache) commit(path string, blocks map[int][]uint16) error {
+	if !c.rw {
+		return ErrReadOnly
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(blocks) == 0 {
+		return nil
+	}
+	for b := range blocks {
+		if end := int64(b+1) * 512; b < 0 || end > c.fd.Size() {
+			return fmt.Errorf("write past end of image (%d > %d)", end, c.fd.Size())
+		}
+	}
+	if fd, ok := c.fd.(*fileStorage); ok {
+		if err := c.flushCopy(fd.File, path, blocks); err != nil {
+			return err
+		}
+	} else {
+		w, ok := c.fd.(io.WriterAt)
+		if !ok {
+			return ErrReadOnly
+		}
+		old := map[int][]uint16{}
+		for b := range blocks {
+			data := make([]byte, 512)
+			if _, err := c.fd.ReadAt(data, int64(b)*512); err != nil && err != io.EOF {
+				return err
+			}
+			old[b] = raw2words(data)
+		}
+		if err := writeBlocks(w, blocks); err != nil {
+			writeBlocks(w, old)
+			return err
+		}
+		if s, ok := c.fd.(syncer); ok {
+			if err := s.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	// The committed blocks replace any pending changes to them.
+	for b := range blocks {
+		delete(c.dirty, b)
+	}
+	return nil
+}
+
+// flushCopy writes a copy of the image fd, modified by blocks, to a temporary
+// file in the same directory as path and then renames it to path.  If path is a symbolic
+// link, the file it refers to is replaced, not the link.  On success c.fd
+// refers to the new image.
+func (c *blockCache) flushCopy(fd *os.File, path string, blocks map[int][]uint16) (err error) {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = io.Copy(tmp, io.NewSectionReader(fd, 0, fi.Size())); err != nil {
+		return err
+	}
+	if err = writeBlocks(tmp, blocks); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// tmp now is the image.  It was opened read/write, which is what we
+	// want as only read/write images have changes to flush.
+	fd.Close()
+	c.fd = &fileStorage{File: tmp, size: fi.Size()}
+	return nil
+}
+
+// contents returns the entire image with any pending changes applied.
+func (c *blockCache) contents() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make([]byte, c.fd.Size())
+	n, err := c.fd.ReadAt(data, 0)
+	if err != nil && (err != io.EOF || n < len(data)) {
+		return nil, err
+	}
+	for b, words := range c.dirty {
+		copy(data[b*512:], words2raw(words))
+	}
+	return data, nil
+}
+
+// upgrade replaces the read-only image of c with fd, the same image opened
+// read/write.
+func (c *blockCache) upgrade(fd Storage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.fd
+	c.fd, c.rw = fd, true
+	if cl, ok := old.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// close closes the underlying image if it is an io.Closer.  Pending changes
+// are discarded.
+func (c *blockCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty = nil
+	if cl, ok := c.fd.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheSync(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		path := newImage(t, 64)
+		before, _ := os.ReadFile(path)
+		d, err := OpenImage(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Atomic = atomic
+		if err := d.Create("FOO.BN", words(0, 512)); err != nil {
+			t.Fatal(err)
+		}
+		if m := d.Modified(); len(m) != 3 || m[0] != 1 {
+			t.Errorf("atomic=%v: Modified got %v, want 3 blocks starting at 1", atomic, m)
+		}
+		if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+			t.Errorf("atomic=%v: image changed before Sync", atomic)
+		}
+		if err := d.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if m := d.Modified(); len(m) != 0 {
+			t.Errorf("atomic=%v: Modified after Sync got %v", atomic, m)
+		}
+		if after, _ := os.ReadFile(path); bytes.Equal(before, after) {
+			t.Errorf("atomic=%v: image not changed by Sync", atomic)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f, err := GetFile(path + "/FOO.BN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalWords(f.Words(), words(0, 512)) {
+			t.Errorf("atomic=%v: FOO.BN has the wrong contents", atomic)
+		}
+	}
+}
+
+func TestCacheDiscard(t *testing.T) {
+	path := newImage(t, 64)
+	d, err := OpenImage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("FOO.BN", words(0, 256)); err != nil {
+		t.Fatal(err)
+	}
+	d.Discard()
+	if _, err := d.File("FOO.BN"); err == nil {
+		t.Error("FOO.BN exists after Discard")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheReadOnly(t *testing.T) {
+	d, err := OpenImage(newImage(t, 64), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Create("FOO.BN", words(0, 256)); err != ErrReadOnly {
+		t.Errorf("Create got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestCacheWritePastEnd(t *testing.T) {
+	d, err := OpenImage(newImage(t, 64), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.cache.write(64, words(0, 256)); err == nil {
+		t.Error("write past the end of the image succeeded")
+	}
+	if err := d.cache.write(63, words(0, 512)); err == nil {
+		t.Error("write running past the end of the image succeeded")
+	}
+	if err := d.cache.write(63, words(0, 256)); err != nil {
+		t.Errorf("write of the last block: %v", err)
+	}
+	data, err := d.cache.contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 64*512 || data[63*512+2] != 1 {
+		t.Errorf("contents does not hold the last block")
+	}
+}
+
+func TestCacheAtomicSymlink(t *testing.T) {
+	path := newImage(t, 64)
+	link := filepath.Join(t.TempDir(), "link.img")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skip(err)
+	}
+	d, err := OpenImage(link, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Atomic = true
+	if err := d.Create("FOO.BN", words(0, 256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s was replaced by a plain file", link)
+	}
+	if _, err := GetFile(path + "/FOO.BN"); err != nil {
+		t.Errorf("target of link not updated: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// A sentinel is an error that also matches a standard error.
+type sentinel struct {
+	msg string
+	std error
+}
+
+func (e *sentinel) Error() string { return e.msg }
+func (e *sentinel) Unwrap() error { return e.std }
+
+var (
+	// ErrNotExist is returned, wrapped with the name of the file, when a
+	// file does not exist.  errors.Is(err, fs.ErrNotExist) also reports
+	// true for these errors.
+	ErrNotExist error = &sentinel{"file not found", fs.ErrNotExist}
+
+	// ErrExist is returned, wrapped with the name of the file, when
+	// attempting to create a file that already exists.
+	// errors.Is(err, fs.ErrExist) also reports true for these errors.
+	ErrExist error = &sentinel{"file exists", fs.ErrExist}
+
+	// ErrNoSide is returned, wrapped with the name of the file, when a name
+	// has a side prefix (e.g., C:) for a side the disk does not have.
+	ErrNoSide = errors.New("side not found")
+)
+
+// notExist returns ErrNotExist wrapped with name.
+func notExist(name string) error {
+	return fmt.Errorf("%w: %s", ErrNotExist, name)
+}
+
+// noSide returns ErrNoSide wrapped with name.
+func noSide(name string) error {
+	return fmt.Errorf("%w: %s", ErrNoSide, name)
+}
+
+// A CorruptError is returned when a directory block is not valid.
+type CorruptError struct {
+	Block  int    // Block number of the directory block
+	Offset int    // Offset of the bad word in the block
+	Reason string // What is wrong
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt directory block %d, word %d: %s", e.Block, e.Offset, e.Reason)
+}
+
+// A NameError is returned by ParseName when a name is not a valid OS/8
+// filename.
+type NameError struct {
+	Name   string // The name as given
+	Reason string // What is wrong
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("invalid filename %q: %s", e.Name, e.Reason)
+}
+
+// A TruncatedError is returned when an image is smaller than its drive type
+// requires or a block is read past the end of the image.
+type TruncatedError struct {
+	Path string // Path to the image, if known
+	Size int64  // Actual size of the image in bytes
+	Want int64  // Minimum required size in bytes
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("truncated image (%d < %d)", e.Size, e.Want)
+	}
+	return fmt.Sprintf("truncated image (%d < %d): %s", e.Size, e.Want, e.Path)
+}
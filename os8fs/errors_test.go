@@ -0,0 +1,41 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	d := memDisk(t, 64)
+	d.Create("FOO.PA", words(0, 10))
+
+	_, err := d.File("BAR.PA")
+	if !errors.Is(err, ErrNotExist) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("File of missing file got %v", err)
+	}
+	if err := d.Create("FOO.PA", nil); !errors.Is(err, ErrExist) || !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Create of existing file got %v", err)
+	}
+	if _, err := d.File("B:FOO.PA"); !errors.Is(err, ErrNoSide) {
+		t.Errorf("File on missing side got %v, want %v", err, ErrNoSide)
+	}
+	var nerr *NameError
+	if err := d.Create("TOOLONGNAME.PA", nil); !errors.As(err, &nerr) {
+		t.Errorf("Create with a bad name got %v, want a NameError", err)
+	}
+
+	// Claim more entries than a directory block may hold.
+	w, _ := d.Side(0).getBlocks(1, 1)
+	w[0] = 010000 - 50
+	d.Side(0).writeBlocks(1, w)
+	var cerr *CorruptError
+	if _, err := d.List(); !errors.As(err, &cerr) || cerr.Block != 1 {
+		t.Errorf("List of corrupt directory got %v", err)
+	}
+}
@@ -96,6 +96,7 @@
 package os8fs
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -115,9 +116,9 @@ var DefaultImage = os.Getenv("PDP8_IMAGE")
 
 // A FileSystem represents a single OS/8 filesystem as found on a PDP-8 disk.
 type FileSystem struct {
-	fd      *os.File // file descriptor of open image
-	block0  int      // offset to block0 of the image
-	nblocks int      // number of 256 word blocks on filesystem
+	store   blockStore // where blocks are read from and written to
+	block0  int        // offset to block0 of the image
+	nblocks int        // number of 256 word blocks on filesystem
 }
 
 // A FileInfo contains metadata about a single file in an OS/8 filesystem.
@@ -176,6 +177,26 @@ func (f *File) ASCII(strip bool) []byte {
 	return nil
 }
 
+// Text returns the contents of f as host text.  The contents are decoded as by
+// ASCII(true) but stop at the first ^Z (end of file), each CR/LF is converted
+// to LF, and NUL bytes are removed.
+func (f *File) Text() []byte {
+	ascii := f.ASCII(true)
+	if x := bytes.IndexByte(ascii, 032); x >= 0 {
+		ascii = ascii[:x]
+	}
+	text := make([]byte, 0, len(ascii))
+	for i, c := range ascii {
+		switch {
+		case c == 0:
+		case c == '\r' && i+1 < len(ascii) && ascii[i+1] == '\n':
+		default:
+			text = append(text, c)
+		}
+	}
+	return text
+}
+
 // ASCII6 returns the contents of f as 6 bit ASCII encoded as 2 bytes per word.
 func (f *File) ASCII6() []byte {
 	words := f.Words()
@@ -194,10 +215,18 @@ func (f *File) ASCII6() []byte {
 }
 
 // A Disk represents a single disk with one or more filesystems.
+//
+// Changes made to a disk are buffered in memory and are not written to the
+// image until Sync or Close is called.
 type Disk struct {
-	path  string   // location of source file
-	fd    *os.File // actual image
-	drive Drive    // Drive information
+	// Atomic, if true, causes changes to be written to a copy of the image
+	// which is then renamed over the original image.  The image is never
+	// left partially modified, even if the program crashes while writing.
+	Atomic bool
+
+	path  string      // location of source file
+	cache *blockCache // actual image
+	drive Drive       // Drive information
 	sides []*FileSystem
 }
 
@@ -209,6 +238,9 @@ type Disk struct {
 // by using A: or B: as a prefix to the name.  A missing side prefix is taken as
 // the first side.
 //
+// If path has the extension .ovl then it is opened as an overlay (see
+// CreateOverlay) and the disk type is determined by the overlay's base image.
+//
 // If no extension is provided, or the extension is unknown, path is assumed to
 // contain a single OS/8 filesystem.
 //
@@ -220,15 +252,25 @@ func OpenImage(path string, rw bool) (*Disk, error) {
 			return nil, ErrNotPath
 		}
 	}
+	return driveFor(path).OpenImage(path, rw)
+}
+
+// driveFor returns the type of drive for the image path.
+func driveFor(path string) Drive {
 	switch strings.ToUpper(filepath.Ext(path)) {
 	case ".RK05":
-		return RK05.OpenImage(path, rw)
+		return RK05
 	case ".RX01":
-		return RX01.OpenImage(path, rw)
+		return RX01
 	case ".RX02":
-		return RX02.OpenImage(path, rw)
+		return RX02
+	case ".OVL":
+		if base, err := overlayBase(path); err == nil {
+			return driveFor(base)
+		}
+		return Generic
 	default:
-		return Generic.OpenImage(path, rw)
+		return Generic
 	}
 }
 
@@ -236,6 +278,10 @@ func OpenImage(path string, rw bool) (*Disk, error) {
 // both a drive image and file name.
 var ErrNotPath = errors.New("no path to drive")
 
+// ErrReadOnly is returned when attempting to modify an image that was not
+// opened read/write.
+var ErrReadOnly = errors.New("image is read only")
+
 // GetFile returns the file named by the base name of path on the disk image
 // specified by the directory part of path.  E.g. os8.rk05/A:INIT.TX refers to
 // the file named INIT.TX on the first side of the disk image os8.rk05.  The
@@ -247,16 +293,7 @@ func GetFile(path string) (*File, error) {
 		}
 		path = filepath.Join(DefaultImage, path)
 	}
-	switch strings.ToUpper(filepath.Ext(filepath.Dir(path))) {
-	case ".RK05":
-		return RK05.GetFile(path)
-	case ".RX01":
-		return RX01.GetFile(path)
-	case ".RX02":
-		return RX02.GetFile(path)
-	default:
-		return Generic.GetFile(path)
-	}
+	return driveFor(filepath.Dir(path)).GetFile(path)
 }
 
 // GetFile is like the function GetFile but the disk type is specified by d.
@@ -282,7 +319,9 @@ var (
 )
 
 // OpenImage opens path as a disk drive of type d returning either the opened
-// disk or an error.  If rw is true, open the image read/write.
+// disk or an error.  If rw is true, open the image read/write.  Opening an
+// image that is already open returns the same disk, upgraded to read/write
+// if rw is true.
 func (d Drive) OpenImage(path string, rw bool) (_ *Disk, err error) {
 	if path == "" {
 		path = DefaultImage
@@ -297,79 +336,136 @@ func (d Drive) OpenImage(path string, rw bool) (_ *Disk, err error) {
 	driveMu.Lock()
 	defer driveMu.Unlock()
 	if disk := drives[path]; disk != nil {
+		if rw && !disk.cache.rw {
+			st, err := openImageFile(path, rw)
+			if err != nil {
+				return nil, err
+			}
+			if err := disk.cache.upgrade(st); err != nil {
+				return nil, err
+			}
+		}
 		return disk, nil
 	}
-	var fd *os.File
-	if rw {
-		fd, err = os.OpenFile(path, os.O_RDWR, 0)
-	} else {
-		fd, err = os.Open(path)
-	}
+	st, err := openImageFile(path, rw)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			fd.Close()
-		}
-	}()
-	fi, err := fd.Stat()
+	disk, err := d.newDisk(st, path, rw)
 	if err != nil {
+		if c, ok := st.(io.Closer); ok {
+			c.Close()
+		}
 		return nil, err
 	}
+	drives[path] = disk
+	return disk, nil
+}
+
+// OpenImageFrom returns the disk of type d whose image is held in st.  The
+// disk may only be modified if st implements io.WriterAt.  Images opened by
+// OpenImageFrom are not cached.
+func OpenImageFrom(st Storage, d Drive) (*Disk, error) {
+	_, rw := st.(io.WriterAt)
+	return d.newDisk(st, "", rw)
+}
+
+// newDisk returns a disk of type d whose image is held in st.  The path is only
+// used in error messages and is the name used when syncing an Atomic disk.
+func (d Drive) newDisk(st Storage, path string, rw bool) (*Disk, error) {
+	size := st.Size()
 	if d.Sides == 0 {
 		d.Sides = 1
 	}
 	if d.Bytes == 0 {
-		d.Bytes = int(fi.Size()) / d.Sides
+		d.Bytes = int(size) / d.Sides
 	}
-	if d.Bytes > int(fi.Size()) {
-		return nil, fmt.Errorf("truncated image (%d < %d): %s", fi.Size(), d.Bytes, path)
+	if d.Bytes > int(size) {
+		return nil, &TruncatedError{Path: path, Size: size, Want: int64(d.Bytes)}
 	}
 
 	// We have at least one side, see how many sides are in the file
-	for d.Bytes*d.Sides < int(fi.Size()) {
+	for d.Bytes*d.Sides > int(size) {
 		d.Sides--
 	}
 	data := make([]byte, d.Bytes*d.Sides)
-	if _, err := io.ReadFull(fd, data); err != nil {
+	if _, err := io.ReadFull(io.NewSectionReader(st, 0, size), data); err != nil {
 		return nil, err
 	}
 
 	disk := Disk{
 		path:  path,
 		drive: d,
-		fd:    fd,
+		cache: newBlockCache(st, rw),
 		sides: make([]*FileSystem, d.Sides),
 	}
 	for s := range disk.sides {
 		disk.sides[s] = &FileSystem{
-			fd:      fd,
+			store:   disk.cache,
 			block0:  s * d.Bytes >> 9,
 			nblocks: d.Bytes >> 9,
 		}
 	}
-	drives[path] = &disk
 	return &disk, nil
 }
 
+// Sync writes any pending changes to the image.
+func (d *Disk) Sync() error {
+	return d.cache.flush(d.path, d.Atomic)
+}
+
+// Modified returns the list of image blocks, in ascending order, that have
+// changes that have not yet been written out.  Block numbers are relative
+// to the start of the image, not to a side.
+func (d *Disk) Modified() []int {
+	return d.cache.modified()
+}
+
+// Discard throws away any changes to d that have not yet been written out.
+func (d *Disk) Discard() {
+	d.cache.discard()
+}
+
+// WriteTo writes the entire image of d, including any changes that have not
+// yet been written out, to w.
+func (d *Disk) WriteTo(w io.Writer) (int64, error) {
+	data, err := d.cache.contents()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Close writes any pending changes to the image and then closes it.
 func (d *Disk) Close() error {
-	driveMu.Lock()
-	delete(drives, d.path)
-	driveMu.Unlock()
-	// TODO: write out any changes.
-	return nil
+	if d.path != "" {
+		driveMu.Lock()
+		delete(drives, d.path)
+		driveMu.Unlock()
+	}
+	err := d.Sync()
+	if cerr := d.cache.close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 func (d *Disk) getFS(name string) (*FileSystem, string) {
+	return getFS(d.sides, name)
+}
+
+// getFS returns the filesystem from sides named by the side prefix of name,
+// if any, and name without the prefix.
+func getFS(sides []*FileSystem, name string) (*FileSystem, string) {
 	if len(name) > 2 && name[1] == ':' {
 		n := (int(name[0]) | 040) - 'a'
-		if n < 0 || n > len(d.sides) {
+		if n < 0 || n >= len(sides) {
 			return nil, name
 		}
-		return d.sides[n], name[2:]
+		return sides[n], name[2:]
 	}
-	return d.sides[0], name
+	return sides[0], name
 }
 
 // File returns information about the specified file on d, or an error.  A
@@ -377,7 +473,7 @@ func (d *Disk) getFS(name string) (*FileSystem, string) {
 func (d *Disk) File(name string) (*File, error) {
 	fs, name := d.getFS(name)
 	if fs == nil {
-		return nil, fmt.Errorf("side not found: %s", name)
+		return nil, noSide(name)
 	}
 	return fs.File(name)
 }
@@ -392,15 +488,17 @@ func (f *FileSystem) getBlocks(start, cnt int) ([]uint16, error) {
 	if start+cnt > f.nblocks {
 		return nil, fmt.Errorf("getBlocks: block out of range(%d > %d", start+cnt, f.nblocks)
 	}
-	data := make([]byte, cnt*512)
-	n, err := f.fd.ReadAt(data, int64(f.block0+start)*512)
-	if err != nil {
-		return nil, fmt.Errorf("getBlocks(%d,%d): %v", f.block0+start, cnt, err)
+	words, err := f.store.read(f.block0+start, cnt)
+	if err == io.ErrUnexpectedEOF {
+		return nil, &TruncatedError{
+			Size: f.store.size(),
+			Want: int64(f.block0+start+cnt) * 512,
+		}
 	}
-	if n < cnt*512 {
-		return nil, fmt.Errorf("getBlocks: truncated read")
+	if err != nil {
+		return nil, fmt.Errorf("getBlocks(%d,%d): %w", f.block0+start, cnt, err)
 	}
-	return raw2words(data), nil
+	return words, nil
 }
 
 func (f *FileSystem) writeBlocks(start int, words []uint16) error {
@@ -417,15 +515,14 @@ func (f *FileSystem) writeBlocks(start int, words []uint16) error {
 	if start+cnt > f.nblocks {
 		return fmt.Errorf("writeBlocks: block out of range(%d > %d", start+cnt, f.nblocks)
 	}
-	data := words2raw(words)
-	_, err := f.fd.WriteAt(data, int64(f.block0+start)*512)
-	return err
+	return f.store.write(f.block0+start, words)
 }
 
 // File returns information about the specified file on f, or an error.  File
 // names starting with .block represent raw blocks of data in the file system.
 // There are two forms: .blockS and .blockS-E where S is the initial block
-// number and E is the ending block number.
+// number and E is the ending block number.  Other names are parsed with
+// ParseName, so foo.pa and FOO.PA name the same file.
 func (f *FileSystem) File(name string) (*File, error) {
 	if name == "" {
 		return nil, errors.New("missing filename")
@@ -458,7 +555,7 @@ func (f *FileSystem) File(name string) (*File, error) {
 		size := int(end - start + 1)
 		words, err := f.getBlocks(int(start), size)
 		if err != nil {
-			return nil, fmt.Errorf("%v: %s", err, name)
+			return nil, fmt.Errorf("%w: %s", err, name)
 		}
 		return &File{
 			fs:     f,
@@ -468,18 +565,22 @@ func (f *FileSystem) File(name string) (*File, error) {
 			words:  words,
 		}, nil
 	}
+	ename, err := ParseName(name)
+	if err != nil {
+		return nil, err
+	}
 	var file *File
 	if err := f.scan(func(sd *scanData) error {
-		if sd.file == nil || sd.file.Name() != name {
+		if sd.file == nil || sd.file.name != ename {
 			return nil
 		}
 		words, err := f.getBlocks(sd.block0, sd.size)
 		if err != nil {
-			return fmt.Errorf("%v: %s", err, name)
+			return fmt.Errorf("%w: %s", err, name)
 		}
 		file = &File{
 			fs:     f,
-			name:   name,
+			name:   sd.file.Name(),
 			date:   sd.file.date,
 			size:   sd.size,
 			loc:    sd.loc,
@@ -494,22 +595,26 @@ func (f *FileSystem) File(name string) (*File, error) {
 	if file != nil {
 		return file, nil
 	}
-	return nil, fmt.Errorf("file not found: %s", name)
+	return nil, notExist(name)
 }
 
 // List returns a list FileInfos for every file on d.  If d contains multiple
 // sides then file names will contain a drive prefix.
 func (d *Disk) List() ([]FileInfo, error) {
-	if len(d.sides) == 0 {
+	return list(d.sides)
+}
+
+func list(sides []*FileSystem) ([]FileInfo, error) {
+	if len(sides) == 0 {
 		return nil, nil
 	}
 	var cfis []FileInfo
-	for s, fs := range d.sides {
+	for s, fs := range sides {
 		fis, err := fs.List()
 		if err != nil {
 			return cfis, err
 		}
-		if len(d.sides) > 1 {
+		if len(sides) > 1 {
 			for i, fi := range fis {
 				fis[i].Name = fmt.Sprintf("%c:%s", s+'A', fi.Name)
 			}
@@ -569,8 +674,11 @@ func (f *FileSystem) scan(cb func(*scanData) error) (err error) {
 		block = (*dirBlock)(unsafe.Pointer(hdr.Data))
 
 		nfiles := int(010000 - block.nfiles)
-		if nfiles > 40 {
-			return fmt.Errorf("directory block %d: too many entries: %d", index, nfiles)
+		if nfiles > maxEntries {
+			return &CorruptError{
+				Block:  index,
+				Reason: fmt.Sprintf("too many entries: %d", nfiles),
+			}
 		}
 		block0 := int(block.block0)
 
@@ -605,7 +713,11 @@ func (f *FileSystem) scan(cb func(*scanData) error) (err error) {
 			hdr := (*reflect.SliceHeader)(unsafe.Pointer(&edata))
 			e := (*fileEntry)(unsafe.Pointer(hdr.Data))
 			if block0+e.Len() > f.nblocks {
-				return fmt.Errorf("corrupt directory, block out of range (%d)", block0+e.Len())
+				return &CorruptError{
+					Block:  index,
+					Offset: loc + 5,
+					Reason: fmt.Sprintf("block out of range (%d)", block0+e.Len()),
+				}
 			}
 			err := cb(&scanData{
 				index:  index,
@@ -638,25 +750,31 @@ func (f *FileSystem) scan(cb func(*scanData) error) (err error) {
 func (d *Disk) Remove(name string) error {
 	fs, name := d.getFS(name)
 	if fs == nil {
-		return fmt.Errorf("side not found: %s", name)
+		return noSide(name)
 	}
 	return fs.Remove(name)
 }
 
-// Remove removes the named file from f.
+// Remove removes the named file from f.  The space the file occupied is merged
+// with any free space next to it in the directory.
 // THIS IS EXPERIMENTAL!
 func (f *FileSystem) Remove(name string) error {
 	name = strings.ToUpper(name)
+	ename, err := ParseName(name)
+	if err != nil {
+		return err
+	}
 	found := false
 	var werr error
-	err := f.scan(func(sd *scanData) error {
-		if sd.file == nil || sd.file.Name() != name {
+	err = f.scan(func(sd *scanData) error {
+		if sd.file == nil || sd.file.name != ename {
 			return nil
 		}
 		found = true
 		sd.words[sd.loc] = 0
 		sd.words[sd.loc+1] = uint16(010000 - sd.size)
 		copy(sd.words[sd.loc+2:], sd.words[sd.loc+6:])
+		coalesce(sd.words)
 		werr = f.writeBlocks(sd.index, sd.words)
 		return stopReading
 	})
@@ -664,7 +782,7 @@ func (f *FileSystem) Remove(name string) error {
 		return err
 	}
 	if !found {
-		return fmt.Errorf("file not found: %s", name)
+		return notExist(name)
 	}
 	return werr
 }
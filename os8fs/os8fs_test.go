@@ -0,0 +1,96 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newImage writes a generic image of blocks blocks, holding an empty OS/8
+// filesystem, to a new file and returns its path.
+func newImage(t *testing.T, blocks int) string {
+	t.Helper()
+	d, err := NewMemoryImage(Drive{Bytes: blocks * 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.img")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.WriteTo(fd); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// words returns n words counting up from start.
+func words(start, n int) []uint16 {
+	w := make([]uint16, n)
+	for i := range w {
+		w[i] = uint16(start+i) & 07777
+	}
+	return w
+}
+
+// names returns the names of the files in fis.
+func names(fis []FileInfo) []string {
+	var n []string
+	for _, fi := range fis {
+		n = append(n, fi.Name)
+	}
+	return n
+}
+
+// sampleImage writes an image holding FOO.PA and BAR.SV to a new file and
+// returns its path.
+func sampleImage(t *testing.T) string {
+	t.Helper()
+	path := newImage(t, 64)
+	d, err := OpenImage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("FOO.PA", words(0, 300)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("BAR.SV", words(1, 256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// listNames returns the names of the files listed by d.
+func listNames(t *testing.T, d interface {
+	List() ([]FileInfo, error)
+}) []string {
+	t.Helper()
+	fis, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names(fis)
+}
+
+// imageNames returns the names of the files on the image at path.
+func imageNames(t *testing.T, path string) []string {
+	t.Helper()
+	d, err := OpenImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	return listNames(t, d)
+}
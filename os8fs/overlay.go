@@ -0,0 +1,288 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An overlay records changes to a base image without modifying the base image.
+// The overlay file starts with a 512 byte header:
+//
+//	PDP8 OVERLAY\n
+//	/path/to/base/image\n
+//	zero fill
+//
+// The header is followed by 516 byte records, each a 4 byte big endian block
+// number followed by the 512 bytes of the modified block.  Each block is
+// recorded at most once, rewriting a block updates its record in place.  An
+// incomplete record at the end of the file is ignored.
+type overlay struct {
+	base  *os.File
+	delta *os.File
+	size  int64         // size of the base image
+	index map[int]int64 // offset in delta of each modified block
+	end   int64         // offset in delta of the next record
+}
+
+const (
+	overlayMagic  = "PDP8 OVERLAY\n"
+	overlayHeader = 512
+	overlayRecord = 4 + 512
+)
+
+// CreateOverlay creates a new overlay named path for the disk image base.
+// Opening path with OpenImage, read/write, returns a disk that reads from base
+// but writes all changes to path.  base is never modified.  The name of an
+// overlay must have the extension .ovl.
+func CreateOverlay(base, path string) error {
+	if strings.ToUpper(filepath.Ext(path)) != ".OVL" {
+		return fmt.Errorf("overlay must have .ovl extension: %s", path)
+	}
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(base); err != nil {
+		return err
+	}
+	hdr := make([]byte, overlayHeader)
+	if copy(hdr, overlayMagic+base+"\n") < len(overlayMagic)+len(base)+1 {
+		return fmt.Errorf("base image name too long: %s", base)
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(hdr); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return err
+	}
+	return fd.Close()
+}
+
+// OverlayBlocks returns the blocks, in ascending order, of the base image that
+// have been modified by the overlay path.  Block numbers are 512 byte blocks
+// from the start of the image, not blocks within a side.
+func OverlayBlocks(path string) ([]int, error) {
+	o, err := openOverlay(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer o.Close()
+	return o.blocks(), nil
+}
+
+// MergeOverlay writes the changes recorded in the overlay path to its base
+// image and then removes the overlay.  The base image is updated atomically.
+func MergeOverlay(path string) error {
+	o, err := openOverlay(path, false)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+	fd, err := os.OpenFile(o.base.Name(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	c := newBlockCache(&fileStorage{File: fd, size: o.size}, true)
+	for _, b := range o.blocks() {
+		data := make([]byte, 512)
+		if _, err := o.delta.ReadAt(data, o.index[b]); err != nil {
+			c.close()
+			return err
+		}
+		c.dirty[b] = raw2words(data)
+	}
+	if err := c.flush(o.base.Name(), true); err != nil {
+		c.close()
+		return err
+	}
+	if err := c.close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// DiscardOverlay removes the overlay path, discarding all of its changes.
+func DiscardOverlay(path string) error {
+	if _, err := overlayBase(path); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// overlayBase returns the name of the base image of the overlay path.
+func overlayBase(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	return readOverlayHeader(fd, path)
+}
+
+func readOverlayHeader(fd *os.File, path string) (string, error) {
+	hdr := make([]byte, overlayHeader)
+	if _, err := fd.ReadAt(hdr, 0); err != nil || !bytes.HasPrefix(hdr, []byte(overlayMagic)) {
+		return "", fmt.Errorf("not an overlay: %s", path)
+	}
+	hdr = hdr[len(overlayMagic):]
+	x := bytes.IndexByte(hdr, '\n')
+	if x < 1 {
+		return "", fmt.Errorf("corrupt overlay header: %s", path)
+	}
+	base := string(hdr[:x])
+	if !filepath.IsAbs(base) {
+		base = filepath.Join(filepath.Dir(path), base)
+	}
+	return base, nil
+}
+
+// openOverlay opens the overlay path.  The base image is always opened read
+// only.  The overlay itself is opened read/write if rw is set.
+func openOverlay(path string, rw bool) (_ *overlay, err error) {
+	flag := os.O_RDONLY
+	if rw {
+		flag = os.O_RDWR
+	}
+	delta, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			delta.Close()
+		}
+	}()
+	bpath, err := readOverlayHeader(delta, path)
+	if err != nil {
+		return nil, err
+	}
+	base, err := os.Open(bpath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			base.Close()
+		}
+	}()
+	fi, err := base.Stat()
+	if err != nil {
+		return nil, err
+	}
+	o := &overlay{
+		base:  base,
+		delta: delta,
+		size:  fi.Size(),
+		index: map[int]int64{},
+	}
+	if fi, err = delta.Stat(); err != nil {
+		return nil, err
+	}
+	var hdr [4]byte
+	for o.end = overlayHeader; o.end+overlayRecord <= fi.Size(); o.end += overlayRecord {
+		if _, err := delta.ReadAt(hdr[:], o.end); err != nil {
+			return nil, err
+		}
+		o.index[int(binary.BigEndian.Uint32(hdr[:]))] = o.end + 4
+	}
+	return o, nil
+}
+
+// Size returns the size of the base image.
+func (o *overlay) Size() int64 {
+	return o.size
+}
+
+func (o *overlay) blocks() []int {
+	blocks := make([]int, 0, len(o.index))
+	for b := range o.index {
+		blocks = append(blocks, b)
+	}
+	sort.Ints(blocks)
+	return blocks
+}
+
+// ReadAt reads from the overlay if the block has been modified, otherwise
+// from the base image.
+func (o *overlay) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= o.size {
+			return n, io.EOF
+		}
+		b := int(pos / 512)
+		boff := pos % 512
+		cnt := 512 - boff
+		if r := int64(len(p) - n); cnt > r {
+			cnt = r
+		}
+		if r := o.size - pos; cnt > r {
+			cnt = r
+		}
+		var err error
+		if doff, ok := o.index[b]; ok {
+			_, err = o.delta.ReadAt(p[n:n+int(cnt)], doff+boff)
+		} else {
+			_, err = o.base.ReadAt(p[n:n+int(cnt)], pos)
+		}
+		if err != nil {
+			return n, err
+		}
+		n += int(cnt)
+	}
+	return n, nil
+}
+
+// WriteAt writes whole blocks to the overlay.
+func (o *overlay) WriteAt(p []byte, off int64) (int, error) {
+	if off%512 != 0 || len(p)%512 != 0 {
+		return 0, fmt.Errorf("overlay: unaligned write (%d bytes at %d)", len(p), off)
+	}
+	if off+int64(len(p)) > o.size {
+		return 0, fmt.Errorf("overlay: write past end of image (%d > %d)", off+int64(len(p)), o.size)
+	}
+	for i := 0; i < len(p); i += 512 {
+		b := int((off + int64(i)) / 512)
+		doff, ok := o.index[b]
+		if !ok {
+			var hdr [4]byte
+			binary.BigEndian.PutUint32(hdr[:], uint32(b))
+			if _, err := o.delta.WriteAt(hdr[:], o.end); err != nil {
+				return i, err
+			}
+			doff = o.end + 4
+		}
+		if _, err := o.delta.WriteAt(p[i:i+512], doff); err != nil {
+			return i, err
+		}
+		if !ok {
+			o.index[b] = doff
+			o.end += overlayRecord
+		}
+	}
+	return len(p), nil
+}
+
+func (o *overlay) Sync() error {
+	return o.delta.Sync()
+}
+
+func (o *overlay) Close() error {
+	o.base.Close()
+	return o.delta.Close()
+}
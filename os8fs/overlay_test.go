@@ -0,0 +1,156 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// overlayImage returns a sample base image and a new overlay of it.
+func overlayImage(t *testing.T) (base, ovl string) {
+	t.Helper()
+	base = sampleImage(t)
+	ovl = filepath.Join(t.TempDir(), "test.ovl")
+	if err := CreateOverlay(base, ovl); err != nil {
+		t.Fatal(err)
+	}
+	return base, ovl
+}
+
+// modify removes FOO.PA and creates NEW.TX through the overlay ovl.
+func modify(t *testing.T, ovl string) {
+	t.Helper()
+	d, err := OpenImage(ovl, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("FOO.PA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("NEW.TX", words(2, 256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	base, ovl := overlayImage(t)
+	orig, err := os.ReadFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := imageNames(t, ovl); !reflect.DeepEqual(got, []string{"FOO.PA", "BAR.SV"}) {
+		t.Errorf("new overlay got %v", got)
+	}
+	modify(t, ovl)
+
+	if data, _ := os.ReadFile(base); !bytes.Equal(data, orig) {
+		t.Error("base image was modified")
+	}
+	if got, want := imageNames(t, ovl), []string{"NEW.TX", "BAR.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("overlay got %v, want %v", got, want)
+	}
+	// The directory and the data block of NEW.TX are in the overlay.
+	blocks, err := OverlayBlocks(ovl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 7}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("OverlayBlocks got %v, want %v", blocks, want)
+	}
+	f, err := GetFile(ovl + "/NEW.TX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalWords(f.Words(), words(2, 256)) {
+		t.Error("NEW.TX has the wrong contents")
+	}
+	if _, err := GetFile(ovl + "/FOO.PA"); err == nil {
+		t.Error("found FOO.PA in the overlay")
+	}
+	if _, err := GetFile(base + "/FOO.PA"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOverlayMerge(t *testing.T) {
+	base, ovl := overlayImage(t)
+	modify(t, ovl)
+	want := imageNames(t, ovl)
+	if err := MergeOverlay(ovl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ovl); !os.IsNotExist(err) {
+		t.Errorf("overlay not removed: %v", err)
+	}
+	if got := imageNames(t, base); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged image got %v, want %v", got, want)
+	}
+}
+
+func TestOverlayDiscard(t *testing.T) {
+	base, ovl := overlayImage(t)
+	modify(t, ovl)
+	if err := DiscardOverlay(ovl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ovl); !os.IsNotExist(err) {
+		t.Errorf("overlay not removed: %v", err)
+	}
+	if got := imageNames(t, base); !reflect.DeepEqual(got, []string{"FOO.PA", "BAR.SV"}) {
+		t.Errorf("base image got %v", got)
+	}
+	// DiscardOverlay refuses to remove something that is not an overlay.
+	if err := DiscardOverlay(base); err == nil {
+		t.Error("DiscardOverlay removed a disk image")
+	}
+}
+
+func TestOverlayPartialRecord(t *testing.T) {
+	_, ovl := overlayImage(t)
+	modify(t, ovl)
+	want := imageNames(t, ovl)
+	fd, err := os.OpenFile(ovl, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write([]byte{0, 0, 0, 2, 1, 2, 3})
+	fd.Close()
+	if got := imageNames(t, ovl); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	blocks, err := OverlayBlocks(ovl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 7}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("OverlayBlocks got %v, want %v", blocks, want)
+	}
+}
+
+func TestCreateOverlayErrors(t *testing.T) {
+	base := newImage(t, 64)
+	dir := t.TempDir()
+	if err := CreateOverlay(base, filepath.Join(dir, "x.img")); err == nil {
+		t.Error("CreateOverlay accepted a name without .ovl")
+	}
+	if err := CreateOverlay(filepath.Join(dir, "missing"), filepath.Join(dir, "x.ovl")); err == nil {
+		t.Error("CreateOverlay accepted a missing base")
+	}
+	ovl := filepath.Join(dir, "y.ovl")
+	if err := CreateOverlay(base, ovl); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateOverlay(base, ovl); err == nil {
+		t.Error("CreateOverlay replaced an existing overlay")
+	}
+}
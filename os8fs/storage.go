@@ -0,0 +1,137 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage holds the contents of a disk image.  Size returns the size of the
+// image in bytes.  Storage that also implements io.WriterAt may be modified.
+// If Storage has a Sync method it is called after changes are written.  If
+// Storage implements io.Closer then it is closed when the Disk is closed.
+//
+// Both *bytes.Reader and *io.SectionReader implement Storage.
+type Storage interface {
+	io.ReaderAt
+	Size() int64
+}
+
+type syncer interface {
+	Sync() error
+}
+
+// fileStorage is Storage backed by an image file.
+type fileStorage struct {
+	*os.File
+	size int64
+}
+
+func (f *fileStorage) Size() int64 {
+	return f.size
+}
+
+// memStorage is Storage held in memory.
+type memStorage struct {
+	data []byte
+}
+
+func (m *memStorage) Size() int64 {
+	return int64(len(m.data))
+}
+
+func (m *memStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memStorage) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m.data)) {
+		return 0, fmt.Errorf("write past end of image (%d > %d)", off+int64(len(p)), len(m.data))
+	}
+	return copy(m.data[off:], p), nil
+}
+
+// NewMemoryImage returns a new disk of type d that is held in memory.  Each
+// side of the disk contains an empty OS/8 filesystem.  The size of d must be
+// known (Generic may not be used).  Use WriteTo to save the image.
+func NewMemoryImage(d Drive) (*Disk, error) {
+	if d.Bytes == 0 {
+		return nil, fmt.Errorf("drive size unknown")
+	}
+	if d.Sides == 0 {
+		d.Sides = 1
+	}
+	disk, err := OpenImageFrom(&memStorage{data: make([]byte, d.Bytes*d.Sides)}, d)
+	if err != nil {
+		return nil, err
+	}
+	for _, fs := range disk.sides {
+		if err := fs.zero(); err != nil {
+			return nil, err
+		}
+	}
+	return disk, nil
+}
+
+// zero writes an empty directory to f.  The directory occupies blocks 1
+// through 6 and the rest of the filesystem is free space.
+func (f *FileSystem) zero() error {
+	const block0 = 7
+	nblocks := f.nblocks
+	if nblocks > 07777 {
+		nblocks = 07777
+	}
+	if nblocks <= block0 {
+		return fmt.Errorf("filesystem too small (%d blocks)", f.nblocks)
+	}
+	words := make([]uint16, 256)
+	words[0] = 010000 - 1 // one entry
+	words[1] = block0     // first data block
+	words[2] = 0          // no next directory block
+	words[4] = 07777      // one additional word (the date) per entry
+	words[5] = 0          // free space
+	words[6] = uint16(010000 - (nblocks - block0))
+	return f.writeBlocks(1, words)
+}
+
+// openImageFile opens the image path.  Overlays are recognized by the
+// extension .ovl.
+func openImageFile(path string, rw bool) (Storage, error) {
+	if strings.ToUpper(filepath.Ext(path)) == ".OVL" {
+		o, err := openOverlay(path, rw)
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+	var fd *os.File
+	var err error
+	if rw {
+		fd, err = os.OpenFile(path, os.O_RDWR, 0)
+	} else {
+		fd, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &fileStorage{File: fd, size: fi.Size()}, nil
+}
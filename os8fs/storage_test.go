@@ -0,0 +1,148 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestNewMemoryImage(t *testing.T) {
+	d, err := NewMemoryImage(RK05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Sides() != 2 {
+		t.Fatalf("got %d sides, want 2", d.Sides())
+	}
+	if err := d.Create("B:FOO.PA", words(0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := d.Side(0).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 0 {
+		t.Errorf("side A got %v", fis)
+	}
+	fis, err = d.Side(1).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(fis); !reflect.DeepEqual(got, []string{"FOO.PA"}) {
+		t.Errorf("side B got %v", got)
+	}
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 2*RK05.Bytes {
+		t.Errorf("image is %d bytes, want %d", buf.Len(), 2*RK05.Bytes)
+	}
+	if _, err := NewMemoryImage(Generic); err == nil {
+		t.Error("NewMemoryImage accepted Generic")
+	}
+}
+
+func TestOpenImageFrom(t *testing.T) {
+	m, err := NewMemoryImage(Drive{Bytes: 64 * 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Create("FOO.PA", words(0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bytes.Reader is read-only storage.
+	d, err := OpenImageFrom(bytes.NewReader(buf.Bytes()), Generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(fis); !reflect.DeepEqual(got, []string{"FOO.PA"}) {
+		t.Errorf("got %v", got)
+	}
+	if err := d.Remove("FOO.PA"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Remove got %v, want %v", err, ErrReadOnly)
+	}
+
+	// A SectionReader may select the image from a larger file.
+	data := append(make([]byte, 1024), buf.Bytes()...)
+	sr := io.NewSectionReader(bytes.NewReader(data), 1024, int64(buf.Len()))
+	if d, err = OpenImageFrom(sr, Generic); err != nil {
+		t.Fatal(err)
+	}
+	if fis, err = d.List(); err != nil || len(fis) != 1 {
+		t.Errorf("got %v, %v", fis, err)
+	}
+}
+
+func TestOpenImageSides(t *testing.T) {
+	for _, tt := range []struct {
+		bytes int
+		sides int
+	}{
+		{2 * RK05.Bytes, 2},
+		{2*RK05.Bytes - 512, 1},
+		{RK05.Bytes, 1},
+	} {
+		st := bytes.NewReader(make([]byte, tt.bytes))
+		d, err := OpenImageFrom(st, RK05)
+		if err != nil {
+			t.Errorf("%d bytes: %v", tt.bytes, err)
+			continue
+		}
+		if d.Sides() != tt.sides {
+			t.Errorf("%d bytes: got %d sides, want %d", tt.bytes, d.Sides(), tt.sides)
+		}
+	}
+	var terr *TruncatedError
+	if _, err := OpenImageFrom(bytes.NewReader(make([]byte, 512)), RK05); !errors.As(err, &terr) {
+		t.Errorf("short image got %v, want a TruncatedError", err)
+	}
+}
+
+func TestOpenImageUpgrade(t *testing.T) {
+	path := newImage(t, 64)
+	ro, err := OpenImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.Create("FOO.PA", words(0, 10)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Create on read-only image got %v, want %v", err, ErrReadOnly)
+	}
+	rw, err := OpenImage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw != ro {
+		t.Error("OpenImage did not return the open disk")
+	}
+	if err := rw.Create("FOO.PA", words(0, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// Opening read-only again does not downgrade the disk.
+	if d, err := OpenImage(path, false); err != nil || d != rw {
+		t.Fatalf("got %p, %v, want %p", d, err, rw)
+	}
+	if err := rw.Remove("FOO.PA"); err != nil {
+		t.Error(err)
+	}
+}
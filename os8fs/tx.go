@@ -0,0 +1,256 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrTxDone is returned by operations on a Tx that has already been committed
+// or rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// A Tx is a set of changes to a Disk that are staged in memory.  The changes
+// are applied all at once by Commit or thrown away by Rollback.  Changes made
+// to the Disk outside of the Tx while the Tx is open may be overwritten when
+// the Tx is committed.
+type Tx struct {
+	// DryRun, if true, causes Commit to end the transaction without
+	// applying any changes.  Changes reports what would have been
+	// changed.
+	DryRun bool
+
+	d     *Disk
+	stage *txStore
+	sides []*FileSystem
+	done  bool
+}
+
+// A Change describes a single block modified by a Tx.
+type Change struct {
+	Side      int  // Side of the disk (0 is A:)
+	Block     int  // Block number on the side
+	Directory bool // Block is a directory block
+}
+
+func (c Change) String() string {
+	kind := "data"
+	if c.Directory {
+		kind = "directory"
+	}
+	return fmt.Sprintf("%c:%d (%s)", c.Side+'A', c.Block, kind)
+}
+
+// A txStore is a blockStore that stages writes in memory on top of base.
+type txStore struct {
+	base   blockStore
+	rw     bool
+	staged map[int][]uint16
+}
+
+func (s *txStore) read(start, cnt int) ([]uint16, error) {
+	words, err := s.base.read(start, cnt)
+	if err != nil {
+		return nil, err
+	}
+	for b := start; b < start+cnt; b++ {
+		if d := s.staged[b]; d != nil {
+			copy(words[(b-start)*256:], d)
+		}
+	}
+	return words, nil
+}
+
+func (s *txStore) size() int64 {
+	return s.base.size()
+}
+
+func (s *txStore) write(start int, words []uint16) error {
+	if !s.rw {
+		return ErrReadOnly
+	}
+	for i := 0; i < len(words); i += 256 {
+		b := make([]uint16, 256)
+		copy(b, words[i:i+256])
+		s.staged[start+i/256] = b
+	}
+	return nil
+}
+
+// Begin starts a new transaction on d.
+func (d *Disk) Begin() *Tx {
+	tx := &Tx{
+		d: d,
+		stage: &txStore{
+			base:   d.cache,
+			rw:     d.cache.rw,
+			staged: map[int][]uint16{},
+		},
+	}
+	for _, fs := range d.sides {
+		tx.sides = append(tx.sides, &FileSystem{
+			store:   tx.stage,
+			block0:  fs.block0,
+			nblocks: fs.nblocks,
+		})
+	}
+	return tx
+}
+
+// Create is like Disk.Create but the file is created as part of tx.
+func (tx *Tx) Create(name string, words []uint16) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	fs, name := getFS(tx.sides, name)
+	if fs == nil {
+		return noSide(name)
+	}
+	return fs.Create(name, words)
+}
+
+// Remove is like Disk.Remove but the file is removed as part of tx.
+func (tx *Tx) Remove(name string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	fs, name := getFS(tx.sides, name)
+	if fs == nil {
+		return noSide(name)
+	}
+	return fs.Remove(name)
+}
+
+// Rename is like Disk.Rename but the file is renamed as part of tx.
+func (tx *Tx) Rename(oldname, newname string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return rename(tx.sides, oldname, newname)
+}
+
+// Write is like Disk.Write but the file is written as part of tx.
+func (tx *Tx) Write(name string, words []uint16) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	fs, name := getFS(tx.sides, name)
+	if fs == nil {
+		return noSide(name)
+	}
+	return fs.Write(name, words)
+}
+
+// File is like Disk.File but includes the changes staged in tx.
+func (tx *Tx) File(name string) (*File, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	fs, name := getFS(tx.sides, name)
+	if fs == nil {
+		return nil, noSide(name)
+	}
+	return fs.File(name)
+}
+
+// List is like Disk.List but includes the changes staged in tx.
+func (tx *Tx) List() ([]FileInfo, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	return list(tx.sides)
+}
+
+// Changes returns the blocks that would be changed by committing tx, in
+// order.  Blocks that were written with their original contents are not
+// included.
+func (tx *Tx) Changes() ([]Change, error) {
+	blocks := make([]int, 0, len(tx.stage.staged))
+	for b := range tx.stage.staged {
+		blocks = append(blocks, b)
+	}
+	sort.Ints(blocks)
+
+	var changes []Change
+	dirs := map[int]map[int]bool{}
+	for _, b := range blocks {
+		old, err := tx.stage.base.read(b, 1)
+		if err != nil {
+			return nil, err
+		}
+		if equalWords(old, tx.stage.staged[b]) {
+			continue
+		}
+		c := Change{Side: -1, Block: b}
+		for s, fs := range tx.sides {
+			if b < fs.block0 || b >= fs.block0+fs.nblocks {
+				continue
+			}
+			if dirs[s] == nil {
+				dirs[s] = fs.dirBlocks()
+			}
+			c.Side = s
+			c.Block = b - fs.block0
+			c.Directory = dirs[s][c.Block]
+			break
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// Commit writes the changes in tx to the image all at once, whether or not
+// the disk is Atomic.  Changes made to the disk outside of tx that have not
+// been synced are not written.  If Commit fails the image is left unchanged.
+// If tx.DryRun is set then no changes are made.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if tx.DryRun {
+		return nil
+	}
+	return tx.d.cache.commit(tx.d.path, tx.stage.staged)
+}
+
+// Rollback discards all the changes in tx.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.stage.staged = nil
+	return nil
+}
+
+// dirBlocks returns the set of blocks in f's directory chain.
+func (f *FileSystem) dirBlocks() map[int]bool {
+	blocks := map[int]bool{}
+	for index := 1; index != 0 && !blocks[index]; {
+		words, err := f.getBlocks(index, 1)
+		if err != nil {
+			break
+		}
+		blocks[index] = true
+		index = int(words[2])
+	}
+	return blocks
+}
+
+func equalWords(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, w := range a {
+		if w != b[i] {
+			return false
+		}
+	}
+	return true
+}
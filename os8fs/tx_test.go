@@ -0,0 +1,241 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// txDisk returns the path of a sample image and the image opened read/write.
+func txDisk(t *testing.T) (string, *Disk) {
+	t.Helper()
+	path := sampleImage(t)
+	d, err := OpenImage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return path, d
+}
+
+// apply makes the same set of changes to tx.
+func apply(t *testing.T, tx *Tx) {
+	t.Helper()
+	if err := tx.Remove("FOO.PA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create("NEW.TX", words(2, 300)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rename("BAR.SV", "BAZ.SV"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Write("NEW.TX", []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxCommit(t *testing.T) {
+	path, d := txDisk(t)
+	tx := d.Begin()
+	apply(t, tx)
+
+	if got, want := listNames(t, tx), []string{"NEW.TX", "BAZ.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tx.List got %v, want %v", got, want)
+	}
+	if got, want := listNames(t, d), []string{"FOO.PA", "BAR.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("disk changed before commit: got %v, want %v", got, want)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("second Commit got %v, want %v", err, ErrTxDone)
+	}
+	d.Close()
+
+	d, err := OpenImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if got, want := listNames(t, d), []string{"NEW.TX", "BAZ.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after commit got %v, want %v", got, want)
+	}
+	f, err := d.File("NEW.TX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := f.Words(); len(w) != 256 || w[2] != 3 {
+		t.Errorf("NEW.TX has the wrong contents")
+	}
+}
+
+func TestTxCommitPending(t *testing.T) {
+	path, d := txDisk(t)
+	data := make([]uint16, 512)
+	if err := d.Write("FOO.PA", data); err != nil {
+		t.Fatal(err)
+	}
+	tx := d.Begin()
+	if err := tx.Rename("BAR.SV", "BAZ.SV"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// The change to FOO.PA is still pending and was not written by
+	// Commit.
+	if got, want := d.Modified(), []int{7, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("modified got %v, want %v", got, want)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := OpenImageFrom(bytes.NewReader(raw), Drive{Bytes: len(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listNames(t, img), []string{"FOO.PA", "BAZ.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("image got %v, want %v", got, want)
+	}
+	f, err := img.File("FOO.PA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := f.Words(); !reflect.DeepEqual(w[:300], words(0, 300)) {
+		t.Error("Commit wrote the pending change to FOO.PA")
+	}
+}
+
+// failStorage is Storage held in memory whose second write fails.
+type failStorage struct {
+	data   []byte
+	writes int
+}
+
+func (f *failStorage) Size() int64 {
+	return int64(len(f.data))
+}
+
+func (f *failStorage) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(f.data).ReadAt(p, off)
+}
+
+func (f *failStorage) WriteAt(p []byte, off int64) (int, error) {
+	if f.writes++; f.writes == 2 {
+		return 0, errors.New("write failed")
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func TestTxCommitFailure(t *testing.T) {
+	raw, err := os.ReadFile(sampleImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &failStorage{data: append([]byte{}, raw...)}
+	d, err := OpenImageFrom(st, Drive{Bytes: len(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The directory and FOO.PA are written separately.
+	tx := d.Begin()
+	apply(t, tx)
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit did not fail")
+	}
+	if !bytes.Equal(st.data, raw) {
+		t.Error("failed Commit changed the image")
+	}
+	if got := d.Modified(); len(got) != 0 {
+		t.Errorf("failed Commit left pending changes to %v", got)
+	}
+	if got, want := listNames(t, d), []string{"FOO.PA", "BAR.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after failed commit got %v, want %v", got, want)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	_, d := txDisk(t)
+	tx := d.Begin()
+	apply(t, tx)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.List(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("List after Rollback got %v, want %v", err, ErrTxDone)
+	}
+	if got, want := listNames(t, d), []string{"FOO.PA", "BAR.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rollback got %v, want %v", got, want)
+	}
+}
+
+func TestTxDryRun(t *testing.T) {
+	path, d := txDisk(t)
+	tx := d.Begin()
+	tx.DryRun = true
+	apply(t, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	d, err := OpenImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if got, want := listNames(t, d), []string{"FOO.PA", "BAR.SV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run changed the disk: got %v, want %v", got, want)
+	}
+}
+
+func TestTxChanges(t *testing.T) {
+	_, d := txDisk(t)
+	tx := d.Begin()
+	changes, err := tx.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("new tx has changes %v", changes)
+	}
+
+	// Rewriting a file with its own contents changes nothing.
+	if err := tx.Write("BAR.SV", words(1, 256)); err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ = tx.Changes(); len(changes) != 0 {
+		t.Errorf("rewrite has changes %v", changes)
+	}
+
+	// Renaming only changes the directory.
+	if err := tx.Rename("BAR.SV", "BAZ.SV"); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = tx.Changes()
+	if want := []Change{{Side: 0, Block: 1, Directory: true}}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("rename got %v, want %v", changes, want)
+	}
+
+	// FOO.PA is in blocks 7 and 8; only the first block changes.
+	data := make([]uint16, 512)
+	copy(data, words(0, 300))
+	data[0] = 07777
+	if err := tx.Write("FOO.PA", data); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = tx.Changes()
+	want := []Change{{Side: 0, Block: 1, Directory: true}, {Side: 0, Block: 7}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("write got %v, want %v", changes, want)
+	}
+}
@@ -8,6 +8,7 @@ package os8fs
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"unsafe"
 )
 
@@ -167,3 +168,92 @@ func ASCII8(dst []byte, src []uint16, m byte) {
 	dst[1] = byte(src[1]) & m
 	dst[2] = byte(((src[0]>>4)&0xf0)|((src[1]>>8)&0xf)) & m
 }
+
+// Pack8 writes the first 3 bytes of src as two words into dst.  It is the
+// inverse of ASCII8.
+func Pack8(dst []uint16, src []byte) {
+	dst[0] = uint16(src[0]) | uint16(src[2]&0xf0)<<4
+	dst[1] = uint16(src[1]) | uint16(src[2]&0x0f)<<8
+}
+
+// EncodeText returns text as the words of an OS/8 text file, 3 bytes per 2
+// words.  A LF not preceded by a CR is converted to CR/LF, the mark bit (0200)
+// is set on each character as OS/8 editors do, a ^Z is appended to mark the end
+// of the file, and the words are padded with zeros to a whole number of
+// blocks.
+func EncodeText(text []byte) []uint16 {
+	data := make([]byte, 0, len(text)+len(text)/32+3)
+	for i, c := range text {
+		if c == '\n' && (i == 0 || text[i-1] != '\r') {
+			data = append(data, '\r'|0200)
+		}
+		data = append(data, c|0200)
+	}
+	data = append(data, 032|0200)
+	for len(data)%3 != 0 {
+		data = append(data, 0)
+	}
+	nwords := len(data) / 3 * 2
+	words := make([]uint16, (nwords+255)/256*256)
+	for i := 0; i < len(data)/3; i++ {
+		Pack8(words[i*2:], data[i*3:])
+	}
+	return words
+}
+
+// EncodeASCII6 returns s as 6 bit ascii, 2 characters per word.  Lower case
+// letters are converted to upper case.  If s has an odd number of characters
+// the last word is padded with @ (0).  Only the characters space through
+// underscore (040-0137) can be encoded.
+func EncodeASCII6(s string) ([]uint16, error) {
+	words := make([]uint16, (len(s)+1)/2)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 040 || c > 0137 {
+			return nil, fmt.Errorf("cannot encode %q as 6 bit ascii", s[i])
+		}
+		words[i/2] |= uint16(c&077) << uint(6*(1-i%2))
+	}
+	return words, nil
+}
+
+// ParseName returns name as the four 6 bit ascii words of an OS/8 directory
+// entry.  Lower case letters are converted to upper case.  The name must be 1
+// to 6 letters or digits optionally followed by a . and an extension of up to
+// 2 letters or digits.  Unused characters are padded with @ (0).  A
+// *NameError is returned if name is not a valid OS/8 filename.
+func ParseName(name string) (words [4]uint16, err error) {
+	base, ext := name, ""
+	if x := strings.Index(name, "."); x >= 0 {
+		base, ext = name[:x], name[x+1:]
+	}
+	switch {
+	case base == "":
+		return words, &NameError{name, "missing name"}
+	case len(base) > 6:
+		return words, &NameError{name, "name longer than 6 characters"}
+	case strings.Contains(ext, "."):
+		return words, &NameError{name, "more than one ."}
+	case len(ext) > 2:
+		return words, &NameError{name, "extension longer than 2 characters"}
+	}
+	for _, c := range base + ext {
+		switch {
+		case c >= 'A' && c <= 'Z':
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9':
+		default:
+			return words, &NameError{name, fmt.Sprintf("invalid character %q", c)}
+		}
+	}
+	var six [8]byte // zero is @
+	copy(six[:], strings.ToUpper(base))
+	copy(six[6:], strings.ToUpper(ext))
+	for i, c := range six {
+		words[i/2] |= uint16(c&077) << uint(6*(1-i%2))
+	}
+	return words, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeText(t *testing.T) {
+	words := EncodeText([]byte("AB\n"))
+	if len(words) != 256 {
+		t.Fatalf("got %d words, want 256", len(words))
+	}
+	// A, B, CR and LF, ^Z, NUL with the mark bit set, 3 bytes per 2 words.
+	want := []uint16{04301, 06702, 00212, 00232}
+	for i, w := range want {
+		if words[i] != w {
+			t.Errorf("word %d got %04o, want %04o", i, words[i], w)
+		}
+	}
+	for i, w := range words[len(want):] {
+		if w != 0 {
+			t.Fatalf("word %d got %04o, want 0", i+len(want), w)
+		}
+	}
+	if got := len(EncodeText(make([]byte, 384))); got != 512 {
+		t.Errorf("384 characters and ^Z took %d words, want 512", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	d := memDisk(t, 64)
+	for _, text := range []string{
+		"",
+		"hello\n",
+		"line 1\r\nline 2\nno newline",
+		"\n\n\n",
+	} {
+		if err := d.Write("T.TX", EncodeText([]byte(text))); err != nil {
+			t.Fatal(err)
+		}
+		f, err := d.File("T.TX")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := text
+		if text == "line 1\r\nline 2\nno newline" {
+			want = "line 1\nline 2\nno newline"
+		}
+		if got := string(f.Text()); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPack8(t *testing.T) {
+	src := []byte{0xC1, 0xC2, 0x8D}
+	var words [2]uint16
+	Pack8(words[:], src)
+	var dst [3]byte
+	ASCII8(dst[:], words[:], 0xff)
+	if dst != [3]byte{0xC1, 0xC2, 0x8D} {
+		t.Errorf("got %x, want %x", dst, src)
+	}
+	ASCII8(dst[:], words[:], 0x7f)
+	if string(dst[:]) != "AB\r" {
+		t.Errorf("stripped got %q, want %q", dst, "AB\r")
+	}
+}
+
+func TestEncodeASCII6(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []uint16
+	}{
+		{"", []uint16{}},
+		{"A", []uint16{00100}},
+		{"ab", []uint16{00102}},
+		{"PDP-8", []uint16{02004, 02055, 07000}},
+		{" _", []uint16{04037}},
+	} {
+		got, err := EncodeASCII6(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %04o, want %04o", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"\t", "a{", "\x80"} {
+		if _, err := EncodeASCII6(in); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want [4]uint16
+	}{
+		{"A", [4]uint16{00100, 0, 0, 0}},
+		{"foo.pa", [4]uint16{00617, 01700, 0, 02001}},
+		{"ABCDEF.XY", [4]uint16{00102, 00304, 00506, 03031}},
+		{"X1.", [4]uint16{03061, 0, 0, 0}},
+		{"B.S", [4]uint16{00200, 0, 0, 02300}},
+	} {
+		got, err := ParseName(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %04o, want %04o", tt.in, got, tt.want)
+		}
+		if name := (fileEntry{name: got}).Name(); name != strings.ToUpper(strings.TrimSuffix(tt.in, ".")) {
+			t.Errorf("%q: Name got %q", tt.in, name)
+		}
+	}
+	for _, in := range []string{"", ".PA", "ABCDEFG", "A.B.C", "A.XYZ", "A-B", "A B", "A:B"} {
+		var nerr *NameError
+		if _, err := ParseName(in); !errors.As(err, &nerr) {
+			t.Errorf("%q: got %v, want a NameError", in, err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Create creates a new file named name on d containing words.  The filename
+// may be preceded by A: or B: to indicate which side of the disk should be
+// used.
+// THIS IS EXPERIMENTAL!
+func (d *Disk) Create(name string, words []uint16) error {
+	fs, name := d.getFS(name)
+	if fs == nil {
+		return noSide(name)
+	}
+	return fs.Create(name, words)
+}
+
+// Write replaces the contents of the named file on d with words, creating the
+// file if it does not exist.  The filename may be preceded by A: or B: to
+// indicate which side of the disk should be used.
+// THIS IS EXPERIMENTAL!
+func (d *Disk) Write(name string, words []uint16) error {
+	fs, name := d.getFS(name)
+	if fs == nil {
+		return noSide(name)
+	}
+	return fs.Write(name, words)
+}
+
+// Rename renames the file oldname on d to newname.  Both names must refer to
+// the same side of the disk.
+// THIS IS EXPERIMENTAL!
+func (d *Disk) Rename(oldname, newname string) error {
+	return rename(d.sides, oldname, newname)
+}
+
+func rename(sides []*FileSystem, oldname, newname string) error {
+	fs, oldname := getFS(sides, oldname)
+	if fs == nil {
+		return noSide(oldname)
+	}
+	nfs, newname := getFS(sides, newname)
+	if nfs != fs {
+		return fmt.Errorf("cannot rename across sides: %s", newname)
+	}
+	return fs.Rename(oldname, newname)
+}
+
+// dirEnd returns the index of the word following the last entry in the
+// directory block words.
+func dirEnd(words []uint16) int {
+	nfiles := int(010000 - words[0])
+	loc := 5
+	for i := 0; i < nfiles && loc < len(words); i++ {
+		if words[loc] == 0 {
+			loc += 2
+		} else {
+			loc += 6
+		}
+	}
+	return loc
+}
+
+// find returns the scanData of the file with the encoded name ename on f, or
+// nil if there is no such file.
+func (f *FileSystem) find(ename [4]uint16) (*scanData, error) {
+	var found *scanData
+	err := f.scan(func(sd *scanData) error {
+		if sd.file == nil || sd.file.name != ename {
+			return nil
+		}
+		found = sd
+		return stopReading
+	})
+	return found, err
+}
+
+// Create creates a new file named name on f containing words.  The file is
+// placed in the first free space large enough to hold it.  If the directory
+// block holding that space is full, a new directory segment is started.  The
+// last block of the file is padded with zeros.  An error is returned if the file already
+// exists or there is no room for it.
+// THIS IS EXPERIMENTAL!
+func (f *FileSystem) Create(name string, words []uint16) error {
+	name = strings.ToUpper(name)
+	ename, err := ParseName(name)
+	if err != nil {
+		return err
+	}
+	switch sd, err := f.find(ename); {
+	case err != nil:
+		return err
+	case sd != nil:
+		return fmt.Errorf("%w: %s", ErrExist, name)
+	}
+	size := (len(words) + 255) / 256
+	if size == 0 {
+		size = 1
+	}
+	for {
+		created, split := false, false
+		var werr error
+		err = f.scan(func(sd *scanData) error {
+			if sd.file != nil || sd.size < size {
+				return nil
+			}
+			// The free entry grows from 2 to 6 words.  If there is
+			// space left over then a new 2 word free entry follows
+			// the file.
+			grow := 4
+			if sd.size > size {
+				grow = 6
+			}
+			end := dirEnd(sd.words)
+			n := int(010000 - sd.words[0])
+			if end+grow > len(sd.words) || (grow == 6 && n >= maxEntries) {
+				// The directory block is full.  Move part of
+				// it to a new segment and look again.
+				switch werr = f.split(sd, end); werr {
+				case nil:
+					split = true
+					return stopReading
+				case errDirFull:
+					werr = nil
+					return skipBlock
+				}
+				return werr
+			}
+			w := sd.words
+			copy(w[sd.loc+2+grow:], w[sd.loc+2:end])
+			copy(w[sd.loc:], ename[:])
+			w[sd.loc+4] = 0
+			w[sd.loc+5] = uint16(010000 - size)
+			if grow == 6 {
+				w[sd.loc+6] = 0
+				w[sd.loc+7] = uint16(010000 - (sd.size - size))
+				w[0]--
+			}
+			data := make([]uint16, size*256)
+			copy(data, words)
+			if werr = f.writeBlocks(sd.block0, data); werr == nil {
+				werr = f.writeBlocks(sd.index, w)
+			}
+			created = true
+			return stopReading
+		})
+		switch {
+		case err != nil:
+			return err
+		case werr != nil:
+			return werr
+		case created:
+			return nil
+		case !split:
+			return fmt.Errorf("no room for %d blocks: %s", size, name)
+		}
+	}
+}
+
+// errDirFull is returned by split when all the directory segments are in use.
+var errDirFull = errors.New("directory full")
+
+// maxEntries is the most entries a directory block may hold.
+const maxEntries = 40
+
+// split makes room in the full directory block of sd, whose entries end at
+// end, as OS/8 does when a directory segment overflows.  The entries that
+// follow the free entry of sd, or the free entry itself if it is the last
+// entry, are moved to a new directory segment that is chained after the
+// block.  The directory occupies blocks 1 through 6, so there are at most 6
+// segments.  errDirFull is returned if they are all in use.
+func (f *FileSystem) split(sd *scanData, end int) error {
+	used := f.dirBlocks()
+	index := 0
+	for b := 2; b <= 6; b++ {
+		if !used[b] {
+			index = b
+			break
+		}
+	}
+	if index == 0 {
+		return errDirFull
+	}
+	at, block0 := sd.loc+2, sd.block0+sd.size
+	if at >= end {
+		at, block0 = sd.loc, sd.block0
+	}
+	n := 0
+	for loc := at; loc < end; n++ {
+		if sd.words[loc] == 0 {
+			loc += 2
+		} else {
+			loc += 6
+		}
+	}
+	w := make([]uint16, 256)
+	w[0] = uint16(010000 - n)
+	w[1] = uint16(block0)
+	w[2] = sd.words[2]
+	w[4] = sd.words[4]
+	copy(w[5:], sd.words[at:end])
+	if err := f.writeBlocks(index, w); err != nil {
+		return err
+	}
+	old := sd.words
+	old[0] += uint16(n)
+	old[2] = uint16(index)
+	for i := at; i < end; i++ {
+		old[i] = 0
+	}
+	return f.writeBlocks(sd.index, old)
+}
+
+// coalesce merges adjacent free entries in the directory block words into a
+// single entry, as OS/8 does, and removes empty free entries unless one is the
+// only entry in the block.
+func coalesce(words []uint16) {
+	nfiles := int(010000 - words[0])
+	entries := make([]uint16, 0, len(words))
+	free := -1 // index in entries of the free entry being extended
+	loc := 5
+	for i := 0; i < nfiles; i++ {
+		if words[loc] != 0 {
+			entries = append(entries, words[loc:loc+6]...)
+			free = -1
+			loc += 6
+			continue
+		}
+		size := (010000 - words[loc+1]) & 07777
+		switch {
+		case free >= 0:
+			merged := (010000 - entries[free+1]) & 07777
+			entries[free+1] = (010000 - (merged + size)) & 07777
+		case size == 0 && nfiles > 1:
+		default:
+			free = len(entries)
+			entries = append(entries, 0, words[loc+1])
+		}
+		loc += 2
+	}
+	n := 0
+	for loc := 0; loc < len(entries); n++ {
+		if entries[loc] == 0 {
+			loc += 2
+		} else {
+			loc += 6
+		}
+	}
+	words[0] = uint16(010000 - n)
+	copy(words[5:], entries)
+	for i := 5 + len(entries); i < len(words); i++ {
+		words[i] = 0
+	}
+}
+
+// Write replaces the contents of the named file on f with words, creating the
+// file if it does not exist.  If the size of the file changes then the file
+// may be moved.
+// THIS IS EXPERIMENTAL!
+func (f *FileSystem) Write(name string, words []uint16) error {
+	name = strings.ToUpper(name)
+	ename, err := ParseName(name)
+	if err != nil {
+		return err
+	}
+	sd, err := f.find(ename)
+	if err != nil {
+		return err
+	}
+	if sd == nil {
+		return f.Create(name, words)
+	}
+	size := (len(words) + 255) / 256
+	if size == 0 {
+		size = 1
+	}
+	if size == sd.size {
+		data := make([]uint16, size*256)
+		copy(data, words)
+		return f.writeBlocks(sd.block0, data)
+	}
+	// Remember the original directory block so the file can be restored
+	// if it cannot be recreated.
+	saved := append([]uint16(nil), sd.words...)
+	if err := f.Remove(name); err != nil {
+		return err
+	}
+	if err := f.Create(name, words); err != nil {
+		if rerr := f.writeBlocks(sd.index, saved); rerr != nil {
+			return fmt.Errorf("%v (restoring %s: %v)", err, name, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Rename renames the file oldname on f to newname.  It is an error if newname
+// already exists.
+// THIS IS EXPERIMENTAL!
+func (f *FileSystem) Rename(oldname, newname string) error {
+	oldname = strings.ToUpper(oldname)
+	newname = strings.ToUpper(newname)
+	oename, err := ParseName(oldname)
+	if err != nil {
+		return err
+	}
+	ename, err := ParseName(newname)
+	if err != nil {
+		return err
+	}
+	if oename != ename {
+		switch sd, err := f.find(ename); {
+		case err != nil:
+			return err
+		case sd != nil:
+			return fmt.Errorf("%w: %s", ErrExist, newname)
+		}
+	}
+	sd, err := f.find(oename)
+	if err != nil {
+		return err
+	}
+	if sd == nil {
+		return notExist(oldname)
+	}
+	copy(sd.words[sd.loc:], ename[:])
+	return f.writeBlocks(sd.index, sd.words)
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package os8fs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// memDisk returns a new in memory disk with a single side of blocks blocks.
+func memDisk(t *testing.T, blocks int) *Disk {
+	t.Helper()
+	d, err := NewMemoryImage(Drive{Bytes: blocks * 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// dirEntries returns the entries of directory block index of f as a list of
+// names, with free entries given as "<n>" for n blocks.
+func dirEntries(t *testing.T, f *FileSystem, index int) []string {
+	t.Helper()
+	w, err := f.getBlocks(index, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	loc := 5
+	for i := 0; i < int(010000-w[0]); i++ {
+		if w[loc] == 0 {
+			entries = append(entries, fmt.Sprintf("<%d>", (010000-w[loc+1])&07777))
+			loc += 2
+			continue
+		}
+		entries = append(entries, fileEntry{name: [4]uint16{w[loc], w[loc+1], w[loc+2], w[loc+3]}}.Name())
+		loc += 6
+	}
+	return entries
+}
+
+func TestCreate(t *testing.T) {
+	d := memDisk(t, 64)
+	if err := d.Create("foo.pa", words(0, 300)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("BAR.SV", words(1, 256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("FOO.PA", nil); !errors.Is(err, ErrExist) {
+		t.Errorf("Create of existing file got %v, want %v", err, ErrExist)
+	}
+	if err := d.Create("BIG.DA", words(0, 64*256)); err == nil {
+		t.Error("Create of a file larger than the disk succeeded")
+	}
+	fis, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileInfo{
+		{Name: "FOO.PA", Size: 2, Offset: 7},
+		{Name: "BAR.SV", Size: 1, Offset: 9},
+	}
+	if !reflect.DeepEqual(fis, want) {
+		t.Errorf("List got %v, want %v", fis, want)
+	}
+	if got := dirEntries(t, d.Side(0), 1); !reflect.DeepEqual(got, []string{"FOO.PA", "BAR.SV", "<54>"}) {
+		t.Errorf("directory got %v", got)
+	}
+	f, err := d.File("FOO.PA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want300 := append(words(0, 300), make([]uint16, 212)...)
+	if !equalWords(f.Words(), want300) {
+		t.Error("FOO.PA is not padded with zeros")
+	}
+}
+
+func TestRemoveCoalesces(t *testing.T) {
+	d := memDisk(t, 64)
+	for i, name := range []string{"A", "B", "C", "D"} {
+		if err := d.Create(name, words(i, 256*(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		remove string
+		want   []string
+	}{
+		{"B", []string{"A", "<2>", "C", "D", "<47>"}},
+		{"D", []string{"A", "<2>", "C", "<51>"}},
+		{"A", []string{"<3>", "C", "<51>"}},
+		{"C", []string{"<57>"}},
+	} {
+		if err := d.Remove(tt.remove); err != nil {
+			t.Fatal(err)
+		}
+		if got := dirEntries(t, d.Side(0), 1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("after removing %s got %v, want %v", tt.remove, got, tt.want)
+		}
+	}
+	if err := d.Remove("A"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Remove of missing file got %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestWriteDoesNotFragment(t *testing.T) {
+	d := memDisk(t, 64)
+	if err := d.Create("KEEP", words(0, 256)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		size := 256 * (1 + i%5)
+		if err := d.Write("FOO.TX", words(i, size)); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		f, err := d.File("FOO.TX")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalWords(f.Words(), words(i, size)) {
+			t.Fatalf("write %d: wrong contents", i)
+		}
+	}
+	if got := dirEntries(t, d.Side(0), 1); len(got) > 4 {
+		t.Errorf("directory fragmented: %v", got)
+	}
+}
+
+func TestCreateChainsSegments(t *testing.T) {
+	d := memDisk(t, 800)
+	const n = 150
+	for i := 0; i < n; i++ {
+		if err := d.Create(fmt.Sprintf("F%d", i), words(i, 256)); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+	fis, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != n {
+		t.Fatalf("got %d files, want %d", len(fis), n)
+	}
+	for i, fi := range fis {
+		if want := fmt.Sprintf("F%d", i); fi.Name != want || fi.Offset != 7+i {
+			t.Fatalf("file %d is %s at %d, want %s at %d", i, fi.Name, fi.Offset, want, 7+i)
+		}
+		f, err := d.File(fi.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Words()[0] != uint16(i) {
+			t.Fatalf("%s has the wrong contents", fi.Name)
+		}
+	}
+	if blocks := d.Side(0).dirBlocks(); len(blocks) < 4 {
+		t.Errorf("directory has %d segments, want at least 4", len(blocks))
+	}
+}
+
+func TestCreateDirectoryFull(t *testing.T) {
+	d := memDisk(t, 800)
+	var err error
+	i := 0
+	for ; err == nil && i < 400; i++ {
+		err = d.Create(fmt.Sprintf("F%d", i), words(i, 1))
+	}
+	if err == nil {
+		t.Fatal("directory never filled")
+	}
+	// Every segment is full, but the files created are all intact.
+	if blocks := d.Side(0).dirBlocks(); len(blocks) != 6 {
+		t.Errorf("directory has %d segments, want 6", len(blocks))
+	}
+	fis, lerr := d.List()
+	if lerr != nil {
+		t.Fatal(lerr)
+	}
+	if len(fis) != i-1 {
+		t.Errorf("got %d files, want %d", len(fis), i-1)
+	}
+}
+
+func TestRename(t *testing.T) {
+	d := memDisk(t, 64)
+	d.Create("FOO.PA", words(0, 10))
+	d.Create("BAR.PA", words(0, 10))
+	if err := d.Rename("FOO.PA", "BAR.PA"); !errors.Is(err, ErrExist) {
+		t.Errorf("Rename onto existing file got %v, want %v", err, ErrExist)
+	}
+	if err := d.Rename("FOO.PA", "baz.sv"); err != nil {
+		t.Fatal(err)
+	}
+	fis, _ := d.List()
+	if got := names(fis); !reflect.DeepEqual(got, []string{"BAZ.SV", "BAR.PA"}) {
+		t.Errorf("got %v", got)
+	}
+	if err := d.Rename("FOO.PA", "X"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Rename of missing file got %v, want %v", err, ErrNotExist)
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package pal
+
+import (
+	"strings"
+
+	"github.com/pborman/pdp8/os8fs"
+)
+
+func isLetter(c byte) bool { return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// isEnd reports if c ends an expression.
+func isEnd(c byte) bool {
+	return strings.IndexByte("\x00;/)]<>", c) >= 0
+}
+
+// isOperator reports if c is a binary operator.
+func isOperator(c byte) bool {
+	return strings.IndexByte("+-^%!&", c) >= 0
+}
+
+// peek returns the next character of the line, or 0 at the end of the line.
+func (a *asm) peek() byte {
+	if a.i >= len(a.s) {
+		return 0
+	}
+	return a.s[a.i]
+}
+
+// space skips white space.
+func (a *asm) space() {
+	for a.i < len(a.s) && strings.IndexByte(" \t\f\r", a.s[a.i]) >= 0 {
+		a.i++
+	}
+}
+
+// name returns the symbol at the current position in upper case, truncated
+// to 6 characters.
+func (a *asm) name() string {
+	start := a.i
+	for a.i < len(a.s) && (isLetter(a.s[a.i]) || isDigit(a.s[a.i])) {
+		a.i++
+	}
+	name := strings.ToUpper(a.s[start:a.i])
+	if len(name) > 6 {
+		name = name[:6]
+	}
+	return name
+}
+
+// instruction returns the value of an instruction or expression.
+func (a *asm) instruction() value {
+	a.space()
+	if c := a.peek(); isEnd(c) || isOperator(c) {
+		return a.operators(value{})
+	}
+	v, mri := a.term()
+	if mri {
+		return a.mri(v)
+	}
+	return a.operators(v)
+}
+
+// operators applies the operators and terms that follow v, left to right.
+func (a *asm) operators(v value) value {
+	for {
+		switch c := a.peek(); {
+		case isOperator(c):
+			a.i++
+			a.space()
+			t, _ := a.term()
+			v = a.operate(c, v, t)
+		case c == ' ', c == '\t', c == '\f', c == '\r':
+			a.space()
+			if c := a.peek(); isEnd(c) || isOperator(c) {
+				continue
+			}
+			t, _ := a.term()
+			v = a.operate('!', v, t)
+		default:
+			return v
+		}
+	}
+}
+
+// operate returns v c t.  Only + and - may have relocatable operands.  At
+// most one operand of + may be relocatable, and the difference of two
+// addresses in the same section is absolute.
+func (a *asm) operate(c byte, v, t value) value {
+	switch {
+	case v.kind == absolute && t.kind == absolute:
+	case c == '+' && v.kind == absolute:
+		v.kind, v.sect, v.name = t.kind, t.sect, t.name
+	case c == '+' && t.kind == absolute, c == '-' && t.kind == absolute:
+	case c == '-' && v.kind == relative && t.kind == relative && v.sect == t.sect:
+		v.kind = absolute
+	default:
+		a.errorf("RE", "")
+		v = value{v: v.v}
+	}
+	switch c {
+	case '+':
+		v.v += t.v
+	case '-':
+		v.v -= t.v
+	case '^':
+		v.v *= t.v
+	case '%':
+		if t.v != 0 {
+			v.v /= t.v
+		} else {
+			v.v = 0
+		}
+	case '!':
+		v.v |= t.v
+	case '&':
+		v.v &= t.v
+	}
+	v.v &= 07777
+	return v
+}
+
+// term returns the value of the next term and reports if it is a memory
+// reference instruction.  In relocatable source a symbol of another section is
+// an external reference to the symbol, which becomes an entry point of its
+// section.
+func (a *asm) term() (value, bool) {
+	c := a.peek()
+	switch {
+	case isDigit(c):
+		var v uint16
+		bad := false
+		for ; a.i < len(a.s) && isDigit(a.s[a.i]); a.i++ {
+			d := uint16(a.s[a.i] - '0')
+			if int(d) >= a.radix {
+				bad = true
+			}
+			v = v*uint16(a.radix) + d
+		}
+		if bad {
+			a.errorf("IC", "")
+		}
+		return value{v: v & 07777}, false
+	case isLetter(c):
+		name := a.name()
+		if s := a.user[name]; s != nil {
+			if !s.defined {
+				a.undef = true
+				a.errorf("US", name)
+			}
+			v := s.val
+			if v.kind == relative && v.sect != a.sect {
+				if a.pass == 2 {
+					a.sections[v.sect].entries[name] = true
+				}
+				v = value{kind: external, name: name}
+			}
+			return v, s.mri
+		}
+		if v, ok := a.mris[name]; ok {
+			return value{v: v}, true
+		}
+		if v, ok := a.perm[name]; ok {
+			return value{v: v}, false
+		}
+		a.undef = true
+		a.errorf("US", name)
+		return value{}, false
+	case c == '.':
+		a.i++
+		return a.dot(), false
+	case c == '"':
+		a.i++
+		if a.i >= len(a.s) {
+			a.errorf("IC", "")
+			return value{}, false
+		}
+		a.i++
+		return value{v: uint16(a.s[a.i-1]) | 0200}, false
+	case c == '(':
+		a.i++
+		v := a.instruction()
+		if a.peek() == ')' {
+			a.i++
+		}
+		l := a.dot()
+		l.v = a.literal(a.current(), v)
+		return l, false
+	case c == '[':
+		a.i++
+		v := a.instruction()
+		if a.peek() == ']' {
+			a.i++
+		}
+		p := a.zpool
+		if a.reloc {
+			a.errorf("IP", "")
+			p = a.current()
+		}
+		l := a.dot()
+		l.v = a.literal(p, v)
+		return l, false
+	case c == 0:
+		return value{}, false
+	}
+	a.errorf("IC", "")
+	a.i++
+	return value{}, false
+}
+
+// mri returns the memory reference instruction op and its operand.  Only an
+// absolute address may be on page zero, and only an address in the current
+// section may be on the current page.
+func (a *asm) mri(op value) value {
+	w := op.v
+	z := false
+modifiers:
+	for {
+		a.space()
+		save := a.i
+		switch a.name() {
+		case "I":
+			w |= 0400
+		case "Z":
+			z = true
+		default:
+			a.i = save
+			break modifiers
+		}
+	}
+	var addr value
+	if c := a.peek(); !isEnd(c) {
+		if isOperator(c) {
+			addr = a.operators(value{})
+		} else {
+			t, _ := a.term()
+			addr = a.operators(t)
+		}
+	}
+	if a.pass == 1 {
+		return value{v: w}
+	}
+	switch {
+	case addr.kind == absolute && addr.v&07600 == 0:
+		return value{v: w | addr.v}
+	case z:
+		a.errorf("IZ", "")
+		return value{v: w | addr.v&0177}
+	case a.local(addr) && addr.v&07600 == a.loc&07600:
+		return value{v: w | 0200 | addr.v&0177}
+	case w&0400 != 0:
+		a.errorf("II", "")
+		return value{v: w | 0200 | addr.v&0177}
+	}
+	// Generate a link.
+	l := a.literal(a.current(), addr)
+	if l&07600 == 0 && !a.reloc {
+		return value{v: w | 0400 | l}
+	}
+	return value{v: w | 0600 | l&0177}
+}
+
+// cond starts a conditional whose text, enclosed in <>, is assembled if ok.
+func (a *asm) cond(ok bool) {
+	a.space()
+	if a.peek() != '<' {
+		a.errorf("IP", "")
+		return
+	}
+	a.i++
+	if ok {
+		a.open++
+	} else {
+		a.skip = 1
+	}
+	a.opened = true
+}
+
+// ifzero handles IFZERO (zero is true) and IFNZRO.
+func (a *asm) ifzero(zero bool) {
+	v := a.instruction()
+	a.cond((v.v == 0) == zero)
+}
+
+// ifdef handles IFDEF (def is true) and IFNDEF.
+func (a *asm) ifdef(def bool) {
+	a.space()
+	name := a.name()
+	s := a.user[name]
+	_, perm := a.perm[name]
+	_, mri := a.mris[name]
+	a.cond((s != nil && s.defined || perm || mri) == def)
+}
+
+// text handles TEXT (term is true) and SIXBIT.
+func (a *asm) text(term bool) {
+	a.space()
+	if a.i >= len(a.s) {
+		a.errorf("IP", "")
+		return
+	}
+	delim := a.s[a.i]
+	a.i++
+	n := strings.IndexByte(a.s[a.i:], delim)
+	if n < 0 {
+		a.errorf("IP", "")
+		n = len(a.s) - a.i
+	}
+	chars := a.s[a.i : a.i+n]
+	a.i += n
+	if a.i < len(a.s) {
+		a.i++
+	}
+	for i := 0; i < len(chars); i += 2 {
+		w := sixbit(chars[i]) << 6
+		if i+1 < len(chars) {
+			w |= sixbit(chars[i+1])
+		}
+		a.emit(value{v: w})
+	}
+	if term && len(chars)%2 == 0 {
+		a.emit(value{})
+	}
+}
+
+// sixbit returns c as 6 bit ASCII.  Lower case letters are folded to upper
+// case.
+func sixbit(c byte) uint16 {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return uint16(c & 077)
+}
+
+// page handles PAGE.
+func (a *asm) page() {
+	a.space()
+	if isEnd(a.peek()) {
+		if a.loc&0177 != 0 {
+			a.origin((a.loc + 0200) & 07600)
+		}
+		return
+	}
+	v, ok := a.defined()
+	if !ok {
+		a.errorf("UO", "")
+		return
+	}
+	a.origin(v.v << 7)
+}
+
+// field handles FIELD, which relocatable source may not use.
+func (a *asm) field() {
+	if a.reloc {
+		a.errorf("IP", "")
+		a.i = len(a.s)
+		return
+	}
+	v, ok := a.defined()
+	if !ok {
+		a.errorf("UO", "")
+		return
+	}
+	a.dump(a.pool)
+	a.dump(a.zpool)
+	a.fld = int(v.v & 7)
+	a.pool = nil
+	a.zpool = &pool{field: a.fld}
+	a.loc = 0200
+}
+
+// zblock handles ZBLOCK.
+func (a *asm) zblock() {
+	v, ok := a.defined()
+	if !ok {
+		a.errorf("US", "")
+		return
+	}
+	for n := v.v; n > 0; n-- {
+		a.emit(value{})
+	}
+}
+
+// device handles DEVICE.
+func (a *asm) device() {
+	a.space()
+	name := a.name()
+	if name == "" || len(name) > 4 {
+		a.errorf("IP", "")
+		return
+	}
+	words, _ := os8fs.EncodeASCII6(name + strings.Repeat("@", 4-len(name)))
+	for _, w := range words {
+		a.emit(value{v: w})
+	}
+}
+
+// filename handles FILENAME.
+func (a *asm) filename() {
+	a.space()
+	start := a.i
+	for a.i < len(a.s) && (isLetter(a.s[a.i]) || isDigit(a.s[a.i]) || a.s[a.i] == '.') {
+		a.i++
+	}
+	words, err := os8fs.ParseName(a.s[start:a.i])
+	if err != nil {
+		a.errorf("IP", "")
+		return
+	}
+	for _, w := range words {
+		a.emit(value{v: w})
+	}
+}
+
+// fixmri handles FIXMRI NAME=EXPR.
+func (a *asm) fixmri() {
+	a.space()
+	name := a.name()
+	a.space()
+	if name == "" || a.peek() != '=' {
+		a.errorf("IP", "")
+		return
+	}
+	a.i++
+	v, _ := a.defined()
+	a.user[name] = &symbol{val: v, defined: true, mri: true, fixed: true}
+}
+
+// fixtab handles FIXTAB.  Symbols defined after FIXTAB are only known during
+// the second pass, so the symbols are fixed during the first pass.
+func (a *asm) fixtab() {
+	if a.pass != 1 {
+		return
+	}
+	for _, s := range a.user {
+		if s.defined {
+			s.fixed = true
+		}
+	}
+}
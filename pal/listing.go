@@ -0,0 +1,111 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package pal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// Symbol returns the value of the user symbol name and reports if it is
+// defined.
+func (p *Program) Symbol(name string) (uint16, bool) {
+	s := p.symbols[name]
+	if s == nil || !s.defined {
+		return 0, false
+	}
+	return s.val.v, true
+}
+
+// WriteListing writes the listing of p to w.  Each source line is preceded by
+// its errors and its line number and, if it generated any words, the address
+// and value of each word.  Literals are listed without source where they are
+// written.  The symbol table follows the source on a new page.  In the listing
+// of relocatable source a relocated word is marked with ', and an external or
+// common reference with *.
+func (p *Program) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range p.lines {
+		for _, e := range l.errs {
+			if e.Symbol != "" {
+				fmt.Fprintf(bw, "%s AT %s %s\n", e.Code, addr(e.Addr), e.Symbol)
+			} else {
+				fmt.Fprintf(bw, "%s AT %s\n", e.Code, addr(e.Addr))
+			}
+		}
+		if l.hidden && len(l.errs) == 0 {
+			continue
+		}
+		switch {
+		case l.num == 0:
+			fmt.Fprintf(bw, "      %s %04o%s\n", addr(l.addr), l.words[0].v, mark(l.words[0]))
+		case len(l.words) == 0:
+			fmt.Fprintf(bw, "%5d             %s\n", l.num, l.text)
+		default:
+			fmt.Fprintf(bw, "%5d %s %04o%-1s %s\n", l.num, addr(l.addr), l.words[0].v, mark(l.words[0]), l.text)
+			for i, v := range l.words[1:] {
+				a := core.MakeAddr(l.addr.Field(), l.addr.Offset()+uint16(i+1))
+				fmt.Fprintf(bw, "      %s %04o%s\n", addr(a), v.v, mark(v))
+			}
+		}
+	}
+	fmt.Fprintf(bw, "\f\n")
+	p.writeSymbols(bw)
+	return bw.Flush()
+}
+
+// WriteSymbols writes the symbol table of p to w.  Each user symbol is written
+// with its value, in alphabetical order.  The value of an undefined symbol is
+// written as US.  Symbols made permanent by FIXTAB and FIXMRI are not written.
+// Relocatable symbols are marked as in the listing and external symbols and
+// common blocks are written as EXT and COM.
+func (p *Program) WriteSymbols(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	p.writeSymbols(bw)
+	return bw.Flush()
+}
+
+func (p *Program) writeSymbols(w io.Writer) {
+	var names []string
+	for name, s := range p.symbols {
+		if !s.fixed {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch s := p.symbols[name]; {
+		case !s.defined:
+			fmt.Fprintf(w, "%-6s US\n", name)
+		case s.val.kind == external:
+			fmt.Fprintf(w, "%-6s EXT\n", name)
+		case s.val.kind == common:
+			fmt.Fprintf(w, "%-6s COM\n", name)
+		default:
+			fmt.Fprintf(w, "%-6s %04o%s\n", name, s.val.v, mark(s.val))
+		}
+	}
+}
+
+// mark returns the mark that follows the word v in a listing.
+func mark(v value) string {
+	switch v.kind {
+	case relative:
+		return "'"
+	case external, common:
+		return "*"
+	}
+	return ""
+}
+
+// addr returns a as the 5 digits used by PAL8 listings.
+func addr(a core.Addr) string {
+	return fmt.Sprintf("%o%04o", a.Field(), a.Offset())
+}
@@ -0,0 +1,627 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package pal is a two pass assembler for the PAL8 assembly language.
+//
+// The source is made of statements separated by newlines or semicolons.  Text
+// following a / is a comment.  A statement is one of:
+//
+//	NAME,           define NAME as the current location
+//	NAME=EXPR       define NAME as EXPR
+//	*EXPR           set the current location
+//	PSEUDO ...      a pseudo-op
+//	EXPR            assemble a word
+//	$               end of the source
+//
+// Labels may precede any statement.  Expressions are evaluated left to right
+// with the operators + - ^ (multiply) % (divide) ! (or) & (and).  A space
+// between terms also ors them, so CLA CLL is 7300.  A term is a number in the
+// current radix, a symbol, . for the current location, "C for the ASCII value
+// of C with the mark bit (0200) set, (EXPR for the address of a current page
+// literal, or [EXPR for the address of a page zero literal.  The closing ) or
+// ] is optional.  Symbols are a letter followed by letters and digits, only
+// the first 6 of which are significant.  Lower case letters are folded to
+// upper case.
+//
+// A memory reference instruction (AND, TAD, ISZ, DCA, JMS, JMP, or a symbol
+// defined with FIXMRI) is followed by an optional I, for indirect, an optional
+// Z, and the address as an expression.  Addresses on page zero and on the
+// current page are addressed directly.  An off page reference generates a
+// link: the address is stored as a current page literal that is referred to
+// indirectly.
+//
+// Current page literals are allocated from the top of the page down, in the
+// order they are first used.  Literals with the same value share a word.  The
+// literals of a page are written when the current location leaves the page,
+// by *, PAGE, FIELD, or running off the end of the page.  Page zero literals
+// are allocated from 0177 down and are written at FIELD and at the end of the
+// source.  On page zero current page literals are page zero literals.
+//
+// The pseudo-ops are:
+//
+//	DECIMAL          numbers are decimal
+//	OCTAL            numbers are octal (the default)
+//	DEVICE NAME      NAME, up to 4 characters, as 6 bit ASCII in 2 words
+//	FILENAME N.E     the OS/8 filename N.E as 6 bit ASCII in 4 words
+//	EJECT            start a new listing page (the rest of the line is ignored)
+//	NOPUNCH          stop writing words to the binary output
+//	ENPUNCH          resume writing words to the binary output
+//	EXPUNGE          remove all permanent symbols other than pseudo-ops
+//	FIXMRI NAME=EXPR define NAME as a memory reference instruction
+//	FIXTAB           make the symbols defined so far permanent
+//	FIELD N          set the field to N and the current location to 0200
+//	PAGE [N]         set the current location to page N, or the next page
+//	IFDEF NAME <...> assemble ... if NAME is defined
+//	IFNDEF NAME <...> assemble ... if NAME is not defined
+//	IFZERO EXPR <...> assemble ... if EXPR is 0
+//	IFNZRO EXPR <...> assemble ... if EXPR is not 0
+//	TEXT /STRING/    STRING as 6 bit ASCII, 2 characters per word, ending
+//	                 with a 0 character
+//	SIXBIT /STRING/  STRING as 6 bit ASCII with no ending 0 character
+//	XLIST            turn the listing off or back on
+//	ZBLOCK N         N words of 0
+//
+// Lower case letters in the STRING of TEXT and SIXBIT are folded to upper case.
+// Assembly starts at location 0200 of field 0.
+//
+// Errors are reported with the codes used by PAL8:
+//
+//	IC  illegal character
+//	ID  illegal redefinition of a label
+//	II  illegal indirect, an off page reference with I
+//	IP  illegal pseudo-op
+//	IZ  illegal page zero reference, Z with an address not on page zero
+//	PE  current page literals overlap the code of the page
+//	RD  redefinition of a permanent symbol with a different value
+//	UO  undefined origin
+//	US  undefined symbol
+//	ZE  page zero literals overlap the code of page zero
+//	RE  relocation error, an expression that cannot be relocated
+//
+// AssembleModules assembles relocatable source, such as MACREL source after
+// its macros are expanded by package macrel, into relocatable modules (see
+// package reloc).  The source is divided into sections.  Each section is
+// assembled as though it starts at location 0 of a page aligned base and
+// becomes a module.  Words that refer to symbols of the section are relocated
+// by the base of the module, references to symbols of other sections are
+// external references, and the symbols referred to from other sections are
+// entry points.  Since the loader may place a module on any page, a memory
+// reference instruction only addresses page zero if its address is absolute.
+// All other references off the current page are links, and there are no page
+// zero literals.  FIELD may not be used.
+//
+// Relocatable source also accepts the following directives:
+//
+//	.RSECT NAME           start or continue the section NAME
+//	.ENTRY NAME,...       make each NAME an entry point of its section
+//	.EXTERNAL NAME,...    declare each NAME as an external symbol
+//	.COMMON NAME,SIZE     declare the common block NAME of SIZE words
+//	.START EXPR           start the program at EXPR
+//	.BLOCK N              N words of 0 (ZBLOCK)
+//	.TEXT /STRING/        TEXT
+//	.SIXBT /STRING/       SIXBIT
+//	.RADIX N              numbers are in radix N (8 or 10)
+//	.IF COND ARG <...>    assemble ... if COND is true of ARG
+//	.LIST, .NOLIST        turn the listing on or off
+//	.TITLE TEXT           ignored
+//	.END                  end of the source
+//
+// COND is one of EQ, NE, GT, GE, LT, or LE, comparing the expression ARG with
+// 0 as a signed number, DF or NDF, testing if the symbol ARG is defined, or BL
+// or NB, testing if ARG is blank, as it is when a macro argument is omitted.
+// Before the first .RSECT the section has the name passed to
+// AssembleModules.
+package pal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/reloc"
+)
+
+// An Error is an assembly error.
+type Error struct {
+	Line   int       // source line, starting at 1
+	Code   string    // PAL8 error code, e.g., US
+	Addr   core.Addr // current location
+	Symbol string    // the undefined or redefined symbol, if any
+}
+
+func (e *Error) Error() string {
+	if e.Symbol != "" {
+		return fmt.Sprintf("line %d: %s AT %s %s", e.Line, e.Code, addr(e.Addr), e.Symbol)
+	}
+	return fmt.Sprintf("line %d: %s AT %s", e.Line, e.Code, addr(e.Addr))
+}
+
+// An ErrorList is a list of errors.
+type ErrorList []*Error
+
+func (e ErrorList) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", e[0], len(e)-1)
+}
+
+// A Program is an assembled program.
+type Program struct {
+	Image   *core.Image     // the absolute program, nil if relocatable
+	Modules []*reloc.Module // the relocatable modules, one per section
+	Errors  ErrorList
+
+	reloc   bool
+	symbols map[string]*symbol
+	lines   []*line
+}
+
+// A kind is the kind of a value.
+type kind int
+
+const (
+	absolute = kind(iota)
+	relative // relative to the base of section sect
+	external // relative to the external symbol name
+	common   // relative to the common block name
+)
+
+// A value is the value of an expression.  Only relocatable source has values
+// other than absolute values.
+type value struct {
+	v    uint16
+	kind kind
+	sect int
+	name string
+}
+
+// A symbol is a user symbol.
+type symbol struct {
+	val     value
+	defined bool
+	mri     bool // defined by FIXMRI
+	fixed   bool // made permanent by FIXTAB or FIXMRI
+	labels  int  // times defined as a label during pass 1
+}
+
+// A line is a line of the listing.
+type line struct {
+	num    int    // source line number, 0 for literals
+	text   string // source text
+	addr   core.Addr
+	words  []value
+	errs   []*Error
+	hidden bool // listing turned off by XLIST
+}
+
+// An asm is the state of the assembler.
+type asm struct {
+	pass     int
+	user     map[string]*symbol
+	perm     map[string]uint16
+	mris     map[string]uint16
+	radix    int
+	fld      int
+	loc      uint16
+	pool     *pool // current page literals
+	zpool    *pool // page zero literals
+	img      *core.Image
+	punch    bool
+	list     bool
+	lines    []*line
+	cur      *line
+	errs     ErrorList
+	skip     int  // depth of <> being skipped by a false conditional
+	open     int  // depth of <> of true conditionals
+	opened   bool // the statement started a conditional
+	done     bool // $ was found
+	undef    bool // an undefined symbol was used in the current expression
+	s        string
+	i        int
+	lineNum  int
+	lineNums []int // source line numbers of the lines, if not 1, 2, ...
+
+	// Relocatable source only
+	reloc    bool
+	first    string     // name of the first section
+	sections []*section // sections in the order they are started
+	sect     int        // the current section
+	commons  map[string]int
+}
+
+// A pool is a literal pool.  Literal i is at page+0177-i.
+type pool struct {
+	field int
+	sect  int
+	page  uint16
+	vals  []value
+}
+
+// Assemble assembles src and returns the assembled program.  If there are
+// errors, the returned error is an ErrorList of all the errors, which are
+// also found in the returned program.
+func Assemble(src []byte) (*Program, error) {
+	a := &asm{user: map[string]*symbol{}}
+	return a.assemble(src)
+}
+
+// AssembleModules assembles the relocatable source src and returns the
+// assembled program, whose Modules are the sections of src.  name is the name
+// of the section that starts the source.  If lineNums is not nil, lineNums[i]
+// is the line number reported for line i of src, starting at 0.  This permits
+// errors in source produced by expanding macros to be reported by the line
+// numbers of the original source.  Errors are returned as by Assemble.
+func AssembleModules(name string, src []byte, lineNums []int) (*Program, error) {
+	a := &asm{
+		user:     map[string]*symbol{},
+		lineNums: lineNums,
+		reloc:    true,
+		first:    name,
+	}
+	return a.assemble(src)
+}
+
+// assemble assembles src in two passes.
+func (a *asm) assemble(src []byte) (*Program, error) {
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.run(src)
+	}
+	p := &Program{
+		Errors:  a.errs,
+		reloc:   a.reloc,
+		symbols: a.user,
+		lines:   a.lines,
+	}
+	if a.reloc {
+		p.Modules = a.modules()
+	} else {
+		p.Image = a.img
+	}
+	if len(a.errs) > 0 {
+		return p, a.errs
+	}
+	return p, nil
+}
+
+// run makes a single pass over src.
+func (a *asm) run(src []byte) {
+	a.perm = map[string]uint16{}
+	for name, v := range permanent {
+		a.perm[name] = v
+	}
+	a.mris = map[string]uint16{}
+	for name, v := range mris {
+		a.mris[name] = v
+	}
+	a.radix = 8
+	a.fld = 0
+	a.loc = 0200
+	a.pool = nil
+	a.zpool = &pool{}
+	a.img = core.New()
+	a.punch = true
+	a.list = true
+	a.lines = nil
+	a.skip, a.open = 0, 0
+	a.done = false
+	if a.reloc {
+		// The sections are kept between passes so a symbol refers to the
+		// same section in both passes.
+		if a.sections == nil {
+			a.sections = []*section{newSection(a.first)}
+		}
+		for i, s := range a.sections {
+			a.sections[i] = newSection(s.name)
+		}
+		a.sect = 0
+		a.commons = map[string]int{}
+		a.loc = 0
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for n := 0; !a.done && s.Scan(); n++ {
+		a.lineNum = n + 1
+		if n < len(a.lineNums) {
+			a.lineNum = a.lineNums[n]
+		}
+		a.cur = &line{num: a.lineNum, text: s.Text(), hidden: !a.list}
+		a.lines = append(a.lines, a.cur)
+		a.line(s.Text())
+	}
+	a.cur = nil
+	if a.reloc {
+		a.sections[a.sect].pool, a.pool = a.pool, nil
+		for _, sect := range a.sections {
+			a.dump(sect.pool)
+			sect.pool = nil
+		}
+		return
+	}
+	a.dump(a.pool)
+	a.dump(a.zpool)
+}
+
+// errorf records an error with code and the optional symbol during pass 2.
+func (a *asm) errorf(code, sym string) {
+	if a.pass != 2 {
+		return
+	}
+	e := &Error{Line: a.lineNum, Code: code, Addr: a.addr(a.loc), Symbol: sym}
+	a.errs = append(a.errs, e)
+	if a.cur != nil {
+		a.cur.errs = append(a.cur.errs, e)
+	}
+}
+
+// addr returns the address of loc in the current field.
+func (a *asm) addr(loc uint16) core.Addr {
+	return core.MakeAddr(a.fld, loc&07777)
+}
+
+// dot returns the value of the current location.
+func (a *asm) dot() value {
+	if a.reloc {
+		return value{v: a.loc, kind: relative, sect: a.sect}
+	}
+	return value{v: a.loc}
+}
+
+// local reports if v is an address in the current section.  All absolute
+// addresses are local to an absolute program.
+func (a *asm) local(v value) bool {
+	if a.reloc {
+		return v.kind == relative && v.sect == a.sect
+	}
+	return true
+}
+
+// line assembles the source line s.
+func (a *asm) line(s string) {
+	a.s, a.i = s, 0
+	for !a.done {
+		if a.skip > 0 {
+			for a.skip > 0 && a.i < len(a.s) {
+				switch a.s[a.i] {
+				case '<':
+					a.skip++
+				case '>':
+					a.skip--
+				}
+				a.i++
+			}
+			if a.skip > 0 {
+				return
+			}
+		}
+		a.opened = false
+		a.statement()
+		if a.opened || a.skip > 0 {
+			continue
+		}
+		a.space()
+		switch a.peek() {
+		case ';':
+			a.i++
+		case '>':
+			if a.open > 0 {
+				a.open--
+			}
+			a.i++
+		case 0, '/':
+			return
+		default:
+			a.errorf("IC", "")
+			return
+		}
+	}
+}
+
+// statement assembles a single statement.
+func (a *asm) statement() {
+	a.space()
+	switch c := a.peek(); {
+	case c == 0, c == '/', c == ';', c == '>':
+		return
+	case c == '$':
+		a.done = true
+		a.i = len(a.s)
+		return
+	case c == '*':
+		a.i++
+		v, ok := a.defined()
+		if !ok {
+			a.errorf("UO", "")
+			return
+		}
+		a.origin(v.v)
+		return
+	case c == '.' && a.reloc && a.i+1 < len(a.s) && isLetter(a.s[a.i+1]):
+		a.i++
+		name := a.name()
+		if fn, ok := directives[name]; ok {
+			fn(a)
+			return
+		}
+		a.errorf("IP", "")
+		a.i = len(a.s)
+		return
+	case isLetter(c):
+		save := a.i
+		name := a.name()
+		a.space()
+		switch a.peek() {
+		case ',':
+			a.i++
+			a.label(name)
+			a.statement()
+			return
+		case '=':
+			a.i++
+			a.assign(name)
+			return
+		}
+		if fn, ok := pseudo[name]; ok {
+			fn(a)
+			return
+		}
+		a.i = save
+	}
+	a.emit(a.instruction())
+}
+
+// label defines name as the current location.
+func (a *asm) label(name string) {
+	s := a.user[name]
+	if s == nil {
+		s = &symbol{}
+		a.user[name] = s
+	}
+	dot := a.dot()
+	switch a.pass {
+	case 1:
+		if s.labels > 0 && s.val != dot {
+			s.labels++
+		} else if s.labels == 0 {
+			s.labels = 1
+		}
+	case 2:
+		if s.labels > 1 {
+			a.errorf("ID", name)
+		}
+	}
+	s.val = dot
+	s.defined = true
+}
+
+// assign defines name as the value of the rest of the statement.
+func (a *asm) assign(name string) {
+	v, ok := a.defined()
+	if p, perm := a.perm[name]; perm && (v.kind != absolute || v.v != p) {
+		a.errorf("RD", name)
+	}
+	s := a.user[name]
+	if s == nil {
+		s = &symbol{}
+		a.user[name] = s
+	}
+	s.val = v
+	s.defined = s.defined || ok
+}
+
+// defined returns the value of an expression and reports if all of its symbols
+// were defined.
+func (a *asm) defined() (value, bool) {
+	a.undef = false
+	v := a.instruction()
+	return v, !a.undef
+}
+
+// origin sets the current location to loc.
+func (a *asm) origin(loc uint16) {
+	loc &= 07777
+	if a.pool != nil && loc&07600 != a.pool.page {
+		a.dump(a.pool)
+		a.pool = nil
+	}
+	a.loc = loc
+}
+
+// emit assembles v at the current location.
+func (a *asm) emit(v value) {
+	v.v &= 07777
+	if a.pass == 2 {
+		if p := a.current(); a.loc >= p.low() {
+			a.overflow(p)
+		}
+		a.store(a.sect, a.loc, v)
+		if a.cur != nil {
+			if len(a.cur.words) == 0 {
+				a.cur.addr = a.addr(a.loc)
+			}
+			a.cur.words = append(a.cur.words, v)
+		}
+	}
+	a.origin(a.loc + 1)
+}
+
+// store writes v to loc of the current field, or of section sect.
+func (a *asm) store(sect int, loc uint16, v value) {
+	switch {
+	case !a.punch:
+	case a.reloc:
+		a.sections[sect].store(loc, v)
+	default:
+		a.img.Load(a.addr(loc), v.v)
+	}
+}
+
+// current returns the literal pool for the current page.
+func (a *asm) current() *pool {
+	page := a.loc & 07600
+	if page == 0 && !a.reloc {
+		return a.zpool
+	}
+	if a.pool == nil || a.pool.page != page {
+		a.dump(a.pool)
+		a.pool = &pool{field: a.fld, sect: a.sect, page: page}
+	}
+	return a.pool
+}
+
+// overflow reports that the literals in p overlap the code.
+func (a *asm) overflow(p *pool) {
+	if p == a.zpool {
+		a.errorf("ZE", "")
+	} else {
+		a.errorf("PE", "")
+	}
+}
+
+// literal returns the address of the literal v in p, allocating it if needed.
+func (a *asm) literal(p *pool, v value) uint16 {
+	if a.pass == 1 {
+		return 0
+	}
+	v.v &= 07777
+	for i, x := range p.vals {
+		if x == v {
+			return p.addr(i)
+		}
+	}
+	p.vals = append(p.vals, v)
+	addr := p.addr(len(p.vals) - 1)
+	if a.loc&07600 == p.page && addr < a.loc {
+		a.overflow(p)
+	}
+	return addr
+}
+
+// addr returns the address of literal i.
+func (p *pool) addr(i int) uint16 {
+	return p.page + 0177 - uint16(i)
+}
+
+// low returns the lowest address used by the literals.
+func (p *pool) low() uint16 {
+	return p.page + 0200 - uint16(len(p.vals))
+}
+
+// dump writes the literals in p, if any.
+func (a *asm) dump(p *pool) {
+	if p == nil || a.pass != 2 {
+		return
+	}
+	for i := len(p.vals) - 1; i >= 0; i-- {
+		a.store(p.sect, p.addr(i), p.vals[i])
+		addr := core.MakeAddr(p.field, p.addr(i))
+		a.lines = append(a.lines, &line{addr: addr, words: []value{p.vals[i]}, hidden: !a.list})
+	}
+	p.vals = nil
+}
@@ -0,0 +1,352 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package pal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+	"github.com/pborman/pdp8/reloc"
+)
+
+// words maps addresses to the words expected to be loaded there.
+type words map[core.Addr]uint16
+
+func f1(a uint16) core.Addr { return core.MakeAddr(1, a) }
+
+func TestAssemble(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want words
+	}{{
+		name: "instructions",
+		src: `*200
+START,	CLA CLL		/ comment
+	TAD 20
+	TAD I 10
+	DCA START
+	JMP .
+	IAC RAL; HLT
+`,
+		want: words{0200: 07300, 0201: 01020, 0202: 01410, 0203: 03200, 0204: 05204, 0205: 07005, 0206: 07402},
+	}, {
+		name: "expressions",
+		src: `*200
+	3+4^2
+	7-10
+	77&14!1
+	"A
+	"a
+	DECIMAL
+	10
+	OCTAL
+	10
+	X=.+2
+	X
+`,
+		want: words{0200: 016, 0201: 07777, 0202: 015, 0203: 0301, 0204: 0341, 0205: 012, 0206: 010, 0207: 0211},
+	}, {
+		name: "current page literals",
+		src: `*200
+	TAD (3)
+	TAD (3
+	TAD (4)
+	TAD (START
+START,	HLT
+`,
+		want: words{0200: 01377, 0201: 01377, 0202: 01376, 0203: 01375, 0204: 07402, 0377: 3, 0376: 4, 0375: 0204},
+	}, {
+		name: "literals at the end of each page",
+		src: `*200
+	TAD (1)
+PAGE
+	TAD (1)
+`,
+		want: words{0200: 01377, 0377: 1, 0400: 01377, 0577: 1},
+	}, {
+		name: "page zero literals",
+		src: `*20
+	TAD (7)
+*200
+	TAD [5]
+	TAD [5]
+	TAD [6
+`,
+		want: words{020: 01177, 0177: 7, 0200: 01176, 0201: 01176, 0202: 01175, 0176: 5, 0175: 6},
+	}, {
+		name: "links",
+		src: `*200
+	JMS SUB
+	JMP SUB
+	TAD K
+*400
+SUB,	0
+K,	5
+`,
+		want: words{0200: 04777, 0201: 05777, 0202: 01776, 0377: 0400, 0376: 0401, 0400: 0, 0401: 5},
+	}, {
+		name: "text",
+		src: `*200
+	TEXT /AB/
+	TEXT "abc"
+	SIXBIT /XY/
+	TEXT //
+`,
+		want: words{0200: 0102, 0201: 0, 0202: 0102, 0203: 0300, 0204: 03031, 0205: 0},
+	}, {
+		name: "field",
+		src: `*200
+	TAD [7]
+	JMP .
+FIELD 1
+	TAD [7]
+	CDF 10
+`,
+		want: words{0177: 7, 0200: 01177, 0201: 05201, f1(0177): 7, f1(0200): 01177, f1(0201): 06211},
+	}, {
+		name: "pseudo-ops",
+		src: `*200
+	ZBLOCK 2
+	DEVICE DSK
+	FILENAME FOO.PA
+	NOPUNCH
+	1
+	ENPUNCH
+	2
+	IFDEF X <3>
+	IFNDEF X <4>
+	IFZERO 0 <5>
+	IFNZRO 0 <6>
+`,
+		want: words{
+			0200: 0, 0201: 0,
+			0202: 0423, 0203: 01300,
+			0204: 0617, 0205: 01700, 0206: 0, 0207: 02001,
+			0211: 2, 0212: 4, 0213: 5,
+		},
+	}, {
+		name: "permanent symbols",
+		src: `*200
+	FIXMRI LDA=1000
+	LDA 300
+	LDA (5)
+	MYOP=7200
+	FIXTAB
+	MYOP
+	$
+	7402
+`,
+		want: words{0200: 01300, 0201: 01377, 0202: 07200, 0377: 5},
+	}} {
+		p, err := Assemble([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := words{}
+		for _, r := range p.Image.Ranges() {
+			for a := r.Start; a < r.End; a++ {
+				got[a] = p.Image.Word(a)
+			}
+		}
+		for _, a := range addrs(got, tt.want) {
+			g, gok := got[a]
+			w, wok := tt.want[a]
+			switch {
+			case !wok:
+				t.Errorf("%s: %v: got %04o, want nothing", tt.name, a, g)
+			case !gok:
+				t.Errorf("%s: %v: got nothing, want %04o", tt.name, a, w)
+			case g != w:
+				t.Errorf("%s: %v: got %04o, want %04o", tt.name, a, g, w)
+			}
+		}
+	}
+}
+
+// addrs returns the addresses in a and b in order.
+func addrs(a, b words) []core.Addr {
+	var list []core.Addr
+	for x := range a {
+		list = append(list, x)
+	}
+	for x := range b {
+		if _, ok := a[x]; !ok {
+			list = append(list, x)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		code string
+		line int
+	}{
+		{"{\n", "IC", 1},
+		{"A, 0\nA, 0\n", "ID", 2},
+		{"*200\nTAD I 400\n", "II", 2},
+		{"TEXT\n", "IP", 1},
+		{"TEXT /AB\n", "IP", 1},
+		{"*400\nTAD Z 200\n", "IZ", 2},
+		{"*200\nZBLOCK 177\nTAD (1)\n", "PE", 3},
+		{"CLA=5\n", "RD", 1},
+		{"*FOO\n", "UO", 1},
+		{"TAD FOO\n", "US", 1},
+		{"*0\nZBLOCK 177\nTAD [1]\n", "ZE", 3},
+	} {
+		p, err := Assemble([]byte(tt.src))
+		if err == nil {
+			t.Errorf("%q: no error", tt.src)
+			continue
+		}
+		found := false
+		for _, e := range p.Errors {
+			found = found || (e.Code == tt.code && e.Line == tt.line)
+		}
+		if !found {
+			t.Errorf("%q: got %v, want %s on line %d", tt.src, err, tt.code, tt.line)
+		}
+	}
+
+	// Redefining a permanent symbol with its own value is not an error.
+	if _, err := Assemble([]byte("CLA=7200\n")); err != nil {
+		t.Errorf("CLA=7200: %v", err)
+	}
+}
+
+func TestListing(t *testing.T) {
+	p, err := Assemble([]byte("*200\nSTART,\tTAD (3)\n\tHLT\nK=15\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := p.Symbol("START"); !ok || v != 0200 {
+		t.Errorf("START got %04o, %v", v, ok)
+	}
+	if _, ok := p.Symbol("CLA"); ok {
+		t.Error("permanent symbol CLA found")
+	}
+	var listing, symbols strings.Builder
+	if err := p.WriteListing(&listing); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"00200 1377", "00377 0003", "START"} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing does not contain %q:\n%s", want, listing.String())
+		}
+	}
+	if err := p.WriteSymbols(&symbols); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"K      0015", "START  0200"} {
+		if !strings.Contains(symbols.String(), want) {
+			t.Errorf("symbols do not contain %q:\n%s", want, symbols.String())
+		}
+	}
+}
+
+func TestAssembleModules(t *testing.T) {
+	src := `	.EXTERNAL EXT
+	.COMMON BUF,10
+START,	TAD K
+	JMS EXT
+	TAD BUF
+	K-START
+	K
+	EXT+1
+	JMP I (START)
+K,	5
+	.START START
+	.RSECT TWO
+	START
+	TAD 20
+	JMP K
+`
+	p, err := AssembleModules("ONE", []byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Image != nil {
+		t.Error("relocatable source has an image")
+	}
+	if len(p.Modules) != 2 {
+		t.Fatalf("got %d modules, want 2", len(p.Modules))
+	}
+
+	one := p.Modules[0]
+	if one.Name != "ONE" || one.Start != 0 || len(one.Code) != 0200 {
+		t.Errorf("got module %s start %d size %d", one.Name, one.Start, len(one.Code))
+	}
+	if got, want := one.Code[:8], []uint16{01207, 04777, 01776, 07, 07, 01, 05775, 05}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ONE: got %04o, want %04o", got, want)
+	}
+	wantRelocs := []reloc.Reloc{
+		{Addr: 04, Kind: reloc.Relative},
+		{Addr: 05, Kind: reloc.External},
+		{Addr: 0175, Kind: reloc.Relative},
+		{Addr: 0176, Kind: reloc.Common},
+		{Addr: 0177, Kind: reloc.External},
+	}
+	if !reflect.DeepEqual(one.Relocs, wantRelocs) {
+		t.Errorf("ONE: got relocs %+v, want %+v", one.Relocs, wantRelocs)
+	}
+	if !reflect.DeepEqual(one.Externals, []string{"EXT"}) {
+		t.Errorf("ONE: got externals %v", one.Externals)
+	}
+	if !reflect.DeepEqual(one.Commons, []reloc.Block{{Name: "BUF", Size: 8}}) {
+		t.Errorf("ONE: got commons %v", one.Commons)
+	}
+	if !reflect.DeepEqual(one.Entries, []reloc.Symbol{{Name: "K", Addr: 7}, {Name: "START", Addr: 0}}) {
+		t.Errorf("ONE: got entries %v", one.Entries)
+	}
+
+	two := p.Modules[1]
+	if two.Name != "TWO" || two.Start != -1 {
+		t.Errorf("got module %s start %d", two.Name, two.Start)
+	}
+	if got, want := two.Code[:3], []uint16{0, 01020, 05777}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TWO: got %04o, want %04o", got, want)
+	}
+	if !reflect.DeepEqual(two.Externals, []string{"START", "K"}) {
+		t.Errorf("TWO: got externals %v", two.Externals)
+	}
+}
+
+func TestAssembleModulesErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		code string
+		line int
+	}{
+		{"START,\tK+K\nK,\t0\n", "RE", 1},
+		{"\t.EXTERNAL E\n\tE-1+E\n", "RE", 2},
+		{"\t.START 5\n", "RE", 1},
+		{"\t.ENTRY X\nX=5\n", "RE", 1},
+		{"\t.ENTRY X\n", "US", 1},
+		{"\tTAD [1]\n", "IP", 1},
+		{"\tFIELD 1\n", "IP", 1},
+		{"X,\t0\n\t.EXTERNAL X\n", "ID", 2},
+	} {
+		p, err := AssembleModules("X", []byte(tt.src), nil)
+		if err == nil {
+			t.Errorf("%q: no error", tt.src)
+			continue
+		}
+		found := false
+		for _, e := range p.Errors {
+			found = found || (e.Code == tt.code && e.Line == tt.line)
+		}
+		if !found {
+			t.Errorf("%q: got %v, want %s on line %d", tt.src, err, tt.code, tt.line)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package pal
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pborman/pdp8/reloc"
+)
+
+// A section is a section of relocatable source.  Each section becomes a
+// module.
+type section struct {
+	name    string
+	words   map[uint16]value
+	size    int
+	loc     uint16 // location counter while another section is current
+	pool    *pool  // literals while another section is current
+	start   int
+	entries map[string]bool // symbols of the section that are entry points
+}
+
+func newSection(name string) *section {
+	return &section{
+		name:    name,
+		words:   map[uint16]value{},
+		start:   -1,
+		entries: map[string]bool{},
+	}
+}
+
+// store stores v at loc of s.
+func (s *section) store(loc uint16, v value) {
+	s.words[loc] = v
+	if int(loc) >= s.size {
+		s.size = int(loc) + 1
+	}
+}
+
+// directives are the directives of relocatable source.
+var directives = map[string]func(*asm){
+	"BLOCK":  (*asm).zblock,
+	"COMMON": (*asm).common,
+	"END":    func(a *asm) { a.done = true; a.i = len(a.s) },
+	"ENTRY":  (*asm).entry,
+	"EXTERN": (*asm).extern,
+	"IF":     (*asm).dotif,
+	"LIST":   func(a *asm) { a.list = true },
+	"NOLIST": func(a *asm) { a.list = false },
+	"RADIX":  (*asm).dotradix,
+	"RSECT":  (*asm).dotrsect,
+	"SIXBT":  func(a *asm) { a.text(false) },
+	"START":  (*asm).start,
+	"TEXT":   func(a *asm) { a.text(true) },
+	"TITLE":  func(a *asm) { a.i = len(a.s) },
+}
+
+// names returns the comma separated list of symbols that follows.
+func (a *asm) names() []string {
+	var names []string
+	for {
+		a.space()
+		name := a.name()
+		if name == "" {
+			a.errorf("IP", "")
+			return names
+		}
+		names = append(names, name)
+		a.space()
+		if a.peek() != ',' {
+			return names
+		}
+		a.i++
+	}
+}
+
+// rsect makes section i the current section.
+func (a *asm) rsect(i int) {
+	s := a.sections[a.sect]
+	s.loc, s.pool = a.loc, a.pool
+	s = a.sections[i]
+	a.sect, a.loc, a.pool = i, s.loc, s.pool
+	s.pool = nil
+}
+
+// dotrsect handles .RSECT.
+func (a *asm) dotrsect() {
+	a.space()
+	name := a.name()
+	if name == "" {
+		a.errorf("IP", "")
+		return
+	}
+	for i, s := range a.sections {
+		if s.name == name {
+			a.rsect(i)
+			return
+		}
+	}
+	a.sections = append(a.sections, newSection(name))
+	a.rsect(len(a.sections) - 1)
+}
+
+// entry handles .ENTRY.  The symbols need not be defined until later in the
+// source, so they are made entry points during the second pass.
+func (a *asm) entry() {
+	for _, name := range a.names() {
+		if a.pass != 2 {
+			continue
+		}
+		s := a.user[name]
+		switch {
+		case s == nil || !s.defined:
+			a.errorf("US", name)
+		case s.val.kind != relative:
+			a.errorf("RE", name)
+		default:
+			a.sections[s.val.sect].entries[name] = true
+		}
+	}
+}
+
+// extern handles .EXTERNAL.
+func (a *asm) extern() {
+	for _, name := range a.names() {
+		s := a.user[name]
+		if s != nil && s.defined && s.val.kind != external {
+			a.errorf("ID", name)
+			continue
+		}
+		a.user[name] = &symbol{val: value{kind: external, name: name}, defined: true}
+	}
+}
+
+// common handles .COMMON NAME,SIZE.
+func (a *asm) common() {
+	a.space()
+	name := a.name()
+	a.space()
+	if name == "" || a.peek() != ',' {
+		a.errorf("IP", "")
+		return
+	}
+	a.i++
+	size, ok := a.defined()
+	if !ok || size.kind != absolute {
+		a.errorf("IP", "")
+		return
+	}
+	s := a.user[name]
+	if s != nil && s.defined && s.val.kind != common {
+		a.errorf("ID", name)
+		return
+	}
+	a.user[name] = &symbol{val: value{kind: common, name: name}, defined: true}
+	if int(size.v) > a.commons[name] {
+		a.commons[name] = int(size.v)
+	}
+}
+
+// start handles .START.
+func (a *asm) start() {
+	v, ok := a.defined()
+	switch {
+	case !ok:
+		a.errorf("US", "")
+	case v.kind != relative:
+		a.errorf("RE", "")
+	default:
+		a.sections[v.sect].start = int(v.v)
+	}
+}
+
+// dotradix handles .RADIX N, where N is always decimal.
+func (a *asm) dotradix() {
+	a.space()
+	n := 0
+	for ; isDigit(a.peek()); a.i++ {
+		n = n*10 + int(a.s[a.i]-'0')
+	}
+	if n != 8 && n != 10 {
+		a.errorf("IP", "")
+		return
+	}
+	a.radix = n
+}
+
+// dotif handles .IF COND ARG <...>.
+func (a *asm) dotif() {
+	a.space()
+	cond := a.name()
+	a.space()
+	var ok bool
+	switch cond {
+	case "EQ", "NE", "GT", "GE", "LT", "LE":
+		v := a.instruction()
+		n := int(v.v)
+		if n&04000 != 0 {
+			n -= 010000
+		}
+		switch cond {
+		case "EQ":
+			ok = n == 0
+		case "NE":
+			ok = n != 0
+		case "GT":
+			ok = n > 0
+		case "GE":
+			ok = n >= 0
+		case "LT":
+			ok = n < 0
+		case "LE":
+			ok = n <= 0
+		}
+	case "DF", "NDF":
+		name := a.name()
+		s := a.user[name]
+		_, perm := a.perm[name]
+		_, mri := a.mris[name]
+		ok = (s != nil && s.defined || perm || mri) == (cond == "DF")
+	case "BL", "NB":
+		start := a.i
+		for a.i < len(a.s) && a.s[a.i] != ',' && a.s[a.i] != '<' {
+			a.i++
+		}
+		blank := strings.TrimSpace(a.s[start:a.i]) == ""
+		ok = blank == (cond == "BL")
+	default:
+		a.errorf("IP", "")
+		a.i = len(a.s)
+		return
+	}
+	a.space()
+	if a.peek() == ',' {
+		a.i++
+	}
+	a.cond(ok)
+}
+
+// modules returns the modules assembled from the sections.  The first section
+// is omitted if it is empty.
+func (a *asm) modules() []*reloc.Module {
+	var modules []*reloc.Module
+	for i, s := range a.sections {
+		if i == 0 && s.size == 0 && len(s.entries) == 0 && len(a.sections) > 1 {
+			continue
+		}
+		modules = append(modules, a.module(s))
+	}
+	return modules
+}
+
+// module returns the module assembled from s.
+func (a *asm) module(s *section) *reloc.Module {
+	m := &reloc.Module{
+		Name:  s.name,
+		Code:  make([]uint16, s.size),
+		Start: s.start,
+	}
+	externals := map[string]int{}
+	commons := map[string]int{}
+	var locs []int
+	for loc := range s.words {
+		locs = append(locs, int(loc))
+	}
+	sort.Ints(locs)
+	for _, loc := range locs {
+		v := s.words[uint16(loc)]
+		m.Code[loc] = v.v
+		switch v.kind {
+		case relative:
+			m.Relocs = append(m.Relocs, reloc.Reloc{Addr: loc, Kind: reloc.Relative})
+		case external:
+			n, ok := externals[v.name]
+			if !ok {
+				n = len(m.Externals)
+				externals[v.name] = n
+				m.Externals = append(m.Externals, v.name)
+			}
+			m.Relocs = append(m.Relocs, reloc.Reloc{Addr: loc, Kind: reloc.External, Index: n})
+		case common:
+			n, ok := commons[v.name]
+			if !ok {
+				n = len(m.Commons)
+				commons[v.name] = n
+				m.Commons = append(m.Commons, reloc.Block{Name: v.name, Size: a.commons[v.name]})
+			}
+			m.Relocs = append(m.Relocs, reloc.Reloc{Addr: loc, Kind: reloc.Common, Index: n})
+		}
+	}
+	var names []string
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.Entries = append(m.Entries, reloc.Symbol{Name: name, Addr: int(a.user[name].val.v)})
+	}
+	return m
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package pal
+
+// mris are the permanent memory reference instructions.
+var mris = map[string]uint16{
+	"AND": 00000, "TAD": 01000, "ISZ": 02000, "DCA": 03000, "JMS": 04000, "JMP": 05000,
+}
+
+// permanent are the permanent symbols of PAL8, other than the memory reference
+// instructions and pseudo-ops.
+var permanent = map[string]uint16{
+	// Operate microinstructions, group 1
+	"NOP": 07000, "IAC": 07001, "BSW": 07002, "RAL": 07004, "RTL": 07006,
+	"RAR": 07010, "RTR": 07012, "CML": 07020, "CMA": 07040, "CIA": 07041,
+	"CLL": 07100, "STL": 07120, "CLA": 07200, "GLK": 07204, "STA": 07240,
+
+	// Operate microinstructions, group 2
+	"HLT": 07402, "OSR": 07404, "SKP": 07410, "SNL": 07420, "SZL": 07430,
+	"SZA": 07440, "SNA": 07450, "SMA": 07500, "SPA": 07510, "LAS": 07604,
+
+	// Operate microinstructions, group 3 (MQ and EAE)
+	"MQL": 07421, "MQA": 07501, "SWP": 07521, "CAM": 07621, "ACL": 07701,
+	"SCA": 07441, "SCL": 07403, "MUY": 07405, "DVI": 07407, "NMI": 07411,
+	"SHL": 07413, "ASR": 07415, "LSR": 07417,
+
+	// Processor and interrupt IOTs
+	"SKON": 06000, "ION": 06001, "IOF": 06002, "SRQ": 06003,
+	"GTF": 06004, "RTF": 06005, "SGT": 06006, "CAF": 06007,
+
+	// Paper tape reader and punch
+	"RPE": 06010, "RSF": 06011, "RRB": 06012, "RFC": 06014,
+	"PCE": 06020, "PSF": 06021, "PCF": 06022, "PPC": 06024, "PLS": 06026,
+
+	// Console keyboard and teleprinter
+	"KCF": 06030, "KSF": 06031, "KCC": 06032, "KRS": 06034, "KIE": 06035, "KRB": 06036,
+	"TFL": 06040, "TSF": 06041, "TCF": 06042, "TPC": 06044, "SPI": 06045, "TLS": 06046,
+
+	// Memory extension
+	"CDF": 06201, "CIF": 06202, "RDF": 06214, "RIF": 06224, "RIB": 06234, "RMF": 06244,
+	"CINT": 06204, "SINT": 06254, "CUF": 06264, "SUF": 06274,
+
+	// Memory reference modifiers
+	"I": 00400, "Z": 00000,
+}
+
+// pseudo are the pseudo-ops, keyed by the 6 significant characters of their
+// names.
+var pseudo = map[string]func(*asm){
+	"DECIMA": func(a *asm) { a.radix = 10 },
+	"OCTAL":  func(a *asm) { a.radix = 8 },
+	"DEVICE": (*asm).device,
+	"EJECT":  func(a *asm) { a.i = len(a.s) },
+	"ENPUNC": func(a *asm) { a.punch = true },
+	"EXPUNG": func(a *asm) { a.perm, a.mris = map[string]uint16{}, map[string]uint16{} },
+	"FIELD":  (*asm).field,
+	"FILENA": (*asm).filename,
+	"FIXMRI": (*asm).fixmri,
+	"FIXTAB": (*asm).fixtab,
+	"IFDEF":  func(a *asm) { a.ifdef(true) },
+	"IFNDEF": func(a *asm) { a.ifdef(false) },
+	"IFNZRO": func(a *asm) { a.ifzero(false) },
+	"IFZERO": func(a *asm) { a.ifzero(true) },
+	"NOPUNC": func(a *asm) { a.punch = false },
+	"PAGE":   (*asm).page,
+	"SIXBIT": func(a *asm) { a.text(false) },
+	"TEXT":   func(a *asm) { a.text(true) },
+	"XLIST":  func(a *asm) { a.list = !a.list },
+	"ZBLOCK": (*asm).zblock,
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package papertape
+
+import (
+	"fmt"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// A ChecksumError is returned when the checksum on a tape does not match the
+// checksum computed from the data on the tape.
+type ChecksumError struct {
+	Want uint16 // Checksum on the tape
+	Got  uint16 // Checksum of the data
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: tape has %04o, data sums to %04o", e.Want, e.Got)
+}
+
+// DecodeBIN decodes data as a BIN format tape.  Decoding stops at the trailer.
+// BIN tapes do not record a starting address, so the returned image does not
+// have one.  The caller must supply the starting address, if needed.
+//
+// If the checksum on the tape does not match the data, the decoded image is
+// returned along with a *ChecksumError.
+func DecodeBIN(data []byte) (*core.Image, error) {
+	f := newFrames(data)
+	if _, ok := f.peek(); !ok {
+		return nil, ErrNoData
+	}
+	img := core.New()
+	var (
+		field   int
+		addr    uint16
+		sum     uint16
+		pending bool      // a data word has been read but not loaded
+		paddr   core.Addr // address of the pending word
+		pword   uint16    // value of the pending word
+		psum    uint16    // sum of the pending word's frames
+	)
+	for {
+		c1, ok := f.next()
+		if !ok || c1 == leader {
+			break
+		}
+		if c1&0300 == 0300 {
+			field = int(c1>>3) & 07
+			continue
+		}
+		c2, ok := f.next()
+		if !ok || c2&0300 != 0 {
+			return img, ErrTruncated
+		}
+		// Only now do we know the pending word was not the checksum.
+		if pending {
+			img.Load(paddr, pword)
+			sum += psum
+			pending = false
+		}
+		w := uint16(c1&077)<<6 | uint16(c2)
+		if c1&0100 != 0 {
+			addr = w
+			sum += uint16(c1) + uint16(c2)
+			continue
+		}
+		pending = true
+		paddr = core.MakeAddr(field, addr)
+		pword = w
+		psum = uint16(c1) + uint16(c2)
+		addr = (addr + 1) & 07777
+	}
+	if !pending {
+		return img, fmt.Errorf("missing checksum")
+	}
+	if sum&07777 != pword {
+		return img, &ChecksumError{Want: pword, Got: sum & 07777}
+	}
+	return img, nil
+}
+
+// EncodeBIN returns the loaded words of img as a BIN format tape, including
+// leader, trailer, and checksum.  A field setting is written before the data of
+// each field other than field 0.
+func EncodeBIN(img *core.Image) []byte {
+	data := leaderFrames()
+	var sum uint16
+	frame := func(c byte) {
+		data = append(data, c)
+		sum += uint16(c)
+	}
+	field := 0
+	for _, r := range img.Ranges() {
+		if f := r.Start.Field(); f != field {
+			data = append(data, byte(0300|f<<3))
+			field = f
+		}
+		o := r.Start.Offset()
+		frame(byte(0100 | (o>>6)&077))
+		frame(byte(o & 077))
+		for a := r.Start; a < r.End; a++ {
+			w := img.Word(a)
+			frame(byte((w >> 6) & 077))
+			frame(byte(w & 077))
+		}
+	}
+	sum &= 07777
+	data = append(data, byte(sum>>6), byte(sum&077))
+	return append(data, leaderFrames()...)
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package papertape
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// tape returns frames surrounded by leader and trailer.
+func tape(frames ...byte) []byte {
+	data := append(leaderFrames(), frames...)
+	return append(data, leaderFrames()...)
+}
+
+// binTape is a BIN tape that loads CLA CLL and HLT at 0200 and 0201.
+var binTape = tape(
+	0102, 000, // origin 0200
+	073, 000, // 7300
+	074, 002, // 7402
+	002, 073, // checksum 0273
+)
+
+func TestDecodeBIN(t *testing.T) {
+	img, err := DecodeBIN(binTape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Ranges(), []core.Range{{Start: 0200, End: 0202}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ranges %v, want %v", got, want)
+	}
+	if img.Word(0200) != 07300 || img.Word(0201) != 07402 {
+		t.Errorf("got %04o %04o, want 7300 7402", img.Word(0200), img.Word(0201))
+	}
+	if img.HasStart {
+		t.Errorf("BIN tape has starting address %v", img.Start)
+	}
+}
+
+func TestEncodeBIN(t *testing.T) {
+	img := core.New()
+	img.Load(0200, 07300)
+	img.Load(0201, 07402)
+	if got := EncodeBIN(img); !bytes.Equal(got, binTape) {
+		t.Errorf("got %o, want %o", got, binTape)
+	}
+}
+
+func TestBINFields(t *testing.T) {
+	img := core.New()
+	for i := uint16(0); i < 10; i++ {
+		img.Load(core.MakeAddr(0, 0200+i), 07000+i)
+		img.Load(core.MakeAddr(1, 04000+i), 01234+i)
+		img.Load(core.MakeAddr(0, 0170+i), 0100+i)
+	}
+	data := EncodeBIN(img)
+	if bytes.IndexByte(data, 0310) < 0 {
+		t.Error("no field 1 setting on tape")
+	}
+	got, err := DecodeBIN(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Ranges(), img.Ranges()) {
+		t.Errorf("got ranges %v, want %v", got.Ranges(), img.Ranges())
+	}
+	for _, r := range img.Ranges() {
+		for a := r.Start; a < r.End; a++ {
+			if got.Word(a) != img.Word(a) {
+				t.Errorf("%v: got %04o, want %04o", a, got.Word(a), img.Word(a))
+			}
+		}
+	}
+	if got.HasStart {
+		t.Errorf("BIN tape has starting address %v", got.Start)
+	}
+}
+
+func TestDecodeBINErrors(t *testing.T) {
+	bad := append([]byte{}, binTape...)
+	bad[LeaderLength+3]++
+	img, err := DecodeBIN(bad)
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) || cerr.Want != 0273 || cerr.Got != 0274 {
+		t.Errorf("got %v, want a checksum error", err)
+	}
+	if img == nil || img.Word(0200) != 07301 {
+		t.Error("image not returned with a checksum error")
+	}
+	if _, err := DecodeBIN(leaderFrames()); err != ErrNoData {
+		t.Errorf("blank tape got %v, want %v", err, ErrNoData)
+	}
+	if _, err := DecodeBIN(append(leaderFrames(), 0102, 000, 073)); err != ErrTruncated {
+		t.Errorf("short tape got %v, want %v", err, ErrTruncated)
+	}
+
+	// Rubouts are ignored.
+	withRubouts := tape(0102, 000, rubout, 073, 000, 074, rubout, 002, 002, 073)
+	if _, err := DecodeBIN(withRubouts); err != nil {
+		t.Errorf("tape with rubouts: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package papertape reads and writes PDP-8 paper tape formats.
+//
+// Paper tape has 8 channels per frame (byte).  Channel 8 is the most
+// significant bit (0200) and channel 1 the least significant (0001).  Binary
+// tapes start with leader and end with trailer, both of which are frames with
+// only channel 8 punched (0200).  Rubout frames (0377) are ignored.
+//
+// A BIN (binary loader) tape holds each 12 bit word in two frames, the upper 6
+// bits in the first frame and the lower 6 bits in the second.  Channel 7 set in
+// the first frame of a pair indicates the pair is an origin rather than a data
+// word.  Data words are loaded at consecutive addresses starting at the most
+// recent origin:
+//
+//	+--------+--------+
+//	|01hhhhhh|00llllll|   Origin hhhhhhllllll
+//	+--------+--------+
+//	|00hhhhhh|00llllll|   Data word hhhhhhllllll
+//	+--------+--------+
+//
+// A single frame with channels 8 and 7 punched, 11fff000 (0300-0370), changes
+// the field that following data is loaded into to fff.
+//
+// The last word before the trailer is a checksum.  The checksum is the sum,
+// modulo 4096, of all the origin and data frames (including channel 7 of the
+// origin frames).  Field settings and the checksum itself are not included.
+package papertape
+
+import "errors"
+
+const (
+	leader = 0200 // leader and trailer code
+	rubout = 0377 // ignored frame
+)
+
+// LeaderLength is the number of leader and trailer frames written to tapes.
+const LeaderLength = 64
+
+var (
+	// ErrNoData is returned when a tape has no data.
+	ErrNoData = errors.New("no data on tape")
+
+	// ErrTruncated is returned when a tape ends in the middle of a word.
+	ErrTruncated = errors.New("truncated tape")
+)
+
+// frames reads frames from a tape, skipping rubouts.
+type frames struct {
+	data []byte
+	i    int
+}
+
+// newFrames returns a frame reader for data positioned after the leader.  Blank
+// tape (0 frames) and rubouts preceding the leader are also skipped.
+func newFrames(data []byte) *frames {
+	f := &frames{data: data}
+	for f.i < len(data) {
+		switch data[f.i] {
+		case 0, leader, rubout:
+			f.i++
+			continue
+		}
+		break
+	}
+	return f
+}
+
+// peek returns the next frame without consuming it.
+func (f *frames) peek() (byte, bool) {
+	for f.i < len(f.data) {
+		if c := f.data[f.i]; c != rubout {
+			return c, true
+		}
+		f.i++
+	}
+	return 0, false
+}
+
+// next returns the next frame.
+func (f *frames) next() (byte, bool) {
+	c, ok := f.peek()
+	if ok {
+		f.i++
+	}
+	return c, ok
+}
+
+// leaderFrames returns LeaderLength leader frames.
+func leaderFrames() []byte {
+	data := make([]byte, LeaderLength)
+	for i := range data {
+		data[i] = leader
+	}
+	return data
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package papertape
+
+import (
+	"fmt"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// A Format is a paper tape format.
+type Format int
+
+const (
+	Unknown = Format(iota)
+	BIN     // BIN loader format
+	RIM     // Read-in mode loader format
+)
+
+func (f Format) String() string {
+	switch f {
+	case BIN:
+		return "BIN"
+	case RIM:
+		return "RIM"
+	default:
+		return "unknown"
+	}
+}
+
+// DecodeRIM decodes data as a RIM format tape.  A RIM tape, read by the RIM
+// loader toggled in from the front panel, has an origin before every data word
+// and has neither field settings nor a checksum:
+//
+//	+--------+--------+
+//	|01hhhhhh|00llllll|   Address hhhhhhllllll
+//	+--------+--------+
+//	|00hhhhhh|00llllll|   Data word hhhhhhllllll
+//	+--------+--------+
+//
+// Decoding stops at the trailer.  RIM tapes do not record a starting address,
+// so the returned image does not have one.  All words are loaded into field 0.
+func DecodeRIM(data []byte) (*core.Image, error) {
+	f := newFrames(data)
+	if _, ok := f.peek(); !ok {
+		return nil, ErrNoData
+	}
+	img := core.New()
+	for {
+		a, err := f.word()
+		if err != nil {
+			return img, err
+		}
+		if a < 0 {
+			return img, nil
+		}
+		if a&010000 == 0 {
+			return img, fmt.Errorf("data word %04o without an address", a)
+		}
+		w, err := f.word()
+		if err != nil {
+			return img, err
+		}
+		if w < 0 || w&010000 != 0 {
+			return img, fmt.Errorf("address %04o without a data word", a&07777)
+		}
+		img.Load(core.MakeAddr(0, uint16(a)), uint16(w))
+	}
+}
+
+// word reads a two frame word.  The word is returned with 010000 set if
+// channel 7 was punched in the first frame.  -1 is returned at the trailer or
+// the end of the tape.
+func (f *frames) word() (int, error) {
+	c1, ok := f.next()
+	if !ok || c1 == leader {
+		return -1, nil
+	}
+	if c1&0200 != 0 {
+		return 0, fmt.Errorf("unexpected frame %03o", c1)
+	}
+	c2, ok := f.next()
+	if !ok || c2&0300 != 0 {
+		return 0, ErrTruncated
+	}
+	w := int(c1&077)<<6 | int(c2)
+	if c1&0100 != 0 {
+		w |= 010000
+	}
+	return w, nil
+}
+
+// EncodeRIM returns the loaded words of img as a RIM format tape, including
+// leader and trailer.  RIM tapes can only be loaded into field 0, so an error
+// is returned if img has words in any other field.
+func EncodeRIM(img *core.Image) ([]byte, error) {
+	data := leaderFrames()
+	for _, r := range img.Ranges() {
+		if r.Start.Field() != 0 {
+			return nil, fmt.Errorf("RIM tapes cannot load field %o", r.Start.Field())
+		}
+		for a := r.Start; a < r.End; a++ {
+			o, w := a.Offset(), img.Word(a)
+			data = append(data,
+				byte(0100|(o>>6)&077), byte(o&077),
+				byte((w>>6)&077), byte(w&077))
+		}
+	}
+	return append(data, leaderFrames()...), nil
+}
+
+// Detect returns the format of the tape data.  A tape whose origins and data
+// words strictly alternate, and which has no field settings, is a RIM tape.
+// Any other tape that starts with an origin or field setting is a BIN tape.
+func Detect(data []byte) Format {
+	f := newFrames(data)
+	c, ok := f.peek()
+	if !ok || c&0100 == 0 {
+		return Unknown
+	}
+	n := 0
+	for {
+		c, ok := f.peek()
+		if !ok || c == leader {
+			break
+		}
+		if c&0300 == 0300 {
+			return BIN
+		}
+		w, err := f.word()
+		if err != nil {
+			return Unknown
+		}
+		// Even words are addresses and odd words are data
+		if (w&010000 != 0) != (n%2 == 0) {
+			return BIN
+		}
+		n++
+	}
+	// A BIN tape with a single word (the checksum) looks like a RIM tape
+	// with a single word.  It does not matter how it is decoded.
+	if n%2 != 0 {
+		return BIN
+	}
+	return RIM
+}
+
+// Origin returns the first origin on data, a BIN or RIM tape, in the field
+// set when the origin is read.  This is where the tape starts loading and,
+// by convention, where the program on the tape starts.  False is returned if
+// the tape has no origin.
+func Origin(data []byte) (core.Addr, bool) {
+	f := newFrames(data)
+	field := 0
+	for {
+		c1, ok := f.next()
+		if !ok || c1 == leader {
+			return 0, false
+		}
+		if c1&0300 == 0300 {
+			field = int(c1>>3) & 07
+			continue
+		}
+		c2, ok := f.next()
+		if !ok || c2&0300 != 0 {
+			return 0, false
+		}
+		if c1&0100 != 0 {
+			return core.MakeAddr(field, uint16(c1&077)<<6|uint16(c2)), true
+		}
+	}
+}
+
+// Decode decodes data as either a BIN or RIM tape, as determined by Detect.
+func Decode(data []byte) (*core.Image, Format, error) {
+	switch format := Detect(data); format {
+	case BIN:
+		img, err := DecodeBIN(data)
+		return img, format, err
+	case RIM:
+		img, err := DecodeRIM(data)
+		return img, format, err
+	default:
+		return nil, format, fmt.Errorf("not a BIN or RIM tape")
+	}
+}
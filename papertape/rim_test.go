@@ -0,0 +1,98 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package papertape
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// rimTape is a RIM tape that loads CLA CLL and HLT at 0200 and 0201.
+var rimTape = tape(
+	0102, 000, 073, 000, // 0200: 7300
+	0102, 001, 074, 002, // 0201: 7402
+)
+
+func TestDecodeRIM(t *testing.T) {
+	img, err := DecodeRIM(rimTape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Len() != 2 || img.Word(0200) != 07300 || img.Word(0201) != 07402 {
+		t.Errorf("got %v", img.Ranges())
+	}
+	if img.HasStart {
+		t.Errorf("RIM tape has starting address %v", img.Start)
+	}
+	if _, err := DecodeRIM(tape(0102, 000, 0102, 001)); err == nil {
+		t.Error("address without a data word was accepted")
+	}
+	if _, err := DecodeRIM(tape(073, 000)); err == nil {
+		t.Error("data word without an address was accepted")
+	}
+}
+
+func TestEncodeRIM(t *testing.T) {
+	img := core.New()
+	img.Load(0200, 07300)
+	img.Load(0201, 07402)
+	got, err := EncodeRIM(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, rimTape) {
+		t.Errorf("got %o, want %o", got, rimTape)
+	}
+	img.Load(core.MakeAddr(1, 0200), 0)
+	if _, err := EncodeRIM(img); err == nil {
+		t.Error("RIM tape loading field 1 was accepted")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want Format
+	}{
+		{"bin", binTape, BIN},
+		{"rim", rimTape, RIM},
+	} {
+		if f := Detect(tt.data); f != tt.want {
+			t.Errorf("%s: Detect got %v, want %v", tt.name, f, tt.want)
+		}
+		img, f, err := Decode(tt.data)
+		if err != nil || f != tt.want {
+			t.Errorf("%s: Decode got %v, %v, want %v", tt.name, f, err, tt.want)
+			continue
+		}
+		if img.Word(0200) != 07300 || img.Word(0201) != 07402 {
+			t.Errorf("%s: got %v", tt.name, img.Ranges())
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want core.Addr
+		ok   bool
+	}{
+		{"bin", binTape, 0200, true},
+		{"rim", rimTape, 0200, true},
+		{"field", tape(0320, 0145, 000, 073, 000), 024500, true},
+		{"no origin", tape(073, 000), 0, false},
+		{"empty", nil, 0, false},
+	} {
+		a, ok := Origin(tt.data)
+		if a != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, a, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package reloc reads and writes relocatable binary modules (.RL) and libraries
+// of modules (.LB).
+//
+// The format is specific to this package and the programs in this repository
+// (package pal, 8pal -r, 8macrel, and 8link).  It is not the relocatable format
+// of the OS/8 LOADER (SABR), of RALF and LINK (FORTRAN IV), or of MACREL and
+// LINK.  Modules produced by DEC's tools cannot be read by this package, and
+// modules written by this package cannot be loaded by DEC's loaders.  The
+// format borrows the framing of a BIN tape so the modules may be punched and
+// stored on OS/8 devices.
+//
+// A relocatable module is punched like a BIN tape: it starts with leader and
+// ends with trailer (frames of 0200) and each 12 bit word is punched as two 6
+// bit frames.  A library is simply a sequence of modules.  The words of a
+// module are grouped into items.  Each item starts with a header word whose
+// first frame has channel 7 punched.  The header holds the item type and the
+// number of words that follow it:
+//
+//	+--------+--------+
+//	|01tttttt|00nnnnnn|   Item of type tttttt followed by nnnnnn words
+//	+--------+--------+
+//
+// The item types are:
+//
+//	01 MODULE  name name name length  Start of a module of length words
+//	02 ENTRY   name name name addr    Entry point at relative address addr
+//	03 EXTERN  name name name         External symbol (numbered from 1)
+//	04 COMMON  name name name size    Common block (numbered from 1)
+//	05 ORIGIN  addr                   Set the relative location counter
+//	06 ABS     word ...               Absolute words
+//	07 REL     word ...               Words relocated by the module base
+//	10 EXTREF  n offset               Address of external n plus offset
+//	11 COMREF  n offset               Address of common block n plus offset
+//	12 END     [start]                End of module with optional start
+//
+// Names are 6 characters of 6 bit ASCII, 2 characters per word, padded with
+// @ (0).  Addresses are relative to the base of the module, which the loader
+// chooses.  ABS, REL, EXTREF, and COMREF store words at the location counter
+// and advance it.  Relocated values are truncated to 12 bits.
+package reloc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Item types.
+const (
+	itemModule = 001
+	itemEntry  = 002
+	itemExtern = 003
+	itemCommon = 004
+	itemOrigin = 005
+	itemAbs    = 006
+	itemRel    = 007
+	itemExtRef = 010
+	itemComRef = 011
+	itemEnd    = 012
+)
+
+const (
+	leader   = 0200
+	rubout   = 0377
+	maxCount = 077 // maximum words following an item header
+)
+
+// A Kind is the kind of relocation applied to a word.
+type Kind int
+
+const (
+	Relative = Kind(iota) // add the module base
+	External              // add the address of an external symbol
+	Common                // add the address of a common block
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Relative:
+		return "REL"
+	case External:
+		return "EXT"
+	case Common:
+		return "COM"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// A Reloc is a relocation record.  The word at Addr in the module's code holds
+// an offset which the loader adds to the module base, the address of external
+// Externals[Index], or the address of common block Commons[Index].
+type Reloc struct {
+	Addr  int  // Relative address of the word to relocate
+	Kind  Kind // What to add to the word
+	Index int  // Index into Externals or Commons
+}
+
+// A Symbol is an entry point.
+type Symbol struct {
+	Name string
+	Addr int // Relative address
+}
+
+// A Block is a common block.
+type Block struct {
+	Name string
+	Size int // Size in words
+}
+
+// A Module is a single relocatable module.
+type Module struct {
+	Name      string
+	Code      []uint16 // Contents of the module, before relocation
+	Entries   []Symbol // Entry points
+	Externals []string // External symbols referenced
+	Commons   []Block  // Common blocks referenced
+	Relocs    []Reloc  // Relocation records, ordered by address
+	Start     int      // Relative starting address, or -1
+}
+
+// Entry returns the entry point of m called name, or nil.
+func (m *Module) Entry(name string) *Symbol {
+	for i, e := range m.Entries {
+		if e.Name == name {
+			return &m.Entries[i]
+		}
+	}
+	return nil
+}
+
+// ErrNoModule is returned when data contains no modules.
+var ErrNoModule = errors.New("no relocatable module")
+
+// Decode decodes the modules in data.  data is a single module (a .RL file) or
+// a library of modules (a .LB file).
+func Decode(data []byte) ([]*Module, error) {
+	r := &reader{data: data}
+	var modules []*Module
+	for {
+		r.skipLeader()
+		if r.eof() {
+			break
+		}
+		m, err := r.module()
+		if err != nil {
+			if m != nil {
+				err = fmt.Errorf("%s: %v", m.Name, err)
+			}
+			return modules, err
+		}
+		modules = append(modules, m)
+	}
+	if len(modules) == 0 {
+		return nil, ErrNoModule
+	}
+	return modules, nil
+}
+
+// Encode returns m as a relocatable module including leader and trailer.
+func (m *Module) Encode() []byte {
+	w := &writer{}
+	w.leader()
+	w.item(itemModule, append(sixbit(m.Name), uint16(len(m.Code))))
+	for _, e := range m.Entries {
+		w.item(itemEntry, append(sixbit(e.Name), uint16(e.Addr)))
+	}
+	for _, e := range m.Externals {
+		w.item(itemExtern, sixbit(e))
+	}
+	for _, c := range m.Commons {
+		w.item(itemCommon, append(sixbit(c.Name), uint16(c.Size)))
+	}
+	relocs := map[int]Reloc{}
+	for _, r := range m.Relocs {
+		relocs[r.Addr] = r
+	}
+	w.item(itemOrigin, []uint16{0})
+	for a := 0; a < len(m.Code); {
+		r, ok := relocs[a]
+		switch {
+		case ok && r.Kind == External:
+			w.item(itemExtRef, []uint16{uint16(r.Index + 1), m.Code[a]})
+			a++
+			continue
+		case ok && r.Kind == Common:
+			w.item(itemComRef, []uint16{uint16(r.Index + 1), m.Code[a]})
+			a++
+			continue
+		}
+		// Collect a run of words with the same relocation.
+		typ := itemAbs
+		if ok {
+			typ = itemRel
+		}
+		n := 0
+		for a+n < len(m.Code) && n < maxCount {
+			r, ok := relocs[a+n]
+			if ok != (typ == itemRel) || (ok && r.Kind != Relative) {
+				break
+			}
+			n++
+		}
+		w.item(typ, m.Code[a:a+n])
+		a += n
+	}
+	if m.Start >= 0 {
+		w.item(itemEnd, []uint16{uint16(m.Start)})
+	} else {
+		w.item(itemEnd, nil)
+	}
+	w.leader()
+	return w.data
+}
+
+// EncodeLibrary returns modules as a library.
+func EncodeLibrary(modules []*Module) []byte {
+	var data []byte
+	for _, m := range modules {
+		data = append(data, m.Encode()...)
+	}
+	return data
+}
+
+// A reader reads words and items from a module.
+type reader struct {
+	data []byte
+	i    int
+}
+
+func (r *reader) eof() bool {
+	return r.i >= len(r.data)
+}
+
+// skipLeader skips leader, rubouts and blank tape.
+func (r *reader) skipLeader() {
+	for r.i < len(r.data) {
+		switch r.data[r.i] {
+		case 0, leader, rubout:
+			r.i++
+			continue
+		}
+		return
+	}
+}
+
+func (r *reader) frame() (byte, error) {
+	for r.i < len(r.data) {
+		c := r.data[r.i]
+		r.i++
+		if c != rubout {
+			return c, nil
+		}
+	}
+	return 0, errors.New("unexpected end of module")
+}
+
+// word reads the next word.  header is true if the word is an item header.
+func (r *reader) word() (w uint16, header bool, err error) {
+	c1, err := r.frame()
+	if err != nil {
+		return 0, false, err
+	}
+	c2, err := r.frame()
+	if err != nil {
+		return 0, false, err
+	}
+	if c1&0200 != 0 || c2&0300 != 0 {
+		return 0, false, fmt.Errorf("invalid frames %03o %03o", c1, c2)
+	}
+	return uint16(c1&077)<<6 | uint16(c2), c1&0100 != 0, nil
+}
+
+// item reads the next item.
+func (r *reader) item() (int, []uint16, error) {
+	h, header, err := r.word()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !header {
+		return 0, nil, fmt.Errorf("expected item header, got %04o", h)
+	}
+	words := make([]uint16, h&077)
+	for i := range words {
+		w, header, err := r.word()
+		if err != nil {
+			return 0, nil, err
+		}
+		if header {
+			return 0, nil, fmt.Errorf("item %02o: short item", h>>6)
+		}
+		words[i] = w
+	}
+	return int(h >> 6), words, nil
+}
+
+// module reads one module.
+func (r *reader) module() (*Module, error) {
+	typ, words, err := r.item()
+	if err != nil {
+		return nil, err
+	}
+	if typ != itemModule || len(words) != 4 {
+		return nil, fmt.Errorf("module does not start with a MODULE item")
+	}
+	m := &Module{
+		Name:  unsixbit(words[:3]),
+		Code:  make([]uint16, words[3]),
+		Start: -1,
+	}
+	loc := 0
+	store := func(w uint16) error {
+		if loc >= len(m.Code) {
+			return fmt.Errorf("word stored past end of module (%04o)", loc)
+		}
+		m.Code[loc] = w
+		loc++
+		return nil
+	}
+	for {
+		typ, words, err := r.item()
+		if err != nil {
+			return m, err
+		}
+		bad := false
+		switch typ {
+		case itemEntry:
+			bad = len(words) != 4
+			if !bad {
+				m.Entries = append(m.Entries, Symbol{Name: unsixbit(words[:3]), Addr: int(words[3])})
+			}
+		case itemExtern:
+			bad = len(words) != 3
+			if !bad {
+				m.Externals = append(m.Externals, unsixbit(words))
+			}
+		case itemCommon:
+			bad = len(words) != 4
+			if !bad {
+				m.Commons = append(m.Commons, Block{Name: unsixbit(words[:3]), Size: int(words[3])})
+			}
+		case itemOrigin:
+			bad = len(words) != 1
+			if !bad {
+				loc = int(words[0])
+			}
+		case itemAbs, itemRel:
+			for _, w := range words {
+				if typ == itemRel {
+					m.Relocs = append(m.Relocs, Reloc{Addr: loc, Kind: Relative})
+				}
+				if err := store(w); err != nil {
+					return m, err
+				}
+			}
+		case itemExtRef, itemComRef:
+			bad = len(words) != 2
+			if bad {
+				break
+			}
+			n := int(words[0]) - 1
+			kind := External
+			if typ == itemComRef {
+				kind = Common
+				if n < 0 || n >= len(m.Commons) {
+					return m, fmt.Errorf("undefined common block %d", n+1)
+				}
+			} else if n < 0 || n >= len(m.Externals) {
+				return m, fmt.Errorf("undefined external %d", n+1)
+			}
+			m.Relocs = append(m.Relocs, Reloc{Addr: loc, Kind: kind, Index: n})
+			if err := store(words[1]); err != nil {
+				return m, err
+			}
+		case itemEnd:
+			switch len(words) {
+			case 0:
+			case 1:
+				m.Start = int(words[0])
+			default:
+				bad = true
+			}
+			if !bad {
+				sort.SliceStable(m.Relocs, func(i, j int) bool { return m.Relocs[i].Addr < m.Relocs[j].Addr })
+				return m, nil
+			}
+		default:
+			return m, fmt.Errorf("unknown item type %02o", typ)
+		}
+		if bad {
+			return m, fmt.Errorf("item %02o: wrong length (%d)", typ, len(words))
+		}
+	}
+}
+
+// A writer writes words and items of a module.
+type writer struct {
+	data []byte
+}
+
+func (w *writer) leader() {
+	for i := 0; i < 64; i++ {
+		w.data = append(w.data, leader)
+	}
+}
+
+func (w *writer) item(typ int, words []uint16) {
+	w.data = append(w.data, byte(0100|typ), byte(len(words)))
+	for _, x := range words {
+		w.data = append(w.data, byte((x>>6)&077), byte(x&077))
+	}
+}
+
+// sixbit returns the first 6 characters of name as 3 words of 6 bit ASCII.
+func sixbit(name string) []uint16 {
+	words := make([]uint16, 3)
+	name = strings.ToUpper(name)
+	for i := 0; i < 6 && i < len(name); i++ {
+		words[i/2] |= uint16(name[i]&077) << uint(6*(1-i%2))
+	}
+	return words
+}
+
+// unsixbit returns words, 6 bit ASCII, as a string without trailing @s.
+func unsixbit(words []uint16) string {
+	var b []byte
+	for _, w := range words {
+		for _, c := range []uint16{w >> 6, w & 077} {
+			if c < 040 {
+				c += 0100
+			}
+			b = append(b, byte(c))
+		}
+	}
+	return strings.TrimRight(string(b), "@")
+}
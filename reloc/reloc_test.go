@@ -0,0 +1,111 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package reloc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// module returns frames surrounded by leader and trailer.
+func module(frames ...byte) []byte {
+	w := &writer{}
+	w.leader()
+	w.data = append(w.data, frames...)
+	w.leader()
+	return w.data
+}
+
+// subFrames is the module SUB:
+//
+//	SUB,	JMP .+1		/ relocated
+//		X-1		/ external X minus 1
+//		HLT
+var subFrames = []byte{
+	0101, 004, 023, 025, 002, 000, 000, 000, 000, 003, // MODULE SUB 3
+	0102, 004, 023, 025, 002, 000, 000, 000, 000, 000, // ENTRY SUB 0
+	0103, 003, 030, 000, 000, 000, 000, 000, // EXTERN X
+	0105, 001, 000, 000, // ORIGIN 0
+	0107, 001, 050, 001, // REL 5001
+	0110, 002, 000, 001, 077, 077, // EXTREF 1 7777
+	0106, 001, 074, 002, // ABS 7402
+	0112, 001, 000, 000, // END 0
+}
+
+var subModule = &Module{
+	Name:      "SUB",
+	Code:      []uint16{05001, 07777, 07402},
+	Entries:   []Symbol{{Name: "SUB", Addr: 0}},
+	Externals: []string{"X"},
+	Relocs:    []Reloc{{Addr: 0, Kind: Relative}, {Addr: 1, Kind: External}},
+	Start:     0,
+}
+
+func TestDecode(t *testing.T) {
+	got, err := Decode(module(subFrames...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], subModule) {
+		t.Errorf("got %+v, want %+v", got[0], subModule)
+	}
+	if e := got[0].Entry("SUB"); e == nil || e.Addr != 0 {
+		t.Errorf("Entry(SUB) got %v", e)
+	}
+	if e := got[0].Entry("X"); e != nil {
+		t.Errorf("Entry(X) got %v", e)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	if got, want := subModule.Encode(), module(subFrames...); !bytes.Equal(got, want) {
+		t.Errorf("got %o, want %o", got, want)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	main := &Module{
+		Name:      "MAIN",
+		Code:      []uint16{07200, 01005, 04000, 05002, 0, 0123, 0},
+		Entries:   []Symbol{{"MAIN", 0}},
+		Externals: []string{"SUB"},
+		Commons:   []Block{{"DATA", 010}},
+		Relocs:    []Reloc{{1, Relative, 0}, {3, Relative, 0}, {4, External, 0}, {6, Common, 0}},
+		Start:     -1,
+	}
+	data := EncodeLibrary([]*Module{main, subModule})
+	// Rubouts and blank tape between modules are ignored.
+	data = append(append([]byte{0, 0377}, data...), 0377)
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Module{main, subModule}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"no module", module(0106, 001, 074, 002)},
+		{"unknown item", module(0101, 004, 023, 025, 002, 000, 000, 000, 000, 001, 0177, 000)},
+		{"past end", module(0101, 004, 023, 025, 002, 000, 000, 000, 000, 001, 0106, 002, 0, 0, 0, 0, 0112, 0)},
+		{"undefined external", module(0101, 004, 023, 025, 002, 000, 000, 000, 000, 001, 0110, 002, 0, 2, 0, 0, 0112, 0)},
+		{"short item", module(0101, 004, 023, 025, 002, 000, 000, 000, 0112, 0)},
+		{"no end", subFrames[:len(subFrames)-4]},
+	} {
+		if _, err := Decode(tt.data); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+	if _, err := Decode(module()); err != ErrNoModule {
+		t.Errorf("empty tape got %v, want %v", err, ErrNoModule)
+	}
+}
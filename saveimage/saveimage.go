@@ -0,0 +1,208 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package saveimage reads and writes OS/8 core image (.SV) files.
+//
+// A core image file holds the contents of memory as saved by the OS/8 SAVE
+// command.  The first 128 words of the first block of the file are the Core
+// Control Block (CCB):
+//
+//	+------------+
+//	|010000-nseg |   Number of core segments
+//	+------------+
+//	| 62F3       |   CDF CIF to the starting field F
+//	+------------+
+//	| START      |   Starting address
+//	+------------+
+//	| JSW        |   Job Status Word
+//	+------------+
+//	| SEGMENT 1  |   Core segment control words
+//	+------------+
+//	|    ...     |
+//	+------------+
+//
+// Each core segment control word describes one contiguous segment of memory:
+//
+//	+-----+----+---+
+//	|PPPPP|LLLL|FFF|
+//	+-----+----+---+
+//
+// PPPPP is the page the segment starts on, LLLL is the number of double pages
+// (256 words, one block) in the segment, with 0 meaning 16, and FFF is the
+// field of the segment.  The segments follow the first block of the file in
+// the order they are listed in the CCB, each segment starting on a new block.
+package saveimage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pborman/pdp8/core"
+)
+
+const (
+	blockSize  = 0400 // words per block
+	ccbSize    = 0200 // words in the core control block
+	maxSegment = 16   // maximum double pages in a segment
+)
+
+// A Segment is a contiguous segment of memory saved in a core image.
+type Segment struct {
+	Start core.Addr // First address of the segment
+	Pages int       // Number of pages in the segment (always even)
+}
+
+// Len returns the number of words in s.
+func (s Segment) Len() int {
+	return s.Pages * core.PageSize
+}
+
+func (s Segment) String() string {
+	return fmt.Sprintf("%v-%04o", s.Start, s.Start.Offset()+uint16(s.Len()-1))
+}
+
+// A CCB is the Core Control Block of a core image.
+type CCB struct {
+	Start    core.Addr // Starting address
+	JSW      uint16    // Job Status Word
+	Segments []Segment // Core segments in the order saved
+}
+
+// ErrNoStart is returned by Encode when the image has no starting address.
+var ErrNoStart = errors.New("no starting address")
+
+// DecodeCCB decodes the core control block found at the start of words.
+func DecodeCCB(words []uint16) (*CCB, error) {
+	if len(words) < ccbSize {
+		return nil, fmt.Errorf("core control block too short (%d words)", len(words))
+	}
+	nseg := 010000 - int(words[0])
+	if nseg < 1 || nseg > ccbSize-4 {
+		return nil, fmt.Errorf("invalid segment count: %04o", words[0])
+	}
+	if words[1]&07707 != 06203 {
+		return nil, fmt.Errorf("invalid starting field: %04o", words[1])
+	}
+	ccb := &CCB{
+		Start: core.MakeAddr(int(words[1]>>3)&07, words[2]),
+		JSW:   words[3],
+	}
+	for _, w := range words[4 : 4+nseg] {
+		pages := int(w>>3) & 017
+		if pages == 0 {
+			pages = maxSegment
+		}
+		s := Segment{
+			Start: core.MakeAddr(int(w&07), w&07600),
+			Pages: pages * 2,
+		}
+		if int(s.Start.Offset())+s.Len() > core.FieldSize {
+			return nil, fmt.Errorf("segment %04o extends past end of field", w)
+		}
+		ccb.Segments = append(ccb.Segments, s)
+	}
+	return ccb, nil
+}
+
+// Encode returns the core control block as words.
+func (c *CCB) Encode() []uint16 {
+	words := make([]uint16, ccbSize)
+	words[0] = uint16(010000 - len(c.Segments))
+	words[1] = 06203 | uint16(c.Start.Field())<<3
+	words[2] = c.Start.Offset()
+	words[3] = c.JSW
+	for i, s := range c.Segments {
+		words[4+i] = s.Start.Offset()&07600 | uint16(s.Pages/2%maxSegment)<<3 | uint16(s.Start.Field())
+	}
+	return words
+}
+
+// Decode decodes the core image held in words.  It returns the core control
+// block and the memory image.  The starting address of the memory image is
+// set from the core control block.  Only the memory in the core segments is
+// marked as loaded.
+//
+// If the file is too short to hold all of its segments, the memory image is
+// returned with the segments that could be loaded along with an error.
+func Decode(words []uint16) (*core.Image, *CCB, error) {
+	ccb, err := DecodeCCB(words)
+	if err != nil {
+		return nil, nil, err
+	}
+	img := core.New()
+	img.Start = ccb.Start
+	img.HasStart = true
+	offset := blockSize
+	for _, s := range ccb.Segments {
+		if offset+s.Len() > len(words) {
+			return img, ccb, fmt.Errorf("core image truncated in segment %v", s)
+		}
+		for i, w := range words[offset : offset+s.Len()] {
+			img.Load(s.Start+core.Addr(i), w)
+		}
+		offset += s.Len()
+	}
+	return img, ccb, nil
+}
+
+// Segments returns the segments needed to save the loaded words of img.  A
+// segment is made of consecutive double pages that have at least one word
+// loaded.  Segments do not cross fields or exceed 16 double pages.
+func Segments(img *core.Image) []Segment {
+	var segments []Segment
+	for _, f := range img.Fields() {
+		var s *Segment
+		for dp := 0; dp < core.FieldSize/blockSize; dp++ {
+			start := core.MakeAddr(f, uint16(dp*blockSize))
+			used := false
+			for a := start; a < start+blockSize; a++ {
+				if img.Loaded(a) {
+					used = true
+					break
+				}
+			}
+			if !used || s == nil || s.Pages == maxSegment*2 {
+				s = nil
+			}
+			if !used {
+				continue
+			}
+			if s == nil {
+				segments = append(segments, Segment{Start: start})
+				s = &segments[len(segments)-1]
+			}
+			s.Pages += 2
+		}
+	}
+	return segments
+}
+
+// Encode returns img as a core image with the job status word jsw.  The
+// starting address is taken from img, which must have one.  Memory that is
+// saved, but was not loaded in img, is saved as 0.
+func Encode(img *core.Image, jsw uint16) ([]uint16, error) {
+	if !img.HasStart {
+		return nil, ErrNoStart
+	}
+	ccb := &CCB{
+		Start:    img.Start,
+		JSW:      jsw,
+		Segments: Segments(img),
+	}
+	if len(ccb.Segments) == 0 {
+		return nil, fmt.Errorf("empty core image")
+	}
+	if len(ccb.Segments) > ccbSize-4 {
+		return nil, fmt.Errorf("too many core segments (%d)", len(ccb.Segments))
+	}
+	words := make([]uint16, blockSize)
+	copy(words, ccb.Encode())
+	for _, s := range ccb.Segments {
+		for a := s.Start; a < s.Start+core.Addr(s.Len()); a++ {
+			words = append(words, img.Word(a))
+		}
+	}
+	return words, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package saveimage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// testCCB is the core control block of a program that starts at 1:0200 and
+// saves 0:0000-0777 and 1:7400-7777.
+var testCCB = []uint16{07776, 06213, 00200, 05000, 00020, 07411}
+
+func TestDecodeCCB(t *testing.T) {
+	ccb, err := DecodeCCB(append(testCCB, make([]uint16, ccbSize-len(testCCB))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &CCB{
+		Start: core.MakeAddr(1, 0200),
+		JSW:   05000,
+		Segments: []Segment{
+			{Start: core.MakeAddr(0, 0), Pages: 4},
+			{Start: core.MakeAddr(1, 07400), Pages: 2},
+		},
+	}
+	if !reflect.DeepEqual(ccb, want) {
+		t.Errorf("got %+v, want %+v", ccb, want)
+	}
+	if got := ccb.Encode(); !reflect.DeepEqual(got[:len(testCCB)], testCCB) {
+		t.Errorf("Encode got %04o, want %04o", got[:len(testCCB)], testCCB)
+	}
+	if s := want.Segments[1].String(); s != "1:7400-7777" {
+		t.Errorf("segment is %s, want 1:7400-7777", s)
+	}
+}
+
+func TestDecodeCCBErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		words []uint16
+	}{
+		{"short", testCCB},
+		{"no segments", []uint16{0, 06203, 0200}},
+		{"bad field", []uint16{07777, 06201, 0200, 0, 010}},
+		{"past end of field", []uint16{07777, 06203, 0200, 0, 07420}},
+	} {
+		words := tt.words
+		if tt.name != "short" {
+			words = append(append([]uint16{}, words...), make([]uint16, ccbSize)...)
+		}
+		if _, err := DecodeCCB(words); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	img := core.New()
+	img.Load(0, 1)
+	img.Load(0777, 1)
+	img.Load(03000, 1)
+	img.Load(core.MakeAddr(1, 07777), 1)
+	// Segments do not cross from field 2 into field 3.
+	for a := core.MakeAddr(2, 0); a < core.MakeAddr(3, 01000); a++ {
+		img.Load(a, 1)
+	}
+	got := Segments(img)
+	want := []Segment{
+		{Start: 0, Pages: 4},
+		{Start: 03000, Pages: 2},
+		{Start: core.MakeAddr(1, 07400), Pages: 2},
+		{Start: core.MakeAddr(2, 0), Pages: 32},
+		{Start: core.MakeAddr(3, 0), Pages: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// A whole field is saved as 16 double pages, encoded as 0.
+	ccb := &CCB{Segments: got[3:4]}
+	if w := ccb.Encode()[4]; w != 2 {
+		t.Errorf("whole field segment encoded as %04o, want 0002", w)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	img := core.New()
+	for i := uint16(0); i < 0500; i++ {
+		img.Load(core.MakeAddr(0, 0200+i), i)
+		img.Load(core.MakeAddr(1, 07600+i%0200), 07777)
+	}
+	if _, err := Encode(img, 0); err != ErrNoStart {
+		t.Errorf("Encode without a start got %v, want %v", err, ErrNoStart)
+	}
+	img.Start, img.HasStart = 0200, true
+	words, err := Encode(img, 05000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One block of CCB, 0:0000-0777, and 1:7400-7777.
+	if len(words) != 0400+01000+0400 {
+		t.Errorf("image is %d words, want %d", len(words), 0400+01000+0400)
+	}
+	got, ccb, err := Decode(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ccb.JSW != 05000 || !got.HasStart || got.Start != 0200 {
+		t.Errorf("got JSW %04o and start %v", ccb.JSW, got.Start)
+	}
+	// Unloaded words in a segment are saved as 0.
+	if !got.Loaded(0) || got.Word(0) != 0 {
+		t.Errorf("0:0000 got %04o", got.Word(0))
+	}
+	for _, r := range img.Ranges() {
+		for a := r.Start; a < r.End; a++ {
+			if got.Word(a) != img.Word(a) {
+				t.Fatalf("%v: got %04o, want %04o", a, got.Word(a), img.Word(a))
+			}
+		}
+	}
+
+	// A truncated image returns what could be loaded.
+	got, _, err = Decode(words[:len(words)-1])
+	if err == nil {
+		t.Error("truncated image decoded without error")
+	}
+	if got == nil || got.Word(0300) != 0100 || got.Loaded(core.MakeAddr(1, 07600)) {
+		t.Error("truncated image did not return the first segment")
+	}
+	if _, err := Encode(core.New(), 0); err == nil {
+		t.Error("empty image encoded without error")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package symtab reads symbol tables that name PDP-8 addresses.
+//
+// Symbol tables come from two sources.  ParsePAL8 reads the symbol table
+// printed at the end of a PAL8 listing, or the output of CREF.  Parse reads a
+// simple symbol file written by hand.
+//
+// PAL8 symbols are 12 bit values and do not record a field, so they name
+// their address in every field.  PAL8 does not distinguish labels from other
+// symbols, so a symbol defined as a constant (e.g., CR=215) also names the
+// address with the same value.
+package symtab
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// A Symbol is a name for an address.
+type Symbol struct {
+	Name string
+	Addr core.Addr
+	Any  bool // the symbol names Addr.Offset() in any field
+}
+
+// A Table is a set of symbols.  The first symbol added for an address is the
+// name of the address.
+type Table struct {
+	syms    []Symbol
+	names   map[core.Addr]string // symbols in a specific field
+	any     map[uint16]string    // symbols in any field
+	defined map[string]bool
+}
+
+// New returns a new empty table.
+func New() *Table {
+	return &Table{
+		names:   map[core.Addr]string{},
+		any:     map[uint16]string{},
+		defined: map[string]bool{},
+	}
+}
+
+// Add adds s to t.  Adding a symbol whose name is already in t has no effect.
+func (t *Table) Add(s Symbol) {
+	if t.defined[s.Name] {
+		return
+	}
+	t.defined[s.Name] = true
+	t.syms = append(t.syms, s)
+	if s.Any {
+		if _, ok := t.any[s.Addr.Offset()]; !ok {
+			t.any[s.Addr.Offset()] = s.Name
+		}
+		return
+	}
+	if _, ok := t.names[s.Addr]; !ok {
+		t.names[s.Addr] = s.Name
+	}
+}
+
+// Merge adds the symbols in o to t.
+func (t *Table) Merge(o *Table) {
+	for _, s := range o.syms {
+		t.Add(s)
+	}
+}
+
+// Name returns the name of address a.  A symbol in the field of a is preferred
+// over a symbol in any field.  Name may be called on a nil Table.
+func (t *Table) Name(a core.Addr) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	if name, ok := t.names[a]; ok {
+		return name, true
+	}
+	name, ok := t.any[a.Offset()]
+	return name, ok
+}
+
+// isName reports if s is a PAL8 symbol: a letter followed by up to 5 letters
+// or digits.
+func isName(s string) bool {
+	if len(s) == 0 || len(s) > 6 || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePAL8 returns the symbols in data, a PAL8 listing, a PAL8 symbol table,
+// or CREF output.  Symbol table lines start with a symbol followed by its
+// value in octal.  Anything following the value, such as the line numbers
+// printed by CREF, is ignored.  All other lines, including the numbered
+// source lines of a listing, are ignored.  Symbols name their value in any
+// field.
+func ParsePAL8(data []byte) (*Table, error) {
+	t := New()
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || !isName(fields[0]) || len(fields[1]) > 4 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 8, 12)
+		if err != nil {
+			continue
+		}
+		t.Add(Symbol{Name: fields[0], Addr: core.Addr(v), Any: true})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.syms) == 0 {
+		return nil, fmt.Errorf("no symbols found")
+	}
+	return t, nil
+}
+
+// Parse returns the symbols in data, a symbol file.  Each line of a symbol
+// file defines one symbol as either
+//
+//	NAME VALUE
+//	NAME=VALUE
+//
+// where VALUE is an octal address written as AAAA or F:AAAA.  A symbol without
+// a field names its address in any field.  Lower case names are folded to
+// upper case.  Blank lines and text following a / or # are ignored.
+func Parse(data []byte) (*Table, error) {
+	t := New()
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexAny(text, "/#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(strings.Replace(text, "=", " ", 1))
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+		default:
+			return nil, fmt.Errorf("line %d: want NAME VALUE", line)
+		}
+		name := strings.ToUpper(fields[0])
+		if !isName(name) {
+			return nil, fmt.Errorf("line %d: invalid symbol: %s", line, fields[0])
+		}
+		a, err := core.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		t.Add(Symbol{Name: name, Addr: a, Any: !strings.Contains(fields[1], ":")})
+	}
+	return t, s.Err()
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package symtab
+
+import (
+	"testing"
+
+	"github.com/pborman/pdp8/core"
+)
+
+// listing is the end of a PAL8 listing with its symbol table.
+const listing = `
+    1             *200
+    2 00200  7300 START,  CLA CLL
+    3 00201  1205         TAD K
+    4 00202  4206         JMS SUB
+    5 00203  7402         HLT
+    6 00205  0007 K,      7
+    7 00206  0000 SUB,    0
+    8 00207  5606         JMP I SUB
+
+CR     0215
+K      0205     6     3
+START  0200     2
+SUB    0206     7     4     8
+`
+
+func TestParsePAL8(t *testing.T) {
+	tab, err := ParsePAL8([]byte(listing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		a    core.Addr
+		want string
+		ok   bool
+	}{
+		{0200, "START", true},
+		{0205, "K", true},
+		{core.MakeAddr(3, 0206), "SUB", true},
+		{0215, "CR", true},
+		{0201, "", false},
+		{02, "", false},
+	} {
+		if got, ok := tab.Name(tt.a); got != tt.want || ok != tt.ok {
+			t.Errorf("Name(%v) got %q, %v, want %q, %v", tt.a, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, err := ParsePAL8([]byte("no symbols here\n")); err == nil {
+		t.Error("ParsePAL8 found symbols in plain text")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tab, err := Parse([]byte(`
+# symbols for a test
+start 0200	/ any field
+BUF=1:4000
+OTHER 1:0200
+DUP 0300
+DUP 0400
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		a    core.Addr
+		want string
+		ok   bool
+	}{
+		{0200, "START", true},
+		{core.MakeAddr(1, 0200), "OTHER", true}, // the field wins
+		{core.MakeAddr(2, 0200), "START", true},
+		{core.MakeAddr(1, 04000), "BUF", true},
+		{04000, "", false},
+		{0300, "DUP", true},
+		{0400, "", false}, // the first definition wins
+	} {
+		if got, ok := tab.Name(tt.a); got != tt.want || ok != tt.ok {
+			t.Errorf("Name(%v) got %q, %v, want %q, %v", tt.a, got, ok, tt.want, tt.ok)
+		}
+	}
+	for _, in := range []string{"START", "START 0200 0300", "1ST 0200", "TOOLONG 0200", "START 9"} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	a.Add(Symbol{Name: "A", Addr: 0200, Any: true})
+	b.Add(Symbol{Name: "B", Addr: 0200, Any: true})
+	b.Add(Symbol{Name: "C", Addr: 0300, Any: true})
+	a.Merge(b)
+	if name, _ := a.Name(0200); name != "A" {
+		t.Errorf("0200 got %s, want A", name)
+	}
+	if name, _ := a.Name(0300); name != "C" {
+		t.Errorf("0300 got %s, want C", name)
+	}
+	var nilTable *Table
+	if _, ok := nilTable.Name(0200); ok {
+		t.Error("nil table has a name")
+	}
+}
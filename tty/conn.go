@@ -0,0 +1,163 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package tty
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A conn is a connection of a KL8E to the host.
+type conn struct {
+	rw   io.ReadWriter
+	out  chan byte     // characters to write to rw
+	done chan struct{} // closed when the connection ends
+	once sync.Once
+
+	pending int32 // characters sent to out but not yet written
+}
+
+// close ends c, closing c.rw if it is an io.Closer.
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		if cl, ok := c.rw.(io.Closer); ok {
+			cl.Close()
+		}
+	})
+}
+
+// Connect connects k to rw, ending any previous connection.  Characters read
+// from rw are typed on the keyboard and characters printed are written to rw.
+//
+// If rw is an io.Closer then the connection ends, and rw is closed, when
+// reading or writing rw fails.  Otherwise the connection lasts until it is
+// replaced or Disconnect is called, typing nothing more once reading fails and
+// discarding characters once writing fails.
+func (k *KL8E) Connect(rw io.ReadWriter) {
+	c := &conn{
+		rw:   rw,
+		out:  make(chan byte, 256),
+		done: make(chan struct{}),
+	}
+	k.mu.Lock()
+	old := k.conn
+	k.conn = c
+	k.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	go k.read(c)
+	go k.write(c)
+}
+
+// Disconnect ends the connection of k, if any.
+func (k *KL8E) Disconnect() {
+	k.mu.Lock()
+	c := k.conn
+	k.conn = nil
+	k.mu.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// Connected reports if k is connected.
+func (k *KL8E) Connected() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.conn != nil
+}
+
+// Flush waits up to timeout for the characters printed on k to be written to
+// its connection and reports if they were.
+func (k *KL8E) Flush(timeout time.Duration) bool {
+	k.mu.Lock()
+	c := k.conn
+	k.mu.Unlock()
+	if c == nil {
+		return true
+	}
+	end := time.Now().Add(timeout)
+	for atomic.LoadInt32(&c.pending) > 0 {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(time.Millisecond):
+		}
+		if time.Now().After(end) {
+			return false
+		}
+	}
+	return true
+}
+
+// fail handles a failure reading or writing c.
+func (k *KL8E) fail(c *conn) {
+	if _, ok := c.rw.(io.Closer); !ok {
+		return
+	}
+	k.mu.Lock()
+	if k.conn == c {
+		k.conn = nil
+	}
+	k.mu.Unlock()
+	c.close()
+}
+
+// read types the characters read from c until reading fails or c ends.
+func (k *KL8E) read(c *conn) {
+	var buf [256]byte
+	for {
+		n, err := c.rw.Read(buf[:])
+		for _, b := range buf[:n] {
+			select {
+			case k.in <- b:
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			k.fail(c)
+			return
+		}
+	}
+}
+
+// write writes the characters printed on c until writing fails or c ends.
+// Once writing fails characters are discarded.
+func (k *KL8E) write(c *conn) {
+	var buf [256]byte
+	failed := false
+	for {
+		select {
+		case b := <-c.out:
+			buf[0] = b
+			n := 1
+		more:
+			for n < len(buf) {
+				select {
+				case b := <-c.out:
+					buf[n] = b
+					n++
+				default:
+					break more
+				}
+			}
+			if !failed {
+				if _, err := c.rw.Write(buf[:n]); err != nil {
+					failed = true
+					k.fail(c)
+				}
+			}
+			atomic.AddInt32(&c.pending, -int32(n))
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package tty
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ErrNoPTY is returned by OpenPTY on systems without pseudo-terminal support.
+var ErrNoPTY = errors.New("pseudo-terminals are not supported")
+
+// MakeRaw puts the terminal f into raw mode, without echo, and returns a
+// function that restores its previous mode.  It uses stty(1).
+func MakeRaw(f *os.File) (restore func(), err error) {
+	saved, err := stty(f, "-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty(f, "raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(f, saved) }, nil
+}
+
+// stty runs stty with args on f and returns its output.
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// A pty is the controlling side of a pseudo-terminal.  The terminal side is
+// held open so the pseudo-terminal persists while terminal programs come and
+// go.
+type pty struct {
+	*os.File          // controlling side
+	tty      *os.File // terminal side
+}
+
+func (p *pty) Close() error {
+	p.tty.Close()
+	return p.File.Close()
+}
+
+// OpenPTY opens a new pseudo-terminal and returns its controlling side and the
+// path of its terminal side, which is put into raw mode.  A terminal program,
+// such as screen(1), connects to the line by opening the path.
+func OpenPTY() (io.ReadWriteCloser, string, error) {
+	m, name, err := openPTY()
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		m.Close()
+		return nil, "", err
+	}
+	if _, err := MakeRaw(t); err != nil {
+		t.Close()
+		m.Close()
+		return nil, "", err
+	}
+	return &pty{File: m, tty: t}, name, nil
+}
+
+// files is a connection to an input and an output file.
+type files struct {
+	in   *os.File // nil if there is no input
+	out  *os.File // nil if there is no output
+	done chan struct{}
+	once sync.Once
+}
+
+// OpenFiles returns a connection that types the contents of the file input
+// and writes the characters printed to the file output, which is created or
+// truncated.  Either name may be empty.  Once the input is exhausted nothing
+// more is typed, but the connection does not end.  The input is typed as is;
+// line feeds are not converted to carriage returns.
+func OpenFiles(input, output string) (io.ReadWriteCloser, error) {
+	f := &files{done: make(chan struct{})}
+	var err error
+	if input != "" {
+		if f.in, err = os.Open(input); err != nil {
+			return nil, err
+		}
+	}
+	if output != "" {
+		if f.out, err = os.Create(output); err != nil {
+			if f.in != nil {
+				f.in.Close()
+			}
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Read reads from the input file.  Once the input file is exhausted Read
+// blocks until f is closed.
+func (f *files) Read(buf []byte) (int, error) {
+	if f.in != nil {
+		n, err := f.in.Read(buf)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+	}
+	<-f.done
+	return 0, io.EOF
+}
+
+// Write writes buf to the output file, if any.
+func (f *files) Write(buf []byte) (int, error) {
+	if f.out == nil {
+		return len(buf), nil
+	}
+	return f.out.Write(buf)
+}
+
+func (f *files) Close() error {
+	var err error
+	f.once.Do(func() {
+		close(f.done)
+		if f.in != nil {
+			f.in.Close()
+		}
+		if f.out != nil {
+			err = f.out.Close()
+		}
+	})
+	return err
+}
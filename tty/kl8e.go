@@ -0,0 +1,239 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+// Package tty emulates KL8E asynchronous serial line interfaces for package
+// cpu: the console terminal and additional serial lines.
+//
+// A KL8E has a keyboard (receiver) and a teleprinter (transmitter), each with
+// its own flag and device code, and a single interrupt enable, set by CAF,
+// that requests an interrupt when either flag is set.
+//
+// Characters take Delay cycles of the processor to transfer.  The teleprinter
+// flag is set Delay cycles after a character is sent to the teleprinter, or
+// later if the host connection is not keeping up.  The keyboard flag is set
+// when a character has been typed, but no sooner than Delay cycles after the
+// previous character was received.  Characters typed while the keyboard flag
+// is set wait to be received; they are not lost as they would be on the
+// hardware.
+//
+// A line is connected to the host with Connect.  OpenPTY, Serve, and OpenFiles
+// provide connections to a pseudo-terminal, to telnet clients over TCP, and
+// to files.  Characters printed while a line is not connected are discarded.
+package tty
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pborman/pdp8/cpu"
+)
+
+// KL8E device codes.  Additional lines are normally given keyboard device
+// codes 40, 42, 44, and 46, with the teleprinter on the following code.
+const (
+	KeyboardDevice = 003
+	PrinterDevice  = 004
+)
+
+// DefaultDelay is the default number of cycles a character takes to transfer.
+// It is much faster than any real terminal.
+const DefaultDelay = 100
+
+// Parity is how a line treats the high bit (0200) of each character.
+type Parity int
+
+const (
+	// Mark clears the bit from printed characters and sets it on typed
+	// characters.  OS/8 sets the bit on every character it prints and
+	// expects it to be set on characters it reads.
+	Mark Parity = iota
+
+	// Seven clears the bit from both printed and typed characters.
+	Seven
+
+	// Eight passes all 8 bits unchanged.
+	Eight
+)
+
+// typed returns c as received by the keyboard.
+func (p Parity) typed(c byte) uint16 {
+	switch p {
+	case Mark:
+		c |= 0200
+	case Seven:
+		c &= 0177
+	}
+	return uint16(c)
+}
+
+// printed returns the low 8 bits of w as sent to the host.  The bit is
+// cleared as by os8fs.File.ASCII(true).
+func (p Parity) printed(w uint16) byte {
+	if p == Eight {
+		return byte(w)
+	}
+	return byte(w) & 0177
+}
+
+// A KL8E is a KL8E serial line interface.
+type KL8E struct {
+	Keyboard int    // keyboard device code
+	Printer  int    // teleprinter device code
+	Delay    uint64 // cycles a character takes to transfer
+	Parity   Parity
+
+	in   chan byte // characters typed on the connection
+	mu   sync.Mutex
+	conn *conn // current connection, or nil
+
+	kbd   uint16 // keyboard buffer
+	kflag bool   // keyboard flag
+	kdue  uint64 // cycle the keyboard may receive the next character
+
+	tbuf  byte   // character being printed
+	tbusy bool   // a character is being printed
+	tflag bool   // teleprinter flag
+	tdue  uint64 // cycle the character is printed
+
+	ie  bool   // interrupt enable
+	now uint64 // current cycle
+}
+
+// NewKL8E returns a console KL8E connected to r and w, using the console
+// device codes.
+func NewKL8E(r io.Reader, w io.Writer) *KL8E {
+	k := NewLine(KeyboardDevice)
+	k.Printer = PrinterDevice
+	k.Connect(struct {
+		io.Reader
+		io.Writer
+	}{r, w})
+	return k
+}
+
+// NewLine returns an unconnected KL8E with its keyboard on device code code
+// and its teleprinter on device code code+1.
+func NewLine(code int) *KL8E {
+	return &KL8E{
+		Keyboard: code,
+		Printer:  code + 1,
+		Delay:    DefaultDelay,
+		in:       make(chan byte, 256),
+		ie:       true,
+	}
+}
+
+// Attach attaches k to its device codes on c.
+func (k *KL8E) Attach(c *cpu.CPU) {
+	c.Attach(k.Keyboard, k)
+	c.Attach(k.Printer, k)
+}
+
+// IOT implements cpu.Device.
+func (k *KL8E) IOT(c *cpu.CPU, w uint16) bool {
+	if int(w>>3)&077 == k.Keyboard {
+		return k.keyboard(c, w)
+	}
+	return k.printer(c, w)
+}
+
+// keyboard executes the keyboard IOT w.
+func (k *KL8E) keyboard(c *cpu.CPU, w uint16) bool {
+	switch w & 7 {
+	case 0: // KCF
+		k.kflag = false
+	case 1: // KSF
+		return k.kflag
+	case 2: // KCC
+		c.AC = 0
+		k.kflag = false
+	case 4: // KRS
+		c.AC |= k.kbd
+	case 5: // KIE
+		k.ie = c.AC&1 != 0
+	case 6: // KRB
+		c.AC = k.kbd
+		k.kflag = false
+	}
+	return false
+}
+
+// printer executes the teleprinter IOT w.
+func (k *KL8E) printer(c *cpu.CPU, w uint16) bool {
+	switch w & 7 {
+	case 0: // TFL
+		k.tflag = true
+	case 1: // TSF
+		return k.tflag
+	case 2: // TCF
+		k.tflag = false
+	case 4: // TPC
+		k.print(c.AC)
+	case 5: // TSK
+		return k.tflag || k.kflag
+	case 6: // TLS
+		k.tflag = false
+		k.print(c.AC)
+	}
+	return false
+}
+
+// print starts printing the character in the low 8 bits of w.
+func (k *KL8E) print(w uint16) {
+	k.tbuf = k.Parity.printed(w)
+	k.tbusy = true
+	k.tdue = k.now + k.Delay
+}
+
+// Interrupt implements cpu.Device.
+func (k *KL8E) Interrupt() bool {
+	return k.ie && (k.kflag || k.tflag)
+}
+
+// Reset implements cpu.Device.  Any character being printed is discarded.
+func (k *KL8E) Reset() {
+	k.kflag, k.tflag, k.tbusy = false, false, false
+	k.ie = true
+}
+
+// Clock implements cpu.Clocked.
+func (k *KL8E) Clock(cycles uint64) {
+	k.now = cycles
+	if k.tbusy && cycles >= k.tdue {
+		k.transmit()
+	}
+	if !k.kflag && cycles >= k.kdue {
+		// Check for a character at most once a character time.
+		k.kdue = cycles + k.Delay
+		select {
+		case c := <-k.in:
+			k.kbd = k.Parity.typed(c)
+			k.kflag = true
+		default:
+		}
+	}
+}
+
+// transmit sends the character being printed to the connection and sets the
+// teleprinter flag.  If the connection is not ready for it the character is
+// tried again at the next cycle.
+func (k *KL8E) transmit() {
+	k.mu.Lock()
+	c := k.conn
+	k.mu.Unlock()
+	if c != nil {
+		atomic.AddInt32(&c.pending, 1)
+		select {
+		case c.out <- k.tbuf:
+		case <-c.done:
+		default:
+			atomic.AddInt32(&c.pending, -1)
+			return
+		}
+	}
+	k.tbusy = false
+	k.tflag = true
+}
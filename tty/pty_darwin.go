@@ -0,0 +1,45 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package tty
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal and returns its controlling side and
+// the path of its terminal side.
+func openPTY() (*os.File, string, error) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var name [128]byte
+	for _, req := range []uintptr{syscall.TIOCPTYGRANT, syscall.TIOCPTYUNLK} {
+		if err := ioctl(m, req, nil); err != nil {
+			m.Close()
+			return nil, "", err
+		}
+	}
+	if err := ioctl(m, syscall.TIOCPTYGNAME, unsafe.Pointer(&name)); err != nil {
+		m.Close()
+		return nil, "", err
+	}
+	if x := bytes.IndexByte(name[:], 0); x >= 0 {
+		return m, string(name[:x]), nil
+	}
+	return m, string(name[:]), nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
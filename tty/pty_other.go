@@ -0,0 +1,16 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tty
+
+import "os"
+
+// openPTY returns ErrNoPTY.
+func openPTY() (*os.File, string, error) {
+	return nil, "", ErrNoPTY
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package tty
+
+import (
+	"net"
+)
+
+// Telnet protocol bytes.
+const (
+	iac  = 255
+	dont = 254
+	do   = 253
+	wont = 252
+	will = 251
+	sb   = 250
+	se   = 240
+
+	echo = 1 // echo option
+	sga  = 3 // suppress go ahead option
+)
+
+// Telnet input states.
+const (
+	tnData   = iota // data
+	tnIAC           // after IAC
+	tnOption        // after IAC WILL, WONT, DO, or DONT
+	tnSub           // in a subnegotiation
+	tnSubIAC        // after IAC in a subnegotiation
+	tnCR            // after a CR
+)
+
+// Serve accepts connections on l and connects each to the first of lines that
+// is not connected.  A connection is refused if all the lines are in use.
+// Connections are treated as telnet connections: the client is asked to
+// send each character as it is typed and to let the PDP-8 echo, telnet
+// commands are removed from the input, and CR NUL and CR LF are typed as CR.
+// Serve returns the error from l.Accept.
+//
+// For example, to let users telnet to port 2300 of the local host:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:2300")
+//	if err != nil {
+//		...
+//	}
+//	go tty.Serve(l, line1, line2)
+func Serve(l net.Listener, lines ...*KL8E) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		k := free(lines)
+		if k == nil {
+			c.Write([]byte("All lines are busy.\r\n"))
+			c.Close()
+			continue
+		}
+		c.Write([]byte{iac, will, echo, iac, will, sga, iac, do, sga})
+		k.Connect(&telnet{Conn: c})
+	}
+}
+
+// free returns the first of lines that is not connected, or nil.
+func free(lines []*KL8E) *KL8E {
+	for _, k := range lines {
+		if !k.Connected() {
+			return k
+		}
+	}
+	return nil
+}
+
+// A telnet is a telnet connection.
+type telnet struct {
+	net.Conn
+	state int
+}
+
+// Read reads data from t, removing telnet commands.
+func (t *telnet) Read(buf []byte) (int, error) {
+	for {
+		n, err := t.Conn.Read(buf)
+		j := 0
+		for _, c := range buf[:n] {
+			switch t.state {
+			case tnData, tnCR:
+				if t.state == tnCR && (c == 0 || c == '\n') {
+					t.state = tnData
+					continue
+				}
+				t.state = tnData
+				switch c {
+				case iac:
+					t.state = tnIAC
+					continue
+				case '\r':
+					t.state = tnCR
+				}
+				buf[j] = c
+				j++
+			case tnIAC:
+				switch c {
+				case iac:
+					buf[j] = c
+					j++
+					t.state = tnData
+				case will, wont, do, dont:
+					t.state = tnOption
+				case sb:
+					t.state = tnSub
+				default:
+					t.state = tnData
+				}
+			case tnOption:
+				t.state = tnData
+			case tnSub:
+				if c == iac {
+					t.state = tnSubIAC
+				}
+			case tnSubIAC:
+				t.state = tnSub
+				if c == se {
+					t.state = tnData
+				}
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+// Write writes buf to t, doubling any IAC bytes.
+func (t *telnet) Write(buf []byte) (int, error) {
+	out := make([]byte, 0, len(buf))
+	for _, c := range buf {
+		if c == iac {
+			out = append(out, iac)
+		}
+		out = append(out, c)
+	}
+	if _, err := t.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
@@ -0,0 +1,441 @@
+// Copyright 2017 Paul Borman
+// Use of this source code is governed by a Apache-style
+// license found in the LICENSE file.  It also can be found at
+// https://github.com/pborman/pdp8/blob/master/LICENSE
+
+package tty
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/pdp8/cpu"
+)
+
+// A buffer is a bytes.Buffer that may be written while it is read.
+type buffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// A clock clocks a KL8E.
+type clock struct {
+	k   *KL8E
+	now uint64
+}
+
+// tick advances the clock n cycles.
+func (c *clock) tick(n uint64) {
+	for ; n > 0; n-- {
+		c.now++
+		c.k.Clock(c.now)
+	}
+}
+
+// key clocks the KL8E until its keyboard flag is set, which may take a while
+// as characters are typed by another goroutine, and returns the character
+// read by KRB.
+func (c *clock) key(t *testing.T, p *cpu.CPU) uint16 {
+	t.Helper()
+	end := time.Now().Add(5 * time.Second)
+	for !c.k.kflag {
+		if time.Now().After(end) {
+			t.Fatal("no character typed")
+		}
+		c.tick(1)
+		if !c.k.kflag && c.now%c.k.Delay == 0 {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	p.AC = 0
+	c.k.IOT(p, iot(c.k.Keyboard, 6))
+	return p.AC
+}
+
+// print prints w with TLS and clocks the KL8E until the teleprinter flag is
+// set.
+func (c *clock) print(t *testing.T, p *cpu.CPU, w uint16) {
+	t.Helper()
+	p.AC = w
+	c.k.IOT(p, iot(c.k.Printer, 6))
+	end := time.Now().Add(5 * time.Second)
+	for !c.k.tflag {
+		if time.Now().After(end) {
+			t.Fatal("character not printed")
+		}
+		c.tick(1)
+	}
+}
+
+// iot returns IOT op of device code.
+func iot(code int, op uint16) uint16 {
+	return 06000 | uint16(code)<<3 | op
+}
+
+// wait waits for f to return true.
+func wait(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	end := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(end) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParity(t *testing.T) {
+	for _, tt := range []struct {
+		p       Parity
+		in      byte
+		typed   uint16
+		printed byte
+	}{
+		{Mark, 'A', 0301, 'A'},
+		{Mark, 0301, 0301, 'A'},
+		{Seven, 'A', 0101, 'A'},
+		{Seven, 0301, 0101, 'A'},
+		{Eight, 'A', 0101, 'A'},
+		{Eight, 0301, 0301, 0301},
+	} {
+		if got := tt.p.typed(tt.in); got != tt.typed {
+			t.Errorf("%d typed %03o: got %04o, want %04o", tt.p, tt.in, got, tt.typed)
+		}
+		if got := tt.p.printed(uint16(tt.in) | 07400); got != tt.printed {
+			t.Errorf("%d printed %03o: got %03o, want %03o", tt.p, tt.in, got, tt.printed)
+		}
+	}
+}
+
+func TestIOT(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		w      uint16
+		ac     uint16
+		kflag  bool
+		tflag  bool
+		skip   bool
+		wantAC uint16
+		wantK  bool
+		wantT  bool
+	}{
+		{"KCF", 06030, 01, true, false, false, 01, false, false},
+		{"KSF", 06031, 0, true, false, true, 0, true, false},
+		{"KSF clear", 06031, 0, false, false, false, 0, false, false},
+		{"KCC", 06032, 07, true, false, false, 0, false, false},
+		{"KRS", 06034, 07000, true, false, false, 07301, true, false},
+		{"KRB", 06036, 07000, true, false, false, 0301, false, false},
+		{"TFL", 06040, 0, false, false, false, 0, false, true},
+		{"TSF", 06041, 0, false, true, true, 0, false, true},
+		{"TSF clear", 06041, 0, false, false, false, 0, false, false},
+		{"TCF", 06042, 0, false, true, false, 0, false, false},
+		{"TSK keyboard", 06045, 0, true, false, true, 0, true, false},
+		{"TSK printer", 06045, 0, false, true, true, 0, false, true},
+		{"TSK", 06045, 0, false, false, false, 0, false, false},
+	} {
+		k := NewKL8E(strings.NewReader(""), io.Discard)
+		k.kbd, k.kflag, k.tflag = 0301, tt.kflag, tt.tflag
+		c := cpu.New(1)
+		c.AC = tt.ac
+		if skip := k.IOT(c, tt.w); skip != tt.skip {
+			t.Errorf("%s: got skip %v", tt.name, skip)
+		}
+		if c.AC != tt.wantAC || k.kflag != tt.wantK || k.tflag != tt.wantT {
+			t.Errorf("%s: got AC %04o kflag %v tflag %v, want %04o %v %v", tt.name, c.AC, k.kflag, k.tflag, tt.wantAC, tt.wantK, tt.wantT)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	k := NewLine(040)
+	c := cpu.New(1)
+	k.Attach(c)
+	if k.Interrupt() {
+		t.Fatal("interrupt with no flags")
+	}
+	k.tflag = true
+	if !k.Interrupt() {
+		t.Error("no interrupt on teleprinter flag")
+	}
+	c.AC = 0
+	k.IOT(c, iot(040, 5)) // KIE
+	if k.Interrupt() {
+		t.Error("interrupt when disabled")
+	}
+	c.AC = 1
+	k.IOT(c, iot(040, 5))
+	k.tflag, k.kflag = false, true
+	if !k.Interrupt() {
+		t.Error("no interrupt on keyboard flag")
+	}
+	k.ie = false
+	k.tbusy = true
+	k.Reset()
+	if !k.ie || k.kflag || k.tflag || k.tbusy {
+		t.Errorf("reset: ie %v kflag %v tflag %v tbusy %v", k.ie, k.kflag, k.tflag, k.tbusy)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	out := &buffer{}
+	k := NewKL8E(strings.NewReader("AB"), out)
+	c := cpu.New(1)
+	k.Attach(c)
+	clk := &clock{k: k}
+
+	// The teleprinter flag is set Delay cycles after TLS.
+	c.AC = 0301
+	k.IOT(c, iot(PrinterDevice, 6))
+	clk.tick(k.Delay - 1)
+	if k.tflag {
+		t.Fatalf("flag set after %d cycles", clk.now)
+	}
+	clk.tick(1)
+	if !k.tflag {
+		t.Fatal("flag not set")
+	}
+	if !k.Flush(5 * time.Second) {
+		t.Fatal("flush timed out")
+	}
+	if out.String() != "A" {
+		t.Fatalf("got %q, want %q", out.String(), "A")
+	}
+
+	// Characters are received no sooner than Delay cycles apart.
+	if got := clk.key(t, c); got != 0301 {
+		t.Errorf("got %04o, want 0301", got)
+	}
+	first := clk.now
+	if got := clk.key(t, c); got != 0302 {
+		t.Errorf("got %04o, want 0302", got)
+	}
+	if clk.now-first < k.Delay {
+		t.Errorf("second character after %d cycles", clk.now-first)
+	}
+}
+
+func TestUnconnected(t *testing.T) {
+	k := NewLine(040)
+	c := cpu.New(1)
+	clk := &clock{k: k}
+	clk.print(t, c, 'A')
+	if k.Connected() || !k.Flush(0) {
+		t.Error("unconnected line is connected or cannot flush")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	k := NewLine(040)
+	a, b := net.Pipe()
+	k.Connect(a)
+	if !k.Connected() {
+		t.Fatal("not connected")
+	}
+	out := &buffer{}
+	k.Connect(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(""), out})
+	// The first connection is closed when it is replaced.
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := b.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("replaced connection: got %v, want EOF", err)
+	}
+	// A connection that is not a Closer persists when reading fails.
+	c := cpu.New(1)
+	clk := &clock{k: k}
+	clk.print(t, c, 0310)
+	if !k.Flush(5*time.Second) || out.String() != "H" {
+		t.Errorf("got %q, want %q", out.String(), "H")
+	}
+	if !k.Connected() {
+		t.Error("connection ended at EOF")
+	}
+	k.Disconnect()
+	if k.Connected() {
+		t.Error("still connected")
+	}
+}
+
+func TestTelnetRead(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"data", []byte("abc"), "abc"},
+		{"CR NUL", []byte{'a', '\r', 0, 'b'}, "a\rb"},
+		{"CR LF", []byte{'a', '\r', '\n', 'b'}, "a\rb"},
+		{"CR CR", []byte{'\r', '\r', 'b'}, "\r\rb"},
+		{"option", []byte{'a', iac, will, echo, 'b'}, "ab"},
+		{"command", []byte{'a', iac, 241, 'b'}, "ab"},
+		{"IAC IAC", []byte{'a', iac, iac, 'b'}, "a\xffb"},
+		{"subnegotiation", []byte{'a', iac, sb, 24, 0, iac, iac, iac, se, 'b'}, "ab"},
+	} {
+		a, b := net.Pipe()
+		go func() {
+			a.Write(tt.in)
+			a.Close()
+		}()
+		got, err := io.ReadAll(&telnet{Conn: b})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTelnetWrite(t *testing.T) {
+	a, b := net.Pipe()
+	go func() {
+		(&telnet{Conn: a}).Write([]byte{'a', iac, 'b'})
+		a.Close()
+	}()
+	got, _ := io.ReadAll(b)
+	if want := []byte{'a', iac, iac, 'b'}; !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	k1, k2 := NewLine(040), NewLine(042)
+	go Serve(l, k1, k2)
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return c
+	}
+	// read reads from c until it has read a string ending in suffix.
+	read := func(c net.Conn, suffix string) string {
+		var all []byte
+		buf := make([]byte, 100)
+		for !bytes.HasSuffix(all, []byte(suffix)) {
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatalf("got %q: %v", all, err)
+			}
+			all = append(all, buf[:n]...)
+		}
+		return string(all)
+	}
+
+	c1 := dial()
+	negotiation := string([]byte{iac, will, echo, iac, will, sga, iac, do, sga})
+	read(c1, negotiation)
+	wait(t, "line 1", k1.Connected)
+	c2 := dial()
+	read(c2, negotiation)
+	wait(t, "line 2", k2.Connected)
+	c3 := dial()
+	if got := read(c3, "\r\n"); !strings.Contains(got, "busy") {
+		t.Errorf("third connection got %q", got)
+	}
+	c3.Close()
+
+	c2.Write([]byte{'x', iac, will, 1, '\r', 0, 'y'})
+	p := cpu.New(1)
+	clk := &clock{k: k2}
+	for _, want := range []uint16{0370, 0215, 0371} {
+		if got := clk.key(t, p); got != want {
+			t.Errorf("line 2 got %04o, want %04o", got, want)
+		}
+	}
+
+	clk = &clock{k: k1}
+	clk.print(t, p, 0301)
+	read(c1, "A")
+
+	c1.Close()
+	wait(t, "disconnect", func() bool { return !k1.Connected() })
+	c4 := dial()
+	read(c4, negotiation)
+	wait(t, "reconnect", k1.Connected)
+	c2.Close()
+	c4.Close()
+}
+
+func TestOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	output := filepath.Join(dir, "out")
+	if err := os.WriteFile(input, []byte("HI\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFiles(input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := NewLine(040)
+	k.Connect(f)
+	p := cpu.New(1)
+	clk := &clock{k: k}
+	for _, want := range []uint16{0310, 0311, 0212} {
+		if got := clk.key(t, p); got != want {
+			t.Errorf("got %04o, want %04o", got, want)
+		}
+	}
+	for _, c := range []uint16{0317, 0313} {
+		clk.print(t, p, c)
+	}
+	if !k.Flush(5 * time.Second) {
+		t.Fatal("flush timed out")
+	}
+	// The input is exhausted, but the connection lasts until closed.
+	if !k.Connected() {
+		t.Error("connection ended with the input")
+	}
+	k.Disconnect()
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "OK" {
+		t.Errorf("got %q, want %q", data, "OK")
+	}
+	if _, err := OpenFiles(filepath.Join(dir, "none"), ""); err == nil {
+		t.Error("missing input did not fail")
+	}
+
+	// Neither file is required.
+	f, err = OpenFiles("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("x")); n != 1 || err != nil {
+		t.Errorf("Write got %d, %v", n, err)
+	}
+	go f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read got %v, want EOF", err)
+	}
+}